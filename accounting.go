@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// supportInterval is an engagement that has ended.
+type supportInterval struct {
+	from, to time.Time
+}
+
+// supportAccount is the time a node has spent on life support in engagements
+// that have ended; the current one is counted from its nodeState.
+type supportAccount struct {
+	total time.Duration
+	// recent are the engagements that ended within SUPPORT_WINDOW, or the
+	// last day for digests.
+	recent []supportInterval
+	// alerted is set once the node has been reported for exceeding
+	// SUPPORT_ALERT_THRESHOLD, until it is back under it.
+	alerted bool
+	// disengaged is when the last engagement ended, to detect flaps.
+	disengaged time.Time
+}
+
+// account returns a node's account, creating it. c.mu must be held.
+func (c *NodeLifeSupportController) account(name string) *supportAccount {
+	if c.accounts == nil {
+		c.accounts = make(map[string]*supportAccount)
+	}
+	a, ok := c.accounts[name]
+	if !ok {
+		a = &supportAccount{}
+		c.accounts[name] = a
+	}
+	return a
+}
+
+// closeEngagement adds an engagement ending at now to the node's account.
+// c.mu must be held.
+func (c *NodeLifeSupportController) closeEngagement(name string, since, now time.Time) {
+	a := c.account(name)
+	a.disengaged = now
+	if !now.After(since) {
+		return
+	}
+	a.total += now.Sub(since)
+	a.recent = append(a.recent, supportInterval{from: since, to: now})
+}
+
+// countIntervention counts a new engagement of a node, and a flap if it was
+// disengaged within FLAP_WINDOW, which it returns. c.mu must be held.
+func (c *NodeLifeSupportController) countIntervention(name string, now time.Time) (flapped bool, after time.Duration) {
+	nodeEngagementsTotal.WithLabelValues(c.opts.Cluster, name).Inc()
+	a, ok := c.accounts[name]
+	if !ok || a.disengaged.IsZero() || c.opts.FlapWindow <= 0 {
+		return false, 0
+	}
+	after = now.Sub(a.disengaged)
+	if after > c.opts.FlapWindow {
+		return false, after
+	}
+	nodeFlapsTotal.WithLabelValues(c.opts.Cluster, name).Inc()
+	return true, after
+}
+
+// supportedTime returns how long a node has been on life support: in its
+// current engagement, over the last SUPPORT_WINDOW, and in total. c.mu must
+// be held.
+func (c *NodeLifeSupportController) supportedTime(name string, now time.Time) (current, window, total time.Duration) {
+	start := now.Add(-c.opts.SupportWindow)
+	if st, ok := c.nodes[name]; ok && now.After(st.engagedSince) {
+		current = now.Sub(st.engagedSince)
+		window += overlap(st.engagedSince, now, start)
+	}
+	total = current
+	if a, ok := c.accounts[name]; ok {
+		total += a.total
+		for _, iv := range a.recent {
+			window += overlap(iv.from, iv.to, start)
+		}
+	}
+	return current, window, total
+}
+
+// overlap returns how much of [from, to] lies after start.
+func overlap(from, to, start time.Time) time.Duration {
+	if from.Before(start) {
+		from = start
+	}
+	if !to.After(from) {
+		return 0
+	}
+	return to.Sub(from)
+}
+
+// checkSupportThreshold reports an engaged node whose time on life support
+// over the last SUPPORT_WINDOW has exceeded SUPPORT_ALERT_THRESHOLD, once
+// until it drops back under it, with a Warning Event and a webhook
+// notification.
+func (c *NodeLifeSupportController) checkSupportThreshold(ctx context.Context, node *metav1.PartialObjectMetadata, now time.Time) {
+	threshold := c.opts.SupportAlertThreshold
+	if threshold <= 0 {
+		return
+	}
+	c.mu.Lock()
+	_, window, _ := c.supportedTime(node.Name, now)
+	a := c.account(node.Name)
+	report := window > threshold && !a.alerted
+	if report {
+		a.alerted = true
+	}
+	var since time.Time
+	if st, ok := c.nodes[node.Name]; ok {
+		since = st.engagedSince
+	}
+	c.mu.Unlock()
+	if !report {
+		return
+	}
+
+	msg := fmt.Sprintf("On life support for %s in the last %s, over %s", window.Round(time.Second), c.opts.SupportWindow, threshold)
+	c.logf("node %s: %s", node.Name, msg)
+	c.nodeEvent(node.Name, node.UID, v1.EventTypeWarning, reasonLifeSupportThreshold, "%s", msg)
+	c.notify(ctx, notification{
+		Event:            reasonLifeSupportThreshold,
+		Node:             node.Name,
+		Message:          msg,
+		EngagedSince:     since.UTC(),
+		SupportedSeconds: window.Seconds(),
+	})
+}
+
+// accountSupport forgets engagements that ended before SUPPORT_WINDOW, clears
+// alerts of nodes back under SUPPORT_ALERT_THRESHOLD and exports every node's
+// time on life support.
+func (c *NodeLifeSupportController) accountSupport(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Digests need the last day even with a shorter SUPPORT_WINDOW.
+	keep := c.opts.SupportWindow
+	if c.opts.DigestSchedule != nil && keep < digestPeriod {
+		keep = digestPeriod
+	}
+	start := now.Add(-keep)
+	for _, a := range c.accounts {
+		kept := a.recent[:0]
+		for _, iv := range a.recent {
+			if iv.to.After(start) {
+				kept = append(kept, iv)
+			}
+		}
+		a.recent = kept
+	}
+
+	exported := make(map[string]struct{}, len(c.nodes)+len(c.accounts))
+	for name := range c.nodes {
+		exported[name] = struct{}{}
+	}
+	for name := range c.accounts {
+		exported[name] = struct{}{}
+	}
+	for name := range exported {
+		current, window, total := c.supportedTime(name, now)
+		if a, ok := c.accounts[name]; ok && a.alerted && window <= c.opts.SupportAlertThreshold {
+			a.alerted = false
+		}
+		nodeSupportedSeconds.WithLabelValues(c.opts.Cluster, name, "current").Set(current.Seconds())
+		nodeSupportedSeconds.WithLabelValues(c.opts.Cluster, name, "window").Set(window.Seconds())
+		nodeSupportedSeconds.WithLabelValues(c.opts.Cluster, name, "total").Set(total.Seconds())
+	}
+	for name := range c.accountMetrics {
+		if _, ok := exported[name]; !ok {
+			nodeSupportedSeconds.DeletePartialMatch(prometheus.Labels{"cluster": c.opts.Cluster, "node": name})
+		}
+	}
+	c.accountMetrics = exported
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// TestSupportAccounting tests that a node's time on life support is totalled
+// across engagements and over SUPPORT_WINDOW, survives a restart, and is
+// reported once when it exceeds SUPPORT_ALERT_THRESHOLD.
+func TestSupportAccounting(t *testing.T) {
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	clock := clocktesting.NewFakeClock(start)
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1"}}
+	client := fake.NewSimpleClientset(node)
+	opts := Options{
+		Cluster:               "acct",
+		StateConfigMap:        "state",
+		Namespace:             "nls",
+		SupportWindow:         24 * time.Hour,
+		SupportAlertThreshold: 11 * time.Hour,
+	}
+	recorder := record.NewFakeRecorder(10)
+	c := &NodeLifeSupportController{client: client, opts: opts, clock: clock, recorder: recorder}
+	ctx := context.Background()
+	meta := &metav1.PartialObjectMetadata{ObjectMeta: node.ObjectMeta}
+
+	// 6h, then 7h after a break: 10h in the last 24h, 13h in all.
+	c.engage("node1")
+	clock.Step(6 * time.Hour)
+	if err := c.disengage(ctx, "node1", disengageOperatorDisabled); err != nil {
+		t.Fatal(err)
+	}
+	clock.Step(14 * time.Hour)
+	c.engage("node1")
+	clock.Step(7 * time.Hour)
+
+	c.mu.Lock()
+	current, window, total := c.supportedTime("node1", clock.Now())
+	c.mu.Unlock()
+	if current != 7*time.Hour || window != 10*time.Hour || total != 13*time.Hour {
+		t.Errorf("current=%s window=%s total=%s, want 7h, 10h, 13h", current, window, total)
+	}
+	c.checkSupportThreshold(ctx, meta, clock.Now())
+	if len(recorder.Events) != 0 {
+		t.Errorf("reported under the threshold: %v", <-recorder.Events)
+	}
+
+	// The first engagement has left the window by now.
+	clock.Step(5*time.Hour + time.Minute)
+	for i := 0; i < 2; i++ {
+		c.checkSupportThreshold(ctx, meta, clock.Now())
+	}
+	if len(recorder.Events) != 1 {
+		t.Fatalf("%d Events over the threshold, want 1", len(recorder.Events))
+	}
+	if e := <-recorder.Events; e != "Warning LifeSupportThresholdExceeded On life support for 12h1m0s in the last 24h0m0s, over 11h0m0s" {
+		t.Errorf("Event %q", e)
+	}
+
+	c.accountSupport(clock.Now())
+	if got := testutil.ToFloat64(nodeSupportedSeconds.WithLabelValues("acct", "node1", "total")); got != (18*time.Hour + time.Minute).Seconds() {
+		t.Errorf("total metric = %v", got)
+	}
+	c.persistState(ctx)
+
+	restarted := &NodeLifeSupportController{client: client, opts: opts, clock: clock}
+	if err := restarted.restoreState(ctx); err != nil {
+		t.Fatal(err)
+	}
+	restarted.engage("node1")
+	restarted.mu.Lock()
+	_, window, total = restarted.supportedTime("node1", clock.Now())
+	alerted := restarted.accounts["node1"].alerted
+	restarted.mu.Unlock()
+	if window != 12*time.Hour+time.Minute || total != 18*time.Hour+time.Minute || !alerted {
+		t.Errorf("after restart window=%s total=%s alerted=%v, want 12h1m, 18h1m, true", window, total, alerted)
+	}
+
+	c.forget("node1")
+	c.accountSupport(clock.Now())
+	if n := testutil.CollectAndCount(nodeSupportedSeconds); n != 0 {
+		t.Errorf("%d series left after the node was deleted", n)
+	}
+}
+
+// TestInterventionMetrics tests that engagements are counted per node, and
+// those within FLAP_WINDOW of a disengagement as flaps too.
+func TestInterventionMetrics(t *testing.T) {
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	clock := clocktesting.NewFakeClock(start)
+	c := &NodeLifeSupportController{
+		client: fake.NewSimpleClientset(),
+		opts:   Options{Cluster: "flaps", FlapWindow: 10 * time.Minute},
+		clock:  clock,
+	}
+	ctx := context.Background()
+
+	// Back after 5m, a flap; then after an hour, not one.
+	for _, gap := range []time.Duration{5 * time.Minute, time.Hour} {
+		c.engage("node1")
+		clock.Step(time.Minute)
+		if err := c.disengage(ctx, "node1", disengageRecovered); err != nil {
+			t.Fatal(err)
+		}
+		clock.Step(gap)
+	}
+	c.engage("node1")
+
+	if got := testutil.ToFloat64(nodeEngagementsTotal.WithLabelValues("flaps", "node1")); got != 3 {
+		t.Errorf("engagements = %v, want 3", got)
+	}
+	if got := testutil.ToFloat64(nodeFlapsTotal.WithLabelValues("flaps", "node1")); got != 1 {
+		t.Errorf("flaps = %v, want 1", got)
+	}
+	h, _ := c.nodeHistory("node1")
+	if h[2].Detail != "flapping, disengaged 5m0s earlier" || h[4].Detail != "" {
+		t.Errorf("history = %+v", h)
+	}
+
+	c.forget("node1")
+	if nodeEngagementsTotal.DeleteLabelValues("flaps", "node1") {
+		t.Errorf("engagements still exported after the node was deleted")
+	}
+}
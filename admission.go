@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// maxAdmissionBody bounds the size of an AdmissionReview request.
+const maxAdmissionBody = 3 << 20
+
+// admissionWebhook serves the controller's admission webhooks.
+type admissionWebhook struct {
+	opts *Options
+	// c is the controller whose engagements protect pods from eviction; nil
+	// in multi-cluster mode, where evictions are not checked.
+	c *NodeLifeSupportController
+}
+
+// serveAdmission serves the admission webhooks over TLS on opts.AdmissionAddr
+// until ctx is done.
+func serveAdmission(ctx context.Context, opts *Options, c *NodeLifeSupportController) {
+	wh := &admissionWebhook{opts: opts, c: c}
+	mux := http.NewServeMux()
+	mux.Handle("/mutate-pods", admissionHandler(wh.mutatePod))
+	mux.Handle("/validate-pods", admissionHandler(wh.validatePod))
+
+	certs := &certReloader{certFile: opts.AdmissionCertFile, keyFile: opts.AdmissionKeyFile}
+	srv := &http.Server{
+		Addr:              opts.AdmissionAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig:         &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: certs.get},
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	if err := srv.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("admission webhook server on %s: %v", opts.AdmissionAddr, err)
+	}
+}
+
+// admissionHandler decodes an AdmissionReview, passes its request to review
+// and writes back the response.
+func admissionHandler(review func(*admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in admissionv1.AdmissionReview
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxAdmissionBody)).Decode(&in); err != nil || in.Request == nil {
+			http.Error(w, "malformed AdmissionReview", http.StatusBadRequest)
+			return
+		}
+		resp := review(in.Request)
+		resp.UID = in.Request.UID
+		out := admissionv1.AdmissionReview{TypeMeta: in.TypeMeta, Response: resp}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	})
+}
+
+// taintTolerations are the taints the node lifecycle controller evicts pods
+// for once a kubelet stops heartbeating.
+var taintTolerations = []string{v1.TaintNodeUnreachable, v1.TaintNodeNotReady}
+
+// mutatePod extends pods' NoExecute tolerations of the unreachable and
+// not-ready taints to POD_TOLERATION_SECONDS, if they are bound for nodes the
+// controller supports, so they outlast a brief lapse in our condition patch.
+func (wh *admissionWebhook) mutatePod(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	allowed := &admissionv1.AdmissionResponse{Allowed: true}
+	var pod v1.Pod
+	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
+		// Extending tolerations is never worth refusing a pod over.
+		log.Printf("admission: decoding pod %s/%s: %v; admitting it unchanged", req.Namespace, req.Name, err)
+		return allowed
+	}
+	if !wh.podTargeted(&pod) {
+		return allowed
+	}
+	tolerations, changed := extendTolerations(pod.Spec.Tolerations, wh.opts.PodTolerationSeconds)
+	if !changed {
+		return allowed
+	}
+	patch, err := json.Marshal([]map[string]interface{}{
+		{"op": "add", "path": "/spec/tolerations", "value": tolerations},
+	})
+	if err != nil {
+		log.Printf("admission: patching pod %s/%s: %v; admitting it unchanged", req.Namespace, req.Name, err)
+		return allowed
+	}
+	patchType := admissionv1.PatchTypeJSONPatch
+	allowed.Patch, allowed.PatchType = patch, &patchType
+	return allowed
+}
+
+// podTargeted reports whether a pod's nodeSelector pins it to nodes the
+// controller handles: nodes carrying an allowlisted label, if there is an
+// allowlist, and selected by a policy, if there are policies.
+func (wh *admissionWebhook) podTargeted(pod *v1.Pod) bool {
+	sel := pod.Spec.NodeSelector
+	if len(wh.opts.AllowedLabelKeys) > 0 {
+		found := false
+		for _, k := range wh.opts.AllowedLabelKeys {
+			if _, ok := sel[k]; ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(wh.opts.Policies) == 0 {
+		return true
+	}
+	for _, p := range wh.opts.Policies {
+		if p.selector.Matches(labels.Set(sel)) {
+			return true
+		}
+	}
+	return false
+}
+
+// extendTolerations raises or adds NoExecute tolerations of the unreachable
+// and not-ready taints to seconds. Tolerations without a limit are kept.
+func extendTolerations(tolerations []v1.Toleration, seconds int64) ([]v1.Toleration, bool) {
+	out := append([]v1.Toleration(nil), tolerations...)
+	changed := false
+	for _, key := range taintTolerations {
+		found := false
+		for i := range out {
+			t := &out[i]
+			if t.Key != key || (t.Effect != v1.TaintEffectNoExecute && t.Effect != "") {
+				continue
+			}
+			found = true
+			if t.TolerationSeconds != nil && *t.TolerationSeconds < seconds {
+				s := seconds
+				t.TolerationSeconds = &s
+				changed = true
+			}
+		}
+		if !found {
+			s := seconds
+			out = append(out, v1.Toleration{
+				Key:               key,
+				Operator:          v1.TolerationOpExists,
+				Effect:            v1.TaintEffectNoExecute,
+				TolerationSeconds: &s,
+			})
+			changed = true
+		}
+	}
+	return out, changed
+}
+
+// validatePod denies evictions of pods on nodes on life support, and, for the
+// users in BLOCK_POD_DELETIONS_BY, their deletion, so taint-based eviction
+// cannot win a race against our overrides. Anything else is allowed, as is
+// everything on a node DRAIN_ON_GIVE_UP is draining.
+func (wh *admissionWebhook) validatePod(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	allowed := &admissionv1.AdmissionResponse{Allowed: true}
+	if wh.c == nil {
+		return allowed
+	}
+	var nodeName string
+	switch {
+	case req.Operation == admissionv1.Create && req.SubResource == "eviction":
+		ctx, cancel := wh.c.opContext(context.Background())
+		defer cancel()
+		pod, err := wh.c.client.CoreV1().Pods(req.Namespace).Get(ctx, req.Name, metav1.GetOptions{})
+		if err != nil {
+			// Fail open: the eviction API will report a missing pod itself.
+			wh.c.logf("eviction of pod %s/%s: %v", req.Namespace, req.Name, err)
+			return allowed
+		}
+		nodeName = pod.Spec.NodeName
+	case req.Operation == admissionv1.Delete && req.SubResource == "" && wh.blocksDeletionsBy(req.UserInfo.Username):
+		var pod v1.Pod
+		if err := json.Unmarshal(req.OldObject.Raw, &pod); err != nil {
+			return allowed
+		}
+		nodeName = pod.Spec.NodeName
+	default:
+		return allowed
+	}
+	if nodeName == "" || !wh.onLifeSupport(nodeName) || wh.c.draining(nodeName) {
+		return allowed
+	}
+	wh.c.logf("denied %s of pod %s/%s by %s: node %s is on life support",
+		strings.ToLower(string(req.Operation)), req.Namespace, req.Name, req.UserInfo.Username, nodeName)
+	return &admissionv1.AdmissionResponse{Result: &metav1.Status{
+		Status: metav1.StatusFailure,
+		// 429, like a PodDisruptionBudget, so drains retry rather than fail.
+		Code:    http.StatusTooManyRequests,
+		Reason:  metav1.StatusReasonTooManyRequests,
+		Message: fmt.Sprintf("node %s is on life support", nodeName),
+	}}
+}
+
+// onLifeSupport reports whether a node is on life support. A standby replica
+// knows nothing of the leader's engagements, so goes by the node's
+// LifeSupportActive condition instead.
+func (wh *admissionWebhook) onLifeSupport(name string) bool {
+	if !standingBy() {
+		return wh.c.engaged(name)
+	}
+	ctx, cancel := wh.c.opContext(context.Background())
+	defer cancel()
+	node, err := wh.c.client.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		wh.c.logf("node %s: %v", name, err)
+		return false
+	}
+	cond := findCondition(node.Status.Conditions, lifeSupportCondition)
+	return cond != nil && cond.Status == v1.ConditionTrue
+}
+
+func (wh *admissionWebhook) blocksDeletionsBy(user string) bool {
+	for _, u := range wh.opts.BlockPodDeletionsBy {
+		if u == user {
+			return true
+		}
+	}
+	return false
+}
+
+// certReloader serves the webhook certificate, rereading it when the files
+// change so rotated certificates are picked up without a restart.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	modTime time.Time
+	cert    *tls.Certificate
+}
+
+func (r *certReloader) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fi, err := os.Stat(r.certFile)
+	if err != nil {
+		return nil, err
+	}
+	if r.cert != nil && fi.ModTime().Equal(r.modTime) {
+		return r.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	r.cert, r.modTime = &cert, fi.ModTime()
+	return r.cert, nil
+}
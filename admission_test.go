@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func int64Ptr(i int64) *int64 { return &i }
+
+func TestExtendTolerations(t *testing.T) {
+	unreachable := func(seconds *int64) v1.Toleration {
+		return v1.Toleration{Key: v1.TaintNodeUnreachable, Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute, TolerationSeconds: seconds}
+	}
+	notReady := func(seconds *int64) v1.Toleration {
+		return v1.Toleration{Key: v1.TaintNodeNotReady, Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute, TolerationSeconds: seconds}
+	}
+	other := v1.Toleration{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "edge", Effect: v1.TaintEffectNoSchedule}
+
+	tests := []struct {
+		name        string
+		in          []v1.Toleration
+		want        []v1.Toleration
+		wantChanged bool
+	}{
+		{
+			name:        "none",
+			want:        []v1.Toleration{unreachable(int64Ptr(3600)), notReady(int64Ptr(3600))},
+			wantChanged: true,
+		},
+		{
+			name:        "defaults raised",
+			in:          []v1.Toleration{other, notReady(int64Ptr(300)), unreachable(int64Ptr(300))},
+			want:        []v1.Toleration{other, notReady(int64Ptr(3600)), unreachable(int64Ptr(3600))},
+			wantChanged: true,
+		},
+		{
+			name: "longer and unlimited kept",
+			in:   []v1.Toleration{unreachable(nil), notReady(int64Ptr(7200))},
+			want: []v1.Toleration{unreachable(nil), notReady(int64Ptr(7200))},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed := extendTolerations(tt.in, 3600)
+			if changed != tt.wantChanged || !apiequality.Semantic.DeepEqual(got, tt.want) {
+				t.Errorf("extendTolerations() = %+v, %v; want %+v, %v", got, changed, tt.want, tt.wantChanged)
+			}
+		})
+	}
+}
+
+func TestPodTargeted(t *testing.T) {
+	edge := &Policy{Name: "edge", NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"pool": "edge"}}}
+	if err := edge.compile(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		allowlist    []string
+		policies     []*Policy
+		nodeSelector map[string]string
+		want         bool
+	}{
+		{name: "no restrictions", want: true},
+		{name: "allowlisted key", allowlist: []string{"pool"}, nodeSelector: map[string]string{"pool": "core"}, want: true},
+		{name: "no allowlisted key", allowlist: []string{"pool"}, nodeSelector: map[string]string{"zone": "a"}},
+		{name: "policy selects pool", policies: []*Policy{edge}, nodeSelector: map[string]string{"pool": "edge"}, want: true},
+		{name: "policy selects other pool", policies: []*Policy{edge}, nodeSelector: map[string]string{"pool": "core"}},
+		{name: "no node selector", policies: []*Policy{edge}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := &admissionWebhook{opts: &Options{AllowedLabelKeys: tt.allowlist, Policies: tt.policies}}
+			pod := &v1.Pod{Spec: v1.PodSpec{NodeSelector: tt.nodeSelector}}
+			if got := wh.podTargeted(pod); got != tt.want {
+				t.Errorf("podTargeted() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMutatePodHandler(t *testing.T) {
+	wh := &admissionWebhook{opts: &Options{PodTolerationSeconds: 600}}
+	pod, _ := json.Marshal(&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p"}})
+	review, _ := json.Marshal(&admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request:  &admissionv1.AdmissionRequest{UID: "uid-1", Object: runtime.RawExtension{Raw: pod}},
+	})
+
+	rec := httptest.NewRecorder()
+	admissionHandler(wh.mutatePod).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/mutate-pods", bytes.NewReader(review)))
+	var out admissionv1.AdmissionReview
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatalf("decoding response %q: %v", rec.Body.String(), err)
+	}
+	if out.Kind != "AdmissionReview" || out.Response == nil || out.Response.UID != "uid-1" || !out.Response.Allowed {
+		t.Fatalf("unexpected response %+v", out)
+	}
+	var patch []struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		Value []v1.Toleration `json:"value"`
+	}
+	if err := json.Unmarshal(out.Response.Patch, &patch); err != nil {
+		t.Fatalf("decoding patch %q: %v", out.Response.Patch, err)
+	}
+	if len(patch) != 1 || patch[0].Path != "/spec/tolerations" || len(patch[0].Value) != 2 || *patch[0].Value[0].TolerationSeconds != 600 {
+		t.Errorf("unexpected patch %s", out.Response.Patch)
+	}
+
+	// A pod that cannot be decoded is admitted unchanged.
+	if resp := wh.mutatePod(&admissionv1.AdmissionRequest{Object: runtime.RawExtension{Raw: []byte(`{"spec":"?"}`)}}); !resp.Allowed || resp.Patch != nil {
+		t.Errorf("undecodable pod: got %+v, want allowed without a patch", resp)
+	}
+
+	rec = httptest.NewRecorder()
+	admissionHandler(wh.mutatePod).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/mutate-pods", bytes.NewReader([]byte("{"))))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("malformed review: got status %d, want 400", rec.Code)
+	}
+}
+
+func TestValidatePod(t *testing.T) {
+	onEngaged := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "a"}, Spec: v1.PodSpec{NodeName: "engaged"}}
+	onHealthy := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "b"}, Spec: v1.PodSpec{NodeName: "healthy"}}
+	c := &NodeLifeSupportController{
+		client: fake.NewSimpleClientset(onEngaged, onHealthy),
+		nodes:  map[string]*nodeState{"engaged": {}},
+	}
+	wh := &admissionWebhook{opts: &Options{BlockPodDeletionsBy: []string{"system:serviceaccount:kube-system:node-controller"}}, c: c}
+	raw := func(p *v1.Pod) runtime.RawExtension {
+		b, _ := json.Marshal(p)
+		return runtime.RawExtension{Raw: b}
+	}
+
+	tests := []struct {
+		name string
+		req  admissionv1.AdmissionRequest
+		want bool
+	}{
+		{
+			name: "eviction from engaged node",
+			req:  admissionv1.AdmissionRequest{Operation: admissionv1.Create, SubResource: "eviction", Namespace: "ns", Name: "a"},
+		},
+		{
+			name: "eviction from healthy node",
+			req:  admissionv1.AdmissionRequest{Operation: admissionv1.Create, SubResource: "eviction", Namespace: "ns", Name: "b"},
+			want: true,
+		},
+		{
+			name: "eviction of missing pod",
+			req:  admissionv1.AdmissionRequest{Operation: admissionv1.Create, SubResource: "eviction", Namespace: "ns", Name: "gone"},
+			want: true,
+		},
+		{
+			name: "deletion by node controller",
+			req: admissionv1.AdmissionRequest{Operation: admissionv1.Delete, Namespace: "ns", Name: "a", OldObject: raw(onEngaged),
+				UserInfo: authenticationv1.UserInfo{Username: "system:serviceaccount:kube-system:node-controller"}},
+		},
+		{
+			name: "deletion by user",
+			req: admissionv1.AdmissionRequest{Operation: admissionv1.Delete, Namespace: "ns", Name: "a", OldObject: raw(onEngaged),
+				UserInfo: authenticationv1.UserInfo{Username: "alice"}},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := wh.validatePod(&tt.req)
+			if resp.Allowed != tt.want {
+				t.Fatalf("allowed = %v, want %v", resp.Allowed, tt.want)
+			}
+			if !resp.Allowed && resp.Result.Code != http.StatusTooManyRequests {
+				t.Errorf("denied with code %d, want 429", resp.Result.Code)
+			}
+		})
+	}
+
+	// A standby replica goes by the node's LifeSupportActive condition.
+	leadership = &leaderState{identity: "standby"}
+	defer func() { leadership = nil }()
+	if _, err := c.client.CoreV1().Nodes().Create(context.Background(), &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "healthy"},
+		Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: lifeSupportCondition, Status: v1.ConditionTrue}}},
+	}, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if resp := wh.validatePod(&tests[1].req); resp.Allowed {
+		t.Errorf("standby allowed eviction from a node with %s", lifeSupportCondition)
+	}
+	if resp := wh.validatePod(&tests[0].req); !resp.Allowed {
+		t.Errorf("standby denied eviction from a node it cannot read")
+	}
+
+	// Without a controller (multi-cluster mode) nothing is denied.
+	wh.c = nil
+	if resp := wh.validatePod(&tests[0].req); !resp.Allowed {
+		t.Errorf("denied without a controller")
+	}
+}
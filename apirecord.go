@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+// apiExchange is one API request and its response, a line of an
+// API_RECORD_FILE. Request headers, credentials included, are not recorded;
+// bodies are, so recordings of Secrets (e.g. CAPI kubeconfigs) hold them.
+type apiExchange struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	// URL is the request's path and query.
+	URL     string `json:"url"`
+	Request string `json:"request,omitempty"`
+	// Status, ContentType and Response describe the response, or Error the
+	// failure to get one.
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Response    string `json:"response,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// watchRequest reports whether req is a watch, whose response streams.
+func watchRequest(req *http.Request) bool {
+	return req.URL.Query().Get("watch") == "true" || strings.Contains(req.URL.Path, "/watch/")
+}
+
+// apiRecorder appends every API request and response to API_RECORD_FILE,
+// one JSON apiExchange per line.
+type apiRecorder struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func newAPIRecorder(path string) (*apiRecorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("recording API requests and responses to %s", path)
+	return &apiRecorder{out: f}, nil
+}
+
+// wrap is a rest.Config WrapTransport recording requests.
+func (r *apiRecorder) wrap(rt http.RoundTripper) http.RoundTripper {
+	return &recordingTransport{recorder: r, next: rt}
+}
+
+func (r *apiRecorder) record(e *apiExchange) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.out.Write(append(line, '\n')); err != nil {
+		log.Printf("API_RECORD_FILE: %v", err)
+	}
+}
+
+type recordingTransport struct {
+	recorder *apiRecorder
+	next     http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	e := &apiExchange{Time: time.Now().UTC(), Method: req.Method, URL: req.URL.RequestURI()}
+	if req.Body != nil && req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			raw, _ := io.ReadAll(body)
+			body.Close()
+			e.Request = string(raw)
+		}
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		e.Error = err.Error()
+		t.recorder.record(e)
+		return resp, err
+	}
+	e.Status, e.ContentType = resp.StatusCode, resp.Header.Get("Content-Type")
+	if watchRequest(req) {
+		// The response streams until the watch ends, so it is recorded then.
+		resp.Body = &recordedBody{ReadCloser: resp.Body, recorder: t.recorder, exchange: e}
+		return resp, nil
+	}
+	raw, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	e.Response = string(raw)
+	if err != nil {
+		e.Error = err.Error()
+	}
+	t.recorder.record(e)
+	resp.Body = io.NopCloser(bytes.NewReader(raw))
+	return resp, err
+}
+
+// recordedBody records a streamed response once it is closed.
+type recordedBody struct {
+	io.ReadCloser
+	recorder *apiRecorder
+	exchange *apiExchange
+	buf      bytes.Buffer
+	once     sync.Once
+}
+
+func (b *recordedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	return n, err
+}
+
+func (b *recordedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.exchange.Response = b.buf.String()
+		b.recorder.record(b.exchange)
+	})
+	return err
+}
+
+// volatileParams are query parameters that differ between a recording and
+// its replay, and so are ignored when matching requests.
+var volatileParams = map[string]bool{
+	"resourceVersion":      true,
+	"resourceVersionMatch": true,
+	"timeout":              true,
+	"timeoutSeconds":       true,
+	"allowWatchBookmarks":  true,
+	"sendInitialEvents":    true,
+}
+
+// replayKey identifies requests that replay the same recorded responses: the
+// method, path and query without volatileParams.
+func replayKey(method, uri string) string {
+	u, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return method + " " + uri
+	}
+	q := u.Query()
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		if !volatileParams[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, k := range keys {
+		for _, v := range q[k] {
+			params = append(params, k+"="+v)
+		}
+	}
+	return method + " " + u.Path + "?" + strings.Join(params, "&")
+}
+
+// apiReplay answers requests from an API_RECORD_FILE recording instead of
+// an API server, with API_REPLAY_FILE or in tests. Each request gets the
+// next response recorded for it, and the last one again once they run
+// out; watches, once theirs run out, stay open with nothing to report.
+// Requests never recorded fail.
+type apiReplay struct {
+	mu        sync.Mutex
+	exchanges map[string][]*apiExchange
+	served    map[string]int
+}
+
+// loadAPIReplay reads a recording made with API_RECORD_FILE.
+func loadAPIReplay(path string) (*apiReplay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := &apiReplay{exchanges: make(map[string][]*apiExchange), served: make(map[string]int)}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		e := &apiExchange{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		key := replayKey(e.Method, e.URL)
+		r.exchanges[key] = append(r.exchanges[key], e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// config returns a client configuration whose requests r answers.
+func (r *apiReplay) config() *rest.Config {
+	return &rest.Config{Host: "http://api-replay.invalid", Transport: r}
+}
+
+func (r *apiReplay) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := replayKey(req.Method, req.URL.RequestURI())
+	r.mu.Lock()
+	recorded := r.exchanges[key]
+	i := r.served[key]
+	r.served[key]++
+	r.mu.Unlock()
+
+	if len(recorded) == 0 {
+		return replayResponse(req, http.StatusInternalServerError, "application/json",
+			statusBody(http.StatusInternalServerError, fmt.Sprintf("API replay: %s %s was not recorded", req.Method, req.URL.RequestURI()))), nil
+	}
+	if i >= len(recorded) {
+		if watchRequest(req) {
+			return replayResponse(req, http.StatusOK, recorded[0].ContentType, nil), nil
+		}
+		i = len(recorded) - 1
+	}
+	e := recorded[i]
+	if e.Error != "" && e.Status == 0 {
+		return nil, fmt.Errorf("API replay: %s", e.Error)
+	}
+	return replayResponse(req, e.Status, e.ContentType, []byte(e.Response)), nil
+}
+
+// replayResponse is a response with body, or for a nil body one that stays
+// open until the request is cancelled.
+func replayResponse(req *http.Request, code int, contentType string, body []byte) *http.Response {
+	resp := &http.Response{
+		Status:     fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		StatusCode: code,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": []string{contentType}},
+		Request:    req,
+	}
+	if body != nil {
+		resp.Body, resp.ContentLength = io.NopCloser(bytes.NewReader(body)), int64(len(body))
+		return resp
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		<-req.Context().Done()
+		pw.CloseWithError(io.EOF)
+	}()
+	resp.Body, resp.ContentLength = pr, -1
+	return resp
+}
+
+// statusBody is the JSON of a failure Status with code and message.
+func statusBody(code int, message string) []byte {
+	body, _ := json.Marshal(metav1.Status{
+		TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   metav1.StatusFailure,
+		Code:     int32(code),
+		Reason:   metav1.StatusReasonInternalError,
+		Message:  message,
+	})
+	return body
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Audited actions: what the controller changed, or started or stopped
+// changing, on a node's behalf.
+const (
+	auditEngaged          = "Engaged"
+	auditDisengaged       = "Disengaged"
+	auditLeaseRenewed     = "LeaseRenewed"
+	auditLeaseReleased    = "LeaseReleased"
+	auditReadyAsserted    = "ReadyAsserted"
+	auditConditionCleared = "LifeSupportConditionCleared"
+	auditCordoned         = "Cordoned"
+	auditUncordoned       = "Uncordoned"
+	auditCordonRestored   = "SchedulabilityRestored"
+	auditTaintsUpdated    = "TaintsUpdated"
+	auditLabelsUpdated    = "LabelsUpdated"
+	auditPodNotReady      = "PodMarkedNotReady"
+)
+
+// auditShutdownTimeout bounds the final export when the controller stops.
+const auditShutdownTimeout = 10 * time.Second
+
+// auditPruneInterval is how often exported batches older than
+// AUDIT_RETENTION are looked for.
+const auditPruneInterval = time.Hour
+
+// auditExports tracks the exporters still to flush their last batch, so the
+// process can wait for them on shutdown.
+var auditExports sync.WaitGroup
+
+// auditRecord is one line of an exported batch.
+type auditRecord struct {
+	Time       time.Time `json:"time"`
+	Cluster    string    `json:"cluster,omitempty"`
+	Controller string    `json:"controller"`
+	Node       string    `json:"node"`
+	Action     string    `json:"action"`
+	Detail     string    `json:"detail,omitempty"`
+}
+
+// auditExporter batches audit records and writes them to object storage as
+// gzipped JSON Lines, one object per batch, so the record of what the
+// controller asserted outlives its pods.
+type auditExporter struct {
+	store     objectStore
+	prefix    string
+	identity  string
+	cluster   string
+	batchSize int
+	retention time.Duration
+	logf      func(format string, args ...interface{})
+	now       func() time.Time
+
+	mu      sync.Mutex
+	pending []auditRecord
+	// full is signalled when a batch is ready before the next flush.
+	full      chan struct{}
+	lastPrune time.Time
+	// batches numbers the batches written, telling apart keys written
+	// within the same clock tick.
+	batches int
+}
+
+// unsafeKeyChars are replaced in the identity and cluster used in object
+// keys.
+var unsafeKeyChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+func newAuditExporter(store objectStore, prefix string, opts *Options) *auditExporter {
+	if opts.Cluster != "" {
+		prefix = joinKey(prefix, unsafeKeyChars.ReplaceAllString(opts.Cluster, "_"))
+	}
+	return &auditExporter{
+		store:     store,
+		prefix:    prefix,
+		identity:  unsafeKeyChars.ReplaceAllString(opts.Identity, "_"),
+		cluster:   opts.Cluster,
+		batchSize: opts.AuditBatchSize,
+		retention: opts.AuditRetention,
+		logf:      func(string, ...interface{}) {},
+		now:       time.Now,
+		full:      make(chan struct{}, 1),
+	}
+}
+
+func joinKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "/" + name
+}
+
+// maxPending is how many batches are kept while the store is unreachable;
+// older records are dropped beyond that.
+const maxPending = 10
+
+// add queues a record, signalling a flush once a batch is full.
+func (a *auditExporter) add(r auditRecord) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pending = append(a.pending, r)
+	a.trim()
+	if len(a.pending) >= a.batchSize {
+		select {
+		case a.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// trim drops the oldest records beyond maxPending batches. a.mu must be held.
+func (a *auditExporter) trim() {
+	if limit := maxPending * a.batchSize; len(a.pending) > limit {
+		dropped := len(a.pending) - limit
+		a.pending = a.pending[dropped:]
+		auditRecordsDropped.WithLabelValues(a.cluster).Add(float64(dropped))
+	}
+}
+
+// run exports every interval, and whenever a batch fills up, until ctx is
+// done, then exports what is left.
+func (a *auditExporter) run(ctx context.Context, interval time.Duration) {
+	defer auditExports.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), auditShutdownTimeout)
+			defer cancel()
+			if err := a.flush(flushCtx); err != nil {
+				a.logf("exporting audit records on shutdown: %v", err)
+			}
+			return
+		case <-ticker.C:
+		case <-a.full:
+		}
+		if err := a.flush(ctx); err != nil {
+			a.logf("exporting audit records: %v", err)
+		}
+		a.prune(ctx)
+	}
+}
+
+// flush writes the pending records in batches of AUDIT_BATCH_SIZE. A batch
+// that cannot be written is put back for the next attempt.
+func (a *auditExporter) flush(ctx context.Context) error {
+	for {
+		a.mu.Lock()
+		n := len(a.pending)
+		if n > a.batchSize {
+			n = a.batchSize
+		}
+		batch := append([]auditRecord(nil), a.pending[:n]...)
+		a.pending = a.pending[n:]
+		a.mu.Unlock()
+		if len(batch) == 0 {
+			return nil
+		}
+
+		body, err := encodeAuditBatch(batch)
+		if err != nil {
+			return err
+		}
+		a.batches++
+		key := joinKey(a.prefix, fmt.Sprintf("%s-%s-%d.jsonl.gz", a.now().UTC().Format("2006/01/02/20060102T150405.000000000Z"), a.identity, a.batches))
+		if err := a.store.put(ctx, key, "application/gzip", body); err != nil {
+			auditExportErrors.WithLabelValues(a.cluster).Inc()
+			a.mu.Lock()
+			a.pending = append(batch, a.pending...)
+			a.trim()
+			a.mu.Unlock()
+			return err
+		}
+		auditRecordsExported.WithLabelValues(a.cluster).Add(float64(len(batch)))
+	}
+}
+
+func encodeAuditBatch(batch []auditRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	for _, r := range batch {
+		if err := enc.Encode(r); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// prune deletes exported batches older than AUDIT_RETENTION, at most once per
+// auditPruneInterval. Without a retention, batches are kept, e.g. for the
+// bucket's own lifecycle rules to expire.
+func (a *auditExporter) prune(ctx context.Context) {
+	now := a.now()
+	// Standby replicas leave expiring batches to the leader.
+	if a.retention <= 0 || standingBy() || now.Sub(a.lastPrune) < auditPruneInterval {
+		return
+	}
+	a.lastPrune = now
+	objects, err := a.store.list(ctx, joinKey(a.prefix, ""))
+	if err != nil {
+		a.logf("listing audit batches to expire: %v", err)
+		return
+	}
+	for key, modified := range objects {
+		if now.Sub(modified) < a.retention {
+			continue
+		}
+		if err := a.store.delete(ctx, key); err != nil {
+			a.logf("deleting expired audit batch %s: %v", key, err)
+		}
+	}
+}
+
+// waitAuditExports waits for the exporters of stopped controllers to export
+// their last records.
+func waitAuditExports() {
+	auditExports.Wait()
+}
+
+// audit records an action on a node for AUDIT_EXPORT_URL. It is a no-op
+// without one.
+func (c *NodeLifeSupportController) audit(node, action, detail string) {
+	if c.auditor == nil {
+		return
+	}
+	c.auditor.add(auditRecord{
+		Time:       c.now().UTC(),
+		Cluster:    c.opts.Cluster,
+		Controller: c.opts.Identity,
+		Node:       node,
+		Action:     action,
+		Detail:     detail,
+	})
+}
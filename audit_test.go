@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// memStore is an in-memory objectStore.
+type memStore struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	modified map[string]time.Time
+	fail     bool
+}
+
+func (m *memStore) put(_ context.Context, key, _ string, body []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fail {
+		return errors.New("unavailable")
+	}
+	if m.objects == nil {
+		m.objects, m.modified = make(map[string][]byte), make(map[string]time.Time)
+	}
+	m.objects[key] = body
+	m.modified[key] = time.Now()
+	return nil
+}
+
+func (m *memStore) list(_ context.Context, prefix string) (map[string]time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]time.Time)
+	for k, t := range m.modified {
+		if strings.HasPrefix(k, prefix) {
+			out[k] = t
+		}
+	}
+	return out, nil
+}
+
+func (m *memStore) delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	delete(m.modified, key)
+	return nil
+}
+
+// records decodes every stored batch, in key order.
+func (m *memStore) records(t *testing.T) (keys []string, records []auditRecord) {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k := range m.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		zr, err := gzip.NewReader(bytes.NewReader(m.objects[k]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		for dec.More() {
+			var r auditRecord
+			if err := dec.Decode(&r); err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, r)
+		}
+	}
+	return keys, records
+}
+
+// TestAuditExport tests that a sync's writes are exported in batches under
+// the cluster's prefix, kept while the store is unavailable, and expired
+// after AUDIT_RETENTION.
+func TestAuditExport(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock := clocktesting.NewFakeClock(now)
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1"}}
+	opts := Options{Cluster: "edge", Identity: "nls-0", HolderIdentity: HolderIdentityNode, AuditBatchSize: 2, AuditRetention: 24 * time.Hour}
+	store := &memStore{fail: true}
+	c := &NodeLifeSupportController{client: fake.NewSimpleClientset(node), opts: opts, clock: clock}
+	c.auditor = newAuditExporter(store, "audit", &c.opts)
+	c.auditor.now = c.now
+	ctx := context.Background()
+
+	c.engage("node1")
+	if err := c.SyncNode(ctx, &metav1.PartialObjectMetadata{ObjectMeta: node.ObjectMeta}); err != nil {
+		t.Fatalf("SyncNode() error: %v", err)
+	}
+	if err := c.auditor.flush(ctx); err == nil {
+		t.Fatalf("flush() to an unavailable store succeeded")
+	}
+
+	store.mu.Lock()
+	store.fail = false
+	store.mu.Unlock()
+	if err := c.auditor.flush(ctx); err != nil {
+		t.Fatalf("flush() error: %v", err)
+	}
+	keys, records := store.records(t)
+	var actions []string
+	for _, r := range records {
+		if r.Node != "node1" || r.Cluster != "edge" || r.Controller != "nls-0" || !r.Time.Equal(now) {
+			t.Errorf("record %+v", r)
+		}
+		actions = append(actions, r.Action)
+	}
+	if got, want := strings.Join(actions, ","), "Engaged,LeaseRenewed,ReadyAsserted"; got != want {
+		t.Errorf("actions = %s, want %s", got, want)
+	}
+	want := []string{
+		"audit/edge/2024/05/01/20240501T120000.000000000Z-nls-0-2.jsonl.gz",
+		"audit/edge/2024/05/01/20240501T120000.000000000Z-nls-0-3.jsonl.gz",
+	}
+	if strings.Join(keys, " ") != strings.Join(want, " ") {
+		t.Errorf("keys = %v, want %v", keys, want)
+	}
+
+	c.auditor.lastPrune = time.Time{}
+	c.auditor.now = func() time.Time { return time.Now().Add(25 * time.Hour) }
+	c.auditor.prune(ctx)
+	if keys, _ := store.records(t); len(keys) != 0 {
+		t.Errorf("kept %v past AUDIT_RETENTION", keys)
+	}
+}
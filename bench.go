@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/util/flowcontrol"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// benchLabel labels the nodes the bench command registers on a real API
+// server; the controller benchmarked is restricted to them.
+const benchLabel = "node-life-support.io/bench"
+
+// benchLeaseDuration is the duration of the benchmarked nodes' leases.
+const benchLeaseDuration = 40 * time.Second
+
+// benchRun is the outcome of benchmarking one client rate limit.
+type benchRun struct {
+	qps   float32
+	burst int
+	// synced and failed count node syncs over all cycles, and requests
+	// and writes the API requests they made and the successful writes.
+	synced   int
+	failed   int
+	requests int
+	writes   int
+	elapsed  time.Duration
+}
+
+// nodesPerSecond is the rate nodes were synced at.
+func (r benchRun) nodesPerSecond() float64 {
+	if r.elapsed <= 0 {
+		return 0
+	}
+	return float64(r.synced) / r.elapsed.Seconds()
+}
+
+// benchNames returns the names of n benchmarked nodes.
+func benchNames(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("bench-%05d", i)
+	}
+	return names
+}
+
+// benchObjects returns the Node and Lease of a benchmarked node whose
+// kubelet stopped renewing an hour before now.
+func benchObjects(name string, now time.Time) (*v1.Node, *coordinationv1.Lease) {
+	holder := name
+	duration := int32(benchLeaseDuration / time.Second)
+	renew := metav1.NewMicroTime(now.Add(-time.Hour))
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, UID: types.UID("uid-" + name), Labels: map[string]string{benchLabel: "true"}}}
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: nodeLeaseNamespace},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &duration,
+			RenewTime:            &renew,
+		},
+	}
+	return node, lease
+}
+
+// benchOptions returns opts for benchmarking: as for simulate, with only
+// the benchmarked nodes handled, under the client rate limit qps and burst.
+func benchOptions(opts Options, qps float32, burst int) Options {
+	opts = simulationOptions(opts)
+	opts.AllowedLabelKeys = []string{benchLabel}
+	opts.ClientQPS, opts.ClientBurst = qps, burst
+	return opts
+}
+
+// benchCycles puts every node on life support, then times cycles sync
+// cycles, each a renewal interval after the last so that every node is due.
+// requests counts the controller's API requests, watches aside.
+func benchCycles(ctx context.Context, c *NodeLifeSupportController, clock *clocktesting.FakeClock, names []string, cycles int, requests *atomic.Int64) (benchRun, error) {
+	for _, name := range names {
+		c.engage(name)
+	}
+	r := benchRun{qps: c.opts.ClientQPS, burst: c.opts.ClientBurst}
+	writes, sent := c.writes.Load(), requests.Load()
+	for i := 0; i < cycles; i++ {
+		start := time.Now()
+		if err := c.SyncAllNodes(ctx); err != nil {
+			return r, err
+		}
+		r.elapsed += time.Since(start)
+		c.mu.Lock()
+		r.synced += c.lastSyncAttempts - c.lastSyncFailures
+		r.failed += c.lastSyncFailures
+		c.mu.Unlock()
+		clock.Step(c.renewInterval(nil, benchLeaseDuration))
+	}
+	r.writes = int(c.writes.Load() - writes)
+	r.requests = int(requests.Load() - sent)
+	return r, nil
+}
+
+// benchFake benchmarks syncing nodes against a fake API server, rate
+// limited as a client with opts' ClientQPS and ClientBurst would be and
+// answering each request after latency.
+func benchFake(ctx context.Context, opts Options, nodes, cycles int, latency time.Duration) (benchRun, error) {
+	now := time.Now()
+	names := benchNames(nodes)
+	d := &clusterDump{}
+	for _, name := range names {
+		node, lease := benchObjects(name, now)
+		d.nodes, d.leases = append(d.nodes, node), append(d.leases, lease)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	clock := clocktesting.NewFakeClock(now)
+	c, client, err := newFakeController(d, opts, clock)
+	if err != nil {
+		return benchRun{}, err
+	}
+	watches := &benchWatches{tracker: client.Tracker()}
+	client.PrependWatchReactor("*", watches.watch)
+	client.PrependReactor("*", "*", watches.write)
+	go func() {
+		<-ctx.Done()
+		watches.shutdown()
+	}()
+	if err := c.Start(ctx); err != nil {
+		return benchRun{}, err
+	}
+	limiter := flowcontrol.NewFakeAlwaysRateLimiter()
+	if opts.ClientQPS > 0 {
+		limiter = flowcontrol.NewTokenBucketRateLimiter(opts.ClientQPS, opts.ClientBurst)
+	}
+	var requests atomic.Int64
+	client.PrependReactor("*", "*", func(k8stesting.Action) (bool, runtime.Object, error) {
+		requests.Add(1)
+		if err := limiter.Wait(ctx); err != nil {
+			return true, nil, err
+		}
+		return false, nil, sleepContext(ctx, latency)
+	})
+	return benchCycles(ctx, c, clock, names, cycles, &requests)
+}
+
+// benchWatches serves a fake API server's watches from broadcasters that
+// make writes wait for watchers that fall behind, as an API server's would,
+// rather than from the fake server's own, which panic once one is 100 events
+// behind: informers can be when nothing slows the writes down.
+type benchWatches struct {
+	tracker      k8stesting.ObjectTracker
+	mu           sync.Mutex
+	broadcasters map[schema.GroupVersionResource]*watch.Broadcaster
+}
+
+func (b *benchWatches) broadcaster(gvr schema.GroupVersionResource) *watch.Broadcaster {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.broadcasters == nil {
+		b.broadcasters = make(map[schema.GroupVersionResource]*watch.Broadcaster)
+	}
+	bc, ok := b.broadcasters[gvr]
+	if !ok {
+		bc = watch.NewLongQueueBroadcaster(1000, watch.WaitIfChannelFull)
+		b.broadcasters[gvr] = bc
+	}
+	return bc
+}
+
+// watch is a watch reactor.
+func (b *benchWatches) watch(a k8stesting.Action) (bool, watch.Interface, error) {
+	w, err := b.broadcaster(a.GetResource()).Watch()
+	if err != nil {
+		return true, nil, err
+	}
+	ns := a.GetNamespace()
+	if ns == "" {
+		return true, w, nil
+	}
+	return true, watch.Filter(w, func(e watch.Event) (watch.Event, bool) {
+		o, err := meta.Accessor(e.Object)
+		return e, err == nil && o.GetNamespace() == ns
+	}), nil
+}
+
+// write is a reactor making writes to the tracker and broadcasting them.
+func (b *benchWatches) write(a k8stesting.Action) (bool, runtime.Object, error) {
+	var event watch.EventType
+	var deleted runtime.Object
+	switch a := a.(type) {
+	case k8stesting.CreateAction:
+		event = watch.Added
+	case k8stesting.UpdateAction, k8stesting.PatchAction:
+		event = watch.Modified
+	case k8stesting.DeleteAction:
+		event = watch.Deleted
+		deleted, _ = b.tracker.Get(a.GetResource(), a.GetNamespace(), a.GetName())
+	default:
+		return false, nil, nil
+	}
+	handled, obj, err := k8stesting.ObjectReaction(b.tracker)(a)
+	if err != nil {
+		return handled, obj, err
+	}
+	if event == watch.Deleted {
+		obj = deleted
+	}
+	if obj != nil {
+		b.broadcaster(a.GetResource()).Action(event, obj.DeepCopyObject())
+	}
+	return handled, obj, err
+}
+
+func (b *benchWatches) shutdown() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, bc := range b.broadcasters {
+		bc.Shutdown()
+	}
+}
+
+// registerBenchNodes creates nodes labelled benchLabel, with leases their
+// kubelets stopped renewing, on a real API server, returning their names and
+// a function deleting them again.
+func registerBenchNodes(ctx context.Context, client kubernetes.Interface, nodes int) ([]string, func(), error) {
+	names := benchNames(nodes)
+	now := time.Now()
+	var created []string
+	cleanup := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		for _, name := range created {
+			if err := client.CoreV1().Nodes().Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+				log.Printf("bench: deleting node %s: %v", name, err)
+			}
+			if err := client.CoordinationV1().Leases(nodeLeaseNamespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+				log.Printf("bench: deleting lease %s: %v", name, err)
+			}
+		}
+	}
+	for _, name := range names {
+		node, lease := benchObjects(name, now)
+		node.UID = ""
+		if _, err := client.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{}); err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("create node %s: %w", name, err)
+		}
+		created = append(created, name)
+		if _, err := client.CoordinationV1().Leases(nodeLeaseNamespace).Create(ctx, lease, metav1.CreateOptions{}); err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("create lease %s: %w", name, err)
+		}
+	}
+	return names, cleanup, nil
+}
+
+// benchAPI benchmarks syncing the named nodes against the API server of cfg.
+// The controller runs on a fake clock, so that every node is due each cycle.
+func benchAPI(ctx context.Context, cfg *rest.Config, opts Options, names []string, cycles int) (benchRun, error) {
+	if opts.ClientQPS == 0 {
+		// Unlimited, rather than client-go's default.
+		cfg = rest.CopyConfig(cfg)
+		cfg.QPS = -1
+	}
+	var requests atomic.Int64
+	cfg = rest.CopyConfig(cfg)
+	cfg.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &countingTransport{count: &requests, next: rt}
+	})
+	c, err := NewNodeLifeSupportController(cfg, &opts)
+	if err != nil {
+		return benchRun{}, err
+	}
+	clock := clocktesting.NewFakeClock(time.Now())
+	c.clock = clock
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if err := c.Start(ctx); err != nil {
+		return benchRun{}, err
+	}
+	return benchCycles(ctx, c, clock, names, cycles, &requests)
+}
+
+// countingTransport counts the requests made through it, watches aside.
+type countingTransport struct {
+	count *atomic.Int64
+	next  http.RoundTripper
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !watchRequest(req) {
+		t.count.Add(1)
+	}
+	return t.next.RoundTrip(req)
+}
+
+// parseBenchQPS parses a comma-separated list of client QPS; 0 is
+// unlimited.
+func parseBenchQPS(s string) ([]float32, error) {
+	var out []float32
+	for _, f := range strings.Split(s, ",") {
+		qps, err := strconv.ParseFloat(strings.TrimSpace(f), 32)
+		if err != nil || qps < 0 {
+			return nil, fmt.Errorf("-qps: %q is not a rate", f)
+		}
+		out = append(out, float32(qps))
+	}
+	return out, nil
+}
+
+// writeBenchReport writes the sizing report for runs: the rate nodes were
+// synced at under each client rate limit, how many nodes one replica can
+// therefore keep on life support renewing every renew, and how many
+// replicas fleet nodes on life support need.
+func writeBenchReport(out io.Writer, runs []benchRun, renew time.Duration, fleet int) {
+	fmt.Fprintf(out, "renewing every %s, leases of %s\n", renew, benchLeaseDuration)
+	for _, r := range runs {
+		limit := "unlimited QPS"
+		if r.qps > 0 {
+			limit = fmt.Sprintf("QPS %g burst %d", r.qps, r.burst)
+		}
+		rate := r.nodesPerSecond()
+		capacity := int(rate * renew.Seconds())
+		var requests, writes float64
+		if r.synced > 0 {
+			requests, writes = float64(r.requests)/float64(r.synced), float64(r.writes)/float64(r.synced)
+		}
+		fmt.Fprintf(out, "%s: %.1f nodes/s, %.1f requests (%.1f writes) per node, %d failed syncs\n", limit, rate, requests, writes, r.failed)
+		fmt.Fprintf(out, "  one replica keeps up to %d nodes on life support\n", capacity)
+		if fleet > 0 {
+			if capacity == 0 {
+				fmt.Fprintf(out, "  %d nodes on life support: too slow to size\n", fleet)
+			} else {
+				replicas := int(math.Ceil(float64(fleet) / float64(capacity)))
+				plural := "s"
+				if replicas == 1 {
+					plural = ""
+				}
+				fmt.Fprintf(out, "  %d nodes on life support need %d replica%s\n", fleet, replicas, plural)
+			}
+		}
+	}
+}
+
+// runBench implements the bench command: it measures how many nodes per
+// second the controller renews under each client rate limit, against a
+// fake API server or, with -kubeconfig, a real one, and writes a sizing
+// report.
+func runBench(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	nodes := fs.Int("nodes", 500, "number of nodes on life support to sync")
+	cycles := fs.Int("cycles", 3, "number of sync cycles to time")
+	qpsList := fs.String("qps", "5,20,50,100", "comma-separated client QPS to benchmark; 0 is unlimited")
+	burst := fs.Int("burst", 0, "client burst (default: twice the QPS)")
+	latency := fs.Duration("latency", 0, "latency of each request to the fake API server")
+	fleet := fs.Int("fleet", 0, "number of nodes on life support to size replicas for")
+	kubeconfig := fs.String("kubeconfig", "", "benchmark against this cluster's API server, registering fake nodes on it")
+	verbose := fs.Bool("v", false, "log what the controller does, as it would")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || *nodes <= 0 || *cycles <= 0 {
+		return fmt.Errorf("usage: bench [-nodes N] [-cycles N] [-qps LIST] [-burst N] [-latency D] [-fleet N] [-kubeconfig PATH] [-v]")
+	}
+	rates, err := parseBenchQPS(*qpsList)
+	if err != nil {
+		return err
+	}
+	opts, err := LoadOptions()
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %v", err)
+	}
+	if !*verbose {
+		w := log.Writer()
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(w)
+	}
+	// Interrupting the benchmark still removes the nodes it registered.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var cfg *rest.Config
+	var names []string
+	target := "a fake API server"
+	if *latency > 0 {
+		target += fmt.Sprintf(" answering after %s", *latency)
+	}
+	if *kubeconfig != "" {
+		if cfg, err = BuildConfig(*kubeconfig, ""); err != nil {
+			return err
+		}
+		setup := rest.CopyConfig(cfg)
+		setup.QPS, setup.Burst = 100, 200
+		client, err := kubernetes.NewForConfig(setup)
+		if err != nil {
+			return err
+		}
+		var cleanup func()
+		if names, cleanup, err = registerBenchNodes(ctx, client, *nodes); err != nil {
+			return err
+		}
+		defer cleanup()
+		target = cfg.Host
+	}
+
+	fmt.Fprintf(out, "benchmark of %d nodes on life support, %d cycles each, against %s\n", *nodes, *cycles, target)
+	var runs []benchRun
+	var renew time.Duration
+	for _, qps := range rates {
+		b := *burst
+		if b == 0 {
+			b = int(math.Max(1, float64(2*qps)))
+		}
+		o := benchOptions(*opts, qps, b)
+		var r benchRun
+		if cfg != nil {
+			r, err = benchAPI(ctx, cfg, o, names, *cycles)
+		} else {
+			r, err = benchFake(ctx, o, *nodes, *cycles, *latency)
+		}
+		if err != nil {
+			return err
+		}
+		runs = append(runs, r)
+		renew = (&NodeLifeSupportController{opts: o}).renewInterval(nil, benchLeaseDuration)
+	}
+	writeBenchReport(out, runs, renew, *fleet)
+	return nil
+}
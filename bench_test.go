@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestBenchFake tests that benchmarking against the fake API server renews
+// every node each cycle.
+func TestBenchFake(t *testing.T) {
+	opts := benchOptions(Options{HolderIdentity: HolderIdentityNode, SyncInterval: time.Minute}, 0, 1)
+	r, err := benchFake(context.Background(), opts, 20, 2, 0)
+	if err != nil {
+		t.Fatalf("benchFake() error: %v", err)
+	}
+	if r.synced != 40 || r.failed != 0 {
+		t.Errorf("synced %d nodes, %d failed, want 40 and 0", r.synced, r.failed)
+	}
+	// A lease renewal and a status patch each.
+	if r.writes != 80 {
+		t.Errorf("made %d writes, want 80", r.writes)
+	}
+	if r.requests < r.writes {
+		t.Errorf("made %d requests, fewer than %d writes", r.requests, r.writes)
+	}
+	if r.nodesPerSecond() <= 0 {
+		t.Errorf("nodesPerSecond() = %v", r.nodesPerSecond())
+	}
+}
+
+// TestBenchWatches tests that watches of the bench's fake API server get
+// every write in their namespace, however far behind they fall.
+func TestBenchWatches(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	watches := &benchWatches{tracker: client.Tracker()}
+	client.PrependWatchReactor("*", watches.watch)
+	client.PrependReactor("*", "*", watches.write)
+	defer watches.shutdown()
+	w, err := client.CoordinationV1().Leases("a").Watch(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	// Far more than the fake API server's watches hold, written before any
+	// is read.
+	for i := 0; i < 500; i++ {
+		for _, ns := range []string{"a", "b"} {
+			lease := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprint(i), Namespace: ns}}
+			if _, err := client.CoordinationV1().Leases(ns).Create(ctx, lease, metav1.CreateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := client.CoordinationV1().Leases("a").Delete(ctx, "0", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= 500; i++ {
+		e := <-w.ResultChan()
+		lease := e.Object.(*coordinationv1.Lease)
+		want, name := watch.Added, fmt.Sprint(i)
+		if i == 500 {
+			want, name = watch.Deleted, "0"
+		}
+		if e.Type != want || lease.Namespace != "a" || lease.Name != name {
+			t.Fatalf("event %d: %s %s/%s, want %s a/%s", i, e.Type, lease.Namespace, lease.Name, want, name)
+		}
+	}
+}
+
+func TestWriteBenchReport(t *testing.T) {
+	tests := []struct {
+		name  string
+		run   benchRun
+		fleet int
+		want  []string
+	}{
+		{
+			name:  "rate limited",
+			run:   benchRun{qps: 20, burst: 40, synced: 100, requests: 300, writes: 200, elapsed: 10 * time.Second},
+			fleet: 1000,
+			want: []string{
+				"QPS 20 burst 40: 10.0 nodes/s, 3.0 requests (2.0 writes) per node, 0 failed syncs\n",
+				"  one replica keeps up to 100 nodes on life support\n",
+				"  1000 nodes on life support need 10 replicas\n",
+			},
+		},
+		{
+			name:  "one replica",
+			run:   benchRun{synced: 1000, requests: 3000, writes: 2000, elapsed: time.Second},
+			fleet: 500,
+			want: []string{
+				"unlimited QPS: 1000.0 nodes/s",
+				"  500 nodes on life support need 1 replica\n",
+			},
+		},
+		{
+			name:  "too slow",
+			run:   benchRun{qps: 1, burst: 1, synced: 1, failed: 2, elapsed: time.Minute},
+			fleet: 500,
+			want: []string{
+				"2 failed syncs\n",
+				"up to 0 nodes",
+				"  500 nodes on life support: too slow to size\n",
+			},
+		},
+		{
+			name: "no fleet",
+			run:  benchRun{qps: 5, burst: 10, synced: 10, elapsed: time.Second},
+			want: []string{"  one replica keeps up to 100 nodes on life support\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			writeBenchReport(&out, []benchRun{tt.run}, 10*time.Second, tt.fleet)
+			got := out.String()
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("report does not contain %q:\n%s", want, got)
+				}
+			}
+			if tt.fleet == 0 && strings.Contains(got, "need") {
+				t.Errorf("report sizes a fleet without -fleet:\n%s", got)
+			}
+		})
+	}
+}
+
+func TestParseBenchQPS(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []float32
+		wantErr bool
+	}{
+		{in: "5,20, 50", want: []float32{5, 20, 50}},
+		{in: "0", want: []float32{0}},
+		{in: "2.5", want: []float32{2.5}},
+		{in: "5,", wantErr: true},
+		{in: "-1", wantErr: true},
+		{in: "fast", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseBenchQPS(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseBenchQPS(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseBenchQPS(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
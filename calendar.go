@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Calendar lists business-calendar exclusions (change freezes, holidays)
+// during which no node is newly put on life support. It is read from the file
+// named by CALENDAR_FILE.
+type Calendar struct {
+	// Timezone is the IANA timezone dates and schedules are expressed in.
+	// Defaults to UTC.
+	Timezone string `json:"timezone,omitempty"`
+	// DisengageExisting also takes nodes already on life support off it
+	// during an exclusion. By default they stay engaged.
+	DisengageExisting bool        `json:"disengageExisting,omitempty"`
+	Exclusions        []Exclusion `json:"exclusions"`
+
+	location *time.Location
+}
+
+// Exclusion is either a fixed period from Start to End, or a recurring one
+// given by a cron Schedule and Duration like a policy window. Start and End
+// are dates ("2024-12-24", End inclusive) or RFC 3339 times (End exclusive).
+type Exclusion struct {
+	Name     string          `json:"name"`
+	Start    string          `json:"start,omitempty"`
+	End      string          `json:"end,omitempty"`
+	Schedule string          `json:"schedule,omitempty"`
+	Duration metav1.Duration `json:"duration,omitempty"`
+
+	start, end time.Time
+	window     *Window
+}
+
+// loadCalendar reads and validates the calendar file at path.
+func loadCalendar(path string) (*Calendar, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cal Calendar
+	if err := yaml.UnmarshalStrict(raw, &cal); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := cal.compile(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &cal, nil
+}
+
+func (cal *Calendar) compile() error {
+	cal.location = time.UTC
+	if cal.Timezone != "" {
+		loc, err := time.LoadLocation(cal.Timezone)
+		if err != nil {
+			return fmt.Errorf("timezone: %w", err)
+		}
+		cal.location = loc
+	}
+	for i := range cal.Exclusions {
+		e := &cal.Exclusions[i]
+		if e.Name == "" {
+			return fmt.Errorf("exclusion %d has no name", i)
+		}
+		if err := e.compile(cal.location); err != nil {
+			return fmt.Errorf("exclusion %q: %w", e.Name, err)
+		}
+	}
+	return nil
+}
+
+func (e *Exclusion) compile(loc *time.Location) error {
+	if e.Schedule != "" {
+		if e.Start != "" || e.End != "" {
+			return fmt.Errorf("set either start/end or schedule/duration, not both")
+		}
+		e.window = &Window{Schedule: e.Schedule, Duration: e.Duration}
+		return e.window.compile()
+	}
+	if e.Start == "" || e.End == "" {
+		return fmt.Errorf("start and end are required without a schedule")
+	}
+	var err error
+	if e.start, err = parseCalendarTime(e.Start, loc, false); err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+	if e.end, err = parseCalendarTime(e.End, loc, true); err != nil {
+		return fmt.Errorf("end: %w", err)
+	}
+	if !e.end.After(e.start) {
+		return fmt.Errorf("end must be after start")
+	}
+	return nil
+}
+
+// parseCalendarTime parses a date or an RFC 3339 time. A date used as an end
+// means the end of that day.
+func parseCalendarTime(v string, loc *time.Location, end bool) (time.Time, error) {
+	if d, err := time.ParseInLocation(time.DateOnly, v, loc); err == nil {
+		if end {
+			d = d.AddDate(0, 0, 1)
+		}
+		return d, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// active returns the exclusion in effect at t, or nil.
+func (cal *Calendar) active(t time.Time) *Exclusion {
+	if cal == nil {
+		return nil
+	}
+	local := t.In(cal.location)
+	for i := range cal.Exclusions {
+		e := &cal.Exclusions[i]
+		if e.window != nil {
+			if e.window.open(local) {
+				return e
+			}
+		} else if !t.Before(e.start) && t.Before(e.end) {
+			return e
+		}
+	}
+	return nil
+}
+
+// exclusion returns the calendar exclusion in effect at now, logging when one
+// starts or ends.
+func (c *NodeLifeSupportController) exclusion(now time.Time) *Exclusion {
+	e := c.opts.Calendar.active(now)
+	name := ""
+	if e != nil {
+		name = e.Name
+	}
+	if name != c.lastExclusion {
+		if c.lastExclusion != "" {
+			c.logf("calendar exclusion %q ended", c.lastExclusion)
+		}
+		if name != "" {
+			c.logf("calendar exclusion %q in effect: no new engagements", name)
+		}
+		c.lastExclusion = name
+	}
+	return e
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeCalendarFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "calendar.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestLoadCalendar tests calendar file parsing and validation.
+func TestLoadCalendar(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		expectErr string
+	}{
+		{
+			name: "dates and schedules",
+			content: `
+timezone: Europe/London
+exclusions:
+  - name: christmas
+    start: "2024-12-24"
+    end: "2024-12-26"
+  - name: release-freeze
+    start: "2024-11-01T18:00:00Z"
+    end: "2024-11-04T08:00:00Z"
+  - name: month-end
+    schedule: "0 0 28-31 * *"
+    duration: 24h
+`,
+		},
+		{name: "no name", content: "exclusions:\n  - start: \"2024-12-24\"\n    end: \"2024-12-26\"\n", expectErr: "no name"},
+		{name: "missing end", content: "exclusions:\n  - name: x\n    start: \"2024-12-24\"\n", expectErr: "start and end are required"},
+		{name: "both forms", content: "exclusions:\n  - name: x\n    start: \"2024-12-24\"\n    end: \"2024-12-26\"\n    schedule: \"0 0 * * *\"\n    duration: 1h\n", expectErr: "not both"},
+		{name: "end before start", content: "exclusions:\n  - name: x\n    start: \"2024-12-26\"\n    end: \"2024-12-24\"\n", expectErr: "end must be after start"},
+		{name: "bad date", content: "exclusions:\n  - name: x\n    start: \"24/12/2024\"\n    end: \"2024-12-26\"\n", expectErr: "start"},
+		{name: "unknown field", content: "exclusion: []\n", expectErr: "unknown field"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadCalendar(writeCalendarFile(t, tt.content))
+			if tt.expectErr == "" && err != nil {
+				t.Fatalf("loadCalendar() error: %v", err)
+			}
+			if tt.expectErr != "" && (err == nil || !strings.Contains(err.Error(), tt.expectErr)) {
+				t.Fatalf("loadCalendar() error = %v, want %q", err, tt.expectErr)
+			}
+		})
+	}
+}
+
+// TestCalendarActive tests which exclusion, if any, is in effect.
+func TestCalendarActive(t *testing.T) {
+	cal, err := loadCalendar(writeCalendarFile(t, `
+timezone: Europe/London
+exclusions:
+  - name: christmas
+    start: "2024-12-24"
+    end: "2024-12-26"
+  - name: weekend
+    schedule: "0 0 * * 6"
+    duration: 48h
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		at       time.Time
+		expected string
+	}{
+		{at: time.Date(2024, 12, 23, 23, 59, 0, 0, time.UTC), expected: ""},
+		{at: time.Date(2024, 12, 24, 0, 0, 0, 0, time.UTC), expected: "christmas"},
+		{at: time.Date(2024, 12, 26, 23, 59, 0, 0, time.UTC), expected: "christmas"},
+		{at: time.Date(2024, 12, 27, 0, 0, 0, 0, time.UTC), expected: ""},
+		// Saturday 00:30 in London (BST) is Friday 23:30 UTC.
+		{at: time.Date(2024, 6, 7, 23, 30, 0, 0, time.UTC), expected: "weekend"},
+		{at: time.Date(2024, 6, 10, 9, 0, 0, 0, time.UTC), expected: ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if e := cal.active(tt.at); e != nil {
+			got = e.Name
+		}
+		if got != tt.expected {
+			t.Errorf("active(%s) = %q, want %q", tt.at, got, tt.expected)
+		}
+	}
+
+	var none *Calendar
+	if none.active(time.Now()) != nil {
+		t.Errorf("nil calendar has an active exclusion")
+	}
+}
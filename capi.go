@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// capiClusters are Cluster API's Cluster resources in the management cluster.
+var capiClusters = schema.GroupVersionResource{Group: "cluster.x-k8s.io", Version: "v1beta1", Resource: "clusters"}
+
+// capiKubeconfigKey is the key of a workload cluster's kubeconfig in the
+// <cluster>-kubeconfig Secret Cluster API maintains.
+const capiKubeconfigKey = "value"
+
+// capiDiscovery supervises the workload clusters of a Cluster API management
+// cluster, starting a controller for each one once its control plane is ready
+// and stopping it when the Cluster goes away.
+type capiDiscovery struct {
+	clusters dynamic.Interface
+	secrets  kubernetes.Interface
+	base     *Options
+	// start supervises a discovered cluster until ctx is done.
+	start func(ctx context.Context, name string, cfg *rest.Config, opts *Options)
+
+	running map[string]*discoveredCluster
+	// failed are clusters that could not be started, reported on /status
+	// until they are started or no longer discovered.
+	failed map[string]struct{}
+}
+
+type discoveredCluster struct {
+	cancel context.CancelFunc
+	// secretVersion is the resourceVersion of the kubeconfig Secret the
+	// controller was started with; a rotated kubeconfig restarts it.
+	secretVersion string
+}
+
+func newCAPIDiscovery(cfg *rest.Config, base *Options) (*capiDiscovery, error) {
+	clusters, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &capiDiscovery{
+		clusters: clusters,
+		secrets:  secrets,
+		base:     base,
+		start:    superviseCluster,
+		running:  make(map[string]*discoveredCluster),
+		failed:   make(map[string]struct{}),
+	}, nil
+}
+
+// run reconciles the supervised clusters every interval until ctx is done.
+func (d *capiDiscovery) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := d.reconcile(ctx); err != nil {
+			log.Printf("cluster API discovery: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reconcile starts controllers for new ready clusters, restarts those whose
+// kubeconfig was rotated and stops those whose Cluster is gone or deleting.
+func (d *capiDiscovery) reconcile(ctx context.Context) error {
+	list, err := d.clusters.Resource(capiClusters).Namespace(d.base.ClusterAPINamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: d.base.ClusterAPISelector,
+	})
+	if err != nil {
+		return fmt.Errorf("listing clusters: %w", err)
+	}
+
+	seen := make(map[string]struct{})
+	for i := range list.Items {
+		cl := &list.Items[i]
+		if cl.GetDeletionTimestamp() != nil || !capiControlPlaneReady(cl) {
+			continue
+		}
+		name := cl.GetNamespace() + "/" + cl.GetName()
+		seen[name] = struct{}{}
+		if err := d.ensure(ctx, name, cl); err != nil {
+			log.Printf("cluster %s: %v", name, err)
+			if _, ok := d.running[name]; !ok {
+				d.failed[name] = struct{}{}
+				fleetRegistry.register(name, nil, err)
+			}
+		}
+	}
+	for name, dc := range d.running {
+		if _, ok := seen[name]; !ok {
+			log.Printf("cluster %s: no longer discovered, stopping", name)
+			d.stop(name, dc)
+		}
+	}
+	for name := range d.failed {
+		if _, ok := seen[name]; !ok {
+			delete(d.failed, name)
+			fleetRegistry.unregister(name)
+		}
+	}
+	return nil
+}
+
+// ensure makes sure the cluster is supervised with its current kubeconfig.
+func (d *capiDiscovery) ensure(ctx context.Context, name string, cl *unstructured.Unstructured) error {
+	secret, err := d.secrets.CoreV1().Secrets(cl.GetNamespace()).Get(ctx, cl.GetName()+"-kubeconfig", metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("reading kubeconfig: %w", err)
+	}
+	dc, ok := d.running[name]
+	if ok && dc.secretVersion == secret.ResourceVersion {
+		return nil
+	}
+	cfg, err := clientcmd.RESTConfigFromKubeConfig(secret.Data[capiKubeconfigKey])
+	if err != nil {
+		return fmt.Errorf("parsing kubeconfig: %w", err)
+	}
+	opts, err := ClusterSpec{Name: name}.options(d.base)
+	if err != nil {
+		return err
+	}
+	if ok {
+		log.Printf("cluster %s: kubeconfig rotated, restarting", name)
+		d.stop(name, dc)
+	} else {
+		log.Printf("cluster %s: discovered", name)
+	}
+	clusterCtx, cancel := context.WithCancel(ctx)
+	delete(d.failed, name)
+	d.running[name] = &discoveredCluster{cancel: cancel, secretVersion: secret.ResourceVersion}
+	go d.start(clusterCtx, name, cfg, opts)
+	return nil
+}
+
+func (d *capiDiscovery) stop(name string, dc *discoveredCluster) {
+	dc.cancel()
+	delete(d.running, name)
+	fleetRegistry.unregister(name)
+	forgetClusterMetrics(name)
+}
+
+// capiControlPlaneReady reports whether a Cluster's control plane is up, so
+// its kubeconfig Secret exists and its API server can be reached.
+func capiControlPlaneReady(cl *unstructured.Unstructured) bool {
+	ready, _, _ := unstructured.NestedBool(cl.Object, "status", "controlPlaneReady")
+	return ready
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+const workloadKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: workload
+  cluster:
+    server: https://workload.example:6443
+contexts:
+- name: workload
+  context:
+    cluster: workload
+    user: admin
+current-context: workload
+users:
+- name: admin
+  user:
+    token: secret
+`
+
+func capiCluster(namespace, name string, ready bool, deleting bool) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cluster.x-k8s.io/v1beta1",
+		"kind":       "Cluster",
+		"metadata":   map[string]interface{}{"namespace": namespace, "name": name},
+		"status":     map[string]interface{}{"controlPlaneReady": ready},
+	}}
+	if deleting {
+		now := metav1.Now()
+		u.SetDeletionTimestamp(&now)
+	}
+	return u
+}
+
+func kubeconfigSecret(namespace, cluster, version string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: cluster + "-kubeconfig", ResourceVersion: version},
+		Data:       map[string][]byte{capiKubeconfigKey: []byte(workloadKubeconfig)},
+	}
+}
+
+func TestCAPIDiscoveryReconcile(t *testing.T) {
+	scheme := runtime.NewScheme()
+	clusters := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(scheme,
+		map[schema.GroupVersionResource]string{capiClusters: "ClusterList"},
+		capiCluster("edge", "a", true, false),
+		capiCluster("edge", "b", false, false),
+		capiCluster("edge", "c", true, true),
+		capiCluster("edge", "d", true, false),
+	)
+	secrets := fake.NewSimpleClientset(kubeconfigSecret("edge", "a", "1"))
+
+	started := make(chan string, 10)
+	d := &capiDiscovery{
+		clusters: clusters,
+		secrets:  secrets,
+		base:     &Options{},
+		start: func(ctx context.Context, name string, cfg *rest.Config, opts *Options) {
+			if cfg.Host != "https://workload.example:6443" || opts.Cluster != name {
+				t.Errorf("cluster %s started with host %q, options for %q", name, cfg.Host, opts.Cluster)
+			}
+			started <- name
+		},
+		running: make(map[string]*discoveredCluster),
+		failed:  make(map[string]struct{}),
+	}
+	ctx := context.Background()
+
+	if err := d.reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-started; got != "edge/a" {
+		t.Errorf("started %s, want edge/a", got)
+	}
+	if _, ok := d.failed["edge/d"]; !ok || len(d.running) != 1 {
+		t.Fatalf("running %v, failed %v; want edge/a running and edge/d failed", d.running, d.failed)
+	}
+
+	// Unchanged: nothing restarts. Then the kubeconfig is rotated.
+	if err := d.reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := secrets.CoreV1().Secrets("edge").Update(ctx, kubeconfigSecret("edge", "a", "2"), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-started; got != "edge/a" || len(started) != 0 {
+		t.Errorf("expected exactly one restart of edge/a, got %s and %d more", got, len(started))
+	}
+
+	// Deleting the Cluster stops its controller.
+	if err := clusters.Resource(capiClusters).Namespace("edge").Delete(ctx, "a", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := clusters.Resource(capiClusters).Namespace("edge").Delete(ctx, "d", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.running) != 0 || len(d.failed) != 0 {
+		t.Errorf("running %v, failed %v after deletion; want none", d.running, d.failed)
+	}
+}
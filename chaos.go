@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Kinds of fault injected by chaos, the kind label of
+// node_life_support_chaos_faults_total.
+const (
+	chaosError   = "error"
+	chaosDrop    = "drop"
+	chaosLatency = "latency"
+)
+
+// chaos injects faults into the controller's API requests with CHAOS_*, to
+// exercise retries, partial syncs, quarantine and outage alerts on demand.
+// It is for testing only.
+type chaos struct {
+	cluster   string
+	errorRate float64
+	dropRate  float64
+	latency   time.Duration
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// chaosEnabled reports whether any CHAOS_* fault is configured.
+func (o *Options) chaosEnabled() bool {
+	return o.ChaosErrorRate > 0 || o.ChaosDropRate > 0 || o.ChaosLatency > 0
+}
+
+// newChaos returns the fault injector for opts, seeded with CHAOS_SEED so
+// that a run can be repeated.
+func newChaos(opts *Options) *chaos {
+	log.Printf("chaos: injecting API errors into %.0f%% and dropping %.0f%% of writes, with up to %s latency (seed %d); do not use on a cluster that matters",
+		opts.ChaosErrorRate*100, opts.ChaosDropRate*100, opts.ChaosLatency, opts.ChaosSeed)
+	return &chaos{
+		cluster:   opts.Cluster,
+		errorRate: opts.ChaosErrorRate,
+		dropRate:  opts.ChaosDropRate,
+		latency:   opts.ChaosLatency,
+		rand:      rand.New(rand.NewSource(opts.ChaosSeed)),
+	}
+}
+
+// wrap is a rest.Config WrapTransport injecting faults into requests.
+func (k *chaos) wrap(rt http.RoundTripper) http.RoundTripper {
+	return &chaosTransport{chaos: k, next: rt}
+}
+
+type chaosTransport struct {
+	chaos *chaos
+	next  http.RoundTripper
+}
+
+// chaosFaults are the faults to inject into one request.
+type chaosFaults struct {
+	delay time.Duration
+	drop  bool
+	err   bool
+	code  int
+}
+
+// faults draws the faults for a request. Watches are left alone, so that
+// informers are not forever relisting; only writes are dropped.
+func (k *chaos) faults(req *http.Request) chaosFaults {
+	if req.URL.Query().Get("watch") == "true" {
+		return chaosFaults{}
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	var f chaosFaults
+	if k.latency > 0 {
+		f.delay = time.Duration(k.rand.Int63n(int64(k.latency)))
+	}
+	if req.Method != http.MethodGet && k.rand.Float64() < k.dropRate {
+		f.drop = true
+		return f
+	}
+	if k.rand.Float64() < k.errorRate {
+		f.err = true
+		f.code = []int{http.StatusInternalServerError, http.StatusServiceUnavailable}[k.rand.Intn(2)]
+	}
+	return f
+}
+
+func (t *chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f := t.chaos.faults(req)
+	if f.delay > 0 {
+		chaosFaultsTotal.WithLabelValues(t.chaos.cluster, chaosLatency).Inc()
+		if err := sleepContext(req.Context(), f.delay); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case f.drop:
+		// As if the connection was lost on the way: the API server never
+		// sees the write, and the controller gets no response.
+		chaosFaultsTotal.WithLabelValues(t.chaos.cluster, chaosDrop).Inc()
+		return nil, fmt.Errorf("chaos: %s %s dropped", req.Method, req.URL.Path)
+	case f.err:
+		chaosFaultsTotal.WithLabelValues(t.chaos.cluster, chaosError).Inc()
+		return chaosResponse(req, f.code), nil
+	}
+	return t.next.RoundTrip(req)
+}
+
+// chaosResponse is an API server error response with code, without the
+// request reaching the API server.
+func chaosResponse(req *http.Request, code int) *http.Response {
+	status := metav1.Status{
+		TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   metav1.StatusFailure,
+		Code:     int32(code),
+		Reason:   metav1.StatusReasonInternalError,
+		Message:  "injected by CHAOS_ERROR_RATE",
+	}
+	if code == http.StatusServiceUnavailable {
+		status.Reason = metav1.StatusReasonServiceUnavailable
+	}
+	body, _ := json.Marshal(status)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		StatusCode:    code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// sleepContext sleeps for d, or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// TestChaosFaults tests which requests each CHAOS_* rate applies to.
+func TestChaosFaults(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      Options
+		method    string
+		url       string
+		wantSent  bool
+		wantError bool
+		wantCode  int
+	}{
+		{
+			name:     "errors",
+			opts:     Options{ChaosErrorRate: 1},
+			method:   http.MethodGet,
+			url:      "https://api/api/v1/nodes",
+			wantCode: -1,
+		},
+		{
+			name:      "dropped write",
+			opts:      Options{ChaosDropRate: 1},
+			method:    http.MethodPatch,
+			url:       "https://api/api/v1/nodes/node1/status",
+			wantError: true,
+		},
+		{
+			name:     "read not dropped",
+			opts:     Options{ChaosDropRate: 1},
+			method:   http.MethodGet,
+			url:      "https://api/api/v1/nodes",
+			wantSent: true,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "watch left alone",
+			opts:     Options{ChaosErrorRate: 1, ChaosDropRate: 1},
+			method:   http.MethodGet,
+			url:      "https://api/api/v1/nodes?watch=true",
+			wantSent: true,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "none",
+			opts:     Options{ChaosErrorRate: 0},
+			method:   http.MethodPatch,
+			url:      "https://api/api/v1/nodes/node1/status",
+			wantSent: true,
+			wantCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent := false
+			rt := newChaos(&tt.opts).wrap(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				sent = true
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+			}))
+			req, err := http.NewRequest(tt.method, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := rt.RoundTrip(req)
+			if sent != tt.wantSent {
+				t.Errorf("sent = %v, want %v", sent, tt.wantSent)
+			}
+			if (err != nil) != tt.wantError {
+				t.Fatalf("RoundTrip() error = %v, want error %v", err, tt.wantError)
+			}
+			if err != nil {
+				return
+			}
+			if tt.wantCode == -1 {
+				if resp.StatusCode != http.StatusInternalServerError && resp.StatusCode != http.StatusServiceUnavailable {
+					t.Errorf("status = %d, want a server error", resp.StatusCode)
+				}
+			} else if resp.StatusCode != tt.wantCode {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+		})
+	}
+}
+
+// TestChaosSeed tests that the same CHAOS_SEED injects the same faults.
+func TestChaosSeed(t *testing.T) {
+	run := func(seed int64) []bool {
+		rt := newChaos(&Options{ChaosErrorRate: 0.5, ChaosSeed: seed}).wrap(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+		}))
+		var failed []bool
+		for i := 0; i < 32; i++ {
+			req, _ := http.NewRequest(http.MethodGet, "https://api/api/v1/nodes", nil)
+			resp, err := rt.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			failed = append(failed, resp.StatusCode != http.StatusOK)
+		}
+		return failed
+	}
+	first := run(7)
+	if again := run(7); !reflect.DeepEqual(first, again) {
+		t.Errorf("seed 7 injected %v, then %v", first, again)
+	}
+	if other := run(8); reflect.DeepEqual(first, other) {
+		t.Errorf("seeds 7 and 8 both injected %v", first)
+	}
+}
+
+// TestChaosAPIErrors tests that client-go sees injected errors as the API
+// server's own, and that the API server never sees the request.
+func TestChaosAPIErrors(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	cfg := &rest.Config{Host: srv.URL}
+	cfg.Wrap(newChaos(&Options{ChaosErrorRate: 1}).wrap)
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.CoreV1().Nodes().Patch(context.Background(), "node1", types.MergePatchType, []byte(`{}`), metav1.PatchOptions{}, "status")
+	if !apierrors.IsInternalError(err) && !apierrors.IsServiceUnavailable(err) {
+		t.Errorf("Patch() error = %v, want an internal error or service unavailable", err)
+	}
+	if !retriable(err) {
+		t.Errorf("injected error %v not retriable", err)
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("API server got %d requests", n)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// ClusterFile is the format of the file named by CLUSTERS_FILE.
+type ClusterFile struct {
+	Clusters []ClusterSpec `json:"clusters"`
+}
+
+// ClusterSpec is one cluster supervised by the controller in multi-cluster
+// mode. Settings not given here are taken from the environment as usual.
+type ClusterSpec struct {
+	// Name identifies the cluster in logs, metrics and notifications.
+	Name string `json:"name"`
+	// Kubeconfig and Context locate the cluster's API server. Without a
+	// kubeconfig the controller's own cluster is used.
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	Context    string `json:"context,omitempty"`
+	// APIServers are additional endpoints of the cluster's API server to
+	// fail over to, like API_SERVERS in single-cluster mode.
+	APIServers []string `json:"apiServers,omitempty"`
+	// NodeLabelAllowlist and KeepAliveLeases override NODE_LABEL_ALLOWLIST
+	// and KEEPALIVE_LEASES for this cluster.
+	NodeLabelAllowlist []string `json:"nodeLabelAllowlist,omitempty"`
+	KeepAliveLeases    []string `json:"keepAliveLeases,omitempty"`
+}
+
+// loadClusters reads and validates the cluster file at path.
+func loadClusters(path string) ([]ClusterSpec, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f ClusterFile
+	if err := yaml.UnmarshalStrict(raw, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(f.Clusters) == 0 {
+		return nil, fmt.Errorf("%s: no clusters", path)
+	}
+	seen := make(map[string]struct{})
+	for i, s := range f.Clusters {
+		if errs := validation.IsDNS1123Label(s.Name); len(errs) > 0 {
+			return nil, fmt.Errorf("%s: cluster %d: invalid name %q: %s", path, i, s.Name, errs[0])
+		}
+		if _, ok := seen[s.Name]; ok {
+			return nil, fmt.Errorf("%s: duplicate cluster %q", path, s.Name)
+		}
+		seen[s.Name] = struct{}{}
+		if _, err := parseKeepAliveLeases(s.KeepAliveLeases); err != nil {
+			return nil, fmt.Errorf("%s: cluster %q: %w", path, s.Name, err)
+		}
+	}
+	return f.Clusters, nil
+}
+
+// options returns base adjusted for the cluster.
+func (s ClusterSpec) options(base *Options) (*Options, error) {
+	o := *base
+	o.Cluster = s.Name
+	o.Clusters = nil
+	o.APIServers = s.APIServers
+	if s.NodeLabelAllowlist != nil {
+		o.AllowedLabelKeys = s.NodeLabelAllowlist
+	}
+	if s.KeepAliveLeases != nil {
+		var err error
+		if o.KeepAliveLeases, err = parseKeepAliveLeases(s.KeepAliveLeases); err != nil {
+			return nil, err
+		}
+	}
+	return &o, nil
+}
+
+// logf logs a message, prefixed with the cluster name in multi-cluster mode.
+func (c *NodeLifeSupportController) logf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if c.opts.Cluster != "" {
+		msg = "cluster " + c.opts.Cluster + ": " + msg
+	}
+	log.Print(msg)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+  - name: a
+    cluster:
+      server: https://a.example.com:6443
+  - name: b
+    cluster:
+      server: https://b.example.com:6443
+users:
+  - name: u
+    user:
+      token: secret
+contexts:
+  - name: ctx-a
+    context: {cluster: a, user: u}
+  - name: ctx-b
+    context: {cluster: b, user: u}
+current-context: ctx-a
+`
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestLoadClusters tests cluster file parsing and validation.
+func TestLoadClusters(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		expectErr string
+	}{
+		{name: "valid", content: "clusters:\n  - name: edge-01\n    kubeconfig: /k/edge-01\n    nodeLabelAllowlist: [edge]\n  - name: local\n"},
+		{name: "empty", content: "clusters: []\n", expectErr: "no clusters"},
+		{name: "bad name", content: "clusters:\n  - name: Edge_01\n", expectErr: "invalid name"},
+		{name: "duplicate", content: "clusters:\n  - name: a\n  - name: a\n", expectErr: "duplicate cluster"},
+		{name: "bad keepalive", content: "clusters:\n  - name: a\n    keepAliveLeases: [nonamespace]\n", expectErr: "KEEPALIVE_LEASES"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadClusters(writeFile(t, "clusters.yaml", tt.content))
+			if tt.expectErr == "" && err != nil {
+				t.Fatalf("loadClusters() error: %v", err)
+			}
+			if tt.expectErr != "" && (err == nil || !strings.Contains(err.Error(), tt.expectErr)) {
+				t.Fatalf("loadClusters() error = %v, want %q", err, tt.expectErr)
+			}
+		})
+	}
+}
+
+// TestClusterSpec tests per-cluster overrides.
+func TestClusterSpec(t *testing.T) {
+	base := &Options{AllowedLabelKeys: []string{"global"}, SyncInterval: 5}
+
+	opts, err := ClusterSpec{Name: "b", NodeLabelAllowlist: []string{"edge"}, KeepAliveLeases: []string{"ns/x=30s"}}.options(base)
+	if err != nil {
+		t.Fatalf("options() error: %v", err)
+	}
+	if opts.Cluster != "b" || len(opts.AllowedLabelKeys) != 1 || opts.AllowedLabelKeys[0] != "edge" || len(opts.KeepAliveLeases) != 1 {
+		t.Errorf("options() = %+v", opts)
+	}
+	if base.AllowedLabelKeys[0] != "global" || base.Cluster != "" {
+		t.Errorf("options() modified the base options")
+	}
+	if opts, _ := (ClusterSpec{Name: "c"}).options(base); opts.AllowedLabelKeys[0] != "global" {
+		t.Errorf("cluster without an allowlist did not inherit NODE_LABEL_ALLOWLIST")
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"text/template"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// lifeSupportCondition is the node condition telling consumers of node
+// conditions that the node's readiness is asserted by the controller rather
+// than its kubelet.
+const lifeSupportCondition v1.NodeConditionType = "LifeSupportActive"
+
+// Defaults of READY_REASON_TEMPLATE and READY_MESSAGE_TEMPLATE.
+const (
+	defaultReadyReason  = "NodeLifeSupportOverride"
+	defaultReadyMessage = "node-life-support controller asserting node health."
+)
+
+// conditionTemplateData is passed to READY_REASON_TEMPLATE and
+// READY_MESSAGE_TEMPLATE.
+type conditionTemplateData struct {
+	Node string
+	// Cluster is empty in single-cluster mode, and Policy without a policy
+	// file or when the node's expires-at annotation applies.
+	Cluster      string
+	Policy       string
+	EngagedSince time.Time
+	Controller   string
+	Version      string
+}
+
+// parseConditionTemplate parses the template in the named environment
+// variable, or returns nil if it is unset.
+func parseConditionTemplate(name string) (*template.Template, error) {
+	v := envString(name, "")
+	if v == "" {
+		return nil, nil
+	}
+	t, err := template.New(name).Option("missingkey=error").Parse(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	// Catch references to fields that do not exist before the first sync.
+	if err := t.Execute(&bytes.Buffer{}, conditionTemplateData{}); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return t, nil
+}
+
+// readyCondition returns the Ready condition asserted for a node, its reason
+// and message rendered from READY_REASON_TEMPLATE and READY_MESSAGE_TEMPLATE.
+func (c *NodeLifeSupportController) readyCondition(node *metav1.PartialObjectMetadata, now time.Time) (v1.NodeCondition, error) {
+	ready := v1.NodeCondition{
+		Type:               v1.NodeReady,
+		Status:             v1.ConditionTrue,
+		LastHeartbeatTime:  metav1.Time{Time: now},
+		LastTransitionTime: metav1.Time{Time: now},
+		Reason:             defaultReadyReason,
+		Message:            defaultReadyMessage,
+	}
+	if c.opts.ReadyReasonTemplate == nil && c.opts.ReadyMessageTemplate == nil {
+		return ready, nil
+	}
+	data := conditionTemplateData{Node: node.Name, Cluster: c.opts.Cluster, Controller: c.opts.Identity, Version: version}
+	if p := c.policyFor(node); p != nil {
+		data.Policy = p.Name
+	}
+	if since, ok := c.engagedSince(node.Name); ok {
+		data.EngagedSince = since.UTC()
+	}
+	for _, f := range []struct {
+		tmpl *template.Template
+		out  *string
+	}{
+		{c.opts.ReadyReasonTemplate, &ready.Reason},
+		{c.opts.ReadyMessageTemplate, &ready.Message},
+	} {
+		if f.tmpl == nil {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := f.tmpl.Execute(&buf, data); err != nil {
+			return ready, fmt.Errorf("render %s: %w", f.tmpl.Name(), err)
+		}
+		*f.out = buf.String()
+	}
+	return ready, nil
+}
+
+// lifeSupportActive returns the condition written alongside Ready for a node
+// on life support since the given time, which is its transition time.
+func lifeSupportActive(since, now time.Time) v1.NodeCondition {
+	return v1.NodeCondition{
+		Type:               lifeSupportCondition,
+		Status:             v1.ConditionTrue,
+		LastHeartbeatTime:  metav1.Time{Time: now},
+		LastTransitionTime: metav1.Time{Time: since.UTC()},
+		Reason:             "NodeLifeSupportEngaged",
+		Message:            fmt.Sprintf("node-life-support controller asserting node health since %s.", since.UTC().Format(time.RFC3339)),
+	}
+}
+
+// clearLifeSupportCondition sets a node's LifeSupportActive condition to
+// False as it leaves life support. Unlike ForceNodeReady it patches only that
+// condition, leaving those the kubelet has written since alone.
+func (c *NodeLifeSupportController) clearLifeSupportCondition(ctx context.Context, nodeName string) error {
+	now := c.now().UTC()
+	patch := map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []v1.NodeCondition{{
+				Type:               lifeSupportCondition,
+				Status:             v1.ConditionFalse,
+				LastHeartbeatTime:  metav1.Time{Time: now},
+				LastTransitionTime: metav1.Time{Time: now},
+				Reason:             "NodeLifeSupportDisengaged",
+				Message:            "node-life-support controller no longer asserting node health.",
+			}},
+		},
+	}
+	raw, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	c.debugPayload(nodeName, "patching status", raw)
+
+	client, err := c.nodeClient(nodeName)
+	if err != nil {
+		return err
+	}
+	err = c.retryWrite(ctx, func(ctx context.Context) error {
+		_, err := client.CoreV1().Nodes().Patch(ctx, nodeName, types.StrategicMergePatchType, raw, metav1.PatchOptions{}, "status")
+		return err
+	})
+	if err == nil {
+		c.audit(nodeName, auditConditionCleared, "")
+	}
+	return err
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func nodeCondition(t *testing.T, client *fake.Clientset, name string, typ v1.NodeConditionType) *v1.NodeCondition {
+	t.Helper()
+	n, err := client.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range n.Status.Conditions {
+		if n.Status.Conditions[i].Type == typ {
+			return &n.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// TestLifeSupportCondition tests that an engaged node reports
+// LifeSupportActive=True from when it was engaged, and False once
+// disengaged, leaving the conditions its kubelet has written since alone.
+func TestLifeSupportCondition(t *testing.T) {
+	since := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1"}}
+	client := fake.NewSimpleClientset(node)
+	clock := clocktesting.NewFakeClock(since)
+	c := &NodeLifeSupportController{client: client, opts: Options{HolderIdentity: HolderIdentityNode}, clock: clock}
+	ctx := context.Background()
+	meta := &metav1.PartialObjectMetadata{ObjectMeta: node.ObjectMeta}
+
+	c.engage("node1")
+	clock.Step(time.Minute)
+	if err := c.SyncNode(ctx, meta); err != nil {
+		t.Fatalf("SyncNode() error: %v", err)
+	}
+	got := nodeCondition(t, client, "node1", lifeSupportCondition)
+	if got == nil || got.Status != v1.ConditionTrue || !got.LastTransitionTime.Time.Equal(since) || !got.LastHeartbeatTime.Time.Equal(since.Add(time.Minute)) {
+		t.Fatalf("condition = %+v, want True since %v", got, since)
+	}
+
+	// The kubelet is back and reports its own conditions.
+	n, err := client.CoreV1().Nodes().Get(ctx, "node1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Status.Conditions = append(n.Status.Conditions, v1.NodeCondition{Type: v1.NodeMemoryPressure, Status: v1.ConditionFalse})
+	if _, err := client.CoreV1().Nodes().UpdateStatus(ctx, n, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.disengage(ctx, "node1", disengageOperatorDisabled); err != nil {
+		t.Fatalf("disengage() error: %v", err)
+	}
+	if got := nodeCondition(t, client, "node1", lifeSupportCondition); got == nil || got.Status != v1.ConditionFalse {
+		t.Errorf("condition after disengage = %+v, want False", got)
+	}
+	if nodeCondition(t, client, "node1", v1.NodeMemoryPressure) == nil {
+		t.Errorf("kubelet's MemoryPressure condition removed on disengage")
+	}
+}
+
+// TestReadyCondition tests rendering the Ready condition's reason and message
+// from READY_REASON_TEMPLATE and READY_MESSAGE_TEMPLATE.
+func TestReadyCondition(t *testing.T) {
+	since := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	policy := &Policy{Name: "edge"}
+	if err := policy.compile(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name            string
+		reason, message string
+		expectReason    string
+		expectMessage   string
+		expectErr       bool
+	}{
+		{name: "defaults", expectReason: defaultReadyReason, expectMessage: defaultReadyMessage},
+		{
+			name:          "message",
+			message:       `Asserted by {{.Controller}} ({{.Version}}) under {{.Policy}} since {{.EngagedSince.Format "2006-01-02T15:04Z07:00"}}, see https://runbooks.example.com/{{.Node}}`,
+			expectReason:  defaultReadyReason,
+			expectMessage: "Asserted by nls-0 (dev) under edge since 2024-05-01T12:00Z, see https://runbooks.example.com/node1",
+		},
+		{name: "reason", reason: "LifeSupportINC1234", expectReason: "LifeSupportINC1234", expectMessage: defaultReadyMessage},
+		{name: "unknown field", message: "{{.Ticket}}", expectErr: true},
+		{name: "syntax error", reason: "{{.Node", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("READY_REASON_TEMPLATE", tt.reason)
+			t.Setenv("READY_MESSAGE_TEMPLATE", tt.message)
+			reason, reasonErr := parseConditionTemplate("READY_REASON_TEMPLATE")
+			message, messageErr := parseConditionTemplate("READY_MESSAGE_TEMPLATE")
+			if failed := reasonErr != nil || messageErr != nil; failed != tt.expectErr {
+				t.Fatalf("parse errors %v, %v; expectErr %v", reasonErr, messageErr, tt.expectErr)
+			}
+			if tt.expectErr {
+				return
+			}
+
+			c := &NodeLifeSupportController{
+				clock: clocktesting.NewFakeClock(since),
+				opts: Options{
+					Identity:             "nls-0",
+					Policies:             []*Policy{policy},
+					ReadyReasonTemplate:  reason,
+					ReadyMessageTemplate: message,
+				},
+			}
+			c.engage("node1")
+			ready, err := c.readyCondition(&metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}, since)
+			if err != nil {
+				t.Fatalf("readyCondition() error: %v", err)
+			}
+			if ready.Reason != tt.expectReason || ready.Message != tt.expectMessage {
+				t.Errorf("reason %q, message %q; want %q, %q", ready.Reason, ready.Message, tt.expectReason, tt.expectMessage)
+			}
+		})
+	}
+}
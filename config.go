@@ -0,0 +1,751 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Options holds the controller settings. They are read from environment
+// variables so the controller can be configured from a plain Deployment.
+type Options struct {
+	// AllowedLabelKeys restricts the controller to nodes carrying at least one
+	// of these label keys. Empty means all nodes.
+	AllowedLabelKeys []string
+	// APITimeout bounds each individual lease or status call, so one slow
+	// request cannot hold up heartbeats for every other node.
+	APITimeout time.Duration
+	// APIRetries is how many times a write failing with a conflict or a
+	// server error is retried, with backoff, within a sync.
+	APIRetries int
+	// APIServers are additional endpoints of the same API server to fail
+	// over to, checked for readiness every APIServerCheckInterval.
+	APIServers             []string
+	APIServerCheckInterval time.Duration
+	// APIProxy, if set, is the proxy for API traffic, bypassed for
+	// APINoProxy hosts. APICAFile and APIClientCertFile/APIClientKeyFile
+	// override the kubeconfig's CA bundle and client certificate.
+	APIProxy          string
+	APINoProxy        []string
+	APICAFile         string
+	APIClientCertFile string
+	APIClientKeyFile  string
+	// RBACCheck checks with SelfSubjectAccessReviews that the controller has
+	// every permission its enabled features need, at startup and then every
+	// RBACCheckInterval.
+	RBACCheck         bool
+	RBACCheckInterval time.Duration
+	// PreflightCheck checks the API server's /readyz before each sync cycle,
+	// only renewing leases while it is not ready.
+	PreflightCheck bool
+	// CredentialReloadInterval is how often kubeconfigs are checked for
+	// rotated embedded credentials; zero disables reloading.
+	CredentialReloadInterval time.Duration
+	// ClientQPS and ClientBurst configure client-side rate limiting of API
+	// requests. Zero keeps client-go's defaults.
+	ClientQPS   float32
+	ClientBurst int
+	// ChaosErrorRate and ChaosDropRate are the fractions of API requests
+	// failed with a server error and of writes dropped, and ChaosLatency the
+	// most added to each request, from a random sequence seeded with
+	// ChaosSeed. For testing only.
+	ChaosErrorRate float64
+	ChaosDropRate  float64
+	ChaosLatency   time.Duration
+	ChaosSeed      int64
+	// APIRecordFile, if set, is the file every API request and response is
+	// appended to; APIReplayFile, if set, a recording answering requests in
+	// place of the API server.
+	APIRecordFile string
+	APIReplayFile string
+	// ResyncPeriod is the node informers' resync period; zero disables it.
+	ResyncPeriod time.Duration
+	// SyncInterval is how often the controller checks for nodes that are
+	// due. RenewInterval, if non-zero, fixes every node's renewal cadence;
+	// otherwise it is derived from each lease's duration.
+	SyncInterval  time.Duration
+	RenewInterval time.Duration
+	// WatchdogIntervals, if non-zero, is how many SyncIntervals may pass
+	// without a completed sync cycle before WatchdogAction is taken.
+	WatchdogIntervals int
+	WatchdogAction    string
+
+	// HolderIdentity selects what is written to a node lease's
+	// holderIdentity; see the HolderIdentity* constants.
+	HolderIdentity         string
+	HolderIdentityTemplate *template.Template
+	// ReadyReasonTemplate and ReadyMessageTemplate, if set, render the
+	// reason and message of the Ready condition asserted for nodes.
+	ReadyReasonTemplate  *template.Template
+	ReadyMessageTemplate *template.Template
+	// PatchHook, loaded from PATCH_HOOK_FILE, may modify or veto each
+	// node status patch.
+	PatchHook *patchHook
+	// ExecHooks are the commands run on each transition they are set for,
+	// for up to ExecHookTimeout.
+	ExecHooks       map[string][]string
+	ExecHookTimeout time.Duration
+	// ProbePluginDir, if set, holds probe plugins run against nodes every
+	// ProbeInterval, each for up to ProbeTimeout.
+	ProbePluginDir string
+	ProbeInterval  time.Duration
+	ProbeTimeout   time.Duration
+	// ConditionRules, loaded from CONDITION_RULES_FILE, map probe results
+	// to what is done for each node. Without them, nodes a probe finds
+	// unhealthy are disengaged.
+	ConditionRules []*ConditionRule
+	// LeaseDurationSeconds, if non-zero, is written to node leases; zero
+	// keeps each lease's existing duration.
+	LeaseDurationSeconds int32
+	// Cordon marks nodes unschedulable while they are on life support;
+	// AssistedTaint only taints them PreferNoSchedule, and ActiveLabel
+	// labels them for selection.
+	Cordon        bool
+	AssistedTaint bool
+	ActiveLabel   bool
+	// RespectOutOfService leaves alone nodes with the out-of-service taint,
+	// and RespectShutdown nodes shutting down on purpose, watching full Node
+	// objects to see them.
+	RespectOutOfService bool
+	RespectShutdown     bool
+	// MarkPodsNotReady marks the pods of supported nodes annotated as dead
+	// NotReady, so Services stop routing to them.
+	MarkPodsNotReady bool
+	// ImpersonateNodes renews node leases and patches node status as
+	// system:node:<name> rather than as the controller.
+	ImpersonateNodes bool
+	// KeepAliveLeases are additional, non-node Leases to keep renewed.
+	KeepAliveLeases []keepAliveLease
+	// ReadinessGates are DaemonSets whose pods must be running on a node
+	// registered less than ReadinessGateNodeAge ago before it is asserted
+	// Ready.
+	ReadinessGates       []readinessGate
+	ReadinessGateNodeAge time.Duration
+	// Policies, loaded from POLICY_FILE, decide which nodes are handled and
+	// when. Without a policy file every selected node is always handled.
+	Policies []*Policy
+	// Calendar, loaded from CALENDAR_FILE, holds periods without new
+	// engagements.
+	Calendar *Calendar
+	// EngageGracePeriod, if non-zero, delays engaging a node until its
+	// kubelet heartbeat has been stale for this long. RecoveryCoolDown, if
+	// non-zero, stands a node down once its kubelet has been heartbeating
+	// again for this long. Either enables watching node leases.
+	EngageGracePeriod time.Duration
+	RecoveryCoolDown  time.Duration
+	// FlapWindow, if non-zero, counts a node engaged again within this long
+	// of being disengaged as flapping.
+	FlapWindow time.Duration
+	// QuarantineAfter, if non-zero, is how many consecutive failed syncs
+	// rejected by the API server put a node in quarantine, where it is not
+	// synced for QuarantineDuration.
+	QuarantineAfter    int
+	QuarantineDuration time.Duration
+	// EngagementLimit, if non-zero, caps new engagements cluster-wide per
+	// EngagementLimitWindow; further nodes are queued.
+	EngagementLimit       int
+	EngagementLimitWindow time.Duration
+	// MaxLifeSupportDuration, if non-zero, caps how long a node stays on
+	// life support in one go.
+	MaxLifeSupportDuration time.Duration
+	// DrainOnGiveUp drains a node reaching MaxLifeSupportDuration, for up
+	// to DrainTimeout, and taints it NoSchedule before disengaging it.
+	DrainOnGiveUp bool
+	DrainTimeout  time.Duration
+	// CleanUpMirrorPods deletes the mirror pods of nodes given up on.
+	CleanUpMirrorPods bool
+	// WebhookURL receives a JSON notification for each expiry.
+	WebhookURL string
+	// NotifyWindow and NotifyBurst throttle Events and webhook
+	// notifications: duplicates within a window are dropped, and only
+	// NotifyBurst per reason are sent, the rest summarised.
+	NotifyWindow time.Duration
+	NotifyBurst  int
+	// APIOutageAlertAfter, if non-zero, is how long the API server may be
+	// unreachable before WebhookURL is told, as it cannot be with an Event.
+	APIOutageAlertAfter time.Duration
+	// LogSampleWindow, if non-zero, is how often a line repeated for a node
+	// every cycle is logged; see sampledLogf.
+	LogSampleWindow time.Duration
+	// MetricsAddr is the address metrics and /healthz are served on; empty
+	// disables the HTTP server.
+	MetricsAddr string
+	// ReportRetention is how long activity is kept for /report.
+	ReportRetention time.Duration
+	// SupportWindow is the rolling period over which each node's time on
+	// life support is totalled; a node exceeding SupportAlertThreshold in it
+	// is reported.
+	SupportWindow         time.Duration
+	SupportAlertThreshold time.Duration
+	// DigestSchedule, if set, is when a digest of the nodes on life support
+	// in the last day is sent to WebhookURL.
+	DigestSchedule cron.Schedule
+	// AuditExportURL, if set, is the bucket or container the audit log of
+	// the controller's writes is exported to, in batches of up to
+	// AuditBatchSize records every AuditFlushInterval. Batches older than
+	// AuditRetention, if set, are deleted.
+	AuditExportURL     string
+	AuditFlushInterval time.Duration
+	AuditBatchSize     int
+	AuditRetention     time.Duration
+	// AdmissionAddr, if set, is the address the admission webhooks are
+	// served on over TLS, with AdmissionCertFile and AdmissionKeyFile.
+	AdmissionAddr     string
+	AdmissionCertFile string
+	AdmissionKeyFile  string
+	// PodTolerationSeconds is how long pods bound for supported nodes
+	// tolerate the unreachable and not-ready taints.
+	PodTolerationSeconds int64
+	// BlockPodDeletionsBy are users whose deletions of pods on supported
+	// nodes are denied, e.g. the node lifecycle controller.
+	BlockPodDeletionsBy []string
+	// PodInventoryInterval is how often the pods on supported nodes are
+	// counted; zero disables the inventory. Pods in CriticalPodNamespaces
+	// or matching CriticalPodSelector are reported individually.
+	PodInventoryInterval  time.Duration
+	CriticalPodNamespaces []string
+	CriticalPodSelector   labels.Selector
+	// LeaseGCInterval is how often to sweep kube-node-lease for leases of
+	// nodes that no longer exist; zero disables the sweep.
+	LeaseGCInterval time.Duration
+
+	// Clusters, loaded from CLUSTERS_FILE, are the clusters to supervise in
+	// multi-cluster mode. Cluster is the name of the one a controller
+	// instance handles, empty in single-cluster mode.
+	Clusters []ClusterSpec
+	Cluster  string
+	// ClusterAPIDiscovery also supervises the workload clusters of the
+	// Cluster API management cluster the controller is pointed at, limited
+	// to ClusterAPINamespace (empty for all) and ClusterAPISelector, and
+	// rediscovered every ClusterAPIInterval.
+	ClusterAPIDiscovery bool
+	ClusterAPINamespace string
+	ClusterAPISelector  string
+	ClusterAPIInterval  time.Duration
+
+	// StateConfigMap, in Namespace, persists engagement state across
+	// restarts; empty disables it.
+	StateConfigMap string
+	// Namespace and Identity locate this replica; they come from the
+	// downward API (POD_NAMESPACE, POD_NAME) where available.
+	Namespace string
+	Identity  string
+	PodUID    string
+	// Sharding splits nodes across all running replicas instead of every
+	// replica handling every node.
+	Sharding           bool
+	ShardLeaseDuration time.Duration
+	// LeaderElection has only the replica holding a Lease sync nodes, the
+	// others standing by.
+	LeaderElection      bool
+	LeaderLeaseDuration time.Duration
+	// HeartbeatLeaseDuration, if non-zero, has a Lease renewed after every
+	// successful sync cycle, with this duration, for external monitors.
+	HeartbeatLeaseDuration time.Duration
+}
+
+// LoadOptions reads Options from the environment, applying defaults for
+// anything unset.
+func LoadOptions() (*Options, error) {
+	o := &Options{
+		AllowedLabelKeys: envList("NODE_LABEL_ALLOWLIST"),
+	}
+
+	var err error
+	if o.APITimeout, err = envDuration("API_TIMEOUT", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if o.APIRetries, err = envInt("API_RETRIES", 3); err != nil {
+		return nil, err
+	}
+	o.APIServers = envList("API_SERVERS")
+	if o.APIServerCheckInterval, err = envDuration("API_SERVER_CHECK_INTERVAL", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if len(o.APIServers) > 0 && o.APIServerCheckInterval <= 0 {
+		return nil, fmt.Errorf("API_SERVER_CHECK_INTERVAL: must be positive")
+	}
+	o.APIProxy = envString("API_PROXY", "")
+	o.APINoProxy = envList("API_NO_PROXY")
+	o.APICAFile = envString("API_CA_FILE", "")
+	o.APIClientCertFile = envString("API_CLIENT_CERT_FILE", "")
+	o.APIClientKeyFile = envString("API_CLIENT_KEY_FILE", "")
+	if err := validateTransportOptions(o); err != nil {
+		return nil, err
+	}
+	if o.RBACCheck, err = envBool("RBAC_CHECK", true); err != nil {
+		return nil, err
+	}
+	if o.RBACCheckInterval, err = envDuration("RBAC_CHECK_INTERVAL", 10*time.Minute); err != nil {
+		return nil, err
+	}
+	if o.PreflightCheck, err = envBool("PREFLIGHT_CHECK", false); err != nil {
+		return nil, err
+	}
+	if o.CredentialReloadInterval, err = envDuration("CREDENTIAL_RELOAD_INTERVAL", time.Minute); err != nil {
+		return nil, err
+	}
+	qps, err := envFloat("CLIENT_QPS", 0)
+	if err != nil {
+		return nil, err
+	}
+	o.ClientQPS = float32(qps)
+	if o.ClientBurst, err = envInt("CLIENT_BURST", 0); err != nil {
+		return nil, err
+	}
+	for name, rate := range map[string]*float64{"CHAOS_ERROR_RATE": &o.ChaosErrorRate, "CHAOS_DROP_RATE": &o.ChaosDropRate} {
+		if *rate, err = envFloat(name, 0); err != nil {
+			return nil, err
+		}
+		if *rate > 1 {
+			return nil, fmt.Errorf("%s: must be between 0 and 1", name)
+		}
+	}
+	if o.ChaosLatency, err = envDuration("CHAOS_LATENCY", 0); err != nil {
+		return nil, err
+	}
+	seed, err := envInt("CHAOS_SEED", 1)
+	if err != nil {
+		return nil, err
+	}
+	o.ChaosSeed = int64(seed)
+	o.APIRecordFile = envString("API_RECORD_FILE", "")
+	o.APIReplayFile = envString("API_REPLAY_FILE", "")
+	if o.APIRecordFile != "" && o.APIRecordFile == o.APIReplayFile {
+		return nil, fmt.Errorf("API_RECORD_FILE: cannot be API_REPLAY_FILE")
+	}
+	if o.ResyncPeriod, err = envDuration("RESYNC_PERIOD", 0); err != nil {
+		return nil, err
+	}
+	if o.SyncInterval, err = envDuration("SYNC_INTERVAL", 5*time.Second); err != nil {
+		return nil, err
+	}
+	if o.SyncInterval <= 0 {
+		return nil, fmt.Errorf("SYNC_INTERVAL: must be positive")
+	}
+	if o.WatchdogIntervals, err = envInt("SYNC_WATCHDOG_INTERVALS", 0); err != nil {
+		return nil, err
+	}
+	if o.WatchdogIntervals < 0 {
+		return nil, fmt.Errorf("SYNC_WATCHDOG_INTERVALS: must not be negative")
+	}
+	o.WatchdogAction = envString("SYNC_WATCHDOG_ACTION", watchdogExit)
+	if o.WatchdogAction != watchdogExit && o.WatchdogAction != watchdogFailReadiness {
+		return nil, fmt.Errorf("SYNC_WATCHDOG_ACTION: must be %s or %s", watchdogExit, watchdogFailReadiness)
+	}
+	if o.RenewInterval, err = envDuration("RENEW_INTERVAL", 0); err != nil {
+		return nil, err
+	}
+
+	o.HolderIdentity = envString("HOLDER_IDENTITY", HolderIdentityNode)
+	if o.HolderIdentityTemplate, err = parseHolderIdentity(o.HolderIdentity, os.Getenv("HOLDER_IDENTITY_TEMPLATE")); err != nil {
+		return nil, err
+	}
+	if o.ReadyReasonTemplate, err = parseConditionTemplate("READY_REASON_TEMPLATE"); err != nil {
+		return nil, err
+	}
+	if o.ReadyMessageTemplate, err = parseConditionTemplate("READY_MESSAGE_TEMPLATE"); err != nil {
+		return nil, err
+	}
+	if path := envString("PATCH_HOOK_FILE", ""); path != "" {
+		if o.PatchHook, err = loadPatchHook(path); err != nil {
+			return nil, fmt.Errorf("PATCH_HOOK_FILE: %w", err)
+		}
+	}
+	o.ExecHooks = loadExecHooks()
+	if o.ExecHookTimeout, err = envDuration("EXEC_HOOK_TIMEOUT", time.Minute); err != nil {
+		return nil, err
+	}
+	if o.ExecHookTimeout <= 0 {
+		return nil, fmt.Errorf("EXEC_HOOK_TIMEOUT: must be positive")
+	}
+	o.ProbePluginDir = envString("PROBE_PLUGIN_DIR", "")
+	if o.ProbePluginDir != "" {
+		if fi, err := os.Stat(o.ProbePluginDir); err != nil || !fi.IsDir() {
+			return nil, fmt.Errorf("PROBE_PLUGIN_DIR: must be a directory")
+		}
+	}
+	if o.ProbeInterval, err = envDuration("PROBE_INTERVAL", time.Minute); err != nil {
+		return nil, err
+	}
+	if o.ProbeInterval <= 0 {
+		return nil, fmt.Errorf("PROBE_INTERVAL: must be positive")
+	}
+	if o.ProbeTimeout, err = envDuration("PROBE_TIMEOUT", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if o.ProbeTimeout <= 0 {
+		return nil, fmt.Errorf("PROBE_TIMEOUT: must be positive")
+	}
+	leaseDuration, err := envInt("LEASE_DURATION_SECONDS", 0)
+	if err != nil {
+		return nil, err
+	}
+	if leaseDuration > math.MaxInt32 {
+		return nil, fmt.Errorf("LEASE_DURATION_SECONDS: out of range")
+	}
+	o.LeaseDurationSeconds = int32(leaseDuration)
+	if o.Cordon, err = envBool("CORDON", false); err != nil {
+		return nil, err
+	}
+	if o.AssistedTaint, err = envBool("ASSISTED_TAINT", false); err != nil {
+		return nil, err
+	}
+	if o.ActiveLabel, err = envBool("ACTIVE_LABEL", false); err != nil {
+		return nil, err
+	}
+	if o.RespectOutOfService, err = envBool("RESPECT_OUT_OF_SERVICE", true); err != nil {
+		return nil, err
+	}
+	if o.RespectShutdown, err = envBool("RESPECT_SHUTDOWN", true); err != nil {
+		return nil, err
+	}
+	if o.MarkPodsNotReady, err = envBool("MARK_PODS_NOT_READY", false); err != nil {
+		return nil, err
+	}
+	if o.ImpersonateNodes, err = envBool("IMPERSONATE_NODES", false); err != nil {
+		return nil, err
+	}
+	if o.KeepAliveLeases, err = parseKeepAliveLeases(envList("KEEPALIVE_LEASES")); err != nil {
+		return nil, err
+	}
+	if o.ReadinessGates, err = parseReadinessGates(envList("READINESS_GATES")); err != nil {
+		return nil, err
+	}
+	if o.ReadinessGateNodeAge, err = envDuration("READINESS_GATE_NODE_AGE", time.Hour); err != nil {
+		return nil, err
+	}
+	if o.EngageGracePeriod, err = envDuration("ENGAGE_GRACE_PERIOD", 0); err != nil {
+		return nil, err
+	}
+	if o.RecoveryCoolDown, err = envDuration("RECOVERY_COOLDOWN", 0); err != nil {
+		return nil, err
+	}
+	if o.FlapWindow, err = envDuration("FLAP_WINDOW", 30*time.Minute); err != nil {
+		return nil, err
+	}
+	if o.QuarantineAfter, err = envInt("QUARANTINE_AFTER", 5); err != nil {
+		return nil, err
+	}
+	if o.QuarantineDuration, err = envDuration("QUARANTINE_DURATION", 10*time.Minute); err != nil {
+		return nil, err
+	}
+	if o.EngagementLimit, err = envInt("ENGAGEMENT_LIMIT", 0); err != nil {
+		return nil, err
+	}
+	if o.EngagementLimitWindow, err = envDuration("ENGAGEMENT_LIMIT_WINDOW", 10*time.Minute); err != nil {
+		return nil, err
+	}
+	if o.EngagementLimit > 0 && o.EngagementLimitWindow <= 0 {
+		return nil, fmt.Errorf("ENGAGEMENT_LIMIT_WINDOW: must be positive")
+	}
+	if o.MaxLifeSupportDuration, err = envDuration("MAX_LIFE_SUPPORT_DURATION", 0); err != nil {
+		return nil, err
+	}
+	if o.DrainOnGiveUp, err = envBool("DRAIN_ON_GIVE_UP", false); err != nil {
+		return nil, err
+	}
+	if o.DrainTimeout, err = envDuration("DRAIN_TIMEOUT", 10*time.Minute); err != nil {
+		return nil, err
+	}
+	if o.CleanUpMirrorPods, err = envBool("CLEAN_UP_MIRROR_PODS", false); err != nil {
+		return nil, err
+	}
+	o.WebhookURL = envString("WEBHOOK_URL", "")
+	if o.WebhookURL != "" {
+		if u, err := url.Parse(o.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("WEBHOOK_URL: must be an http(s) URL")
+		}
+	}
+	if o.NotifyWindow, err = envDuration("NOTIFY_WINDOW", 5*time.Minute); err != nil {
+		return nil, err
+	}
+	if o.NotifyBurst, err = envInt("NOTIFY_BURST", 10); err != nil {
+		return nil, err
+	}
+	if o.APIOutageAlertAfter, err = envDuration("API_OUTAGE_ALERT_AFTER", 0); err != nil {
+		return nil, err
+	}
+	if o.APIOutageAlertAfter < 0 {
+		return nil, fmt.Errorf("API_OUTAGE_ALERT_AFTER: must not be negative")
+	}
+	if o.LogSampleWindow, err = envDuration("LOG_SAMPLE_WINDOW", 0); err != nil {
+		return nil, err
+	}
+	o.MetricsAddr = envString("METRICS_ADDR", ":8080")
+	if o.ReportRetention, err = envDuration("REPORT_RETENTION", 24*time.Hour); err != nil {
+		return nil, err
+	}
+	if o.SupportWindow, err = envDuration("SUPPORT_WINDOW", 7*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if o.SupportWindow <= 0 {
+		return nil, fmt.Errorf("SUPPORT_WINDOW: must be positive")
+	}
+	if o.SupportAlertThreshold, err = envDuration("SUPPORT_ALERT_THRESHOLD", 0); err != nil {
+		return nil, err
+	}
+	if v := envString("DIGEST_SCHEDULE", ""); v != "" {
+		if o.DigestSchedule, err = parseSchedule(v); err != nil {
+			return nil, fmt.Errorf("DIGEST_SCHEDULE: %v", err)
+		}
+		if o.WebhookURL == "" {
+			return nil, fmt.Errorf("DIGEST_SCHEDULE: needs WEBHOOK_URL")
+		}
+	}
+	o.AuditExportURL = envString("AUDIT_EXPORT_URL", "")
+	if o.AuditExportURL != "" {
+		if _, _, err := newObjectStore(o.AuditExportURL); err != nil {
+			return nil, fmt.Errorf("AUDIT_EXPORT_URL: %v", err)
+		}
+	}
+	if o.AuditFlushInterval, err = envDuration("AUDIT_FLUSH_INTERVAL", time.Minute); err != nil {
+		return nil, err
+	}
+	if o.AuditFlushInterval <= 0 {
+		return nil, fmt.Errorf("AUDIT_FLUSH_INTERVAL: must be positive")
+	}
+	if o.AuditBatchSize, err = envInt("AUDIT_BATCH_SIZE", 1000); err != nil {
+		return nil, err
+	}
+	if o.AuditBatchSize <= 0 {
+		return nil, fmt.Errorf("AUDIT_BATCH_SIZE: must be positive")
+	}
+	if o.AuditRetention, err = envDuration("AUDIT_RETENTION", 0); err != nil {
+		return nil, err
+	}
+	o.AdmissionAddr = envString("ADMISSION_ADDR", "")
+	o.AdmissionCertFile = envString("ADMISSION_CERT_FILE", "")
+	o.AdmissionKeyFile = envString("ADMISSION_KEY_FILE", "")
+	if o.AdmissionAddr != "" && (o.AdmissionCertFile == "" || o.AdmissionKeyFile == "") {
+		return nil, fmt.Errorf("ADMISSION_ADDR: ADMISSION_CERT_FILE and ADMISSION_KEY_FILE are required")
+	}
+	tolerationSeconds, err := envInt("POD_TOLERATION_SECONDS", 3600)
+	if err != nil {
+		return nil, err
+	}
+	o.PodTolerationSeconds = int64(tolerationSeconds)
+	o.BlockPodDeletionsBy = envList("BLOCK_POD_DELETIONS_BY")
+	if o.PodInventoryInterval, err = envDuration("POD_INVENTORY_INTERVAL", 0); err != nil {
+		return nil, err
+	}
+	o.CriticalPodNamespaces = envList("CRITICAL_POD_NAMESPACES")
+	if v := envString("CRITICAL_POD_SELECTOR", ""); v != "" {
+		if o.CriticalPodSelector, err = labels.Parse(v); err != nil {
+			return nil, fmt.Errorf("CRITICAL_POD_SELECTOR: %w", err)
+		}
+	}
+	if o.LeaseGCInterval, err = envDuration("LEASE_GC_INTERVAL", 0); err != nil {
+		return nil, err
+	}
+	if path := envString("POLICY_FILE", ""); path != "" {
+		if o.Policies, err = loadPolicies(path); err != nil {
+			return nil, fmt.Errorf("POLICY_FILE: %w", err)
+		}
+	}
+
+	if path := envString("CONDITION_RULES_FILE", ""); path != "" {
+		if o.ConditionRules, err = loadConditionRules(path); err != nil {
+			return nil, fmt.Errorf("CONDITION_RULES_FILE: %w", err)
+		}
+	}
+
+	if path := envString("CALENDAR_FILE", ""); path != "" {
+		if o.Calendar, err = loadCalendar(path); err != nil {
+			return nil, fmt.Errorf("CALENDAR_FILE: %w", err)
+		}
+	}
+
+	o.Namespace = podNamespace()
+	o.StateConfigMap = envString("STATE_CONFIGMAP", "node-life-support-state")
+	o.Identity = podIdentity()
+	o.PodUID = os.Getenv("POD_UID")
+	if o.Sharding, err = envBool("SHARDING_ENABLED", false); err != nil {
+		return nil, err
+	}
+	if o.ShardLeaseDuration, err = envDuration("SHARD_LEASE_DURATION", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if o.Sharding && o.ShardLeaseDuration < time.Second {
+		return nil, fmt.Errorf("SHARD_LEASE_DURATION: must be at least 1s")
+	}
+	if o.LeaderElection, err = envBool("LEADER_ELECTION", false); err != nil {
+		return nil, err
+	}
+	if o.LeaderLeaseDuration, err = envDuration("LEADER_ELECTION_LEASE_DURATION", 15*time.Second); err != nil {
+		return nil, err
+	}
+	if o.LeaderElection {
+		if o.LeaderLeaseDuration < time.Second {
+			return nil, fmt.Errorf("LEADER_ELECTION_LEASE_DURATION: must be at least 1s")
+		}
+		if o.Sharding {
+			return nil, fmt.Errorf("LEADER_ELECTION and SHARDING_ENABLED cannot both be enabled")
+		}
+	}
+	if o.HeartbeatLeaseDuration, err = envDuration("HEARTBEAT_LEASE_DURATION", 0); err != nil {
+		return nil, err
+	}
+	if o.HeartbeatLeaseDuration != 0 && o.HeartbeatLeaseDuration < time.Second {
+		return nil, fmt.Errorf("HEARTBEAT_LEASE_DURATION: must be at least 1s")
+	}
+	if path := envString("CLUSTERS_FILE", ""); path != "" {
+		if o.Clusters, err = loadClusters(path); err != nil {
+			return nil, fmt.Errorf("CLUSTERS_FILE: %w", err)
+		}
+		if o.Sharding {
+			return nil, fmt.Errorf("SHARDING_ENABLED is not supported with CLUSTERS_FILE")
+		}
+		if o.LeaderElection {
+			return nil, fmt.Errorf("LEADER_ELECTION is not supported with CLUSTERS_FILE")
+		}
+		if o.HeartbeatLeaseDuration > 0 {
+			return nil, fmt.Errorf("HEARTBEAT_LEASE_DURATION is not supported with CLUSTERS_FILE")
+		}
+		if o.APIRecordFile != "" || o.APIReplayFile != "" {
+			return nil, fmt.Errorf("API_RECORD_FILE and API_REPLAY_FILE are not supported with CLUSTERS_FILE")
+		}
+	}
+	if o.ClusterAPIDiscovery, err = envBool("CAPI_DISCOVERY", false); err != nil {
+		return nil, err
+	}
+	o.ClusterAPINamespace = envString("CAPI_NAMESPACE", "")
+	o.ClusterAPISelector = envString("CAPI_CLUSTER_SELECTOR", "")
+	if _, err := labels.Parse(o.ClusterAPISelector); err != nil {
+		return nil, fmt.Errorf("CAPI_CLUSTER_SELECTOR: %w", err)
+	}
+	if o.ClusterAPIInterval, err = envDuration("CAPI_DISCOVERY_INTERVAL", time.Minute); err != nil {
+		return nil, err
+	}
+	if o.ClusterAPIDiscovery {
+		if o.ClusterAPIInterval <= 0 {
+			return nil, fmt.Errorf("CAPI_DISCOVERY_INTERVAL: must be positive")
+		}
+		if o.Sharding {
+			return nil, fmt.Errorf("SHARDING_ENABLED is not supported with CAPI_DISCOVERY")
+		}
+		if o.LeaderElection {
+			return nil, fmt.Errorf("LEADER_ELECTION is not supported with CAPI_DISCOVERY")
+		}
+		if o.HeartbeatLeaseDuration > 0 {
+			return nil, fmt.Errorf("HEARTBEAT_LEASE_DURATION is not supported with CAPI_DISCOVERY")
+		}
+		if o.APIRecordFile != "" || o.APIReplayFile != "" {
+			return nil, fmt.Errorf("API_RECORD_FILE and API_REPLAY_FILE are not supported with CAPI_DISCOVERY")
+		}
+	}
+
+	return o, nil
+}
+
+// envString returns the trimmed value of an environment variable, or def if
+// it is unset or blank.
+func envString(name, def string) string {
+	if v := strings.TrimSpace(os.Getenv(name)); v != "" {
+		return v
+	}
+	return def
+}
+
+// envList splits a comma-separated environment variable, dropping blanks.
+func envList(name string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(name), ",") {
+		if t := strings.TrimSpace(v); t != "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// envDuration parses a Go duration (e.g. "10s") from the environment.
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%s: must not be negative", name)
+	}
+	return d, nil
+}
+
+// envInt parses a non-negative integer from the environment.
+func envInt(name string, def int) (int, error) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	if i < 0 {
+		return 0, fmt.Errorf("%s: must not be negative", name)
+	}
+	return i, nil
+}
+
+// envFloat parses a non-negative number from the environment.
+func envFloat(name string, def float64) (float64, error) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	if f < 0 {
+		return 0, fmt.Errorf("%s: must not be negative", name)
+	}
+	return f, nil
+}
+
+// envBool parses a boolean ("true", "false", "1", "0", …) from the environment.
+func envBool(name string, def bool) (bool, error) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", name, err)
+	}
+	return b, nil
+}
+
+// podNamespace returns the namespace the controller runs in, falling back to
+// the service account mount and finally the default install namespace.
+func podNamespace() string {
+	if ns := os.Getenv("POD_NAMESPACE"); ns != "" {
+		return ns
+	}
+	if b, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace"); err == nil {
+		if ns := strings.TrimSpace(string(b)); ns != "" {
+			return ns
+		}
+	}
+	return "node-life-support"
+}
+
+// podIdentity returns a name unique to this replica.
+func podIdentity() string {
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name
+	}
+	host, _ := os.Hostname()
+	return host
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestEnvList tests comma-separated environment parsing.
+func TestEnvList(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected []string
+	}{
+		{name: "unset", value: "", expected: nil},
+		{name: "single", value: "a", expected: []string{"a"}},
+		{name: "blanks and whitespace dropped", value: " a, ,b ,", expected: []string{"a", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_LIST", tt.value)
+			result := envList("TEST_LIST")
+			if len(result) != len(tt.expected) {
+				t.Fatalf("envList() = %q, want %q", result, tt.expected)
+			}
+			for i := range result {
+				if result[i] != tt.expected[i] {
+					t.Errorf("envList()[%d] = %q, want %q", i, result[i], tt.expected[i])
+				}
+			}
+		})
+	}
+}
+
+// TestEnvDuration tests duration parsing and defaults.
+func TestEnvDuration(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		expected  time.Duration
+		expectErr bool
+	}{
+		{name: "unset uses default", value: "", expected: 10 * time.Second},
+		{name: "explicit", value: "2m", expected: 2 * time.Minute},
+		{name: "zero", value: "0s", expected: 0},
+		{name: "garbage", value: "soon", expectErr: true},
+		{name: "negative", value: "-1s", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_DURATION", tt.value)
+			result, err := envDuration("TEST_DURATION", 10*time.Second)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("envDuration() error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if !tt.expectErr && result != tt.expected {
+				t.Errorf("envDuration() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
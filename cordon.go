@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// wasUnschedulableAnnotation records a node's spec.unschedulable from before
+// the controller cordoned it, so it can be restored when life support ends,
+// even by a controller that restarted in between.
+const wasUnschedulableAnnotation = "node-life-support.io/was-unschedulable"
+
+// cordon marks an engaged node unschedulable, once per engagement, so no new
+// pods land on a machine whose kubelet is down, or schedulable instead if
+// unschedulable is false.
+func (c *NodeLifeSupportController) cordon(ctx context.Context, name string, unschedulable bool) error {
+	c.mu.Lock()
+	st, ok := c.nodes[name]
+	done := ok && st.cordoned
+	c.mu.Unlock()
+	if !ok || done {
+		return nil
+	}
+
+	var wasUnschedulable bool
+	err := c.retryWrite(ctx, func(ctx context.Context) error {
+		node, err := c.client.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		wasUnschedulable = node.Spec.Unschedulable
+		patch := map[string]interface{}{"spec": map[string]interface{}{"unschedulable": unschedulable}}
+		// Keep an existing record: it holds the state from before our first
+		// cordon.
+		if _, ok := node.Annotations[wasUnschedulableAnnotation]; !ok {
+			patch["metadata"] = map[string]interface{}{"annotations": map[string]interface{}{
+				wasUnschedulableAnnotation: strconv.FormatBool(node.Spec.Unschedulable),
+			}}
+		}
+		return c.patchNode(ctx, node, patch)
+	})
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if st, ok := c.nodes[name]; ok {
+		st.cordoned = true
+	}
+	c.mu.Unlock()
+	switch {
+	case unschedulable && !wasUnschedulable:
+		c.audit(name, auditCordoned, "")
+		c.logf("node %s: cordoned", name)
+	case !unschedulable && wasUnschedulable:
+		c.audit(name, auditUncordoned, "")
+		c.logf("node %s: uncordoned, as its policy's schedulability is %s", name, SchedulabilitySchedulable)
+	}
+	return nil
+}
+
+// uncordon restores a node's schedulability to what it was before the
+// controller cordoned it.
+func (c *NodeLifeSupportController) uncordon(ctx context.Context, name string) error {
+	if !c.opts.patcherConfigured(CordonPatcher{}) {
+		return nil
+	}
+	return c.restoreSchedulable(ctx, name)
+}
+
+// restoreSchedulable restores spec.unschedulable from a node's
+// wasUnschedulableAnnotation, if it has one, and removes the annotation.
+func (c *NodeLifeSupportController) restoreSchedulable(ctx context.Context, name string) error {
+	var restored, was bool
+	err := c.retryWrite(ctx, func(ctx context.Context) error {
+		node, err := c.client.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		v, ok := node.Annotations[wasUnschedulableAnnotation]
+		if !ok {
+			restored = false
+			return nil
+		}
+		if was, err = strconv.ParseBool(v); err != nil {
+			// Someone else's value; uncordoning is the safer guess.
+			c.invalidAnnotation(name, wasUnschedulableAnnotation, v, err)
+		}
+		restored = true
+		return c.patchNode(ctx, node, map[string]interface{}{
+			"metadata": map[string]interface{}{"annotations": map[string]interface{}{wasUnschedulableAnnotation: nil}},
+			"spec":     map[string]interface{}{"unschedulable": was},
+		})
+	})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil || !restored {
+		return err
+	}
+	c.audit(name, auditCordonRestored, "unschedulable="+strconv.FormatBool(was))
+	if was {
+		c.logf("node %s: left cordoned, as it was before life support", name)
+	} else {
+		c.logf("node %s: uncordoned", name)
+	}
+	return nil
+}
+
+// restoreOrphanedCordons restores nodes the controller cordoned that are no
+// longer on life support without having been disengaged, e.g. because the
+// controller restarted or CORDON was turned off in between.
+func (c *NodeLifeSupportController) restoreOrphanedCordons(ctx context.Context) {
+	for _, n := range c.listNodes() {
+		if _, ok := n.Annotations[wasUnschedulableAnnotation]; !ok || c.engaged(n.Name) {
+			continue
+		}
+		if c.shard != nil && !c.shard.owns(n.Name) {
+			continue
+		}
+		if err := c.restoreSchedulable(ctx, n.Name); err != nil {
+			c.logf("node %s: restoring schedulability: %v", n.Name, err)
+		}
+	}
+}
+
+// patchNode merge-patches a node as read at node's resourceVersion, failing
+// with a conflict if it has changed since, so a decision based on that read
+// never overwrites a newer change. Retry by reading it again.
+func (c *NodeLifeSupportController) patchNode(ctx context.Context, node *v1.Node, patch map[string]interface{}) error {
+	meta, _ := patch["metadata"].(map[string]interface{})
+	if meta == nil {
+		meta = make(map[string]interface{})
+		patch["metadata"] = meta
+	}
+	meta["resourceVersion"] = node.ResourceVersion
+	raw, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	c.debugPayload(node.Name, "patching", raw)
+	_, err = c.client.CoreV1().Nodes().Patch(ctx, node.Name, types.MergePatchType, raw, metav1.PatchOptions{})
+	return err
+}
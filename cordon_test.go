@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// TestCordonWhileEngaged tests that a node is cordoned while engaged, or
+// uncordoned or left alone as its policy's schedulability says, and its
+// original schedulability is restored when disengaged.
+func TestCordonWhileEngaged(t *testing.T) {
+	tests := []struct {
+		name           string
+		cordon         bool
+		schedulability string
+		unschedulable  bool
+		wantWhile      bool
+	}{
+		{name: "enabled", cordon: true, wantWhile: true},
+		{name: "enabled, already cordoned", cordon: true, unschedulable: true, wantWhile: true},
+		{name: "disabled"},
+		{name: "forced unschedulable", schedulability: SchedulabilityUnschedulable, wantWhile: true},
+		{name: "forced schedulable", cordon: true, schedulability: SchedulabilitySchedulable, unschedulable: true},
+		{name: "unmanaged", cordon: true, schedulability: SchedulabilityUnmanaged},
+		{name: "unmanaged, already cordoned", cordon: true, schedulability: SchedulabilityUnmanaged, unschedulable: true, wantWhile: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1"},
+				Spec:       corev1.NodeSpec{Unschedulable: tt.unschedulable},
+			}
+			client := fake.NewSimpleClientset(node)
+			c := &NodeLifeSupportController{client: client, opts: Options{HolderIdentity: HolderIdentityNode, Cordon: tt.cordon}}
+			if tt.schedulability != "" {
+				p := &Policy{Name: "p", Schedulability: tt.schedulability}
+				if err := p.compile(); err != nil {
+					t.Fatal(err)
+				}
+				c.opts.Policies = []*Policy{p}
+				c.nodePolicies = map[string]string{"node1": "p"}
+			}
+			ctx := context.Background()
+			meta := &metav1.PartialObjectMetadata{ObjectMeta: node.ObjectMeta}
+
+			get := func() *corev1.Node {
+				n, err := client.CoreV1().Nodes().Get(ctx, "node1", metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return n
+			}
+
+			c.engage("node1")
+			for i := 0; i < 2; i++ {
+				if err := c.SyncNode(ctx, meta); err != nil {
+					t.Fatalf("SyncNode() error: %v", err)
+				}
+			}
+			if got := get().Spec.Unschedulable; got != tt.wantWhile {
+				t.Errorf("unschedulable while engaged = %v, want %v", got, tt.wantWhile)
+			}
+			if err := c.disengage(ctx, "node1", disengageOperatorDisabled); err != nil {
+				t.Fatalf("disengage() error: %v", err)
+			}
+			n := get()
+			if n.Spec.Unschedulable != tt.unschedulable {
+				t.Errorf("unschedulable after disengaging = %v, want %v", n.Spec.Unschedulable, tt.unschedulable)
+			}
+			if _, ok := n.Annotations[wasUnschedulableAnnotation]; ok {
+				t.Errorf("%s left on node", wasUnschedulableAnnotation)
+			}
+		})
+	}
+}
+
+// TestRestoreOrphanedCordons tests that a node cordoned before a restart is
+// restored once it is found not to be on life support.
+func TestRestoreOrphanedCordons(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1", Annotations: map[string]string{wasUnschedulableAnnotation: "false"}},
+		Spec:       corev1.NodeSpec{Unschedulable: true},
+	}
+	engaged := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node2", Annotations: map[string]string{wasUnschedulableAnnotation: "false"}},
+		Spec:       corev1.NodeSpec{Unschedulable: true},
+	}
+	client := fake.NewSimpleClientset(node, engaged)
+	scheme := metadatafake.NewTestScheme()
+	metav1.AddMetaToScheme(scheme)
+	meta := metadatafake.NewSimpleMetadataClient(scheme,
+		&metav1.PartialObjectMetadata{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Node"}, ObjectMeta: node.ObjectMeta},
+		&metav1.PartialObjectMetadata{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Node"}, ObjectMeta: engaged.ObjectMeta},
+	)
+	selectors, err := allowlistSelectors(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &NodeLifeSupportController{client: client, meta: meta, selectors: selectors, opts: Options{Cordon: true}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := c.Start(ctx); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	c.engage("node2")
+
+	c.restoreOrphanedCordons(ctx)
+
+	for name, want := range map[string]bool{"node1": false, "node2": true} {
+		n, err := client.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.Spec.Unschedulable != want {
+			t.Errorf("%s: unschedulable = %v, want %v", name, n.Spec.Unschedulable, want)
+		}
+	}
+}
+
+// TestCordonPrecondition tests that cordon patches are conditional on the
+// node being unchanged since it was read, and that a node cordoned by someone
+// else in between is recorded, and later left, cordoned.
+func TestCordonPrecondition(t *testing.T) {
+	defer func(d time.Duration) { retryDelay = d }(retryDelay)
+	retryDelay = time.Millisecond
+
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", ResourceVersion: "1"}}
+	client := fake.NewSimpleClientset(node)
+	raced := false
+	client.PrependReactor("patch", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "" {
+			return false, nil, nil
+		}
+		var patch struct {
+			Metadata struct {
+				ResourceVersion string `json:"resourceVersion"`
+			} `json:"metadata"`
+		}
+		if err := json.Unmarshal(action.(k8stesting.PatchAction).GetPatch(), &patch); err != nil || patch.Metadata.ResourceVersion == "" {
+			t.Errorf("patch without resourceVersion: %s", action.(k8stesting.PatchAction).GetPatch())
+		}
+		if raced {
+			return false, nil, nil
+		}
+		// An administrator cordons the node between our read and write.
+		raced = true
+		n := node.DeepCopy()
+		n.Spec.Unschedulable = true
+		n.ResourceVersion = "2"
+		if err := client.Tracker().Update(schema.GroupVersionResource{Version: "v1", Resource: "nodes"}, n, ""); err != nil {
+			t.Fatal(err)
+		}
+		return true, nil, apierrors.NewConflict(schema.GroupResource{Resource: "nodes"}, "node1", errors.New("modified"))
+	})
+	c := &NodeLifeSupportController{client: client, opts: Options{Cordon: true, APIRetries: 1}}
+	ctx := context.Background()
+
+	c.engage("node1")
+	if err := c.cordon(ctx, "node1", true); err != nil {
+		t.Fatalf("cordon() error: %v", err)
+	}
+	n, err := client.CoreV1().Nodes().Get(ctx, "node1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := n.Annotations[wasUnschedulableAnnotation]; got != "true" {
+		t.Errorf("%s = %q, want the administrator's cordon recorded", wasUnschedulableAnnotation, got)
+	}
+	if err := c.disengage(ctx, "node1", disengageOperatorDisabled); err != nil {
+		t.Fatalf("disengage() error: %v", err)
+	}
+	if n, _ := client.CoreV1().Nodes().Get(ctx, "node1", metav1.GetOptions{}); !n.Spec.Unschedulable {
+		t.Error("administrator's cordon undone")
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// credentialReloader keeps credentials embedded in a kubeconfig current for a
+// long-running controller. client-go already re-reads token and certificate
+// files (including the in-cluster service account token), but not data
+// embedded in the kubeconfig; so embedded credentials are written to files
+// client-go watches, and rewritten whenever the kubeconfig changes.
+type credentialReloader struct {
+	kubeconfig  string
+	kubeContext string
+	dir         string
+	hash        []byte
+}
+
+// Files in credentialReloader.dir.
+const (
+	credentialTokenFile = "token"
+	credentialCertFile  = "tls.crt"
+	credentialKeyFile   = "tls.key"
+)
+
+// newCredentialReloader moves cfg's embedded token and client certificate
+// into files so they can be rotated, and returns the reloader for them, or
+// nil if cfg has no embedded credentials.
+func newCredentialReloader(cfg *rest.Config, kubeconfig, kubeContext string) (*credentialReloader, error) {
+	hasToken := cfg.BearerToken != "" && cfg.BearerTokenFile == ""
+	hasCert := len(cfg.CertData) > 0 && len(cfg.KeyData) > 0 && cfg.CertFile == "" && cfg.KeyFile == ""
+	if !hasToken && !hasCert {
+		return nil, nil
+	}
+	dir, err := os.MkdirTemp("", "node-life-support-credentials-")
+	if err != nil {
+		return nil, err
+	}
+	r := &credentialReloader{kubeconfig: kubeconfig, kubeContext: kubeContext, dir: dir, hash: kubeconfigHash(kubeconfig)}
+	if err := r.write(cfg); err != nil {
+		return nil, err
+	}
+	if hasToken {
+		cfg.BearerTokenFile = filepath.Join(dir, credentialTokenFile)
+		cfg.BearerToken = ""
+	}
+	if hasCert {
+		cfg.CertFile, cfg.KeyFile = filepath.Join(dir, credentialCertFile), filepath.Join(dir, credentialKeyFile)
+		cfg.CertData, cfg.KeyData = nil, nil
+	}
+	return r, nil
+}
+
+// kubeconfigHash returns the hash of the kubeconfig files in effect.
+func kubeconfigHash(kubeconfig string) []byte {
+	paths := []string{kubeconfig}
+	if kubeconfig == "" {
+		paths = clientcmd.NewDefaultClientConfigLoadingRules().GetLoadingPrecedence()
+	}
+	h := sha256.New()
+	for _, p := range paths {
+		if b, err := os.ReadFile(p); err == nil {
+			h.Write(b)
+		}
+	}
+	return h.Sum(nil)
+}
+
+// write stores cfg's embedded credentials, replacing each file atomically so
+// client-go never reads a partial one.
+func (r *credentialReloader) write(cfg *rest.Config) error {
+	files := map[string][]byte{}
+	if cfg.BearerToken != "" {
+		files[credentialTokenFile] = []byte(cfg.BearerToken)
+	}
+	if len(cfg.CertData) > 0 && len(cfg.KeyData) > 0 {
+		files[credentialCertFile], files[credentialKeyFile] = cfg.CertData, cfg.KeyData
+	}
+	for name, data := range files {
+		tmp := filepath.Join(r.dir, "."+name)
+		if err := os.WriteFile(tmp, data, 0o600); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, filepath.Join(r.dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reload re-reads the kubeconfig if it changed and rewrites the credential
+// files. It reports whether anything was reloaded.
+func (r *credentialReloader) reload() (bool, error) {
+	hash := kubeconfigHash(r.kubeconfig)
+	if bytes.Equal(hash, r.hash) {
+		return false, nil
+	}
+	cfg, err := BuildConfig(r.kubeconfig, r.kubeContext)
+	if err != nil {
+		return false, err
+	}
+	if err := r.write(cfg); err != nil {
+		return false, err
+	}
+	r.hash = hash
+	return true, nil
+}
+
+// run checks the kubeconfig for rotated credentials every interval until ctx
+// is done. client-go picks up a new token within a minute and a new client
+// certificate within five.
+func (r *credentialReloader) run(ctx context.Context, interval time.Duration) {
+	defer os.RemoveAll(r.dir)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		reloaded, err := r.reload()
+		if err != nil {
+			log.Printf("reloading credentials from kubeconfig: %v", err)
+		} else if reloaded {
+			log.Printf("kubeconfig changed, credentials reloaded")
+			lifecycleEvent(v1.EventTypeNormal, reasonCredentialsReloaded, "Kubeconfig changed, credentials reloaded")
+		}
+	}
+}
+
+// loadConfig builds the client configuration for a kubeconfig and context
+// and, with CREDENTIAL_RELOAD_INTERVAL, keeps its embedded credentials
+// current until ctx is done.
+func loadConfig(ctx context.Context, kubeconfig, kubeContext string, interval time.Duration) (*rest.Config, error) {
+	cfg, err := BuildConfig(kubeconfig, kubeContext)
+	if err != nil || interval <= 0 {
+		return cfg, err
+	}
+	r, err := newCredentialReloader(cfg, kubeconfig, kubeContext)
+	if err != nil {
+		log.Printf("credential reload disabled: %v", err)
+		return cfg, nil
+	}
+	if r != nil {
+		go r.run(ctx, interval)
+	}
+	return cfg, nil
+}
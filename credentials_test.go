@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/rest"
+)
+
+// TestCredentialReloader tests that embedded kubeconfig credentials are moved
+// to files and rewritten when the kubeconfig changes.
+func TestCredentialReloader(t *testing.T) {
+	kubeconfig := writeFile(t, "kubeconfig", testKubeconfig)
+	cfg, err := BuildConfig(kubeconfig, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := newCredentialReloader(cfg, kubeconfig, "")
+	if err != nil || r == nil {
+		t.Fatalf("newCredentialReloader() = %v, %v", r, err)
+	}
+	defer os.RemoveAll(r.dir)
+
+	if cfg.BearerToken != "" || cfg.BearerTokenFile == "" {
+		t.Fatalf("token not moved to a file: token=%q file=%q", cfg.BearerToken, cfg.BearerTokenFile)
+	}
+	readToken := func() string {
+		b, err := os.ReadFile(cfg.BearerTokenFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	if got := readToken(); got != "secret" {
+		t.Errorf("token file = %q, want secret", got)
+	}
+
+	if reloaded, err := r.reload(); err != nil || reloaded {
+		t.Errorf("reload() of an unchanged kubeconfig = %v, %v", reloaded, err)
+	}
+	rotated := strings.Replace(testKubeconfig, "token: secret", "token: rotated", 1)
+	if err := os.WriteFile(kubeconfig, []byte(rotated), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := r.reload(); err != nil || !reloaded {
+		t.Fatalf("reload() after rotation = %v, %v", reloaded, err)
+	}
+	if got := readToken(); got != "rotated" {
+		t.Errorf("token file after rotation = %q, want rotated", got)
+	}
+}
+
+// TestCredentialReloaderFileCredentials tests that configurations whose
+// credentials client-go already reloads are left alone.
+func TestCredentialReloaderFileCredentials(t *testing.T) {
+	// As returned by rest.InClusterConfig.
+	cfg := &rest.Config{BearerToken: "sa", BearerTokenFile: "/var/run/secrets/kubernetes.io/serviceaccount/token"}
+	if r, err := newCredentialReloader(cfg, "", ""); r != nil || err != nil {
+		t.Errorf("newCredentialReloader() = %v, %v; want nil for file-based credentials", r, err)
+	}
+}
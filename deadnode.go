@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// deadAnnotation declares a node on life support dead, e.g. set by an
+// operator or an out-of-band health check once the machine itself is known to
+// be down rather than just its kubelet. With MARK_PODS_NOT_READY its pods are
+// then marked NotReady, so Services stop routing to them, while the node is
+// kept Ready so they are not evicted, and with CLEAN_UP_MIRROR_PODS its mirror
+// pods are deleted as for a node given up on.
+const deadAnnotation = "node-life-support.io/dead"
+
+// podNotReadyReason is set on the conditions the controller marks NotReady.
+const podNotReadyReason = "NodeLifeSupportDeadNode"
+
+// declaredDead reports whether a node carries a true deadAnnotation.
+func (c *NodeLifeSupportController) declaredDead(node *metav1.PartialObjectMetadata) bool {
+	v, ok := node.Annotations[deadAnnotation]
+	if !ok {
+		return false
+	}
+	dead, err := strconv.ParseBool(v)
+	if err != nil {
+		c.invalidAnnotation(node.Name, deadAnnotation, v, err)
+		return false
+	}
+	return dead
+}
+
+// handleDeclaredDead marks the pods of a node on life support NotReady and
+// deletes its mirror pods, as enabled, once it is declared dead, retrying on
+// later syncs only what failed; a node declared dead again after the
+// annotation was removed is handled again.
+func (c *NodeLifeSupportController) handleDeclaredDead(ctx context.Context, node *metav1.PartialObjectMetadata) {
+	if !c.opts.MarkPodsNotReady && !c.opts.CleanUpMirrorPods {
+		return
+	}
+	dead := c.declaredDead(node)
+	c.mu.Lock()
+	st, ok := c.nodes[node.Name]
+	if !ok || st.deadHandled == dead {
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+	if dead {
+		handled := c.markPodsNotReady(ctx, node)
+		if c.opts.CleanUpMirrorPods && !c.deleteMirrorPods(ctx, node.Name) {
+			handled = false
+		}
+		if !handled {
+			return
+		}
+	}
+	c.mu.Lock()
+	st.deadHandled = dead
+	c.mu.Unlock()
+}
+
+// markPodsNotReady sets the Ready and ContainersReady conditions of a dead
+// node's ready pods to False, taking their endpoints out of EndpointSlices,
+// and reports whether every one was. The kubelet would normally do this, but
+// it is gone.
+func (c *NodeLifeSupportController) markPodsNotReady(ctx context.Context, node *metav1.PartialObjectMetadata) bool {
+	if !c.opts.MarkPodsNotReady || !c.declaredDead(node) {
+		return true
+	}
+	pods, err := c.nodePods(ctx, node.Name)
+	if err != nil {
+		c.logf("node %s: listing pods to mark NotReady: %v", node.Name, err)
+		return false
+	}
+	failed := false
+	now := metav1.NewTime(c.now())
+	marked := 0
+	for _, p := range pods {
+		if !podReady(&p) {
+			continue
+		}
+		conditions := []v1.PodCondition{
+			{Type: v1.PodReady, Status: v1.ConditionFalse, Reason: podNotReadyReason, LastTransitionTime: now,
+				Message: "node-life-support: node declared dead"},
+			{Type: v1.ContainersReady, Status: v1.ConditionFalse, Reason: podNotReadyReason, LastTransitionTime: now,
+				Message: "node-life-support: node declared dead"},
+		}
+		raw, err := json.Marshal(map[string]interface{}{"status": map[string]interface{}{"conditions": conditions}})
+		if err != nil {
+			failed = true
+			continue
+		}
+		c.debugPayload(node.Name, "patching pod "+p.Namespace+"/"+p.Name+" status", raw)
+		err = c.retryWrite(ctx, func(ctx context.Context) error {
+			_, err := c.client.CoreV1().Pods(p.Namespace).Patch(ctx, p.Name, types.StrategicMergePatchType, raw, metav1.PatchOptions{}, "status")
+			return err
+		})
+		if err != nil && !apierrors.IsNotFound(err) {
+			c.logf("node %s: marking pod %s/%s NotReady: %v", node.Name, p.Namespace, p.Name, err)
+			failed = true
+			continue
+		}
+		if err == nil {
+			c.audit(node.Name, auditPodNotReady, p.Namespace+"/"+p.Name)
+			marked++
+		}
+	}
+	if marked > 0 {
+		c.logf("node %s: declared dead, marked %d pods NotReady", node.Name, marked)
+	}
+	return !failed
+}
+
+func podReady(p *v1.Pod) bool {
+	for _, cond := range p.Status.Conditions {
+		if cond.Type == v1.PodReady {
+			return cond.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// TestMarkPodsNotReady tests that the ready pods of a node annotated dead, and
+// only those, are marked NotReady when MARK_PODS_NOT_READY is set.
+func TestMarkPodsNotReady(t *testing.T) {
+	ready := func(p *v1.Pod) {
+		p.Status.Conditions = []v1.PodCondition{
+			{Type: v1.PodScheduled, Status: v1.ConditionTrue},
+			{Type: v1.PodReady, Status: v1.ConditionTrue},
+		}
+	}
+	tests := []struct {
+		name       string
+		enabled    bool
+		annotation string
+		want       bool
+	}{
+		{name: "dead", enabled: true, annotation: "true", want: true},
+		{name: "disabled", enabled: false, annotation: "true"},
+		{name: "not dead", enabled: true, annotation: "false"},
+		{name: "invalid", enabled: true, annotation: "maybe"},
+		{name: "unannotated", enabled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(
+				drainPod("app", "node1", ready),
+				drainPod("pending", "node1", nil),
+				drainPod("elsewhere", "node2", ready),
+			)
+			c := &NodeLifeSupportController{client: client, opts: Options{MarkPodsNotReady: tt.enabled}}
+			node := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+			if tt.annotation != "" {
+				node.Annotations = map[string]string{deadAnnotation: tt.annotation}
+			}
+			ctx := context.Background()
+
+			c.markPodsNotReady(ctx, node)
+
+			for name, want := range map[string]bool{"app": !tt.want, "elsewhere": true} {
+				p, err := client.CoreV1().Pods("ns").Get(ctx, name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := podReady(p); got != want {
+					t.Errorf("pod %s ready = %v, want %v", name, got, want)
+				}
+			}
+			patches := 0
+			for _, a := range client.Actions() {
+				if a.Matches("patch", "pods") && a.GetSubresource() == "status" {
+					patches++
+					if name := a.(k8stesting.PatchAction).GetName(); name != "app" {
+						t.Errorf("patched pod %s", name)
+					}
+				}
+			}
+			if tt.want && patches != 1 {
+				t.Errorf("%d status patches, want 1", patches)
+			}
+
+			// Pods already marked are left alone.
+			client.ClearActions()
+			c.markPodsNotReady(ctx, node)
+			for _, a := range client.Actions() {
+				if a.Matches("patch", "pods") {
+					t.Errorf("pod %s patched again", a.(k8stesting.PatchAction).GetName())
+				}
+			}
+		})
+	}
+}
+
+// TestMarkPodsNotReadyGone tests that pods gone before they could be marked
+// are not counted as marked.
+func TestMarkPodsNotReadyGone(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	ready := func(p *v1.Pod) {
+		p.Status.Conditions = []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}}
+	}
+	client := fake.NewSimpleClientset(drainPod("app", "node1", ready), drainPod("gone", "node1", ready))
+	client.PrependReactor("patch", "pods", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		if name := a.(k8stesting.PatchAction).GetName(); name == "gone" {
+			return true, nil, apierrors.NewNotFound(v1.Resource("pods"), name)
+		}
+		return false, nil, nil
+	})
+	c := &NodeLifeSupportController{client: client, opts: Options{MarkPodsNotReady: true}}
+	node := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "node1", Annotations: map[string]string{deadAnnotation: "true"}}}
+	c.markPodsNotReady(context.Background(), node)
+	if want := "marked 1 pods NotReady"; !strings.Contains(buf.String(), want) {
+		t.Errorf("log does not contain %q:\n%s", want, buf.String())
+	}
+}
+
+// TestHandleDeclaredDead tests that a node declared dead has its pods marked
+// NotReady and its mirror pods deleted once, retried only after a failure,
+// and again once declared dead anew.
+func TestHandleDeclaredDead(t *testing.T) {
+	ready := func(p *v1.Pod) {
+		p.Status.Conditions = []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}}
+	}
+	mirror := func(p *v1.Pod) { p.Annotations = map[string]string{mirrorPodAnnotation: "x"} }
+	client := fake.NewSimpleClientset(drainPod("app", "node1", ready), drainPod("static", "node1", mirror), drainPod("late", "node1", nil))
+	failures := 1
+	client.PrependReactor("patch", "pods", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		if failures > 0 {
+			failures--
+			return true, nil, apierrors.NewForbidden(v1.Resource("pods"), a.(k8stesting.PatchAction).GetName(), nil)
+		}
+		return false, nil, nil
+	})
+	c := &NodeLifeSupportController{client: client, opts: Options{MarkPodsNotReady: true, CleanUpMirrorPods: true}}
+	c.engage("node1")
+	ctx := context.Background()
+	dead := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "node1", Annotations: map[string]string{deadAnnotation: "true"}}}
+	alive := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	podReadyNow := func(name string) bool {
+		p, err := client.CoreV1().Pods("ns").Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return podReady(p)
+	}
+	makeReady := func(name string) {
+		p, err := client.CoreV1().Pods("ns").Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ready(p)
+		if _, err := client.CoreV1().Pods("ns").UpdateStatus(ctx, p, metav1.UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first patch fails, so the node is handled again on the next sync.
+	c.handleDeclaredDead(ctx, dead)
+	if !podReadyNow("app") {
+		t.Fatal("app marked NotReady although its patch failed")
+	}
+	c.handleDeclaredDead(ctx, dead)
+	if podReadyNow("app") {
+		t.Error("app still ready after the retry")
+	}
+	if _, err := client.CoreV1().Pods("ns").Get(ctx, "static", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("mirror pod not deleted: %v", err)
+	}
+
+	// Handled: later syncs leave the node's pods alone.
+	makeReady("late")
+	client.ClearActions()
+	c.handleDeclaredDead(ctx, dead)
+	if n := len(client.Actions()); n != 0 {
+		t.Errorf("%d API calls once handled, want none", n)
+	}
+
+	// Declared dead anew after the annotation was removed.
+	c.handleDeclaredDead(ctx, alive)
+	c.handleDeclaredDead(ctx, dead)
+	if podReadyNow("late") {
+		t.Error("late still ready after the node was declared dead again")
+	}
+}
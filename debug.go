@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// debugAnnotation, set to "true" on a node, has the controller log what it
+// does to that node in detail: every sync, the full payload of every write
+// and the input and output of every probe. Other nodes are unaffected, so one
+// problematic node can be looked into on a large cluster.
+const debugAnnotation = "node-life-support.io/debug"
+
+// noteDebug records whether a node asks for debug logging, as of the metadata
+// it was last synced or probed with, and returns it.
+func (c *NodeLifeSupportController) noteDebug(node *metav1.PartialObjectMetadata) bool {
+	debug := false
+	if v, ok := node.Annotations[debugAnnotation]; ok {
+		var err error
+		if debug, err = strconv.ParseBool(v); err != nil {
+			c.invalidAnnotation(node.Name, debugAnnotation, v, err)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !debug {
+		delete(c.debugNodes, node.Name)
+		return false
+	}
+	if c.debugNodes == nil {
+		c.debugNodes = make(map[string]struct{})
+	}
+	c.debugNodes[node.Name] = struct{}{}
+	return true
+}
+
+// debugging reports whether a node asks for debug logging. c.mu must not be
+// held.
+func (c *NodeLifeSupportController) debugging(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.debugNodes[name]
+	return ok
+}
+
+// debugf logs a message about a node only if it asks for debug logging.
+// c.mu must not be held.
+func (c *NodeLifeSupportController) debugf(name, format string, args ...interface{}) {
+	if c.debugging(name) {
+		c.logf("node %s: debug: "+format, append([]interface{}{name}, args...)...)
+	}
+}
+
+// debugPayload logs what is about to be written for a node, if it asks for
+// debug logging: raw JSON is logged as is, anything else encoded as JSON.
+func (c *NodeLifeSupportController) debugPayload(name, what string, payload interface{}) {
+	if !c.debugging(name) {
+		return
+	}
+	raw, ok := payload.([]byte)
+	if !ok {
+		var err error
+		if raw, err = json.Marshal(payload); err != nil {
+			c.logf("node %s: debug: %s: %v", name, what, err)
+			return
+		}
+	}
+	c.logf("node %s: debug: %s %s", name, what, raw)
+}
+
+// debugDiff logs how a write would change a node's lease or conditions, as
+// leaseDiff or conditionDiff describe it. Callers check debugging first, as
+// working out the diff may take a read.
+func (c *NodeLifeSupportController) debugDiff(name string, diff []string) {
+	if len(diff) == 0 {
+		c.logf("node %s: debug: no change", name)
+		return
+	}
+	c.logf("node %s: debug: would change %s", name, strings.Join(diff, "; "))
+}
+
+// debugStatusDiff logs how the status patch raw would change a node's
+// conditions, reading the node for its current ones.
+func (c *NodeLifeSupportController) debugStatusDiff(ctx context.Context, name string, raw []byte) {
+	var intended v1.Node
+	if err := json.Unmarshal(raw, &intended); err != nil {
+		c.logf("node %s: debug: reading patch: %v", name, err)
+		return
+	}
+	opCtx, cancel := c.opContext(ctx)
+	defer cancel()
+	current, err := c.client.CoreV1().Nodes().Get(opCtx, name, metav1.GetOptions{})
+	if err != nil {
+		c.logf("node %s: debug: reading current conditions: %v", name, err)
+		return
+	}
+	c.debugDiff(name, conditionDiff(current.Status.Conditions, intended.Status.Conditions))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestDebugAnnotation tests that the writes to a node annotated for debug
+// logging are logged with their payloads, and those to other nodes not.
+func TestDebugAnnotation(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+
+	tests := []struct {
+		annotation string
+		want       bool
+	}{
+		{annotation: "true", want: true},
+		{annotation: "false"},
+		{annotation: "yes please"},
+		{},
+	}
+	for _, tt := range tests {
+		t.Run(tt.annotation, func(t *testing.T) {
+			buf.Reset()
+			meta := metav1.ObjectMeta{Name: "node1", UID: "uid-1"}
+			if tt.annotation != "" {
+				meta.Annotations = map[string]string{debugAnnotation: tt.annotation}
+			}
+			c := &NodeLifeSupportController{
+				client: fake.NewSimpleClientset(&v1.Node{ObjectMeta: meta}),
+				opts:   Options{HolderIdentity: HolderIdentityNode},
+			}
+			if err := c.SyncNode(context.Background(), &metav1.PartialObjectMetadata{ObjectMeta: meta}); err != nil {
+				t.Fatal(err)
+			}
+			logged := buf.String()
+			for _, line := range []string{
+				"node node1: debug: creating lease {",
+				`node node1: debug: patching status {"status":{"conditions":[{"type":"Ready","status":"True"`,
+				`node node1: debug: would change lease holderIdentity: <none> -> "node1"; `,
+				"node node1: debug: would change condition Ready: <none> -> True (NodeLifeSupportOverride)",
+			} {
+				if got := strings.Contains(logged, line); got != tt.want {
+					t.Errorf("logged %q: %v, want %v; log:\n%s", line, got, tt.want, logged)
+				}
+			}
+
+			c.forget("node1")
+			if c.debugging("node1") {
+				t.Errorf("still debugging a deleted node")
+			}
+		})
+	}
+}
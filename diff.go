@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coordinationv1apply "k8s.io/client-go/applyconfigurations/coordination/v1"
+)
+
+// leaseDiff describes how a node lease would change from current to
+// intended, one line per field; current is nil for a lease to be created.
+func leaseDiff(current, intended *coordinationv1.Lease) []string {
+	if current == nil {
+		current = &coordinationv1.Lease{}
+	}
+	var diff []string
+	field := func(name, from, to string) {
+		if from != to {
+			diff = append(diff, fmt.Sprintf("lease %s: %s -> %s", name, from, to))
+		}
+	}
+	field("holderIdentity", quotedOrNone(current.Spec.HolderIdentity), quotedOrNone(intended.Spec.HolderIdentity))
+	field("leaseDurationSeconds", int32OrNone(current.Spec.LeaseDurationSeconds), int32OrNone(intended.Spec.LeaseDurationSeconds))
+	field("acquireTime", microTimeOrNone(current.Spec.AcquireTime), microTimeOrNone(intended.Spec.AcquireTime))
+	field("renewTime", microTimeOrNone(current.Spec.RenewTime), microTimeOrNone(intended.Spec.RenewTime))
+	field("leaseTransitions", int32OrNone(current.Spec.LeaseTransitions), int32OrNone(intended.Spec.LeaseTransitions))
+	from, ok := current.Annotations[originalHolderAnnotation]
+	to, ok2 := intended.Annotations[originalHolderAnnotation]
+	field(originalHolderAnnotation, annotationOrNone(from, ok), annotationOrNone(to, ok2))
+	return diff
+}
+
+// appliedLease returns lease as it would be after apply, which sets only the
+// fields leaseRenewal does.
+func appliedLease(lease *coordinationv1.Lease, apply *coordinationv1apply.LeaseApplyConfiguration) *coordinationv1.Lease {
+	out := lease.DeepCopy()
+	if s := apply.Spec; s != nil {
+		if s.HolderIdentity != nil {
+			out.Spec.HolderIdentity = s.HolderIdentity
+		}
+		if s.LeaseDurationSeconds != nil {
+			out.Spec.LeaseDurationSeconds = s.LeaseDurationSeconds
+		}
+		if s.AcquireTime != nil {
+			out.Spec.AcquireTime = s.AcquireTime
+		}
+		if s.RenewTime != nil {
+			out.Spec.RenewTime = s.RenewTime
+		}
+		if s.LeaseTransitions != nil {
+			out.Spec.LeaseTransitions = s.LeaseTransitions
+		}
+	}
+	for k, v := range apply.Annotations {
+		if out.Annotations == nil {
+			out.Annotations = make(map[string]string)
+		}
+		out.Annotations[k] = v
+	}
+	return out
+}
+
+// conditionDiff describes how a node's conditions would change from current
+// to intended, one line per condition added, changed or removed. Heartbeat
+// and transition times, which change on every patch, are left out.
+func conditionDiff(current, intended []v1.NodeCondition) []string {
+	var diff []string
+	seen := make(map[v1.NodeConditionType]bool)
+	for _, to := range intended {
+		seen[to.Type] = true
+		from := findCondition(current, to.Type)
+		switch {
+		case from == nil:
+			diff = append(diff, fmt.Sprintf("condition %s: <none> -> %s", to.Type, describeCondition(to)))
+		case from.Status != to.Status || from.Reason != to.Reason || from.Message != to.Message:
+			diff = append(diff, fmt.Sprintf("condition %s: %s -> %s", to.Type, describeCondition(*from), describeCondition(to)))
+		}
+	}
+	for _, from := range current {
+		if !seen[from.Type] {
+			diff = append(diff, fmt.Sprintf("condition %s: %s -> <none>", from.Type, describeCondition(from)))
+		}
+	}
+	return diff
+}
+
+func findCondition(conditions []v1.NodeCondition, t v1.NodeConditionType) *v1.NodeCondition {
+	for i := range conditions {
+		if conditions[i].Type == t {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+// describeCondition renders a condition as its status, reason and message.
+func describeCondition(c v1.NodeCondition) string {
+	s := string(c.Status)
+	if c.Reason != "" {
+		s += " (" + c.Reason + ")"
+	}
+	if c.Message != "" {
+		s += " " + strconv.Quote(c.Message)
+	}
+	return s
+}
+
+func quotedOrNone(s *string) string {
+	if s == nil {
+		return "<none>"
+	}
+	return strconv.Quote(*s)
+}
+
+func int32OrNone(i *int32) string {
+	if i == nil {
+		return "<none>"
+	}
+	return strconv.Itoa(int(*i))
+}
+
+func microTimeOrNone(t *metav1.MicroTime) string {
+	if t == nil {
+		return "<none>"
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func annotationOrNone(v string, ok bool) string {
+	if !ok {
+		return "<none>"
+	}
+	return strconv.Quote(v)
+}
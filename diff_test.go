@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLeaseDiff(t *testing.T) {
+	then := time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)
+	now := then.Add(time.Hour)
+	renewed := metav1.NewMicroTime(then)
+	ptr := func(i int32) *int32 { return &i }
+	str := func(s string) *string { return &s }
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1", Namespace: nodeLeaseNamespace},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       str("node1"),
+			LeaseDurationSeconds: ptr(40),
+			RenewTime:            &renewed,
+		},
+	}
+	tests := []struct {
+		name      string
+		current   *coordinationv1.Lease
+		holder    string
+		setHolder bool
+		want      []string
+	}{
+		{name: "renewal", current: lease, want: []string{
+			"lease renewTime: 2024-05-01T11:00:00Z -> 2024-05-01T12:00:00Z",
+		}},
+		{name: "takeover", current: lease, holder: "node-life-support", setHolder: true, want: []string{
+			`lease holderIdentity: "node1" -> "node-life-support"`,
+			"lease acquireTime: <none> -> 2024-05-01T12:00:00Z",
+			"lease renewTime: 2024-05-01T11:00:00Z -> 2024-05-01T12:00:00Z",
+			"lease leaseTransitions: <none> -> 1",
+			`lease node-life-support.io/original-holder: <none> -> "node1"`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apply := leaseRenewal(tt.current, tt.holder, tt.setHolder, now, 0)
+			if got := leaseDiff(tt.current, appliedLease(tt.current, apply)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("leaseDiff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if got := leaseDiff(nil, lease); len(got) != 3 || got[0] != `lease holderIdentity: <none> -> "node1"` {
+		t.Errorf("leaseDiff() of a new lease = %q", got)
+	}
+}
+
+func TestConditionDiff(t *testing.T) {
+	notReady := v1.NodeCondition{Type: v1.NodeReady, Status: v1.ConditionUnknown, Reason: "NodeStatusUnknown", Message: "Kubelet stopped posting node status."}
+	ready := v1.NodeCondition{Type: v1.NodeReady, Status: v1.ConditionTrue, Reason: "NodeLifeSupportOverride", LastHeartbeatTime: metav1.Now()}
+	pressure := v1.NodeCondition{Type: v1.NodeMemoryPressure, Status: v1.ConditionFalse}
+	tests := []struct {
+		name     string
+		current  []v1.NodeCondition
+		intended []v1.NodeCondition
+		want     []string
+	}{
+		{name: "unchanged", current: []v1.NodeCondition{ready}, intended: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue, Reason: "NodeLifeSupportOverride"}}},
+		{name: "changed", current: []v1.NodeCondition{notReady}, intended: []v1.NodeCondition{ready}, want: []string{
+			`condition Ready: Unknown (NodeStatusUnknown) "Kubelet stopped posting node status." -> True (NodeLifeSupportOverride)`,
+		}},
+		{name: "added and removed", current: []v1.NodeCondition{pressure}, intended: []v1.NodeCondition{ready}, want: []string{
+			"condition Ready: <none> -> True (NodeLifeSupportOverride)",
+			"condition MemoryPressure: False -> <none>",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := conditionDiff(tt.current, tt.intended); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("conditionDiff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// digestPeriod is how far back a digest looks.
+const digestPeriod = 24 * time.Hour
+
+// digestEvent is the Event of digest notifications.
+const digestEvent = "LifeSupportDigest"
+
+// digestListed is how many nodes a digest's message names; the rest are only
+// in its Digest.
+const digestListed = 10
+
+// digestNode is a node on life support at some point in a digest's period.
+type digestNode struct {
+	Node             string  `json:"node"`
+	SupportedSeconds float64 `json:"supportedSeconds"`
+	// Engaged is set if the node is still on life support.
+	Engaged bool `json:"engaged"`
+}
+
+// digest returns the nodes on life support in the digestPeriod before now,
+// longest supported first.
+func (c *NodeLifeSupportController) digest(now time.Time) []digestNode {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	start := now.Add(-digestPeriod)
+	var nodes []digestNode
+	seen := make(map[string]struct{}, len(c.nodes))
+	for name, st := range c.nodes {
+		seen[name] = struct{}{}
+		supported := overlap(st.engagedSince, now, start)
+		if a, ok := c.accounts[name]; ok {
+			for _, iv := range a.recent {
+				supported += overlap(iv.from, iv.to, start)
+			}
+		}
+		nodes = append(nodes, digestNode{Node: name, SupportedSeconds: supported.Seconds(), Engaged: true})
+	}
+	for name, a := range c.accounts {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		var supported time.Duration
+		for _, iv := range a.recent {
+			supported += overlap(iv.from, iv.to, start)
+		}
+		if supported > 0 {
+			nodes = append(nodes, digestNode{Node: name, SupportedSeconds: supported.Seconds()})
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].SupportedSeconds != nodes[j].SupportedSeconds {
+			return nodes[i].SupportedSeconds > nodes[j].SupportedSeconds
+		}
+		return nodes[i].Node < nodes[j].Node
+	})
+	return nodes
+}
+
+// digestMessage summarises a digest, naming its first digestListed nodes.
+func digestMessage(nodes []digestNode) string {
+	if len(nodes) == 0 {
+		return fmt.Sprintf("No nodes on life support in the last %s", digestPeriod)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d nodes on life support in the last %s: ", len(nodes), digestPeriod)
+	for i, n := range nodes {
+		if i == digestListed {
+			fmt.Fprintf(&b, ", and %d more", len(nodes)-digestListed)
+			break
+		}
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		supported := time.Duration(n.SupportedSeconds * float64(time.Second)).Round(time.Second)
+		fmt.Fprintf(&b, "%s (%s", n.Node, supported)
+		if n.Engaged {
+			b.WriteString(", still engaged")
+		}
+		b.WriteString(")")
+	}
+	return b.String()
+}
+
+// sendDigest sends the digest notification when DIGEST_SCHEDULE is due. The
+// first call only schedules the next digest, so a restart does not send one.
+func (c *NodeLifeSupportController) sendDigest(ctx context.Context, now time.Time) {
+	if c.opts.DigestSchedule == nil || c.notifier == nil {
+		return
+	}
+	if c.nextDigest.IsZero() {
+		c.nextDigest = c.opts.DigestSchedule.Next(now)
+		return
+	}
+	if now.Before(c.nextDigest) {
+		return
+	}
+	c.nextDigest = c.opts.DigestSchedule.Next(now)
+
+	nodes := c.digest(now)
+	c.sendNotification(ctx, notification{
+		Event:   digestEvent,
+		Message: digestMessage(nodes),
+		Nodes:   len(nodes),
+		Digest:  nodes,
+	})
+}
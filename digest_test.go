@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// TestSendDigest tests that the digest is sent when DIGEST_SCHEDULE is due,
+// with the time each node spent on life support in the last day.
+func TestSendDigest(t *testing.T) {
+	received := make(chan notification, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		received <- n
+	}))
+	defer srv.Close()
+
+	schedule, err := cron.ParseStandard("0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	clock := clocktesting.NewFakeClock(start)
+	c := &NodeLifeSupportController{
+		opts:     Options{SupportWindow: time.Hour, DigestSchedule: schedule},
+		clock:    clock,
+		notifier: newWebhookNotifier(srv.URL),
+	}
+	ctx := context.Background()
+
+	// node1 was supported for 3h before the digest's period and 1h in it;
+	// node2 for 2h and still is; node3 only before it. SUPPORT_WINDOW is
+	// shorter than the period, yet node1's hour must be kept.
+	c.mu.Lock()
+	c.closeEngagement("node1", start.Add(6*time.Hour), start.Add(9*time.Hour))
+	c.closeEngagement("node1", start.Add(20*time.Hour), start.Add(21*time.Hour))
+	c.closeEngagement("node3", start.Add(time.Hour), start.Add(2*time.Hour))
+	c.mu.Unlock()
+
+	clock.SetTime(start.Add(10 * time.Hour))
+	c.sendDigest(ctx, clock.Now())
+	clock.SetTime(start.Add(31 * time.Hour))
+	c.engage("node2")
+	c.sendDigest(ctx, clock.Now())
+	clock.SetTime(start.Add(33 * time.Hour))
+	c.accountSupport(clock.Now())
+	select {
+	case n := <-received:
+		t.Fatalf("digest sent before it was due: %+v", n)
+	default:
+	}
+
+	c.sendDigest(ctx, clock.Now())
+	n := <-received
+	want := []digestNode{
+		{Node: "node2", SupportedSeconds: 7200, Engaged: true},
+		{Node: "node1", SupportedSeconds: 3600},
+	}
+	if n.Event != digestEvent || n.Nodes != 2 || len(n.Digest) != len(want) {
+		t.Fatalf("digest = %+v", n)
+	}
+	for i := range want {
+		if n.Digest[i] != want[i] {
+			t.Errorf("digest node %d = %+v, want %+v", i, n.Digest[i], want[i])
+		}
+	}
+	if msg := "2 nodes on life support in the last 24h0m0s: node2 (2h0m0s, still engaged), node1 (1h0m0s)"; n.Message != msg {
+		t.Errorf("message = %q, want %q", n.Message, msg)
+	}
+
+	c.sendDigest(ctx, clock.Now())
+	select {
+	case n := <-received:
+		t.Errorf("digest sent twice: %+v", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// TestDigestMessage tests that a digest's message names at most digestListed
+// nodes.
+func TestDigestMessage(t *testing.T) {
+	if got := digestMessage(nil); got != "No nodes on life support in the last 24h0m0s" {
+		t.Errorf("empty digest: %q", got)
+	}
+	var nodes []digestNode
+	for i := 0; i < digestListed+3; i++ {
+		nodes = append(nodes, digestNode{Node: "node", SupportedSeconds: 60})
+	}
+	got := digestMessage(nodes)
+	if !strings.HasSuffix(got, "node (1m0s), and 3 more") || strings.Count(got, "node (") != digestListed {
+		t.Errorf("message = %q", got)
+	}
+}
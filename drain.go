@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// gaveUpTaint is applied, NoSchedule, to a node the controller has given up
+// on once it has been drained. It is left for an operator to remove.
+const gaveUpTaint = "node-life-support.io/gave-up"
+
+// mirrorPodAnnotation marks static pods, which cannot be evicted.
+const mirrorPodAnnotation = "kubernetes.io/config.mirror"
+
+// drainRetryInterval is how long to wait before retrying evictions, e.g.
+// those refused by a PodDisruptionBudget.
+var drainRetryInterval = 5 * time.Second
+
+// drainState tracks the drain of a node the controller is giving up on.
+type drainState struct {
+	cancel context.CancelFunc
+	done   bool
+	// remaining and blockers are the pods still to be evicted and the
+	// PodDisruptionBudgets ("namespace/name") refusing their eviction.
+	remaining int
+	blockers  map[string]int
+}
+
+// drained starts draining a node if it is not already being drained and
+// reports whether the drain has finished. The node stays on life support
+// meanwhile, so its pods can move off while it still looks healthy.
+func (c *NodeLifeSupportController) drained(ctx context.Context, node *metav1.PartialObjectMetadata) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d, ok := c.drains[node.Name]; ok {
+		return d.done
+	}
+	if c.drains == nil {
+		c.drains = make(map[string]*drainState)
+	}
+	drainCtx, cancel := context.WithCancel(ctx)
+	d := &drainState{cancel: cancel}
+	c.drains[node.Name] = d
+	force := false
+	if p := c.policyFor(node); p != nil {
+		force = p.DisruptionBudgets == DisruptionBudgetsForce
+	}
+	go func() {
+		evicted, remaining := c.drain(drainCtx, node.Name, c.now().Add(c.opts.DrainTimeout))
+		if drainCtx.Err() != nil {
+			return
+		}
+		blockers := c.drainBlockers(node.Name)
+		switch {
+		case remaining == 0:
+			c.nodeEvent(node.Name, node.UID, v1.EventTypeNormal, reasonLifeSupportDrained, "Evicted %d pods before giving up", evicted)
+		case force:
+			deleted := c.forceDelete(drainCtx, node.Name)
+			c.nodeEvent(node.Name, node.UID, v1.EventTypeWarning, reasonLifeSupportDrained,
+				"Evicted %d pods before giving up; deleted %d more after %s, overriding PodDisruptionBudgets %s",
+				evicted, deleted, c.opts.DrainTimeout, formatBlockers(blockers))
+		default:
+			c.nodeEvent(node.Name, node.UID, v1.EventTypeWarning, reasonLifeSupportDrained,
+				"Evicted %d pods before giving up; %d could not be evicted within %s, blocked by PodDisruptionBudgets %s",
+				evicted, remaining, c.opts.DrainTimeout, formatBlockers(blockers))
+		}
+		c.mu.Lock()
+		d.done = true
+		c.mu.Unlock()
+	}()
+	c.logf("node %s: giving up, draining first", node.Name)
+	return false
+}
+
+// draining reports whether the node is being drained, so the admission
+// webhook lets the drain's own evictions through.
+func (c *NodeLifeSupportController) draining(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d, ok := c.drains[name]
+	return ok && !d.done
+}
+
+// stopDrain abandons a node's drain, e.g. because it left life support for
+// another reason.
+func (c *NodeLifeSupportController) stopDrain(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d, ok := c.drains[name]; ok {
+		d.cancel()
+		delete(c.drains, name)
+		c.updateDrainBlockedMetric()
+	}
+}
+
+// drain evicts the node's pods through the eviction API, so
+// PodDisruptionBudgets are respected, retrying refused evictions until
+// deadline. DaemonSet and static pods are skipped, as they would only come
+// back. It returns how many pods were evicted and how many remain.
+func (c *NodeLifeSupportController) drain(ctx context.Context, name string, deadline time.Time) (evicted, remaining int) {
+	for {
+		pods, err := c.podsToEvict(ctx, name)
+		if err != nil {
+			c.logf("node %s: listing pods to drain: %v", name, err)
+		}
+		remaining = len(pods)
+		var refused []v1.Pod
+		for _, p := range pods {
+			err := c.evict(ctx, p)
+			switch {
+			case err == nil:
+				evicted++
+				remaining--
+			case apierrors.IsNotFound(err):
+				remaining--
+			case apierrors.IsTooManyRequests(err):
+				// Refused by a PodDisruptionBudget; retried below.
+				refused = append(refused, p)
+			default:
+				c.logf("node %s: evicting pod %s/%s: %v", name, p.Namespace, p.Name, err)
+			}
+		}
+		c.recordDrainProgress(ctx, name, remaining, refused)
+		if err == nil && remaining == 0 {
+			c.logf("node %s: drained, %d pods evicted", name, evicted)
+			return evicted, 0
+		}
+		if !c.now().Add(drainRetryInterval).Before(deadline) {
+			c.logf("node %s: drain timed out with %d pods remaining", name, remaining)
+			return evicted, remaining
+		}
+		select {
+		case <-ctx.Done():
+			return evicted, remaining
+		case <-time.After(drainRetryInterval):
+		}
+	}
+}
+
+// recordDrainProgress notes how many pods a drain has left and which
+// PodDisruptionBudgets refused their eviction, for /status and metrics, and
+// logs when the set of blockers changes.
+func (c *NodeLifeSupportController) recordDrainProgress(ctx context.Context, name string, remaining int, refused []v1.Pod) {
+	blockers := c.blockingBudgets(ctx, refused)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d, ok := c.drains[name]
+	if !ok {
+		return
+	}
+	if len(blockers) > 0 && formatBlockers(blockers) != formatBlockers(d.blockers) {
+		c.logf("node %s: drain blocked by PodDisruptionBudgets %s", name, formatBlockers(blockers))
+	}
+	d.remaining, d.blockers = remaining, blockers
+	c.updateDrainBlockedMetric()
+}
+
+// blockingBudgets returns the PodDisruptionBudgets selecting each of pods,
+// with how many of the pods each selects.
+func (c *NodeLifeSupportController) blockingBudgets(ctx context.Context, pods []v1.Pod) map[string]int {
+	blockers := make(map[string]int)
+	budgets := make(map[string][]policyv1.PodDisruptionBudget)
+	for _, p := range pods {
+		pdbs, ok := budgets[p.Namespace]
+		if !ok {
+			opCtx, cancel := c.opContext(ctx)
+			list, err := c.client.PolicyV1().PodDisruptionBudgets(p.Namespace).List(opCtx, metav1.ListOptions{})
+			cancel()
+			if err != nil {
+				c.logf("listing PodDisruptionBudgets in %s: %v", p.Namespace, err)
+			} else {
+				pdbs = list.Items
+			}
+			budgets[p.Namespace] = pdbs
+		}
+		for _, pdb := range pdbs {
+			sel, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+			if err != nil || sel.Empty() || !sel.Matches(labels.Set(p.Labels)) {
+				continue
+			}
+			blockers[pdb.Namespace+"/"+pdb.Name]++
+		}
+	}
+	return blockers
+}
+
+// drainBlockers returns the PodDisruptionBudgets last found blocking a
+// node's drain.
+func (c *NodeLifeSupportController) drainBlockers(name string) map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d, ok := c.drains[name]; ok {
+		return d.blockers
+	}
+	return nil
+}
+
+// updateDrainBlockedMetric sets node_life_support_drain_blocked_pods from
+// every drain in progress. c.mu must be held.
+func (c *NodeLifeSupportController) updateDrainBlockedMetric() {
+	drainBlockedPods.DeletePartialMatch(prometheus.Labels{"cluster": c.opts.Cluster})
+	totals := make(map[string]int)
+	for _, d := range c.drains {
+		if d.done {
+			continue
+		}
+		for pdb, n := range d.blockers {
+			totals[pdb] += n
+		}
+	}
+	for pdb, n := range totals {
+		drainBlockedPods.WithLabelValues(c.opts.Cluster, pdb).Set(float64(n))
+	}
+}
+
+func formatBlockers(blockers map[string]int) string {
+	if len(blockers) == 0 {
+		return "(none found)"
+	}
+	names := make([]string, 0, len(blockers))
+	for pdb := range blockers {
+		names = append(names, pdb)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// forceDelete deletes the pods a drain could not evict, for policies whose
+// disruptionBudgets is Force. It returns how many were deleted.
+func (c *NodeLifeSupportController) forceDelete(ctx context.Context, name string) int {
+	pods, err := c.podsToEvict(ctx, name)
+	if err != nil {
+		c.logf("node %s: listing pods to delete: %v", name, err)
+		return 0
+	}
+	deleted := 0
+	for _, p := range pods {
+		opCtx, cancel := c.opContext(ctx)
+		err := c.client.CoreV1().Pods(p.Namespace).Delete(opCtx, p.Name, metav1.DeleteOptions{})
+		cancel()
+		if err != nil && !apierrors.IsNotFound(err) {
+			c.logf("node %s: deleting pod %s/%s: %v", name, p.Namespace, p.Name, err)
+			continue
+		}
+		deleted++
+	}
+	c.logf("node %s: deleted %d pods overriding PodDisruptionBudgets", name, deleted)
+	return deleted
+}
+
+// podsToEvict lists the node's pods that a drain has to evict.
+func (c *NodeLifeSupportController) podsToEvict(ctx context.Context, name string) ([]v1.Pod, error) {
+	all, err := c.nodePods(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	var pods []v1.Pod
+	for _, p := range all {
+		if p.DeletionTimestamp != nil {
+			continue
+		}
+		if _, ok := p.Annotations[mirrorPodAnnotation]; ok {
+			continue
+		}
+		if ref := metav1.GetControllerOf(&p); ref != nil && ref.Kind == "DaemonSet" {
+			continue
+		}
+		pods = append(pods, p)
+	}
+	return pods, nil
+}
+
+func (c *NodeLifeSupportController) evict(ctx context.Context, p v1.Pod) error {
+	ctx, cancel := c.opContext(ctx)
+	defer cancel()
+	return c.client.PolicyV1().Evictions(p.Namespace).Evict(ctx, &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{Namespace: p.Namespace, Name: p.Name},
+	})
+}
+
+// taintGaveUp applies the gaveUpTaint to a node, so nothing new is scheduled
+// onto it once life support ends.
+func (c *NodeLifeSupportController) taintGaveUp(ctx context.Context, name string) error {
+	if err := c.addTaint(ctx, name, v1.Taint{Key: gaveUpTaint, Effect: v1.TaintEffectNoSchedule}); err != nil {
+		return fmt.Errorf("tainting: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	policyv1client "k8s.io/client-go/kubernetes/typed/policy/v1"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func drainPod(name, node string, mutate func(*v1.Pod)) *v1.Pod {
+	p := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: name}, Spec: v1.PodSpec{NodeName: node}}
+	if mutate != nil {
+		mutate(p)
+	}
+	return p
+}
+
+// evictionClient returns a clientset whose evictions delete the pod, except
+// that pods in refuse are refused as if by a PodDisruptionBudget that many
+// times.
+func evictionClient(refuse map[string]int, objects ...runtime.Object) *fake.Clientset {
+	client := fake.NewSimpleClientset(objects...)
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		name := action.(k8stesting.CreateAction).GetObject().(metav1.Object).GetName()
+		if refuse[name] > 0 {
+			refuse[name]--
+			return true, nil, apierrors.NewTooManyRequests("disruption budget", 0)
+		}
+		return true, nil, client.Tracker().Delete(schema.GroupVersionResource{Version: "v1", Resource: "pods"}, action.GetNamespace(), name)
+	})
+	return client
+}
+
+func TestPodsToEvict(t *testing.T) {
+	now := metav1.Now()
+	ds := true
+	client := fake.NewSimpleClientset(
+		drainPod("app", "node1", nil),
+		drainPod("elsewhere", "node2", nil),
+		drainPod("daemon", "node1", func(p *v1.Pod) {
+			p.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "DaemonSet", Name: "ds", UID: "ds", Controller: &ds}}
+		}),
+		drainPod("static", "node1", func(p *v1.Pod) { p.Annotations = map[string]string{mirrorPodAnnotation: "x"} }),
+		drainPod("terminating", "node1", func(p *v1.Pod) {
+			p.DeletionTimestamp = &now
+			p.Finalizers = []string{"example.com/hold"}
+		}),
+		drainPod("completed", "node1", func(p *v1.Pod) { p.Status.Phase = v1.PodSucceeded }),
+	)
+	c := &NodeLifeSupportController{client: client}
+	pods, err := c.podsToEvict(context.Background(), "node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods) != 1 || pods[0].Name != "app" {
+		t.Errorf("podsToEvict() = %v, want only app", pods)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	defer func(d time.Duration) { drainRetryInterval = d }(drainRetryInterval)
+	drainRetryInterval = time.Millisecond
+
+	tests := []struct {
+		name          string
+		refuse        int
+		timeout       time.Duration
+		wantEvicted   int
+		wantRemaining int
+	}{
+		{name: "clean", timeout: time.Minute, wantEvicted: 2},
+		{name: "budget retried", refuse: 3, timeout: time.Minute, wantEvicted: 2},
+		{name: "budget outlasts timeout", refuse: 1 << 30, timeout: 20 * time.Millisecond, wantEvicted: 1, wantRemaining: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := evictionClient(map[string]int{"guarded": tt.refuse}, drainPod("app", "node1", nil), drainPod("guarded", "node1", nil))
+			c := &NodeLifeSupportController{client: client}
+			evicted, remaining := c.drain(context.Background(), "node1", time.Now().Add(tt.timeout))
+			if evicted != tt.wantEvicted || remaining != tt.wantRemaining {
+				t.Errorf("drain() = %d evicted, %d remaining; want %d, %d", evicted, remaining, tt.wantEvicted, tt.wantRemaining)
+			}
+		})
+	}
+}
+
+// TestDrainOnGiveUp tests that a node reaching MAX_LIFE_SUPPORT_DURATION is
+// kept on life support while it is drained, then tainted and expired.
+func TestDrainOnGiveUp(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1"}}
+	client := evictionClient(nil, node, drainPod("app", "node1", nil))
+	c := &NodeLifeSupportController{
+		client: client,
+		opts:   Options{HolderIdentity: HolderIdentityNode, MaxLifeSupportDuration: time.Hour, DrainOnGiveUp: true, DrainTimeout: time.Minute},
+	}
+	ctx := context.Background()
+	meta := &metav1.PartialObjectMetadata{ObjectMeta: node.ObjectMeta}
+	c.engage("node1")
+	if err := c.SyncNode(ctx, meta); err != nil {
+		t.Fatal(err)
+	}
+	c.nodes["node1"].engagedSince = time.Now().Add(-2 * time.Hour)
+
+	if c.overstayed(ctx, meta, time.Now()) {
+		t.Fatal("expired before draining")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !c.overstayed(ctx, meta, time.Now()) {
+		if !c.engaged("node1") {
+			t.Fatal("disengaged while draining")
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("drain did not finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if c.engaged("node1") {
+		t.Error("still engaged after giving up")
+	}
+	if pods, _ := client.CoreV1().Pods("ns").List(ctx, metav1.ListOptions{}); len(pods.Items) != 0 {
+		t.Errorf("pods left after drain: %v", pods.Items)
+	}
+	n, err := client.CoreV1().Nodes().Get(ctx, "node1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(n.Spec.Taints) != 1 || n.Spec.Taints[0].Key != gaveUpTaint || n.Spec.Taints[0].Effect != v1.TaintEffectNoSchedule {
+		t.Errorf("taints = %v, want %s:NoSchedule", n.Spec.Taints, gaveUpTaint)
+	}
+}
+
+// TestDrainBlockedByBudget tests that PodDisruptionBudgets refusing evictions
+// are reported, and that pods are only deleted despite them under a Force
+// policy.
+func TestDrainBlockedByBudget(t *testing.T) {
+	defer func(d time.Duration) { drainRetryInterval = d }(drainRetryInterval)
+	drainRetryInterval = time.Millisecond
+
+	for _, mode := range []string{DisruptionBudgetsRespect, DisruptionBudgetsForce} {
+		t.Run(mode, func(t *testing.T) {
+			policy := &Policy{Name: "edge", DisruptionBudgets: mode}
+			if err := policy.compile(); err != nil {
+				t.Fatal(err)
+			}
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1"}}
+			pdb := &policyv1.PodDisruptionBudget{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "db"},
+				Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}},
+			}
+			guarded := drainPod("guarded", "node1", func(p *v1.Pod) { p.Labels = map[string]string{"app": "db"} })
+			client := evictionClient(map[string]int{"guarded": 1 << 30}, node, pdb, guarded)
+			c := &NodeLifeSupportController{
+				client: client,
+				opts: Options{
+					Policies:               []*Policy{policy},
+					MaxLifeSupportDuration: time.Hour,
+					DrainOnGiveUp:          true,
+					DrainTimeout:           20 * time.Millisecond,
+				},
+			}
+			ctx := context.Background()
+			meta := &metav1.PartialObjectMetadata{ObjectMeta: node.ObjectMeta}
+			c.engage("node1")
+
+			deadline := time.Now().Add(5 * time.Second)
+			for !c.drained(ctx, meta) {
+				if time.Now().After(deadline) {
+					t.Fatal("drain did not finish")
+				}
+				time.Sleep(time.Millisecond)
+			}
+			if got := formatBlockers(c.drainBlockers("node1")); got != "ns/db" {
+				t.Errorf("blockers = %s, want ns/db", got)
+			}
+			_, err := client.CoreV1().Pods("ns").Get(ctx, "guarded", metav1.GetOptions{})
+			if deleted := apierrors.IsNotFound(err); deleted != (mode == DisruptionBudgetsForce) {
+				t.Errorf("pod deleted = %v under %s", deleted, mode)
+			}
+			c.stopDrain("node1")
+		})
+	}
+}
+
+// admittingClient sends evictions through the admission webhook first, as
+// the API server would with ADMISSION_ADDR set.
+type admittingClient struct {
+	*fake.Clientset
+	wh *admissionWebhook
+}
+
+func (a admittingClient) PolicyV1() policyv1client.PolicyV1Interface {
+	return admittingPolicy{a.Clientset.PolicyV1(), a.wh}
+}
+
+type admittingPolicy struct {
+	policyv1client.PolicyV1Interface
+	wh *admissionWebhook
+}
+
+func (a admittingPolicy) Evictions(namespace string) policyv1client.EvictionInterface {
+	return admittingEvictions{a.PolicyV1Interface.Evictions(namespace), a.wh}
+}
+
+type admittingEvictions struct {
+	policyv1client.EvictionInterface
+	wh *admissionWebhook
+}
+
+func (a admittingEvictions) Evict(ctx context.Context, eviction *policyv1.Eviction) error {
+	resp := a.wh.validatePod(&admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create, SubResource: "eviction", Namespace: eviction.Namespace, Name: eviction.Name,
+	})
+	if !resp.Allowed {
+		return &apierrors.StatusError{ErrStatus: *resp.Result}
+	}
+	return a.EvictionInterface.Evict(ctx, eviction)
+}
+
+// TestDrainOnGiveUpAdmission tests that the eviction webhook lets through
+// the drain of a node being given up on, while still denying evictions from
+// other engaged nodes.
+func TestDrainOnGiveUpAdmission(t *testing.T) {
+	defer func(d time.Duration) { drainRetryInterval = d }(drainRetryInterval)
+	drainRetryInterval = time.Millisecond
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1"}}
+	c := &NodeLifeSupportController{
+		opts: Options{MaxLifeSupportDuration: time.Hour, DrainOnGiveUp: true, DrainTimeout: time.Minute},
+	}
+	wh := &admissionWebhook{opts: &c.opts, c: c}
+	c.client = admittingClient{evictionClient(nil, node, drainPod("app", "node1", nil), drainPod("other", "node2", nil)), wh}
+	ctx := context.Background()
+	meta := &metav1.PartialObjectMetadata{ObjectMeta: node.ObjectMeta}
+	c.engage("node1")
+	c.engage("node2")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !c.drained(ctx, meta) {
+		if time.Now().After(deadline) {
+			t.Fatal("drain did not finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := c.client.CoreV1().Pods("ns").Get(ctx, "app", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("pod app not evicted: %v", err)
+	}
+	err := c.client.PolicyV1().Evictions("ns").Evict(ctx, &policyv1.Eviction{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "other"}})
+	if !apierrors.IsTooManyRequests(err) {
+		t.Errorf("eviction from node2 = %v, want denied", err)
+	}
+}
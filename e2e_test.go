@@ -0,0 +1,263 @@
+//go:build e2e
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// The end-to-end scenarios run the controller in-process against the API
+// server in KUBECONFIG, e.g. a kind cluster or envtest's, on Nodes they
+// register themselves and whose kubelet they play by renewing its lease:
+//
+//	kind create cluster
+//	go test -tags e2e -run TestE2E -v ./
+//
+// Only Nodes labelled e2eLabel are touched, and they are deleted afterwards.
+
+// e2eLabel is the label key of the Nodes the scenarios register, and the
+// controller's NODE_LABEL_ALLOWLIST.
+const e2eLabel = "node-life-support.io/e2e"
+
+// e2eLeaseSeconds is the leaseDurationSeconds of the scenarios' node leases,
+// short so that a stopped kubelet is noticed quickly.
+const e2eLeaseSeconds = 10
+
+// Step actions, and the states a step can expect the node to reach.
+const (
+	kubeletStart = "start"
+	kubeletStop  = "stop"
+
+	expectEngaged       = "engaged"
+	expectDisengaged    = "disengaged"
+	expectUnschedulable = "unschedulable"
+	expectSchedulable   = "schedulable"
+)
+
+// e2eStep is one step of a scenario: it starts or stops the kubelet, adds
+// a taint to the node, or waits up to within for the node to reach a state.
+type e2eStep struct {
+	kubelet string
+	taint   *v1.Taint
+	expect  string
+	within  time.Duration
+}
+
+// e2eScenario is a named sequence of steps, run against a controller
+// configured by env on top of the scenarios' defaults.
+type e2eScenario struct {
+	name  string
+	env   map[string]string
+	steps []e2eStep
+}
+
+var e2eScenarios = []e2eScenario{
+	{
+		name: "kubelet stops and resumes",
+		steps: []e2eStep{
+			{kubelet: kubeletStop},
+			{expect: expectEngaged, within: 30 * time.Second},
+			{kubelet: kubeletStart},
+			{expect: expectDisengaged, within: 30 * time.Second},
+		},
+	},
+	{
+		name: "cordoned while engaged",
+		env:  map[string]string{"CORDON": "true"},
+		steps: []e2eStep{
+			{kubelet: kubeletStop},
+			{expect: expectEngaged, within: 30 * time.Second},
+			{expect: expectUnschedulable, within: 10 * time.Second},
+			{kubelet: kubeletStart},
+			{expect: expectDisengaged, within: 30 * time.Second},
+			{expect: expectSchedulable, within: 10 * time.Second},
+		},
+	},
+	{
+		name: "out of service",
+		steps: []e2eStep{
+			{kubelet: kubeletStop},
+			{expect: expectEngaged, within: 30 * time.Second},
+			{taint: &v1.Taint{Key: v1.TaintNodeOutOfService, Value: "nodeshutdown", Effect: v1.TaintEffectNoExecute}},
+			{expect: expectDisengaged, within: 15 * time.Second},
+		},
+	},
+}
+
+func TestE2E(t *testing.T) {
+	cfg, err := BuildConfig("", "")
+	if err != nil {
+		t.Fatalf("no API server to test against, set KUBECONFIG: %v", err)
+	}
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sc := range e2eScenarios {
+		t.Run(sc.name, func(t *testing.T) {
+			for k, v := range map[string]string{
+				"NODE_LABEL_ALLOWLIST": e2eLabel,
+				"SYNC_INTERVAL":        "1s",
+				"ENGAGE_GRACE_PERIOD":  "2s",
+				"RECOVERY_COOLDOWN":    "5s",
+				"POD_NAMESPACE":        "default",
+			} {
+				t.Setenv(k, v)
+			}
+			for k, v := range sc.env {
+				t.Setenv(k, v)
+			}
+			runE2EScenario(t, client, cfg, sc)
+		})
+	}
+}
+
+// runE2EScenario registers a node for sc, starts a controller and runs
+// sc's steps in order.
+func runE2EScenario(t *testing.T, client kubernetes.Interface, cfg *rest.Config, sc e2eScenario) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	name := "nls-e2e-" + strings.ReplaceAll(sc.name, " ", "-") + "-" + rand.String(5)
+	kubelet := registerE2ENode(ctx, t, client, name)
+
+	opts, err := LoadOptions()
+	if err != nil {
+		t.Fatalf("invalid configuration: %v", err)
+	}
+	c, err := NewNodeLifeSupportController(cfg, opts)
+	if err != nil {
+		t.Fatalf("NewNodeLifeSupportController() error: %v", err)
+	}
+	if err := c.Start(ctx); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	go c.Run(ctx)
+
+	for i, s := range sc.steps {
+		switch {
+		case s.kubelet == kubeletStart:
+			kubelet.Store(true)
+		case s.kubelet == kubeletStop:
+			kubelet.Store(false)
+		case s.taint != nil:
+			node, err := client.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			node.Spec.Taints = append(node.Spec.Taints, *s.taint)
+			if _, err := client.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{}); err != nil {
+				t.Fatalf("step %d: tainting %s: %v", i, name, err)
+			}
+		case s.expect != "":
+			err := wait.PollUntilContextTimeout(ctx, 500*time.Millisecond, s.within, true, func(ctx context.Context) (bool, error) {
+				return e2eReached(ctx, c, client, name, s.expect)
+			})
+			if err != nil {
+				t.Fatalf("step %d: node %s not %s within %s: %v", i, name, s.expect, s.within, err)
+			}
+		default:
+			t.Fatalf("step %d does nothing", i)
+		}
+	}
+}
+
+// registerE2ENode creates a node labelled e2eLabel, with a lease renewed
+// every second while the returned kubelet is true, and deletes both when t
+// finishes.
+func registerE2ENode(ctx context.Context, t *testing.T, client kubernetes.Interface, name string) *atomic.Bool {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{e2eLabel: "true"}}}
+	node, err := client.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("registering node %s: %v", name, err)
+	}
+	duration := int32(e2eLeaseSeconds)
+	now := metav1.NewMicroTime(time.Now())
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: nodeLeaseNamespace, OwnerReferences: []metav1.OwnerReference{
+			{APIVersion: "v1", Kind: "Node", Name: name, UID: node.UID},
+		}},
+		Spec: coordinationv1.LeaseSpec{HolderIdentity: &name, LeaseDurationSeconds: &duration, RenewTime: &now},
+	}
+	if _, err := client.CoordinationV1().Leases(nodeLeaseNamespace).Create(ctx, lease, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("creating lease of %s: %v", name, err)
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		if err := client.CoreV1().Nodes().Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+			t.Logf("deleting node %s: %v", name, err)
+		}
+		if err := client.CoordinationV1().Leases(nodeLeaseNamespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+			t.Logf("deleting lease of %s: %v", name, err)
+		}
+	})
+
+	kubelet := &atomic.Bool{}
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if !kubelet.Load() {
+				continue
+			}
+			leases := client.CoordinationV1().Leases(nodeLeaseNamespace)
+			lease, err := leases.Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				continue
+			}
+			now := metav1.NewMicroTime(time.Now())
+			lease.Spec.RenewTime = &now
+			lease.Spec.HolderIdentity = &name
+			// Conflicts with the controller's renewals are retried next tick.
+			_, _ = leases.Update(ctx, lease, metav1.UpdateOptions{})
+		}
+	}()
+	return kubelet
+}
+
+// e2eReached reports whether node name is in state, as far as both the
+// controller and the API server are concerned.
+func e2eReached(ctx context.Context, c *NodeLifeSupportController, client kubernetes.Interface, name, state string) (bool, error) {
+	node, err := client.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return false, nil
+	}
+	condition := func(typ v1.NodeConditionType) *v1.NodeCondition {
+		for i := range node.Status.Conditions {
+			if node.Status.Conditions[i].Type == typ {
+				return &node.Status.Conditions[i]
+			}
+		}
+		return nil
+	}
+	switch state {
+	case expectEngaged:
+		ready := condition(v1.NodeReady)
+		return c.engaged(name) && ready != nil && ready.Status == v1.ConditionTrue && ready.Reason == defaultReadyReason, nil
+	case expectDisengaged:
+		active := condition(lifeSupportCondition)
+		return !c.engaged(name) && (active == nil || active.Status == v1.ConditionFalse), nil
+	case expectUnschedulable:
+		return node.Spec.Unschedulable, nil
+	case expectSchedulable:
+		return !node.Spec.Unschedulable, nil
+	}
+	return false, fmt.Errorf("unknown state %q", state)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// eventSource identifies the controller as the reporter of its Events.
+const eventSource = "node-life-support"
+
+// Event reasons recorded on Nodes.
+const (
+	reasonLifeSupportExpired    = "LifeSupportExpired"
+	reasonLifeSupportStoodDown  = "LifeSupportStoodDown"
+	reasonLifeSupportDrained    = "LifeSupportDrained"
+	reasonLifeSupportThreshold  = "LifeSupportThresholdExceeded"
+	reasonLifeSupportDisengaged = "LifeSupportDisengaged"
+	reasonPolicyOverlap         = "PolicyOverlap"
+	reasonLifeSupportSkipped    = "LifeSupportSkipped"
+)
+
+// newEventRecorder returns a recorder that writes Events through client.
+func newEventRecorder(client kubernetes.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: eventSource})
+}
+
+// nodeEvent records an Event on a node, so that it shows up in
+// `kubectl describe node`, unless it is throttled. It is a no-op without a
+// recorder.
+func (c *NodeLifeSupportController) nodeEvent(name string, uid types.UID, eventType, reason, messageFmt string, args ...interface{}) {
+	c.nodeEventAnnotated(name, uid, nil, eventType, reason, messageFmt, args...)
+}
+
+// nodeEventAnnotated is nodeEvent with annotations on the Event.
+func (c *NodeLifeSupportController) nodeEventAnnotated(name string, uid types.UID, annotations map[string]string, eventType, reason, messageFmt string, args ...interface{}) {
+	if c.recorder == nil {
+		return
+	}
+	msg := fmt.Sprintf(messageFmt, args...)
+	if !c.throttle.allow(notifierEvents, reason, name, msg, c.now()) {
+		return
+	}
+	ref := &v1.ObjectReference{APIVersion: "v1", Kind: "Node", Name: name, UID: uid}
+	if len(annotations) > 0 {
+		c.recorder.AnnotatedEventf(ref, annotations, eventType, reason, "%s", msg)
+		return
+	}
+	c.recorder.Event(ref, eventType, reason, msg)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// execHookOutput bounds how much of a failed hook's output is logged.
+const execHookOutput = 1024
+
+// Transitions exec hooks can be configured for, with the environment
+// variables naming their commands.
+var execHookEnv = map[string]string{
+	transitionEngaged:    "EXEC_HOOK_ENGAGED",
+	transitionDisengaged: "EXEC_HOOK_DISENGAGED",
+	transitionGaveUp:     "EXEC_HOOK_GAVE_UP",
+}
+
+// execHookEvent is written as JSON to an exec hook's stdin.
+type execHookEvent struct {
+	Transition string `json:"transition"`
+	Node       string `json:"node"`
+	// Cluster is set in multi-cluster mode.
+	Cluster    string `json:"cluster,omitempty"`
+	Controller string `json:"controller"`
+	// Reason is why the node was disengaged, on Disengaged and GaveUp.
+	Reason       string    `json:"reason,omitempty"`
+	EngagedSince time.Time `json:"engagedSince"`
+	Time         time.Time `json:"time"`
+	// Traceparent identifies the sync cycle the transition happened in, as a
+	// W3C Trace Context, when it happened in one.
+	Traceparent string `json:"traceparent,omitempty"`
+}
+
+// execHooks tracks the exec hooks still running, so the controller waits for
+// them, up to EXEC_HOOK_TIMEOUT, before it exits.
+var execHooks sync.WaitGroup
+
+// waitExecHooks waits for running exec hooks to finish.
+func waitExecHooks() {
+	execHooks.Wait()
+}
+
+// loadExecHooks reads the command of each transition's hook, split on
+// whitespace; there is no shell.
+func loadExecHooks() map[string][]string {
+	hooks := make(map[string][]string)
+	for transition, env := range execHookEnv {
+		if argv := strings.Fields(os.Getenv(env)); len(argv) > 0 {
+			hooks[transition] = argv
+		}
+	}
+	return hooks
+}
+
+// runExecHook runs the hook configured for a transition, if any, in the
+// background so it never holds up heartbeats. The event is passed as JSON on
+// stdin and in NLS_* environment variables; failures are logged and counted.
+func (c *NodeLifeSupportController) runExecHook(transition, node, reason string, since time.Time) {
+	argv, ok := c.opts.ExecHooks[transition]
+	if !ok {
+		return
+	}
+	ev := execHookEvent{
+		Transition:   transition,
+		Node:         node,
+		Cluster:      c.opts.Cluster,
+		Controller:   c.opts.Identity,
+		Reason:       reason,
+		EngagedSince: since.UTC(),
+		Time:         c.now().UTC(),
+	}
+	if t := c.cycleTrace.Load(); t != nil {
+		ev.Traceparent = t.traceparent()
+	}
+	input, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	execHooks.Add(1)
+	go func() {
+		defer execHooks.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), c.opts.ExecHookTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+		cmd.Stdin = bytes.NewReader(input)
+		cmd.Env = append(os.Environ(),
+			"NLS_TRANSITION="+transition,
+			"NLS_NODE="+node,
+			"NLS_CLUSTER="+c.opts.Cluster,
+			"NLS_REASON="+reason,
+		)
+		if ev.Traceparent != "" {
+			cmd.Env = append(cmd.Env, "TRACEPARENT="+ev.Traceparent)
+		}
+		out, err := cmd.CombinedOutput()
+		if err == nil {
+			return
+		}
+		execHookFailures.WithLabelValues(c.opts.Cluster, transition).Inc()
+		if len(out) > execHookOutput {
+			out = out[len(out)-execHookOutput:]
+		}
+		c.logf("node %s: %s exec hook: %v: %s", node, transition, err, strings.TrimSpace(string(out)))
+	}()
+}
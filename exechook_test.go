@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/kubernetes/fake"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// TestExecHooks tests that the hooks configured for a transition run with
+// the event on stdin and in the environment, and that failures are counted.
+func TestExecHooks(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "hook.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\ncat > \"$1/$NLS_TRANSITION-$NLS_NODE.json\"\necho \"$NLS_REASON\" > \"$1/$NLS_TRANSITION-$NLS_NODE.reason\"\n"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	since := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	c := &NodeLifeSupportController{
+		client: fake.NewSimpleClientset(),
+		clock:  clocktesting.NewFakeClock(since),
+		opts: Options{
+			Cluster:  "hooks",
+			Identity: "nls-0",
+			ExecHooks: map[string][]string{
+				transitionEngaged:    {script, dir},
+				transitionDisengaged: {script, dir},
+				transitionGaveUp:     {"/bin/sh", "-c", "echo no BMC >&2; exit 3"},
+			},
+			ExecHookTimeout: 10 * time.Second,
+		},
+	}
+
+	c.engage("node1")
+	if err := c.disengage(context.Background(), "node1", disengageWindowClosed); err != nil {
+		t.Fatal(err)
+	}
+	c.runExecHook(transitionGaveUp, "node2", disengageMaxDurationExceeded, since)
+	waitExecHooks()
+
+	raw, err := os.ReadFile(filepath.Join(dir, "Engaged-node1.json"))
+	if err != nil {
+		t.Fatalf("engage hook did not run: %v", err)
+	}
+	var ev execHookEvent
+	if err := json.Unmarshal(raw, &ev); err != nil {
+		t.Fatal(err)
+	}
+	want := execHookEvent{Transition: transitionEngaged, Node: "node1", Cluster: "hooks", Controller: "nls-0", EngagedSince: since, Time: since}
+	if ev != want {
+		t.Errorf("engage event = %+v, want %+v", ev, want)
+	}
+	reason, err := os.ReadFile(filepath.Join(dir, "Disengaged-node1.reason"))
+	if err != nil {
+		t.Fatalf("disengage hook did not run: %v", err)
+	}
+	if got := strings.TrimSpace(string(reason)); got != disengageWindowClosed {
+		t.Errorf("NLS_REASON = %q, want %q", got, disengageWindowClosed)
+	}
+	if got := testutil.ToFloat64(execHookFailures.WithLabelValues("hooks", transitionGaveUp)); got != 1 {
+		t.Errorf("give-up hook failures = %v, want 1", got)
+	}
+}
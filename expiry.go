@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// expiresAtAnnotation keeps a node on life support until an RFC 3339
+// timestamp, regardless of policies, e.g. "2024-05-04T09:00:00+01:00". Once it
+// has passed the node is disengaged and left alone until the annotation is
+// changed or removed.
+const expiresAtAnnotation = "node-life-support.io/expires-at"
+
+// expiresAt returns the node's expiry from its annotation, or false if it has
+// none or it cannot be parsed.
+func (c *NodeLifeSupportController) expiresAt(node *metav1.PartialObjectMetadata) (time.Time, bool) {
+	v, ok := node.Annotations[expiresAtAnnotation]
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		c.invalidAnnotation(node.Name, expiresAtAnnotation, v, err)
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// overstayed enforces MAX_LIFE_SUPPORT_DURATION: a node engaged for longer is
+// expired and, for as long as it stays selected, not engaged again. With
+// DRAIN_ON_GIVE_UP it is first drained, still on life support, and tainted;
+// with CLEAN_UP_MIRROR_PODS its mirror pods are deleted afterwards. It reports
+// whether the node must be skipped.
+func (c *NodeLifeSupportController) overstayed(ctx context.Context, node *metav1.PartialObjectMetadata, now time.Time) bool {
+	limit := c.opts.MaxLifeSupportDuration
+	if limit <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	_, exhausted := c.exhausted[node.Name]
+	st, engaged := c.nodes[node.Name]
+	c.mu.Unlock()
+	if exhausted {
+		return true
+	}
+	if !engaged || now.Sub(st.engagedSince) < limit {
+		return false
+	}
+	if c.opts.DrainOnGiveUp {
+		if !c.drained(ctx, node) {
+			return false
+		}
+		if err := c.taintGaveUp(ctx, node.Name); err != nil {
+			c.logf("node %s: %v", node.Name, err)
+		}
+	}
+
+	why := fmt.Sprintf("maximum duration %s reached", limit)
+	c.mu.Lock()
+	c.recordTransition(node.Name, transitionGaveUp, why)
+	c.mu.Unlock()
+	c.runExecHook(transitionGaveUp, node.Name, disengageMaxDurationExceeded, st.engagedSince)
+	c.expire(ctx, node, v1.EventTypeWarning, disengageMaxDurationExceeded, why)
+	if c.opts.CleanUpMirrorPods {
+		c.deleteMirrorPods(ctx, node.Name)
+	}
+	c.mu.Lock()
+	if c.exhausted == nil {
+		c.exhausted = make(map[string]struct{})
+	}
+	c.exhausted[node.Name] = struct{}{}
+	c.mu.Unlock()
+	return true
+}
+
+// pruneExhausted lets nodes that are no longer selected be engaged again
+// should they be selected later.
+func (c *NodeLifeSupportController) pruneExhausted(held map[string]struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name := range c.exhausted {
+		if _, ok := held[name]; !ok {
+			delete(c.exhausted, name)
+		}
+	}
+}
+
+// expire disengages a node whose life support has run out, recording a
+// LifeSupportExpired Event and sending a webhook notification with the reason,
+// why in words and how long the node was supported. Nodes that were not
+// engaged are left alone.
+func (c *NodeLifeSupportController) expire(ctx context.Context, node *metav1.PartialObjectMetadata, eventType, reason, why string) {
+	since, ok := c.engagedSince(node.Name)
+	if !ok {
+		return
+	}
+	if err := c.disengage(ctx, node.Name, reason); err != nil {
+		c.logf("failed disengaging expired node %s: %v", node.Name, err)
+		return
+	}
+	supported := c.now().Sub(since)
+	msg := fmt.Sprintf("Life support expired (%s) after %s", why, supported.Round(time.Second))
+	c.logf("node %s: %s", node.Name, msg)
+	c.nodeEventAnnotated(node.Name, node.UID, map[string]string{reasonAnnotation: reason}, eventType, reasonLifeSupportExpired, "%s", msg)
+	c.notify(ctx, notification{
+		Event:            reasonLifeSupportExpired,
+		Reason:           reason,
+		Node:             node.Name,
+		Message:          msg,
+		EngagedSince:     since.UTC(),
+		SupportedSeconds: supported.Seconds(),
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// TestExpiresAt tests parsing of the expiry annotation.
+func TestExpiresAt(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    *string
+		expected time.Time
+		ok       bool
+	}{
+		{name: "no annotation", ok: false},
+		{name: "utc", value: strPtr("2024-05-04T09:00:00Z"), expected: time.Date(2024, 5, 4, 9, 0, 0, 0, time.UTC), ok: true},
+		{name: "offset", value: strPtr("2024-05-04T09:00:00+01:00"), expected: time.Date(2024, 5, 4, 8, 0, 0, 0, time.UTC), ok: true},
+		{name: "invalid", value: strPtr("saturday morning"), ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+			if tt.value != nil {
+				node.Annotations = map[string]string{expiresAtAnnotation: *tt.value}
+			}
+			c := &NodeLifeSupportController{}
+			got, ok := c.expiresAt(node)
+			if ok != tt.ok || !got.Equal(tt.expected) {
+				t.Errorf("expiresAt() = %v, %v; want %v, %v", got, ok, tt.expected, tt.ok)
+			}
+		})
+	}
+}
+
+// TestExpire tests that an expired node is disengaged with an Event, and that
+// nodes never engaged are left alone.
+func TestExpire(t *testing.T) {
+	ctx := context.Background()
+	recorder := record.NewFakeRecorder(10)
+	c := &NodeLifeSupportController{client: fake.NewSimpleClientset(), recorder: recorder}
+	node := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+
+	c.expire(ctx, node, "Normal", disengageExpiryReached, "expires-at 2024-05-04T09:00:00Z reached")
+	if len(recorder.Events) != 0 {
+		t.Fatalf("event recorded for a node that was never engaged: %s", <-recorder.Events)
+	}
+
+	c.engage("node1")
+	c.expire(ctx, node, "Normal", disengageExpiryReached, "expires-at 2024-05-04T09:00:00Z reached")
+	if c.engaged("node1") {
+		t.Errorf("node still engaged after expiry")
+	}
+	select {
+	case e := <-recorder.Events:
+		if !strings.Contains(e, reasonLifeSupportExpired) || !strings.Contains(e, "2024-05-04T09:00:00Z") {
+			t.Errorf("event = %q", e)
+		}
+	default:
+		t.Errorf("no event recorded on expiry")
+	}
+}
+
+func strPtr(s string) *string { return &s }
+
+// TestOverstayed tests MAX_LIFE_SUPPORT_DURATION: an engagement past the limit
+// is expired and not resumed until the node is no longer selected.
+func TestOverstayed(t *testing.T) {
+	ctx := context.Background()
+	recorder := record.NewFakeRecorder(10)
+	c := &NodeLifeSupportController{
+		client:   fake.NewSimpleClientset(),
+		recorder: recorder,
+		opts:     Options{MaxLifeSupportDuration: time.Hour},
+	}
+	node := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	now := time.Now()
+
+	c.engage("node1")
+	if c.overstayed(ctx, node, now) {
+		t.Fatalf("node overstayed right after engaging")
+	}
+	c.nodes["node1"].engagedSince = now.Add(-2 * time.Hour)
+	if !c.overstayed(ctx, node, now) {
+		t.Fatalf("node past the limit not expired")
+	}
+	if c.engaged("node1") {
+		t.Errorf("node still engaged after reaching the limit")
+	}
+	if e := <-recorder.Events; !strings.HasPrefix(e, "Warning "+reasonLifeSupportExpired) || !strings.Contains(e, "maximum duration 1h0m0s reached") {
+		t.Errorf("event = %q", e)
+	}
+	if !c.overstayed(ctx, node, now) {
+		t.Errorf("exhausted node would be engaged again while still selected")
+	}
+
+	c.pruneExhausted(map[string]struct{}{})
+	if c.overstayed(ctx, node, now) {
+		t.Errorf("node still exhausted after it stopped being selected")
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// expressionEnv declares what policy expressions can refer to: the Node
+// object as node, with its metadata, spec and status as in its JSON, and the
+// current time as now.
+var expressionEnv = func() *cel.Env {
+	env, err := cel.NewEnv(
+		cel.Variable("node", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("now", cel.TimestampType),
+	)
+	if err != nil {
+		panic(err)
+	}
+	return env
+}()
+
+// compileExpression compiles a policy's CEL expression, which must evaluate
+// to a bool.
+func compileExpression(expr string) (cel.Program, error) {
+	ast, iss := expressionEnv.Compile(expr)
+	if iss.Err() != nil {
+		return nil, iss.Err()
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("must evaluate to a bool, not %s", ast.OutputType())
+	}
+	return expressionEnv.Program(ast)
+}
+
+// eligible evaluates the policy's expression against a node at now.
+func (p *Policy) eligible(node *v1.Node, now time.Time) (bool, error) {
+	if p.program == nil {
+		return true, nil
+	}
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(node)
+	if err != nil {
+		return false, err
+	}
+	out, _, err := p.program.Eval(map[string]interface{}{"node": obj, "now": now})
+	if err != nil {
+		return false, err
+	}
+	ok, isBool := out.Value().(bool)
+	if !isBool {
+		return false, fmt.Errorf("evaluated to %v, not a bool", out.Value())
+	}
+	return ok, nil
+}
+
+// hasExpressions reports whether any policy has an expression, which needs
+// the full Node objects watched.
+func hasExpressions(policies []*Policy) bool {
+	for _, p := range policies {
+		if p.program != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// startNodeInformer watches full Node objects for policy expressions, which
+// unlike selectors can look at a node's spec and status. It returns the
+// informer's HasSynced.
+func (c *NodeLifeSupportController) startNodeInformer(stop <-chan struct{}) cache.InformerSynced {
+	factory := informers.NewSharedInformerFactory(c.client, c.opts.ResyncPeriod)
+	inf := factory.Core().V1().Nodes()
+	c.fullNodes = inf.Lister()
+	synced := inf.Informer().HasSynced
+	factory.Start(stop)
+	return synced
+}
+
+// expressionErrors logs each policy expression's or filter's failures on a
+// node once per distinct error rather than every cycle.
+type expressionErrors struct {
+	mu   sync.Mutex
+	last map[string]string
+}
+
+func (e *expressionErrors) report(c *NodeLifeSupportController, node, policy, source string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.last == nil {
+		e.last = make(map[string]string)
+	}
+	key := node + " " + policy + " " + source
+	if e.last[key] == err.Error() {
+		return
+	}
+	e.last[key] = err.Error()
+	c.logf("node %s: policy %q %s: %v; treating it as not eligible", node, policy, source, err)
+}
+
+// expressionMatches reports whether a node passes the policy's expression.
+// Nodes not yet in the cache, or whose evaluation fails, do not.
+func (c *NodeLifeSupportController) expressionMatches(p *Policy, name string) bool {
+	if p.program == nil {
+		return true
+	}
+	if c.fullNodes == nil {
+		return false
+	}
+	node, err := c.fullNodes.Get(name)
+	if err != nil {
+		return false
+	}
+	ok, err := p.eligible(node, c.now())
+	if err != nil {
+		c.exprErrors.report(c, name, p.Name, "expression", err)
+		return false
+	}
+	return ok
+}
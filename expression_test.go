@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// TestPolicyExpression tests that policy expressions are evaluated against
+// the whole Node, and that nodes failing them fall through to later policies.
+func TestPolicyExpression(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	policies, err := loadPolicies(writePolicyFile(t, `
+policies:
+  - name: edge
+    nodeSelector:
+      matchLabels:
+        pool: edge
+    expression: >-
+      node.metadata.labels['topology.kubernetes.io/zone'] != 'zone-a' &&
+      now - timestamp(node.metadata.creationTimestamp) > duration('1h')
+  - name: aws
+    expression: has(node.spec.providerID) && node.spec.providerID.startsWith('aws://')
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	nodes := []*v1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "edge-b-old", CreationTimestamp: metav1.NewTime(now.Add(-2 * time.Hour)),
+			Labels: map[string]string{"pool": "edge", "topology.kubernetes.io/zone": "zone-b"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "edge-a-old", CreationTimestamp: metav1.NewTime(now.Add(-2 * time.Hour)),
+			Labels: map[string]string{"pool": "edge", "topology.kubernetes.io/zone": "zone-a"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "edge-b-new", CreationTimestamp: metav1.NewTime(now.Add(-time.Minute)),
+			Labels: map[string]string{"pool": "edge", "topology.kubernetes.io/zone": "zone-b"}},
+			Spec: v1.NodeSpec{ProviderID: "aws:///eu-west-1a/i-0123"}},
+		// Without a zone label the edge expression fails and is logged.
+		{ObjectMeta: metav1.ObjectMeta{Name: "edge-unzoned", CreationTimestamp: metav1.NewTime(now.Add(-2 * time.Hour)),
+			Labels: map[string]string{"pool": "edge"}}},
+	}
+	for _, n := range nodes {
+		if err := indexer.Add(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := &NodeLifeSupportController{
+		opts:      Options{Policies: policies},
+		clock:     clocktesting.NewFakeClock(now),
+		fullNodes: corelisters.NewNodeLister(indexer),
+	}
+
+	tests := []struct {
+		node   string
+		policy string
+	}{
+		{node: "edge-b-old", policy: "edge"},
+		{node: "edge-a-old", policy: ""},
+		{node: "edge-b-new", policy: "aws"},
+		{node: "edge-unzoned", policy: ""},
+		// Not in the cache yet.
+		{node: "missing", policy: ""},
+	}
+	for _, tt := range tests {
+		var got string
+		if p := c.policyFor(labelledNode(tt.node, map[string]string{"pool": "edge"})); p != nil {
+			got = p.Name
+		}
+		if got != tt.policy {
+			t.Errorf("policyFor(%s) = %q, want %q", tt.node, got, tt.policy)
+		}
+	}
+	if len(c.exprErrors.last) != 1 {
+		t.Errorf("expression errors = %v, want edge-unzoned's", c.exprErrors.last)
+	}
+}
+
+// TestCompileExpression tests that expressions must compile to a bool.
+func TestCompileExpression(t *testing.T) {
+	tests := []struct {
+		expr      string
+		expectErr bool
+	}{
+		{expr: "'node-role.kubernetes.io/edge' in node.metadata.labels"},
+		{expr: "node.status.conditions.exists(c, c.type == 'Ready' && c.status != 'True')"},
+		{expr: "node.metadata.name", expectErr: true},
+		{expr: "node.metadata.name ==", expectErr: true},
+		{expr: "nodes.size() > 0", expectErr: true},
+	}
+	for _, tt := range tests {
+		if _, err := compileExpression(tt.expr); (err != nil) != tt.expectErr {
+			t.Errorf("compileExpression(%q) error = %v, expectErr %v", tt.expr, err, tt.expectErr)
+		}
+	}
+}
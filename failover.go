@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+// endpointFailover spreads one cluster's API traffic over redundant API
+// server endpoints: requests go to a single active endpoint, which moves to
+// the next one as soon as a request fails to reach it, and back to the most
+// preferred healthy endpoint once health checks succeed again.
+type endpointFailover struct {
+	endpoints []*url.URL
+
+	mu     sync.Mutex
+	active int
+}
+
+// newEndpointFailover returns a failover over primary followed by others, in
+// order of preference.
+func newEndpointFailover(primary string, others []string) (*endpointFailover, error) {
+	f := &endpointFailover{}
+	for _, e := range append([]string{primary}, others...) {
+		u, err := url.Parse(e)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("invalid API server endpoint %q", e)
+		}
+		f.endpoints = append(f.endpoints, u)
+	}
+	return f, nil
+}
+
+// current returns the index and URL of the active endpoint.
+func (f *endpointFailover) current() (int, *url.URL) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.active, f.endpoints[f.active]
+}
+
+// failed moves off endpoint i after a request to it failed, unless another
+// request already did.
+func (f *endpointFailover) failed(i int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.active != i {
+		return
+	}
+	f.active = (i + 1) % len(f.endpoints)
+	log.Printf("API server %s unreachable (%v), failing over to %s", f.endpoints[i].Host, err, f.endpoints[f.active].Host)
+}
+
+// prefer makes endpoint i active if it is preferred over the active one.
+func (f *endpointFailover) prefer(i int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if i < f.active {
+		log.Printf("API server %s healthy again, switching back from %s", f.endpoints[i].Host, f.endpoints[f.active].Host)
+		f.active = i
+	}
+}
+
+// wrap is a rest.Config WrapTransport sending requests to the active endpoint.
+func (f *endpointFailover) wrap(rt http.RoundTripper) http.RoundTripper {
+	return &failoverTransport{failover: f, next: rt}
+}
+
+type failoverTransport struct {
+	failover *endpointFailover
+	next     http.RoundTripper
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	i, u := t.failover.current()
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = u.Scheme, u.Host
+	req.Host = ""
+	resp, err := t.next.RoundTrip(req)
+	if err != nil && req.Context().Err() == nil && !errors.Is(err, context.Canceled) {
+		t.failover.failed(i, err)
+	}
+	return resp, err
+}
+
+// run health-checks every endpoint preferred over the active one every
+// interval via /readyz, switching back to the first that is ready. cfg must
+// not route through the failover itself.
+func (f *endpointFailover) run(ctx context.Context, cfg *rest.Config, interval time.Duration) {
+	client, err := rest.HTTPClientFor(cfg)
+	if err != nil {
+		log.Printf("API server health checks disabled: %v", err)
+		return
+	}
+	client.Timeout = interval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		active, _ := f.current()
+		for i := 0; i < active; i++ {
+			if f.ready(ctx, client, i) {
+				f.prefer(i)
+				break
+			}
+		}
+	}
+}
+
+// ready reports whether endpoint i answers /readyz successfully.
+func (f *endpointFailover) ready(ctx context.Context, client *http.Client, i int) bool {
+	u := *f.endpoints[i]
+	u.Path = "/readyz"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestEndpointFailover tests failing over when an endpoint is unreachable and
+// switching back once it is ready again.
+func TestEndpointFailover(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Endpoint", "backup")
+	}))
+	defer backup.Close()
+
+	f, err := newEndpointFailover(primary.URL, []string{backup.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: f.wrap(http.DefaultTransport)}
+	primaryURL := primary.URL
+	primary.Close()
+
+	// The first request fails against the dead primary and moves traffic on.
+	if resp, err := client.Get(primaryURL + "/api"); err == nil {
+		resp.Body.Close()
+		t.Fatalf("request to a closed endpoint succeeded")
+	}
+	resp, err := client.Get(primaryURL + "/api")
+	if err != nil {
+		t.Fatalf("request after failover: %v", err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("X-Endpoint") != "backup" {
+		t.Errorf("request not served by the backup endpoint")
+	}
+
+	if f.ready(context.Background(), http.DefaultClient, 0) {
+		t.Errorf("closed primary reported ready")
+	}
+	if !f.ready(context.Background(), http.DefaultClient, 1) {
+		t.Errorf("backup not reported ready")
+	}
+	f.prefer(0)
+	if i, _ := f.current(); i != 0 {
+		t.Errorf("active endpoint = %d after preferring the primary, want 0", i)
+	}
+}
+
+// TestNewEndpointFailover tests endpoint validation.
+func TestNewEndpointFailover(t *testing.T) {
+	if _, err := newEndpointFailover("https://a:6443", []string{"https://b:6443"}); err != nil {
+		t.Errorf("valid endpoints rejected: %v", err)
+	}
+	for _, bad := range []string{"b:6443", "ftp://b", "https://"} {
+		if _, err := newEndpointFailover("https://a:6443", []string{bad}); err == nil {
+			t.Errorf("endpoint %q accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// The fuzz targets below run their seeds with go test; to fuzz one, e.g.
+//
+//	go test -run '^$' -fuzz FuzzLeaseRenewal -fuzztime 1m .
+
+// fuzzTime returns the time sec and nsec after the Unix epoch, or false if
+// it falls outside years 1 to 9999, which neither a clock nor the API server
+// produces and RFC 3339 cannot represent.
+func fuzzTime(sec, nsec int64) (time.Time, bool) {
+	t := time.Unix(sec, nsec).UTC()
+	return t, t.Year() >= 1 && t.Year() <= 9999
+}
+
+func FuzzAllowlistSelectors(f *testing.F) {
+	for _, seed := range []string{"", "pool", "node-life-support.io/enabled,pool", "a/b/c", "-bad", "example.com/", strings.Repeat("k", 64), "a,,b"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, keys string) {
+		allowed := make(map[string]struct{})
+		if keys != "" {
+			for _, k := range strings.Split(keys, ",") {
+				allowed[k] = struct{}{}
+			}
+		}
+		selectors, err := allowlistSelectors(allowed)
+		if err != nil {
+			return
+		}
+		if len(allowed) == 0 {
+			if len(selectors) != 1 || selectors[0] != "" {
+				t.Fatalf("allowlistSelectors(nil) = %q, want one empty selector", selectors)
+			}
+			return
+		}
+		if len(selectors) != len(allowed) {
+			t.Fatalf("allowlistSelectors(%q) = %d selectors, want %d", keys, len(selectors), len(allowed))
+		}
+		parsed := make([]labels.Selector, len(selectors))
+		for i, s := range selectors {
+			if parsed[i], err = labels.Parse(s); err != nil {
+				t.Fatalf("selector %q for %q does not parse: %v", s, keys, err)
+			}
+		}
+		for k := range allowed {
+			set := labels.Set{k: ""}
+			matched := false
+			for _, sel := range parsed {
+				matched = matched || sel.Matches(set)
+			}
+			if !matched {
+				t.Errorf("no selector of %q matches a node labelled %q", selectors, k)
+			}
+		}
+	})
+}
+
+func FuzzParseReadinessGates(f *testing.F) {
+	for _, seed := range []string{"kube-system/cilium", " kube-system/cilium , monitoring/node-exporter", "cilium", "/cilium", "kube-system/", "a/b/c", ""} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		gates, err := parseReadinessGates(strings.Split(s, ","))
+		if err != nil {
+			return
+		}
+		for _, g := range gates {
+			again, err := parseReadinessGates([]string{g.String()})
+			if err != nil || len(again) != 1 || again[0] != g {
+				t.Errorf("gate %+v from %q parses back as %+v, %v", g, s, again, err)
+			}
+		}
+	})
+}
+
+func FuzzParseKeepAliveLeases(f *testing.F) {
+	for _, seed := range []string{"kube-system/keepalive", "ops/beacon=30s, ops/other", "ops/beacon=-1s", "ops/beacon=", "ops", "a/b/c=1s", "ops/beacon=1e400h"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		leases, err := parseKeepAliveLeases(strings.Split(s, ","))
+		if err != nil {
+			return
+		}
+		for _, k := range leases {
+			if k.Namespace == "" || k.Name == "" || strings.Contains(k.Name, "/") || k.Interval < 0 {
+				t.Errorf("parseKeepAliveLeases(%q) accepted %+v", s, k)
+			}
+		}
+	})
+}
+
+func FuzzParseWindowAnnotation(f *testing.F) {
+	for _, seed := range []string{"0 2 * * 6/4h", "*/15 * * * */1h; 0 22 * * 1-5/8h", "CRON_TZ=Europe/London 0 2 * * 6/4h", "0 2 * * 6", "0 2 * * 6/0s", "/", ";", "CRON_TZ=Nowhere/Land * * * * */1h"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		windows, err := parseWindowAnnotation(s)
+		if err != nil {
+			return
+		}
+		if len(windows) == 0 {
+			t.Fatalf("parseWindowAnnotation(%q) returned no windows and no error", s)
+		}
+		for _, w := range windows {
+			if w.schedule == nil || w.Duration.Duration <= 0 {
+				t.Errorf("parseWindowAnnotation(%q) accepted %+v", s, w)
+			}
+		}
+	})
+}
+
+func FuzzParsePolicies(f *testing.F) {
+	for _, seed := range []string{
+		"policies:\n  - name: edge\n    nodeSelector:\n      matchLabels:\n        pool: edge\n",
+		"policies:\n  - name: a\n    nodeSelector:\n      matchExpressions:\n        - {key: pool, operator: In, values: [x, y]}\n    renewInterval: 5s\n",
+		"policies:\n  - name: a\n    nodeSelector:\n      matchLabels:\n        \"bad key!\": x\n",
+		"policies:\n  - name: a\n  - name: a\n",
+		"policies:\n  - nodeSelector: {}\n",
+		"policies:\n  - name: a\n    expression: 'node.metadata.name.startsWith(\"edge-\")'\n",
+		"policies:\n  - name: a\n    windows:\n      - schedule: \"0 2 * * 6\"\n        duration: 4h\n",
+		"conditionProfiles:\n  - name: p\npolicies:\n  - name: a\n    conditionProfile: q\n",
+		"policies: [",
+		"unknown: true\n",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		policies, err := parsePolicies("fuzz.yaml", raw)
+		if err != nil {
+			return
+		}
+		seen := make(map[string]bool)
+		for _, p := range policies {
+			if p == nil || p.Name == "" || seen[p.Name] || p.selector == nil {
+				t.Fatalf("parsePolicies accepted %+v", p)
+			}
+			seen[p.Name] = true
+			p.matches(map[string]string{"pool": "edge"})
+		}
+	})
+}
+
+func FuzzLeaseRenewal(f *testing.F) {
+	f.Add("node1", "node1", true, int32(40), int32(0), int32(0), int64(1714564800), int64(1714568400), int64(123456789), "", true)
+	f.Add("node-life-support", "node1", true, int32(0), int32(0), int32(3), int64(0), int64(1714568400), int64(0), "", true)
+	f.Add("node-life-support", "", false, int32(-5), int32(15), int32(2147483647), int64(-62135596800), int64(253402300799), int64(999999999), "node1", true)
+	f.Add("", "kubelet", true, int32(0), int32(2147483647), int32(-1), int64(1), int64(1), int64(-1), "kubelet", false)
+	f.Fuzz(func(t *testing.T, holder, prev string, hasPrev bool, leaseDuration, duration, transitions int32, renewSec, nowSec, nowNsec int64, original string, setHolder bool) {
+		now, ok := fuzzTime(nowSec, nowNsec)
+		renew, renewOK := fuzzTime(renewSec, 0)
+		if !ok || !renewOK || duration < 0 || !utf8.ValidString(holder) || !utf8.ValidString(prev) || !utf8.ValidString(original) {
+			// Neither the controller's clock, LEASE_DURATION_SECONDS nor
+			// strings from the API server are.
+			t.Skip()
+		}
+		lease := &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1", Namespace: nodeLeaseNamespace},
+			Spec: coordinationv1.LeaseSpec{
+				LeaseDurationSeconds: &leaseDuration,
+				LeaseTransitions:     &transitions,
+				RenewTime:            &metav1.MicroTime{Time: renew},
+			},
+		}
+		if hasPrev {
+			lease.Spec.HolderIdentity = &prev
+		}
+		if original != "" {
+			lease.Annotations = map[string]string{originalHolderAnnotation: original}
+		}
+
+		raw, err := json.Marshal(leaseRenewal(lease, holder, setHolder, now, duration))
+		if err != nil {
+			t.Fatalf("marshal apply configuration: %v", err)
+		}
+		var got coordinationv1.Lease
+		if err := json.Unmarshal(raw, &got); err != nil {
+			t.Fatalf("apply configuration %s is not a Lease: %v", raw, err)
+		}
+		s := got.Spec
+		if s.RenewTime == nil || !s.RenewTime.Time.Equal(now.Truncate(time.Microsecond)) {
+			t.Errorf("renewTime %v, want %v", s.RenewTime, now)
+		}
+		if s.LeaseDurationSeconds == nil || *s.LeaseDurationSeconds <= 0 {
+			t.Errorf("leaseDurationSeconds %v, want a positive duration", s.LeaseDurationSeconds)
+		}
+		if expiry, ok := leaseExpiry(&got); !ok || !expiry.After(now.Truncate(time.Microsecond)) {
+			t.Errorf("renewed lease expires at %v, not after %v", expiry, now)
+		}
+		if setHolder && (s.HolderIdentity == nil || *s.HolderIdentity != holder) {
+			t.Errorf("holderIdentity %v, want %q", s.HolderIdentity, holder)
+		}
+		if s.LeaseTransitions == nil || *s.LeaseTransitions < transitions {
+			t.Errorf("leaseTransitions %v, down from %d", s.LeaseTransitions, transitions)
+		}
+		if original != "" && got.Annotations[originalHolderAnnotation] != original {
+			t.Errorf("original holder annotation %q, want %q kept", got.Annotations[originalHolderAnnotation], original)
+		}
+	})
+}
+
+func FuzzLeaseExpiry(f *testing.F) {
+	f.Add(true, int64(1714564800), int64(0), int32(40))
+	f.Add(true, int64(-62135596800), int64(0), int32(0))
+	f.Add(true, int64(253402300799), int64(999999999), int32(2147483647))
+	f.Add(false, int64(0), int64(0), int32(-1))
+	f.Fuzz(func(t *testing.T, renewed bool, sec, nsec int64, duration int32) {
+		lease := &coordinationv1.Lease{Spec: coordinationv1.LeaseSpec{LeaseDurationSeconds: &duration}}
+		var renew time.Time
+		if renewed {
+			var ok bool
+			if renew, ok = fuzzTime(sec, nsec); !ok {
+				t.Skip()
+			}
+			lease.Spec.RenewTime = &metav1.MicroTime{Time: renew}
+		}
+		expiry, ok := leaseExpiry(lease)
+		if ok != renewed {
+			t.Fatalf("leaseExpiry() ok = %v for a lease renewed %v", ok, renewed)
+		}
+		if ok && !expiry.After(renew) {
+			t.Errorf("leaseExpiry() = %v, not after renewTime %v", expiry, renew)
+		}
+	})
+}
+
+func FuzzExpiresAt(f *testing.F) {
+	for _, seed := range []string{"2024-05-04T09:00:00+01:00", "2024-05-04T09:00:00Z", "2024-05-04", "9999-12-31T23:59:59.999999999-23:59", "0000-01-01T00:00:00Z", "tomorrow", ""} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, v string) {
+		c := &NodeLifeSupportController{}
+		at, ok := c.expiresAt(&metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "node1", Annotations: map[string]string{expiresAtAnnotation: v}}})
+		if !ok {
+			return
+		}
+		again, err := time.Parse(time.RFC3339Nano, at.Format(time.RFC3339Nano))
+		if err != nil || !again.Equal(at) {
+			t.Errorf("expiry %q parsed as %v, which does not round-trip: %v, %v", v, at, again, err)
+		}
+	})
+}
+
+// FuzzForceNodeReady fuzzes the node status patch with odd node names and
+// times, and a message template echoing them.
+func FuzzForceNodeReady(f *testing.F) {
+	f.Add("node1", int64(1714568400), int64(0), int64(1714564800))
+	f.Add("ip-10-0-0-1.ec2.internal", int64(253402300799), int64(999999999), int64(-62135596800))
+	f.Add("nöde \"</script>", int64(0), int64(1), int64(0))
+	f.Add(strings.Repeat("n", 253), int64(-62135596800), int64(0), int64(253402300799))
+	message := template.Must(template.New("READY_MESSAGE_TEMPLATE").Option("missingkey=error").
+		Parse(`{{.Node}} since {{.EngagedSince.Format "2006-01-02T15:04:05Z07:00"}}`))
+	f.Fuzz(func(t *testing.T, name string, nowSec, nowNsec, sinceSec int64) {
+		now, ok := fuzzTime(nowSec, nowNsec)
+		since, sinceOK := fuzzTime(sinceSec, 0)
+		if !ok || !sinceOK || name == "" || !utf8.ValidString(name) {
+			t.Skip()
+		}
+		client := fake.NewSimpleClientset(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}})
+		c := &NodeLifeSupportController{
+			client: client,
+			clock:  clocktesting.NewFakeClock(now),
+			opts:   Options{ReadyMessageTemplate: message},
+		}
+		c.engage(name).engagedSince = since
+		node := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if err := c.ForceNodeReady(context.Background(), node); err != nil {
+			t.Fatalf("ForceNodeReady() error: %v", err)
+		}
+
+		var patch []byte
+		for _, a := range client.Actions() {
+			if p, ok := a.(k8stesting.PatchAction); ok && a.GetSubresource() == "status" {
+				patch = p.GetPatch()
+			}
+		}
+		var got v1.Node
+		if err := json.Unmarshal(patch, &got); err != nil {
+			t.Fatalf("status patch %s is not a Node: %v", patch, err)
+		}
+		conditions := make(map[v1.NodeConditionType]v1.NodeCondition)
+		for _, cond := range got.Status.Conditions {
+			conditions[cond.Type] = cond
+		}
+		ready, ok := conditions[v1.NodeReady]
+		if !ok || ready.Status != v1.ConditionTrue || !ready.LastHeartbeatTime.Time.Equal(now.Truncate(time.Second)) {
+			t.Errorf("Ready condition %+v, want True at %v", ready, now)
+		}
+		if !strings.HasPrefix(ready.Message, name+" since ") {
+			t.Errorf("Ready message %q does not name node %q", ready.Message, name)
+		}
+		if active, ok := conditions[lifeSupportCondition]; !ok || !active.LastTransitionTime.Time.Equal(since) {
+			t.Errorf("%s condition %+v, want transition at %v", lifeSupportCondition, active, since)
+		}
+	})
+}
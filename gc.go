@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+// orphanGracePeriod protects leases renewed recently from collection: a
+// kubelet may create its lease moments before its Node object appears.
+const orphanGracePeriod = 10 * time.Minute
+
+// nodeDeleteHandler reacts to nodes leaving a node informer. That also
+// happens when a node merely stops matching the selector, so the node is
+// looked up again before anything is cleaned up.
+func (c *NodeLifeSupportController) nodeDeleteHandler(ctx context.Context) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			node, ok := obj.(*metav1.PartialObjectMetadata)
+			if !ok {
+				return
+			}
+			go c.handleNodeDeleted(ctx, node.Name, node.UID)
+		},
+	}
+}
+
+// handleNodeDeleted forgets a deleted node and, on the leader, removes its
+// lease, without waiting for the garbage collector if the deleted Node owns
+// it. A lease owned by a Node recreated under the same name is kept.
+func (c *NodeLifeSupportController) handleNodeDeleted(ctx context.Context, name string, uid types.UID) {
+	if !c.nodeDeleted(ctx, name) {
+		// Still there (just no longer selected) or unknown; leave it to the
+		// normal disengage path.
+		return
+	}
+
+	c.forget(name)
+	if standingBy() {
+		// The leader removes the lease.
+		return
+	}
+	if err := c.deleteOrphanedLease(ctx, name, uid); err != nil {
+		c.logf("failed removing lease of deleted node %s: %v", name, err)
+	}
+}
+
+// nodeDeleted reports whether the API server confirms the node no longer
+// exists.
+func (c *NodeLifeSupportController) nodeDeleted(ctx context.Context, name string) bool {
+	ctx, cancel := c.opContext(ctx)
+	defer cancel()
+	_, err := c.meta.Resource(nodesResource).Get(ctx, name, metav1.GetOptions{})
+	return apierrors.IsNotFound(err)
+}
+
+// deleteOrphanedLease deletes the named node lease unless it is owned by a
+// Node other than the deleted one (uid may be empty when unknown) or was
+// renewed within orphanGracePeriod.
+func (c *NodeLifeSupportController) deleteOrphanedLease(ctx context.Context, name string, uid types.UID) error {
+	ctx, cancel := c.opContext(ctx)
+	defer cancel()
+
+	leases := c.client.CoordinationV1().Leases(nodeLeaseNamespace)
+	lease, err := leases.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !leaseOrphaned(lease, uid, c.now()) {
+		return nil
+	}
+
+	err = leases.Delete(ctx, name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &lease.UID, ResourceVersion: &lease.ResourceVersion},
+	})
+	if err == nil {
+		c.logf("removed orphaned lease of deleted node %s", name)
+	}
+	if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+		return nil
+	}
+	return err
+}
+
+// leaseOrphaned reports whether a node lease can be deleted once its node is
+// known to be gone. Only leases the controller has written are: others are
+// left to whoever created them. A lease owned by a Node is only deleted when
+// that Node is the one deletedUID says was deleted; without it, as in the
+// sweep, such leases are left to the garbage collector.
+func leaseOrphaned(lease *coordinationv1.Lease, deletedUID types.UID, now time.Time) bool {
+	if !leaseManaged(lease) {
+		return false
+	}
+	if rt := lease.Spec.RenewTime; rt != nil && now.Sub(rt.Time) < orphanGracePeriod {
+		return false
+	}
+	for _, ref := range lease.OwnerReferences {
+		if ref.Kind == "Node" && (deletedUID == "" || ref.UID != deletedUID) {
+			return false
+		}
+	}
+	return true
+}
+
+// leaseManaged reports whether the controller has written a lease, as its
+// managed fields record.
+func leaseManaged(lease *coordinationv1.Lease) bool {
+	for _, f := range lease.ManagedFields {
+		if f.Manager == fieldManager {
+			return true
+		}
+	}
+	return false
+}
+
+// sweepOrphanedLeases removes leases in kube-node-lease whose node no longer
+// exists, catching deletions that happened while the controller was not
+// running. With sharding, each replica sweeps the leases of the nodes it
+// would own.
+func (c *NodeLifeSupportController) sweepOrphanedLeases(ctx context.Context) error {
+	listCtx, cancel := c.opContext(ctx)
+	defer cancel()
+	leases, err := c.client.CoordinationV1().Leases(nodeLeaseNamespace).List(listCtx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	nodes, err := c.meta.Resource(nodesResource).List(listCtx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	exists := make(map[string]struct{}, len(nodes.Items))
+	for _, n := range nodes.Items {
+		exists[n.Name] = struct{}{}
+	}
+
+	for _, l := range leases.Items {
+		if _, ok := exists[l.Name]; ok {
+			continue
+		}
+		if c.shard != nil && !c.shard.owns(l.Name) {
+			continue
+		}
+		if err := c.deleteOrphanedLease(ctx, l.Name, ""); err != nil {
+			c.logf("failed removing orphaned lease %s: %v", l.Name, err)
+		}
+	}
+	return nil
+}
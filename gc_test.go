@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	metadatafake "k8s.io/client-go/metadata/fake"
+)
+
+// TestLeaseOrphaned tests which leases may be collected once their node is gone.
+func TestLeaseOrphaned(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	recent := metav1.NewMicroTime(now.Add(-time.Minute))
+	stale := metav1.NewMicroTime(now.Add(-time.Hour))
+	managed := []metav1.ManagedFieldsEntry{{Manager: fieldManager, Operation: metav1.ManagedFieldsOperationApply}}
+	nodeRef := func(uid types.UID) metav1.ObjectMeta {
+		return metav1.ObjectMeta{ManagedFields: managed, OwnerReferences: []metav1.OwnerReference{{APIVersion: "v1", Kind: "Node", Name: "node1", UID: uid}}}
+	}
+
+	tests := []struct {
+		name       string
+		lease      coordinationv1.Lease
+		deletedUID types.UID
+		expected   bool
+	}{
+		{name: "no owner, stale", lease: coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{ManagedFields: managed}, Spec: coordinationv1.LeaseSpec{RenewTime: &stale}}, expected: true},
+		{name: "no owner, never renewed", lease: coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{ManagedFields: managed}}, expected: true},
+		{name: "no owner, recently renewed", lease: coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{ManagedFields: managed}, Spec: coordinationv1.LeaseSpec{RenewTime: &recent}}, expected: false},
+		{name: "not written by the controller", lease: coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "kubelet"}}}, Spec: coordinationv1.LeaseSpec{RenewTime: &stale}}, expected: false},
+		{name: "owned by deleted node", lease: coordinationv1.Lease{ObjectMeta: nodeRef("old")}, deletedUID: "old", expected: true},
+		{name: "owned by recreated node", lease: coordinationv1.Lease{ObjectMeta: nodeRef("new")}, deletedUID: "old", expected: false},
+		{name: "owned by a node, uid unknown", lease: coordinationv1.Lease{ObjectMeta: nodeRef("new")}, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := leaseOrphaned(&tt.lease, tt.deletedUID, now); result != tt.expected {
+				t.Errorf("leaseOrphaned() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+// TestSweepOrphanedLeases tests that only leases the controller wrote, of
+// nodes no longer there and owned by this shard, are removed.
+func TestSweepOrphanedLeases(t *testing.T) {
+	ctx := context.Background()
+	lease := func(name, manager string) *coordinationv1.Lease {
+		return &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: nodeLeaseNamespace,
+			ManagedFields: []metav1.ManagedFieldsEntry{{Manager: manager}}}}
+	}
+	members := []string{"replica-a", "replica-b"}
+	var mine, theirs string
+	for _, name := range []string{"gone-1", "gone-2", "gone-3", "gone-4", "gone-5", "gone-6"} {
+		if shardOwner(name, members) == "replica-a" {
+			mine = name
+		} else {
+			theirs = name
+		}
+	}
+	if mine == "" || theirs == "" {
+		t.Fatal("no node names split between the shards")
+	}
+	client := fake.NewSimpleClientset(lease("alive", fieldManager), lease(mine, fieldManager), lease(theirs, fieldManager), lease("foreign", "kubelet"))
+	scheme := metadatafake.NewTestScheme()
+	metav1.AddMetaToScheme(scheme)
+	meta := metadatafake.NewSimpleMetadataClient(scheme, &metav1.PartialObjectMetadata{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Node"},
+		ObjectMeta: metav1.ObjectMeta{Name: "alive"},
+	})
+	c := &NodeLifeSupportController{client: client, meta: meta, shard: &shardMembership{identity: "replica-a", members: members}}
+
+	if err := c.sweepOrphanedLeases(ctx); err != nil {
+		t.Fatalf("sweepOrphanedLeases() error: %v", err)
+	}
+
+	leases, _ := client.CoordinationV1().Leases(nodeLeaseNamespace).List(ctx, metav1.ListOptions{})
+	var remaining []string
+	for _, l := range leases.Items {
+		remaining = append(remaining, l.Name)
+	}
+	sort.Strings(remaining)
+	want := []string{"alive", "foreign", theirs}
+	sort.Strings(want)
+	if !reflect.DeepEqual(remaining, want) {
+		t.Errorf("remaining leases = %v, want %v", remaining, want)
+	}
+}
+
+// TestNodeDeletedMidSync tests that a node deleted after it was listed is
+// forgotten rather than counted as a failed sync, and that disengaging it
+// succeeds.
+func TestNodeDeletedMidSync(t *testing.T) {
+	ctx := context.Background()
+	scheme := metadatafake.NewTestScheme()
+	metav1.AddMetaToScheme(scheme)
+	meta := metadatafake.NewSimpleMetadataClient(scheme)
+	node := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "gone", UID: "uid-1"}}
+
+	c := &NodeLifeSupportController{
+		client: fake.NewSimpleClientset(),
+		meta:   meta,
+		opts:   Options{HolderIdentity: HolderIdentityNode, Cordon: true, AssistedTaint: true},
+	}
+	c.engage("gone")
+	errs := newSyncErrors()
+	if !c.syncAndLog(ctx, node, errs) || errs.total != 0 {
+		t.Errorf("sync of a deleted node reported as failed: %s", errs)
+	}
+	if c.engaged("gone") {
+		t.Error("deleted node still tracked")
+	}
+
+	st := c.engage("gone")
+	st.cordoned, st.assisted = true, true
+	if err := c.disengage(ctx, "gone", disengageOperatorDisabled); err != nil {
+		t.Errorf("disengage() error: %v", err)
+	}
+	if c.engaged("gone") {
+		t.Error("deleted node still tracked after disengage")
+	}
+}
+
+// TestSkipNodeBeingDeleted tests that a node being deleted is disengaged
+// rather than kept alive, and not engaged again while it goes.
+func TestSkipNodeBeingDeleted(t *testing.T) {
+	deleting := metav1.NewTime(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	node := metav1.ObjectMeta{Name: "node1", UID: "uid-1", DeletionTimestamp: &deleting, Finalizers: []string{"example.com/cleanup"}}
+	client := fake.NewSimpleClientset(&v1.Node{ObjectMeta: node})
+	scheme := metadatafake.NewTestScheme()
+	metav1.AddMetaToScheme(scheme)
+	meta := metadatafake.NewSimpleMetadataClient(scheme,
+		&metav1.PartialObjectMetadata{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Node"}, ObjectMeta: node},
+	)
+	selectors, err := allowlistSelectors(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &NodeLifeSupportController{client: client, meta: meta, selectors: selectors, opts: Options{
+		HolderIdentity: HolderIdentityNode, SyncInterval: time.Minute,
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := c.Start(ctx); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+
+	c.engage("node1")
+	for i := 0; i < 2; i++ {
+		if err := c.SyncAllNodes(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if c.engaged("node1") {
+			t.Fatalf("cycle %d: node being deleted still on life support", i)
+		}
+	}
+	if _, err := client.CoordinationV1().Leases(nodeLeaseNamespace).Get(ctx, "node1", metav1.GetOptions{}); err == nil {
+		t.Error("lease of a node being deleted renewed")
+	}
+	h, _ := c.nodeHistory("node1")
+	if len(h) != 2 || h[1].Type != transitionDisengaged || h[1].Detail != disengageNodeDeleting {
+		t.Errorf("history = %+v, want one disengagement, %s", h, disengageNodeDeleting)
+	}
+}
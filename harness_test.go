@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	k8stesting "k8s.io/client-go/testing"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// harness drives a started controller through SyncAllNodes against fake
+// clients, as it runs against an API server, so tests can inject API errors
+// with reactors and assert the exact payloads written.
+type harness struct {
+	t      *testing.T
+	ctx    context.Context
+	client *fake.Clientset
+	clock  *clocktesting.FakeClock
+	c      *NodeLifeSupportController
+}
+
+// harnessStart is the time a harness's clock starts at.
+var harnessStart = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// newHarness starts a controller with opts, defaulting HolderIdentity and
+// SyncInterval, watching nodes that each have a lease their kubelet last
+// renewed an hour before harnessStart.
+func newHarness(t *testing.T, opts Options, nodes ...*v1.Node) *harness {
+	t.Helper()
+	if opts.HolderIdentity == "" {
+		opts.HolderIdentity = HolderIdentityNode
+	}
+	if opts.SyncInterval == 0 {
+		opts.SyncInterval = time.Minute
+	}
+	var objects, metas []runtime.Object
+	duration := int32(40)
+	for _, n := range nodes {
+		renewed := metav1.NewMicroTime(harnessStart.Add(-time.Hour))
+		objects = append(objects, n, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: n.Name, Namespace: nodeLeaseNamespace},
+			Spec:       coordinationv1.LeaseSpec{HolderIdentity: &n.Name, LeaseDurationSeconds: &duration, RenewTime: &renewed},
+		})
+		metas = append(metas, &metav1.PartialObjectMetadata{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Node"}, ObjectMeta: n.ObjectMeta})
+	}
+	scheme := metadatafake.NewTestScheme()
+	metav1.AddMetaToScheme(scheme)
+	selectors, err := allowlistSelectors(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &harness{t: t, client: fake.NewSimpleClientset(objects...), clock: clocktesting.NewFakeClock(harnessStart)}
+	h.c = &NodeLifeSupportController{
+		client:    h.client,
+		meta:      metadatafake.NewSimpleMetadataClient(scheme, metas...),
+		selectors: selectors,
+		clock:     h.clock,
+		opts:      opts,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	h.ctx = ctx
+	if err := h.c.Start(ctx); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	return h
+}
+
+// restart starts a new controller with opts against the same fake clients,
+// as a replacement pod would be, its clock after past h's.
+func (h *harness) restart(opts Options, after time.Duration) *harness {
+	h.t.Helper()
+	if opts.HolderIdentity == "" {
+		opts.HolderIdentity = HolderIdentityNode
+	}
+	if opts.SyncInterval == 0 {
+		opts.SyncInterval = time.Minute
+	}
+	next := &harness{t: h.t, ctx: h.ctx, client: h.client, clock: clocktesting.NewFakeClock(h.clock.Now().Add(after))}
+	next.c = &NodeLifeSupportController{
+		client:    h.client,
+		meta:      h.c.meta,
+		selectors: h.c.selectors,
+		clock:     next.clock,
+		opts:      opts,
+	}
+	if err := next.c.Start(next.ctx); err != nil {
+		h.t.Fatalf("Start() error: %v", err)
+	}
+	return next
+}
+
+// sync runs one sync cycle, failing the test if it returns an error.
+func (h *harness) sync() {
+	h.t.Helper()
+	if err := h.c.SyncAllNodes(h.ctx); err != nil {
+		h.t.Fatalf("SyncAllNodes() error: %v", err)
+	}
+}
+
+// patches returns the payloads of the patches, including applies, of
+// resource and subresource, in the order they were sent.
+func (h *harness) patches(resource, subresource string) []string {
+	var out []string
+	for _, a := range h.client.Actions() {
+		if p, ok := a.(k8stesting.PatchAction); ok && p.GetResource().Resource == resource && p.GetSubresource() == subresource {
+			out = append(out, string(p.GetPatch()))
+		}
+	}
+	return out
+}
+
+// fail makes the next times calls of verb on resource and subresource fail
+// with err, or all of them if times is negative.
+func (h *harness) fail(verb, resource, subresource string, times int, err error) {
+	var mu sync.Mutex
+	calls := 0
+	h.client.PrependReactor(verb, resource, func(a k8stesting.Action) (bool, runtime.Object, error) {
+		if a.GetSubresource() != subresource {
+			return false, nil, nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if times >= 0 && calls > times {
+			return false, nil, nil
+		}
+		return true, nil, err
+	})
+}
+
+// state returns a copy of the controller's state for node name.
+func (h *harness) state(name string) nodeState {
+	h.t.Helper()
+	h.c.mu.Lock()
+	defer h.c.mu.Unlock()
+	st, ok := h.c.nodes[name]
+	if !ok {
+		h.t.Fatalf("node %s not engaged", name)
+	}
+	return *st
+}
+
+// assertJSON fails the test unless got and want are the same JSON value.
+func assertJSON(t *testing.T, what, got, want string) {
+	t.Helper()
+	var g, w interface{}
+	if err := json.Unmarshal([]byte(got), &g); err != nil {
+		t.Fatalf("%s: %v in %s", what, err, got)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatalf("%s: %v in want %s", what, err, want)
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("%s =\n%s\nwant\n%s", what, got, want)
+	}
+}
+
+// TestHarnessPatches tests the exact lease and status patches a sync cycle
+// sends for a node it engages, and that nothing else is written to it.
+func TestHarnessPatches(t *testing.T) {
+	h := newHarness(t, Options{}, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1"}})
+	h.sync()
+
+	leases := h.patches("leases", "")
+	if len(leases) != 1 {
+		t.Fatalf("lease patches = %q, want one", leases)
+	}
+	assertJSON(t, "lease apply", leases[0], `{
+		"kind": "Lease",
+		"apiVersion": "coordination.k8s.io/v1",
+		"metadata": {"name": "node1", "namespace": "kube-node-lease"},
+		"spec": {"holderIdentity": "node1", "leaseDurationSeconds": 40, "renewTime": "2024-05-01T12:00:00.000000Z"}
+	}`)
+
+	statuses := h.patches("nodes", "status")
+	if len(statuses) != 1 {
+		t.Fatalf("status patches = %q, want one", statuses)
+	}
+	assertJSON(t, "status patch", statuses[0], `{"status": {"conditions": [
+		{
+			"type": "Ready",
+			"status": "True",
+			"lastHeartbeatTime": "2024-05-01T12:00:00Z",
+			"lastTransitionTime": "2024-05-01T12:00:00Z",
+			"reason": "NodeLifeSupportOverride",
+			"message": "node-life-support controller asserting node health."
+		},
+		{
+			"type": "LifeSupportActive",
+			"status": "True",
+			"lastHeartbeatTime": "2024-05-01T12:00:00Z",
+			"lastTransitionTime": "2024-05-01T12:00:00Z",
+			"reason": "NodeLifeSupportEngaged",
+			"message": "node-life-support controller asserting node health since 2024-05-01T12:00:00Z."
+		}
+	]}}`)
+
+	if p := h.patches("nodes", ""); len(p) != 0 {
+		t.Errorf("node patches = %q, want none without CORDON, ASSISTED_TAINT or ACTIVE_LABEL", p)
+	}
+	for _, a := range h.client.Actions() {
+		if a.GetVerb() == "update" || a.GetVerb() == "create" || a.GetVerb() == "delete" {
+			t.Errorf("unexpected %s %s/%s", a.GetVerb(), a.GetResource().Resource, a.GetSubresource())
+		}
+	}
+
+	// Not due again until a quarter of the lease duration has passed.
+	h.client.ClearActions()
+	h.clock.Step(5 * time.Second)
+	h.sync()
+	if n := len(h.patches("leases", "")) + len(h.patches("nodes", "status")); n != 0 {
+		t.Errorf("%d patches before the node was due", n)
+	}
+	h.clock.Step(5 * time.Second)
+	h.sync()
+	leases = h.patches("leases", "")
+	if len(leases) != 1 || !strings.Contains(leases[0], `"renewTime":"2024-05-01T12:00:10.000000Z"`) {
+		t.Errorf("lease patches once due = %q, want one renewing at 12:00:10", leases)
+	}
+}
+
+// TestHarnessErrors tests how sync cycles handle the API server rejecting
+// the lease renewal, the status patch or both.
+func TestHarnessErrors(t *testing.T) {
+	forbidden := apierrors.NewForbidden(schema.GroupResource{Resource: "nodes"}, "node1", fmt.Errorf("RBAC"))
+	unavailable := apierrors.NewServiceUnavailable("etcd leader changed")
+	tests := []struct {
+		name string
+		// inject sets up the failures.
+		inject func(h *harness)
+		opts   Options
+		// failedSyncs is the node's consecutive failed syncs after the
+		// cycle, failing the half that failed alone, and errContains what
+		// it last failed with.
+		failedSyncs int
+		failing     string
+		errContains string
+		quarantined bool
+		leases      int
+		statuses    int
+	}{
+		{
+			name:        "status forbidden",
+			inject:      func(h *harness) { h.fail("patch", "nodes", "status", -1, forbidden) },
+			failing:     failingStatus,
+			errContains: "update node status: ",
+			leases:      1,
+			statuses:    1,
+		},
+		{
+			name:        "lease forbidden",
+			inject:      func(h *harness) { h.fail("patch", "leases", "", -1, forbidden) },
+			failing:     failingLease,
+			errContains: "update lease: ",
+			leases:      1,
+			statuses:    1,
+		},
+		{
+			name: "both forbidden",
+			inject: func(h *harness) {
+				h.fail("patch", "leases", "", -1, forbidden)
+				h.fail("patch", "nodes", "status", -1, forbidden)
+			},
+			failedSyncs: 1,
+			errContains: "forbidden",
+			leases:      1,
+			statuses:    1,
+		},
+		{
+			name: "both forbidden, quarantined",
+			inject: func(h *harness) {
+				h.fail("patch", "leases", "", -1, forbidden)
+				h.fail("patch", "nodes", "status", -1, forbidden)
+			},
+			opts:        Options{QuarantineAfter: 1, QuarantineDuration: time.Hour},
+			failedSyncs: 1,
+			errContains: "forbidden",
+			quarantined: true,
+			leases:      1,
+			statuses:    1,
+		},
+		{
+			name:     "status unavailable, retried",
+			inject:   func(h *harness) { h.fail("patch", "nodes", "status", 1, unavailable) },
+			opts:     Options{APIRetries: 1},
+			leases:   1,
+			statuses: 2,
+		},
+		{
+			name:        "status unavailable, not retried",
+			inject:      func(h *harness) { h.fail("patch", "nodes", "status", 1, unavailable) },
+			failing:     failingStatus,
+			errContains: "etcd leader changed",
+			leases:      1,
+			statuses:    1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t, tt.opts, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1"}})
+			tt.inject(h)
+			h.sync()
+
+			st := h.state("node1")
+			if st.failedSyncs != tt.failedSyncs || st.failing != tt.failing {
+				t.Errorf("failedSyncs = %d, failing %q; want %d, %q", st.failedSyncs, st.failing, tt.failedSyncs, tt.failing)
+			}
+			if q := h.c.quarantined("node1", h.clock.Now()); q != tt.quarantined {
+				t.Errorf("quarantined = %v, want %v", q, tt.quarantined)
+			}
+			if !strings.Contains(st.lastError, tt.errContains) || (tt.errContains == "") != (st.lastError == "") {
+				t.Errorf("lastError = %q, want one containing %q", st.lastError, tt.errContains)
+			}
+			if n := len(h.patches("leases", "")); n != tt.leases {
+				t.Errorf("%d lease patches, want %d", n, tt.leases)
+			}
+			if n := len(h.patches("nodes", "status")); n != tt.statuses {
+				t.Errorf("%d status patches, want %d", n, tt.statuses)
+			}
+		})
+	}
+}
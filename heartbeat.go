@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// heartbeatLeaseName is the Lease, in the controller's namespace, renewed
+// after every successful sync cycle with HEARTBEAT_LEASE_DURATION. Sharded
+// replicas each renew their own, suffixed with their identity.
+const heartbeatLeaseName = "node-life-support-heartbeat"
+
+// heartbeatLease returns the name of this replica's heartbeat Lease.
+func (c *NodeLifeSupportController) heartbeatLease() string {
+	if c.opts.Sharding {
+		return heartbeatLeaseName + "-" + c.opts.Identity
+	}
+	return heartbeatLeaseName
+}
+
+// renewHeartbeat records in the heartbeat Lease that a sync cycle has just
+// completed, so monitors can tell from the API alone that the controller is
+// working: its renewTime is when the last cycle succeeded, and it is stale
+// once leaseDurationSeconds have passed since.
+func (c *NodeLifeSupportController) renewHeartbeat(ctx context.Context) error {
+	ctx, cancel := c.opContext(ctx)
+	defer cancel()
+	now := metav1.NewMicroTime(c.now())
+	seconds := int32(c.opts.HeartbeatLeaseDuration.Seconds())
+	leases := c.client.CoordinationV1().Leases(c.opts.Namespace)
+
+	lease, err := leases.Get(ctx, c.heartbeatLease(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: c.heartbeatLease(), Namespace: c.opts.Namespace},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &c.opts.Identity,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		_, err = leases.Create(ctx, lease, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != c.opts.Identity {
+		// Taken over, e.g. from the replica that led before.
+		transitions := int32(1)
+		if lease.Spec.LeaseTransitions != nil {
+			transitions += *lease.Spec.LeaseTransitions
+		}
+		lease.Spec.HolderIdentity = &c.opts.Identity
+		lease.Spec.AcquireTime = &now
+		lease.Spec.LeaseTransitions = &transitions
+	}
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
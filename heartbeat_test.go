@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// TestRenewHeartbeat tests that the heartbeat Lease is created, renewed, and
+// taken over by another replica, and that sharded replicas each have one.
+func TestRenewHeartbeat(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock := clocktesting.NewFakeClock(t0)
+	client := fake.NewSimpleClientset()
+	replica := func(identity string, sharding bool) *NodeLifeSupportController {
+		return &NodeLifeSupportController{client: client, clock: clock, opts: Options{
+			Namespace:              "nls",
+			Identity:               identity,
+			Sharding:               sharding,
+			HeartbeatLeaseDuration: 5 * time.Minute,
+		}}
+	}
+	ctx := context.Background()
+	get := func(name string) (holder string, renew, acquire time.Time, duration, transitions int32) {
+		t.Helper()
+		l, err := client.CoordinationV1().Leases("nls").Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l.Spec.LeaseTransitions != nil {
+			transitions = *l.Spec.LeaseTransitions
+		}
+		return *l.Spec.HolderIdentity, l.Spec.RenewTime.Time, l.Spec.AcquireTime.Time, *l.Spec.LeaseDurationSeconds, transitions
+	}
+
+	a, b := replica("nls-a", false), replica("nls-b", false)
+	steps := []struct {
+		c           *NodeLifeSupportController
+		holder      string
+		acquired    time.Time
+		transitions int32
+	}{
+		{c: a, holder: "nls-a", acquired: t0},
+		{c: a, holder: "nls-a", acquired: t0},
+		{c: b, holder: "nls-b", acquired: t0.Add(2 * time.Minute), transitions: 1},
+	}
+	for i, s := range steps {
+		if err := s.c.renewHeartbeat(ctx); err != nil {
+			t.Fatal(err)
+		}
+		holder, renew, acquire, duration, transitions := get(heartbeatLeaseName)
+		if holder != s.holder || !renew.Equal(clock.Now()) || !acquire.Equal(s.acquired) || duration != 300 || transitions != s.transitions {
+			t.Errorf("step %d: holder %s, renewed %v, acquired %v, duration %d, transitions %d", i, holder, renew, acquire, duration, transitions)
+		}
+		clock.Step(time.Minute)
+	}
+
+	if err := replica("nls-c", true).renewHeartbeat(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if holder, _, _, _, _ := get(heartbeatLeaseName + "-nls-c"); holder != "nls-c" {
+		t.Errorf("sharded heartbeat lease held by %s", holder)
+	}
+}
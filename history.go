@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// historyLength is how many transitions are kept per node.
+const historyLength = 50
+
+// Transitions kept in a node's history.
+const (
+	transitionEngaged             = "Engaged"
+	transitionRenewedAfterFailure = "RenewedAfterFailure"
+	transitionDisengaged          = "Disengaged"
+	transitionGaveUp              = "GaveUp"
+)
+
+type transition struct {
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// nodeHistory is served on /history.
+type nodeHistory struct {
+	// Cluster is empty in single-cluster mode.
+	Cluster     string       `json:"cluster"`
+	Node        string       `json:"node"`
+	Transitions []transition `json:"transitions"`
+}
+
+// recordTransition appends to a node's history, dropping its oldest
+// transition beyond historyLength. History outlives the node's engagement,
+// and the node itself. c.mu must be held.
+func (c *NodeLifeSupportController) recordTransition(name, typ, detail string) {
+	if c.history == nil {
+		c.history = make(map[string][]transition)
+	}
+	h := c.history[name]
+	if len(h) == historyLength {
+		h = append(h[:0], h[1:]...)
+	}
+	c.history[name] = append(h, transition{Time: c.now().UTC(), Type: typ, Detail: detail})
+}
+
+// nodeHistory returns a copy of a node's history, or false if it has none.
+func (c *NodeLifeSupportController) nodeHistory(name string) ([]transition, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h, ok := c.history[name]
+	return append([]transition(nil), h...), ok
+}
+
+// history returns the node's history in every registered cluster that has
+// one.
+func (f *fleet) history(node string) []nodeHistory {
+	f.mu.Lock()
+	members := append([]*fleetMember(nil), f.members...)
+	f.mu.Unlock()
+
+	out := []nodeHistory{}
+	for _, m := range members {
+		if m.c == nil {
+			continue
+		}
+		if h, ok := m.c.nodeHistory(node); ok {
+			out = append(out, nodeHistory{Cluster: m.name, Node: node, Transitions: h})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Cluster < out[j].Cluster })
+	return out
+}
+
+// serveHistory serves the history of the node given by the node query
+// parameter, as JSON unless format=text is asked for.
+func (f *fleet) serveHistory(w http.ResponseWriter, r *http.Request) {
+	node := r.URL.Query().Get("node")
+	if node == "" {
+		http.Error(w, "node: required", http.StatusBadRequest)
+		return
+	}
+	histories := f.history(node)
+	if len(histories) == 0 {
+		http.Error(w, fmt.Sprintf("no history for node %s", node), http.StatusNotFound)
+		return
+	}
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writeHistory(w, histories)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(histories)
+}
+
+// writeHistory renders node histories as timelines for people to read.
+func writeHistory(w io.Writer, histories []nodeHistory) {
+	for i, h := range histories {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		if h.Cluster != "" {
+			fmt.Fprintf(w, "cluster %s, node %s\n", h.Cluster, h.Node)
+		} else {
+			fmt.Fprintf(w, "node %s\n", h.Node)
+		}
+		for _, t := range h.Transitions {
+			line := fmt.Sprintf("  %s  %-19s", t.Time.Format(time.RFC3339), t.Type)
+			if t.Detail != "" {
+				line += "  " + t.Detail
+			}
+			fmt.Fprintln(w, strings.TrimRight(line, " "))
+		}
+	}
+}
+
+// runHistory implements the history command: it fetches a node's timeline
+// from a running controller's METRICS_ADDR and prints it.
+func runHistory(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	addr := fs.String("addr", "http://localhost:8080", "base URL of the controller's METRICS_ADDR")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: history [-addr URL] <node>")
+	}
+	q := url.Values{"node": {fs.Arg(0)}, "format": {"text"}}
+	return fetchText(strings.TrimSuffix(*addr, "/")+"/history?"+q.Encode(), out)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// TestNodeHistory tests that a node's transitions are kept, up to
+// historyLength, after it has left life support.
+func TestNodeHistory(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock := clocktesting.NewFakeClock(now)
+	c := &NodeLifeSupportController{opts: Options{Cluster: "edge"}, clock: clock}
+
+	c.engage("node1")
+	clock.Step(time.Minute)
+	c.recordSyncResult("node1", errors.New("update lease: timeout"), c.now())
+	c.recordSyncResult("node1", errors.New("update lease: timeout"), c.now())
+	c.recordSyncResult("node1", nil, c.now())
+	c.recordSyncResult("node1", nil, c.now())
+	clock.Step(time.Minute)
+	c.forget("node1")
+
+	h, ok := c.nodeHistory("node1")
+	if !ok {
+		t.Fatalf("no history for node1")
+	}
+	want := []transition{
+		{Time: now, Type: transitionEngaged},
+		{Time: now.Add(time.Minute), Type: transitionRenewedAfterFailure, Detail: "after 2 failed syncs, last: update lease: timeout"},
+		{Time: now.Add(2 * time.Minute), Type: transitionDisengaged, Detail: disengageNodeDeleted},
+	}
+	if len(h) != len(want) {
+		t.Fatalf("history = %+v, want %+v", h, want)
+	}
+	for i := range want {
+		if h[i] != want[i] {
+			t.Errorf("transition %d = %+v, want %+v", i, h[i], want[i])
+		}
+	}
+
+	for i := 0; i < historyLength; i++ {
+		c.engage("node1")
+		c.forget("node1")
+	}
+	h, _ = c.nodeHistory("node1")
+	if len(h) != historyLength || h[0].Type != transitionEngaged {
+		t.Errorf("kept %d transitions starting with %s, want %d starting with %s", len(h), h[0].Type, historyLength, transitionEngaged)
+	}
+
+	var buf bytes.Buffer
+	writeHistory(&buf, []nodeHistory{{Cluster: "edge", Node: "node2", Transitions: want}})
+	if !strings.HasPrefix(buf.String(), "cluster edge, node node2\n") || !strings.Contains(buf.String(), "\n  2024-05-01T12:01:00Z  RenewedAfterFailure  after 2 failed syncs") {
+		t.Errorf("text history:\n%s", buf.String())
+	}
+}
+
+// TestRunHistory tests the history command against a controller's /history.
+func TestRunHistory(t *testing.T) {
+	c := &NodeLifeSupportController{}
+	c.engage("node1")
+	f := &fleet{}
+	f.register("", c, nil)
+	srv := httptest.NewServer(http.HandlerFunc(f.serveHistory))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	if err := runHistory([]string{"-addr", srv.URL, "node1"}, &out); err != nil {
+		t.Fatalf("runHistory() error: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "node node1\n") || !strings.Contains(out.String(), transitionEngaged) {
+		t.Errorf("output:\n%s", out.String())
+	}
+	if err := runHistory([]string{"-addr", srv.URL, "node2"}, &out); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("runHistory() for an unknown node: error = %v, want 404", err)
+	}
+	if err := runHistory([]string{"-addr", srv.URL}, &out); err == nil {
+		t.Errorf("runHistory() without a node succeeded")
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	starlarkjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// patchHookSteps bounds the work a single call of the patch hook may do, so a
+// runaway script cannot hold up heartbeats.
+const patchHookSteps = 1_000_000
+
+// errPatchVetoed is returned by ForceNodeReady when the patch hook vetoed the
+// node's status patch.
+var errPatchVetoed = errors.New("status patch vetoed by PATCH_HOOK_FILE")
+
+// patchHook is a Starlark script from PATCH_HOOK_FILE defining
+//
+//	def patch(node, patch):
+//
+// which is called with the node's metadata and the status patch about to be
+// sent, as decoded JSON, and returns the patch to send instead, or None to
+// send nothing this time. A "metadata" key in the returned patch, e.g. extra
+// annotations, is sent to the node itself rather than its status.
+type patchHook struct {
+	path string
+	fn   starlark.Callable
+}
+
+// loadPatchHook runs the script at path and checks that it defines patch.
+// The script can use the json module.
+func loadPatchHook(path string) (*patchHook, error) {
+	thread := &starlark.Thread{Name: "load " + path}
+	thread.SetMaxExecutionSteps(patchHookSteps)
+	predeclared := starlark.StringDict{"json": starlarkjson.Module}
+	globals, err := starlark.ExecFile(thread, path, nil, predeclared)
+	if err != nil {
+		return nil, err
+	}
+	globals.Freeze()
+	fn, ok := globals["patch"].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("%s: must define patch(node, patch)", path)
+	}
+	return &patchHook{path: path, fn: fn}, nil
+}
+
+// apply calls the hook, returning the patch to send, or nil if it vetoed it.
+func (h *patchHook) apply(node *metav1.PartialObjectMetadata, patch map[string]interface{}) (map[string]interface{}, error) {
+	thread := &starlark.Thread{Name: h.path}
+	thread.SetMaxExecutionSteps(patchHookSteps)
+	n, err := toStarlark(thread, map[string]interface{}{
+		"name":        node.Name,
+		"labels":      nonNil(node.Labels),
+		"annotations": nonNil(node.Annotations),
+	})
+	if err != nil {
+		return nil, err
+	}
+	p, err := toStarlark(thread, patch)
+	if err != nil {
+		return nil, err
+	}
+	out, err := starlark.Call(thread, h.fn, starlark.Tuple{n, p}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", h.path, err)
+	}
+	if out == starlark.None {
+		return nil, nil
+	}
+	if _, ok := out.(*starlark.Dict); !ok {
+		return nil, fmt.Errorf("%s: patch returned %s, not a dict or None", h.path, out.Type())
+	}
+	encoded, err := starlark.Call(thread, starlarkjson.Module.Members["encode"], starlark.Tuple{out}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", h.path, err)
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal([]byte(encoded.(starlark.String)), &result); err != nil {
+		return nil, fmt.Errorf("%s: %w", h.path, err)
+	}
+	return result, nil
+}
+
+// patchNodeMetadata merge-patches the metadata a patch hook added, such as
+// annotations, onto the node itself, as the status subresource ignores it.
+func (c *NodeLifeSupportController) patchNodeMetadata(ctx context.Context, name string, meta interface{}) error {
+	raw, err := json.Marshal(map[string]interface{}{"metadata": meta})
+	if err != nil {
+		return err
+	}
+	c.debugPayload(name, "patching", raw)
+	return c.retryWrite(ctx, func(ctx context.Context) error {
+		_, err := c.client.CoreV1().Nodes().Patch(ctx, name, types.MergePatchType, raw, metav1.PatchOptions{})
+		return err
+	})
+}
+
+// toStarlark converts a JSON-encodable value through JSON.
+func toStarlark(thread *starlark.Thread, v interface{}) (starlark.Value, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.Call(thread, starlarkjson.Module.Members["decode"], starlark.Tuple{starlark.String(raw)}, nil)
+}
+
+// nonNil returns m, or an empty map for hooks to look up keys in if it is nil.
+func nonNil(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+	return m
+}
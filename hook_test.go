@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func writeHook(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hook.star")
+	if err := os.WriteFile(path, []byte(script), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestPatchHook tests that the patch hook can add to, and veto, the node
+// status patch, with added metadata sent to the node itself.
+func TestPatchHook(t *testing.T) {
+	hook, err := loadPatchHook(writeHook(t, `
+def patch(node, patch):
+    if node["labels"].get("pool") == "manual":
+        return None
+    for c in patch["status"]["conditions"]:
+        if c["type"] == "Ready":
+            c["message"] = "see INC-" + node["annotations"]["ticket"]
+    patch["metadata"] = {"annotations": {"example.com/assisted": "true"}}
+    return patch
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}},
+	)
+	c := &NodeLifeSupportController{
+		client: client,
+		clock:  clocktesting.NewFakeClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)),
+		opts:   Options{PatchHook: hook},
+	}
+
+	node1 := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "node1", Annotations: map[string]string{"ticket": "42"}}}
+	if err := c.ForceNodeReady(ctx, node1); err != nil {
+		t.Fatalf("ForceNodeReady(node1) error: %v", err)
+	}
+	if ready := nodeCondition(t, client, "node1", v1.NodeReady); ready == nil || ready.Message != "see INC-42" || ready.Reason != defaultReadyReason {
+		t.Errorf("node1 Ready = %+v", ready)
+	}
+	n, err := client.CoreV1().Nodes().Get(ctx, "node1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Annotations["example.com/assisted"] != "true" {
+		t.Errorf("node1 annotations = %v", n.Annotations)
+	}
+
+	node2 := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "node2", Labels: map[string]string{"pool": "manual"}}}
+	if err := c.ForceNodeReady(ctx, node2); !errors.Is(err, errPatchVetoed) {
+		t.Errorf("ForceNodeReady(node2) error = %v, want vetoed", err)
+	}
+	if ready := nodeCondition(t, client, "node2", v1.NodeReady); ready != nil {
+		t.Errorf("vetoed node2 has Ready = %+v", ready)
+	}
+
+	// node3 has no ticket annotation: the hook fails and nothing is sent.
+	node3 := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "node3"}}
+	if err := c.ForceNodeReady(ctx, node3); err == nil || errors.Is(err, errPatchVetoed) {
+		t.Errorf("ForceNodeReady(node3) error = %v, want the hook's", err)
+	}
+}
+
+// TestLoadPatchHook tests that hooks must define patch and are bounded.
+func TestLoadPatchHook(t *testing.T) {
+	tests := []struct {
+		name      string
+		script    string
+		expectErr bool
+	}{
+		{name: "valid", script: "def patch(node, patch):\n    return patch\n"},
+		{name: "no patch", script: "def other(node, patch):\n    return patch\n", expectErr: true},
+		{name: "syntax error", script: "def patch(node, patch)\n", expectErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := loadPatchHook(writeHook(t, tt.script)); (err != nil) != tt.expectErr {
+				t.Errorf("loadPatchHook() error = %v, expectErr %v", err, tt.expectErr)
+			}
+		})
+	}
+
+	hook, err := loadPatchHook(writeHook(t, "def patch(node, patch):\n    for i in range(100000000):\n        pass\n    return patch\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	if _, err := hook.apply(node, map[string]interface{}{}); err == nil {
+		t.Errorf("runaway hook not stopped")
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// nodeUserPrefix and nodesGroup make up the identity kubelets authenticate
+// as, which the Node authorizer and NodeRestriction admission recognise.
+const (
+	nodeUserPrefix = "system:node:"
+	nodesGroup     = "system:nodes"
+)
+
+// nodeImpersonationConfig returns cfg acting as the named node's kubelet.
+func nodeImpersonationConfig(cfg *rest.Config, nodeName string) *rest.Config {
+	cfg = rest.CopyConfig(cfg)
+	cfg.Impersonate = rest.ImpersonationConfig{
+		UserName: nodeUserPrefix + nodeName,
+		Groups:   []string{nodesGroup, "system:authenticated"},
+	}
+	return cfg
+}
+
+// nodeClient returns the client to renew a node's lease and patch its status
+// with: with IMPERSONATE_NODES, one impersonating the node, so audit logs
+// attribute heartbeats to the node identity; otherwise the controller's own.
+func (c *NodeLifeSupportController) nodeClient(nodeName string) (kubernetes.Interface, error) {
+	if !c.opts.ImpersonateNodes || c.restConfig == nil {
+		return c.client, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if client, ok := c.nodeClients[nodeName]; ok {
+		return client, nil
+	}
+	client, err := kubernetes.NewForConfig(nodeImpersonationConfig(c.restConfig, nodeName))
+	if err != nil {
+		return nil, err
+	}
+	if c.nodeClients == nil {
+		c.nodeClients = make(map[string]kubernetes.Interface)
+	}
+	c.nodeClients[nodeName] = client
+	return client, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+// TestNodeClient tests choosing between the controller's own client and
+// per-node impersonating clients.
+func TestNodeClient(t *testing.T) {
+	own := fake.NewSimpleClientset()
+	cfg := &rest.Config{Host: "https://api.example.com:6443", BearerToken: "controller"}
+
+	c := &NodeLifeSupportController{client: own, restConfig: cfg}
+	if got, _ := c.nodeClient("node1"); got != own {
+		t.Errorf("nodeClient() without IMPERSONATE_NODES did not return the controller's client")
+	}
+
+	c.opts.ImpersonateNodes = true
+	first, err := c.nodeClient("node1")
+	if err != nil {
+		t.Fatalf("nodeClient() error: %v", err)
+	}
+	if first == own {
+		t.Errorf("nodeClient() with IMPERSONATE_NODES returned the controller's client")
+	}
+	if again, _ := c.nodeClient("node1"); again != first {
+		t.Errorf("nodeClient() did not reuse the node's client")
+	}
+
+	imp := nodeImpersonationConfig(cfg, "node1").Impersonate
+	if imp.UserName != "system:node:node1" || len(imp.Groups) == 0 || imp.Groups[0] != "system:nodes" {
+		t.Errorf("impersonation = %+v", imp)
+	}
+	if cfg.Impersonate.UserName != "" {
+		t.Errorf("nodeImpersonationConfig() modified the base config")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// nodePods is the inventory of one supported node's pods.
+type nodePods struct {
+	total int
+	// critical are the node's critical pods, as "namespace/name".
+	critical []string
+}
+
+// runPodInventory takes an inventory of the pods on supported nodes every
+// interval until ctx is done, except while standing by for the leader lease.
+func (c *NodeLifeSupportController) runPodInventory(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if !standingBy() {
+			c.takePodInventory(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// takePodInventory counts the pods on every node on life support, which
+// report as running only because the controller says their node is healthy,
+// and picks out the critical ones.
+func (c *NodeLifeSupportController) takePodInventory(ctx context.Context) {
+	c.mu.Lock()
+	names := make([]string, 0, len(c.nodes))
+	for name := range c.nodes {
+		names = append(names, name)
+	}
+	c.mu.Unlock()
+
+	inventory := make(map[string]*nodePods, len(names))
+	for _, name := range names {
+		pods, err := c.nodePods(ctx, name)
+		if err != nil {
+			c.logf("node %s: listing pods for inventory: %v", name, err)
+			continue
+		}
+		inv := &nodePods{}
+		for _, p := range pods {
+			inv.total++
+			if c.criticalPod(&p) {
+				inv.critical = append(inv.critical, p.Namespace+"/"+p.Name)
+			}
+		}
+		sort.Strings(inv.critical)
+		inventory[name] = inv
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Keep only nodes still engaged: one may have left while we listed.
+	total := 0
+	critical := make(map[string]int)
+	for name, inv := range inventory {
+		if _, ok := c.nodes[name]; !ok {
+			delete(inventory, name)
+			continue
+		}
+		total += inv.total
+		for _, p := range inv.critical {
+			ns, _, _ := strings.Cut(p, "/")
+			critical[ns]++
+		}
+	}
+	c.podInventory = inventory
+	supportedPods.WithLabelValues(c.opts.Cluster).Set(float64(total))
+	supportedCriticalPods.DeletePartialMatch(prometheus.Labels{"cluster": c.opts.Cluster})
+	for ns, n := range critical {
+		supportedCriticalPods.WithLabelValues(c.opts.Cluster, ns).Set(float64(n))
+	}
+}
+
+// nodePods lists a node's pods that have not terminated.
+func (c *NodeLifeSupportController) nodePods(ctx context.Context, name string) ([]v1.Pod, error) {
+	ctx, cancel := c.opContext(ctx)
+	defer cancel()
+	list, err := c.client.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", name).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var pods []v1.Pod
+	for _, p := range list.Items {
+		if p.Spec.NodeName != name || p.Status.Phase == v1.PodSucceeded || p.Status.Phase == v1.PodFailed {
+			continue
+		}
+		pods = append(pods, p)
+	}
+	return pods, nil
+}
+
+// criticalPod reports whether a pod is in one of CRITICAL_POD_NAMESPACES or
+// matches CRITICAL_POD_SELECTOR.
+func (c *NodeLifeSupportController) criticalPod(p *v1.Pod) bool {
+	for _, ns := range c.opts.CriticalPodNamespaces {
+		if p.Namespace == ns {
+			return true
+		}
+	}
+	return c.opts.CriticalPodSelector != nil && c.opts.CriticalPodSelector.Matches(labels.Set(p.Labels))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestTakePodInventory(t *testing.T) {
+	pod := func(namespace, name, node string, podLabels map[string]string, phase v1.PodPhase) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: podLabels},
+			Spec:       v1.PodSpec{NodeName: node},
+			Status:     v1.PodStatus{Phase: phase},
+		}
+	}
+	client := fake.NewSimpleClientset(
+		pod("shop", "web", "node1", nil, v1.PodRunning),
+		pod("shop", "db", "node1", map[string]string{"tier": "critical"}, v1.PodRunning),
+		pod("payments", "api", "node1", nil, v1.PodRunning),
+		pod("shop", "job", "node1", nil, v1.PodSucceeded),
+		pod("shop", "other", "node2", nil, v1.PodRunning),
+	)
+	c := &NodeLifeSupportController{
+		client: client,
+		opts: Options{
+			Cluster:               "inventory-test",
+			CriticalPodNamespaces: []string{"payments"},
+			CriticalPodSelector:   labels.SelectorFromSet(labels.Set{"tier": "critical"}),
+		},
+	}
+	c.engage("node1")
+
+	c.takePodInventory(context.Background())
+
+	inv := c.podInventory["node1"]
+	if inv == nil || inv.total != 3 {
+		t.Fatalf("node1 inventory = %+v, want 3 pods", inv)
+	}
+	if len(inv.critical) != 2 || inv.critical[0] != "payments/api" || inv.critical[1] != "shop/db" {
+		t.Errorf("critical pods = %v, want [payments/api shop/db]", inv.critical)
+	}
+	if _, ok := c.podInventory["node2"]; ok {
+		t.Error("inventory taken of a node not on life support")
+	}
+	if got := testutil.ToFloat64(supportedPods.WithLabelValues("inventory-test")); got != 3 {
+		t.Errorf("supported pods metric = %v, want 3", got)
+	}
+	if got := testutil.ToFloat64(supportedCriticalPods.WithLabelValues("inventory-test", "shop")); got != 1 {
+		t.Errorf("critical pods metric for shop = %v, want 1", got)
+	}
+
+	cs := &clusterStatus{}
+	c.fillStatus(cs, time.Now())
+	if cs.Pods != 3 || len(cs.Nodes) != 1 || len(cs.Nodes[0].CriticalPods) != 2 {
+		t.Errorf("status = %+v", cs)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// keepAliveLease is a non-node Lease (e.g. the leader-election lease of a
+// component under maintenance) that the controller keeps renewed without
+// changing its holder.
+type keepAliveLease struct {
+	Namespace string
+	Name      string
+	// Interval is the renewal cadence; zero derives it from the lease's
+	// duration like node leases.
+	Interval time.Duration
+}
+
+func (k keepAliveLease) String() string {
+	return k.Namespace + "/" + k.Name
+}
+
+// parseKeepAliveLeases parses KEEPALIVE_LEASES entries of the form
+// "namespace/name" or "namespace/name=interval".
+func parseKeepAliveLeases(entries []string) ([]keepAliveLease, error) {
+	var out []keepAliveLease
+	for _, e := range entries {
+		ref, interval, hasInterval := strings.Cut(e, "=")
+		ns, name, ok := strings.Cut(strings.TrimSpace(ref), "/")
+		if !ok || ns == "" || name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("KEEPALIVE_LEASES: %q is not namespace/name[=interval]", e)
+		}
+		k := keepAliveLease{Namespace: ns, Name: name}
+		if hasInterval {
+			d, err := time.ParseDuration(strings.TrimSpace(interval))
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("KEEPALIVE_LEASES: invalid interval in %q", e)
+			}
+			k.Interval = d
+		}
+		out = append(out, k)
+	}
+	return out, nil
+}
+
+// renewKeepAliveLeases renews every configured keep-alive lease that is due.
+func (c *NodeLifeSupportController) renewKeepAliveLeases(ctx context.Context, now time.Time) {
+	for _, k := range c.opts.KeepAliveLeases {
+		key := k.String()
+		c.mu.Lock()
+		next := c.keepAliveNext[key]
+		c.mu.Unlock()
+		if now.Before(next) {
+			continue
+		}
+
+		leaseDuration, err := c.renewLease(ctx, k.Namespace, k.Name)
+		if err != nil {
+			c.logf("failed renewing lease %s: %v", key, err)
+			continue
+		}
+		interval := k.Interval
+		if interval == 0 {
+			interval = leaseDuration / renewFraction
+		}
+		c.mu.Lock()
+		if c.keepAliveNext == nil {
+			c.keepAliveNext = make(map[string]time.Time)
+		}
+		c.keepAliveNext[key] = c.now().Add(interval)
+		c.mu.Unlock()
+	}
+}
+
+// renewLease bumps an existing lease's renewTime, leaving its holder and
+// everything else as they are, and returns the lease's duration.
+func (c *NodeLifeSupportController) renewLease(ctx context.Context, namespace, name string) (time.Duration, error) {
+	ctx, cancel := c.opContext(ctx)
+	defer cancel()
+
+	leases := c.client.CoordinationV1().Leases(namespace)
+	lease, err := leases.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return 0, err
+	}
+	apply := leaseApplyFromSpec(lease)
+	apply.Spec.WithRenewTime(metav1.NewMicroTime(c.now()))
+	if _, err := leases.Apply(ctx, apply, metav1.ApplyOptions{FieldManager: fieldManager, Force: true}); err != nil {
+		return 0, err
+	}
+
+	duration := defaultLeaseDurationSeconds
+	if lease.Spec.LeaseDurationSeconds != nil && *lease.Spec.LeaseDurationSeconds > 0 {
+		duration = *lease.Spec.LeaseDurationSeconds
+	}
+	return time.Duration(duration) * time.Second, nil
+}
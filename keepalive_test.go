@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestParseKeepAliveLeases tests KEEPALIVE_LEASES parsing.
+func TestParseKeepAliveLeases(t *testing.T) {
+	tests := []struct {
+		name      string
+		entries   []string
+		expected  []keepAliveLease
+		expectErr bool
+	}{
+		{name: "none", entries: nil, expected: nil},
+		{
+			name:     "with and without interval",
+			entries:  []string{"kube-system/kube-scheduler", "monitoring/operator-lock=15s"},
+			expected: []keepAliveLease{{Namespace: "kube-system", Name: "kube-scheduler"}, {Namespace: "monitoring", Name: "operator-lock", Interval: 15 * time.Second}},
+		},
+		{name: "missing namespace", entries: []string{"kube-scheduler"}, expectErr: true},
+		{name: "too many segments", entries: []string{"a/b/c"}, expectErr: true},
+		{name: "bad interval", entries: []string{"a/b=soon"}, expectErr: true},
+		{name: "zero interval", entries: []string{"a/b=0s"}, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseKeepAliveLeases(tt.entries)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("parseKeepAliveLeases() error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if len(result) != len(tt.expected) {
+				t.Fatalf("parseKeepAliveLeases() = %v, want %v", result, tt.expected)
+			}
+			for i := range result {
+				if result[i] != tt.expected[i] {
+					t.Errorf("parseKeepAliveLeases()[%d] = %v, want %v", i, result[i], tt.expected[i])
+				}
+			}
+		})
+	}
+}
+
+// TestRenewKeepAliveLeases tests that keep-alive leases are renewed without
+// changing their holder, and not again until due.
+func TestRenewKeepAliveLeases(t *testing.T) {
+	ctx := context.Background()
+	holder := "scheduler-abc"
+	duration := int32(15)
+	old := metav1.NewMicroTime(time.Now().Add(-time.Hour))
+	client := fake.NewSimpleClientset(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-scheduler", Namespace: "kube-system"},
+		Spec:       coordinationv1.LeaseSpec{HolderIdentity: &holder, LeaseDurationSeconds: &duration, RenewTime: &old},
+	})
+	c := &NodeLifeSupportController{client: client, opts: Options{
+		KeepAliveLeases: []keepAliveLease{{Namespace: "kube-system", Name: "kube-scheduler"}},
+	}}
+
+	c.renewKeepAliveLeases(ctx, time.Now())
+	lease, err := client.CoordinationV1().Leases("kube-system").Get(ctx, "kube-scheduler", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *lease.Spec.HolderIdentity != holder {
+		t.Errorf("holder = %q, want %q", *lease.Spec.HolderIdentity, holder)
+	}
+	if !lease.Spec.RenewTime.After(old.Time) {
+		t.Errorf("renewTime not bumped: %v", lease.Spec.RenewTime)
+	}
+	if next := c.keepAliveNext["kube-system/kube-scheduler"]; time.Until(next) > 4*time.Second || time.Until(next) <= 0 {
+		t.Errorf("next renewal in %v, want about a quarter of 15s", time.Until(next))
+	}
+
+	actions := len(client.Actions())
+	c.renewKeepAliveLeases(ctx, time.Now())
+	if len(client.Actions()) != actions {
+		t.Errorf("lease renewed again before due")
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// startLeaseInformer watches the node leases, so that kubelet heartbeats can
+// be observed without a GET per node per cycle. It returns the informer's
+// HasSynced.
+func (c *NodeLifeSupportController) startLeaseInformer(stop <-chan struct{}) cache.InformerSynced {
+	factory := informers.NewSharedInformerFactoryWithOptions(c.client, c.opts.ResyncPeriod, informers.WithNamespace(nodeLeaseNamespace))
+	inf := factory.Coordination().V1().Leases()
+	c.leases = inf.Lister().Leases(nodeLeaseNamespace)
+	// The handler only errors once the informer has been started.
+	_, _ = inf.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { c.observeLease(obj) },
+		UpdateFunc: func(_, obj interface{}) { c.observeLease(obj) },
+	})
+	synced := inf.Informer().HasSynced
+	factory.Start(stop)
+	return synced
+}
+
+// leaseExpiry returns when a node lease stops being valid, or false if it has
+// never been renewed.
+func leaseExpiry(lease *coordinationv1.Lease) (time.Time, bool) {
+	if lease.Spec.RenewTime == nil {
+		return time.Time{}, false
+	}
+	seconds := defaultLeaseDurationSeconds
+	if lease.Spec.LeaseDurationSeconds != nil && *lease.Spec.LeaseDurationSeconds > 0 {
+		seconds = *lease.Spec.LeaseDurationSeconds
+	}
+	return lease.Spec.RenewTime.Add(time.Duration(seconds) * time.Second), true
+}
+
+// watchHeartbeats reports whether nodes are engaged and stood down based on
+// their kubelet heartbeats rather than unconditionally.
+func (c *NodeLifeSupportController) watchHeartbeats() bool {
+	if c.opts.EngageGracePeriod > 0 || c.opts.RecoveryCoolDown > 0 {
+		return true
+	}
+	for _, p := range c.opts.Policies {
+		if p.EngageGracePeriod != nil && p.EngageGracePeriod.Duration > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// observeLease notes renewals of an engaged node's lease that the controller
+// did not write, i.e. its kubelet heartbeating again.
+func (c *NodeLifeSupportController) observeLease(obj interface{}) {
+	lease, ok := obj.(*coordinationv1.Lease)
+	if !ok || lease.Spec.RenewTime == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st, ok := c.nodes[lease.Name]
+	if !ok || st.lastRenew.IsZero() {
+		return
+	}
+	if renewed := lease.Spec.RenewTime.Time; renewed.After(st.lastRenew) {
+		st.kubeletRenew = renewed
+	}
+}
+
+// needsSupport implements ENGAGE_GRACE_PERIOD: when heartbeats are watched, a
+// node that is not yet on life support is only engaged once its kubelet has
+// failed to renew its lease for the grace period (possibly zero), so brief
+// kubelet restarts and reboots are not masked.
+// Nodes without a lease count as stale from when the controller first noticed.
+// A node's policy p (nil for none) may set its own grace period.
+func (c *NodeLifeSupportController) needsSupport(name string, p *Policy, now time.Time) bool {
+	grace := c.opts.EngageGracePeriod
+	if p != nil && p.EngageGracePeriod != nil {
+		grace = p.EngageGracePeriod.Duration
+	}
+	if !c.watchHeartbeats() || c.leases == nil || c.engaged(name) {
+		return true
+	}
+
+	var staleSince time.Time
+	lease, err := c.leases.Get(name)
+	switch {
+	case err == nil:
+		expiry, ok := leaseExpiry(lease)
+		if ok && now.Before(expiry) {
+			c.clearStale(name)
+			return false
+		}
+		if ok {
+			staleSince = expiry
+		}
+	case !apierrors.IsNotFound(err):
+		c.logf("node %s: reading cached lease: %v", name, err)
+		return false
+	}
+
+	c.mu.Lock()
+	if c.stale == nil {
+		c.stale = make(map[string]time.Time)
+	}
+	first, seen := c.stale[name]
+	if !seen {
+		first = now
+		c.stale[name] = first
+	}
+	c.mu.Unlock()
+	if staleSince.IsZero() {
+		staleSince = first
+	}
+	if !seen {
+		c.logf("node %s: kubelet heartbeat stale since %s, engaging after %s unless it recovers",
+			name, staleSince.UTC().Format(time.RFC3339), grace)
+	}
+	return !now.Before(staleSince.Add(grace))
+}
+
+// clearStale forgets that a node was seen with a stale heartbeat.
+func (c *NodeLifeSupportController) clearStale(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.stale[name]; ok {
+		delete(c.stale, name)
+		c.logf("node %s: kubelet heartbeat recovered within the grace period", name)
+	}
+}
+
+// coolingDown implements RECOVERY_COOLDOWN for an engaged node. Once its
+// kubelet is seen renewing the lease again the controller keeps its hands
+// off; if the heartbeat stays healthy for the whole cool-down the node is
+// stood down, and if it goes stale again life support resumes at once. It
+// reports whether the node must not be synced.
+func (c *NodeLifeSupportController) coolingDown(ctx context.Context, node *metav1.PartialObjectMetadata, now time.Time) bool {
+	cooldown := c.opts.RecoveryCoolDown
+	if cooldown <= 0 || c.leases == nil {
+		return false
+	}
+	c.mu.Lock()
+	st, ok := c.nodes[node.Name]
+	if !ok || st.kubeletRenew.IsZero() {
+		c.mu.Unlock()
+		return false
+	}
+	if st.recoveringSince.IsZero() {
+		st.recoveringSince = st.kubeletRenew
+		c.logf("node %s: kubelet heartbeating again, cooling down for %s", node.Name, cooldown)
+	}
+	since := st.recoveringSince
+	c.mu.Unlock()
+
+	if lease, err := c.leases.Get(node.Name); err == nil {
+		if expiry, ok := leaseExpiry(lease); ok && !now.Before(expiry) {
+			c.mu.Lock()
+			st.recoveringSince, st.kubeletRenew, st.nextSync = time.Time{}, time.Time{}, time.Time{}
+			c.mu.Unlock()
+			c.logf("node %s: kubelet heartbeat lost again during cool-down, resuming life support", node.Name)
+			return false
+		}
+	}
+	if now.Sub(since) < cooldown {
+		return true
+	}
+
+	if err := c.disengage(ctx, node.Name, disengageRecovered); err != nil {
+		c.logf("failed standing down node %s: %v", node.Name, err)
+		return true
+	}
+	c.nodeEventAnnotated(node.Name, node.UID, map[string]string{reasonAnnotation: disengageRecovered}, v1.EventTypeNormal, reasonLifeSupportStoodDown,
+		"Kubelet heartbeat stable for %s, life support stood down", cooldown)
+	return true
+}
+
+// recordRenew remembers the renewTime the controller is about to write to a
+// node's lease, so that the write is not mistaken for the kubelet's.
+func (c *NodeLifeSupportController) recordRenew(name string, renewed time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if st, ok := c.nodes[name]; ok {
+		// Lease times are stored with microsecond precision.
+		st.lastRenew = renewed.Truncate(time.Microsecond)
+	}
+}
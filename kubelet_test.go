@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	coordinationlisters "k8s.io/client-go/listers/coordination/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+// leaseListerFor returns a node lease lister backed by the given leases.
+func leaseListerFor(t *testing.T, leases ...*coordinationv1.Lease) coordinationlisters.LeaseNamespaceLister {
+	t.Helper()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, l := range leases {
+		if err := indexer.Add(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return coordinationlisters.NewLeaseLister(indexer).Leases(nodeLeaseNamespace)
+}
+
+func nodeLease(name string, renewed time.Time, seconds int32) *coordinationv1.Lease {
+	ts := metav1.NewMicroTime(renewed)
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: nodeLeaseNamespace},
+		Spec:       coordinationv1.LeaseSpec{RenewTime: &ts, LeaseDurationSeconds: &seconds},
+	}
+}
+
+// TestNeedsSupport tests the engage grace period.
+func TestNeedsSupport(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		grace    time.Duration
+		lease    *coordinationv1.Lease
+		engaged  bool
+		expected bool
+	}{
+		{name: "grace period disabled", grace: 0, lease: nodeLease("node1", now, 40), expected: true},
+		{name: "fresh heartbeat", grace: 2 * time.Minute, lease: nodeLease("node1", now.Add(-10*time.Second), 40), expected: false},
+		{name: "stale within grace", grace: 2 * time.Minute, lease: nodeLease("node1", now.Add(-time.Minute), 40), expected: false},
+		{name: "stale beyond grace", grace: 2 * time.Minute, lease: nodeLease("node1", now.Add(-3*time.Minute), 40), expected: true},
+		{name: "already engaged", grace: 2 * time.Minute, lease: nodeLease("node1", now, 40), engaged: true, expected: true},
+		{name: "no lease yet", grace: 2 * time.Minute, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var leases []*coordinationv1.Lease
+			if tt.lease != nil {
+				leases = append(leases, tt.lease)
+			}
+			c := &NodeLifeSupportController{
+				leases: leaseListerFor(t, leases...),
+				opts:   Options{EngageGracePeriod: tt.grace},
+			}
+			if tt.engaged {
+				c.engage("node1")
+			}
+			if got := c.needsSupport("node1", nil, now); got != tt.expected {
+				t.Errorf("needsSupport() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestNeedsSupportMissingLease tests that a node without a lease is engaged
+// once the grace period has passed since it was first seen.
+func TestNeedsSupportMissingLease(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	c := &NodeLifeSupportController{leases: leaseListerFor(t), opts: Options{EngageGracePeriod: time.Minute}}
+	if c.needsSupport("node1", nil, now) {
+		t.Fatalf("node without lease engaged immediately")
+	}
+	if c.needsSupport("node1", nil, now.Add(30*time.Second)) {
+		t.Errorf("node without lease engaged within the grace period")
+	}
+	if !c.needsSupport("node1", nil, now.Add(time.Minute)) {
+		t.Errorf("node without lease not engaged after the grace period")
+	}
+	p := &Policy{EngageGracePeriod: &metav1.Duration{Duration: 2 * time.Minute}}
+	if c.needsSupport("node1", p, now.Add(time.Minute)) {
+		t.Errorf("node without lease engaged within its policy's grace period")
+	}
+}
+
+// TestCoolingDown tests standing a node down once its kubelet has been
+// heartbeating again for the cool-down, and resuming if it stops again.
+func TestCoolingDown(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	node := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	kubeletLease := nodeLease("node1", t0.Add(10*time.Second), 40)
+
+	newController := func() (*NodeLifeSupportController, *record.FakeRecorder) {
+		recorder := record.NewFakeRecorder(10)
+		c := &NodeLifeSupportController{
+			client:   fake.NewSimpleClientset(),
+			recorder: recorder,
+			leases:   leaseListerFor(t, kubeletLease),
+			opts:     Options{RecoveryCoolDown: time.Minute},
+		}
+		c.engage("node1")
+		c.recordRenew("node1", t0)
+		return c, recorder
+	}
+
+	t.Run("own renewals are ignored", func(t *testing.T) {
+		c, _ := newController()
+		c.observeLease(nodeLease("node1", t0, 40))
+		if c.coolingDown(ctx, node, t0.Add(5*time.Second)) {
+			t.Errorf("cooling down after the controller's own renewal")
+		}
+	})
+
+	t.Run("stands down after a stable cool-down", func(t *testing.T) {
+		c, recorder := newController()
+		c.observeLease(kubeletLease)
+		if !c.coolingDown(ctx, node, t0.Add(20*time.Second)) {
+			t.Fatalf("not cooling down after a kubelet renewal")
+		}
+		if !c.engaged("node1") {
+			t.Fatalf("stood down before the cool-down elapsed")
+		}
+		// Keep the kubelet's heartbeat fresh until the cool-down ends.
+		c.leases = leaseListerFor(t, nodeLease("node1", t0.Add(60*time.Second), 40))
+		if !c.coolingDown(ctx, node, t0.Add(70*time.Second)) || c.engaged("node1") {
+			t.Fatalf("node not stood down after the cool-down")
+		}
+		if e := <-recorder.Events; !strings.Contains(e, reasonLifeSupportStoodDown) {
+			t.Errorf("event = %q", e)
+		}
+	})
+
+	t.Run("resumes when the heartbeat is lost again", func(t *testing.T) {
+		c, _ := newController()
+		c.observeLease(kubeletLease)
+		c.coolingDown(ctx, node, t0.Add(20*time.Second))
+		if c.coolingDown(ctx, node, t0.Add(55*time.Second)) {
+			t.Errorf("still cooling down after the kubelet lease expired")
+		}
+		if !c.engaged("node1") || !c.due("node1", t0.Add(55*time.Second)) {
+			t.Errorf("life support not resumed immediately")
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// activeLabel is set to "true" on nodes on life support with ACTIVE_LABEL,
+// so they can be selected with kubectl, Prometheus relabelling or scheduling
+// constraints.
+const activeLabel = "node-life-support.io/active"
+
+// labelActive labels an engaged node with the activeLabel, once per
+// engagement.
+func (c *NodeLifeSupportController) labelActive(ctx context.Context, name string) error {
+	c.mu.Lock()
+	st, ok := c.nodes[name]
+	done := ok && st.labelled
+	c.mu.Unlock()
+	if !ok || done {
+		return nil
+	}
+	if err := c.patchNodeMetadata(ctx, name, map[string]interface{}{"labels": map[string]interface{}{activeLabel: "true"}}); err != nil {
+		return err
+	}
+	c.audit(name, auditLabelsUpdated, activeLabel+"=true")
+	c.mu.Lock()
+	if st, ok := c.nodes[name]; ok {
+		st.labelled = true
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// unlabelActive removes the activeLabel from a node leaving life support.
+// With ACTIVE_LABEL on for any node it checks even if this run did not label
+// the node, in case an earlier one did.
+func (c *NodeLifeSupportController) unlabelActive(ctx context.Context, name string) error {
+	c.mu.Lock()
+	st, ok := c.nodes[name]
+	labelled := ok && st.labelled
+	c.mu.Unlock()
+	if !labelled && !c.opts.patcherConfigured(LabelPatcher{}) {
+		return nil
+	}
+	if !labelled {
+		opCtx, cancel := c.opContext(ctx)
+		node, err := c.client.CoreV1().Nodes().Get(opCtx, name, metav1.GetOptions{})
+		cancel()
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, ok := node.Labels[activeLabel]; !ok {
+			return nil
+		}
+	}
+	return c.removeActiveLabel(ctx, name)
+}
+
+// removeActiveLabel removes the activeLabel from a node.
+func (c *NodeLifeSupportController) removeActiveLabel(ctx context.Context, name string) error {
+	err := c.patchNodeMetadata(ctx, name, map[string]interface{}{"labels": map[string]interface{}{activeLabel: nil}})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err == nil {
+		c.audit(name, auditLabelsUpdated, activeLabel+"-")
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestActiveLabel tests that an engaged node is labelled active, keeping its
+// other labels, and unlabelled when disengaged.
+func TestActiveLabel(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1", Labels: map[string]string{"pool": "edge"}}}
+	client := fake.NewSimpleClientset(node)
+	c := &NodeLifeSupportController{client: client, opts: Options{HolderIdentity: HolderIdentityNode, ActiveLabel: true}}
+	ctx := context.Background()
+	meta := &metav1.PartialObjectMetadata{ObjectMeta: node.ObjectMeta}
+	labels := func() map[string]string {
+		t.Helper()
+		n, err := client.CoreV1().Nodes().Get(ctx, "node1", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n.Labels
+	}
+
+	c.engage("node1")
+	for i := 0; i < 2; i++ {
+		if err := c.SyncNode(ctx, meta); err != nil {
+			t.Fatalf("SyncNode() error: %v", err)
+		}
+	}
+	if l := labels(); l[activeLabel] != "true" || l["pool"] != "edge" {
+		t.Fatalf("labels while engaged = %v", l)
+	}
+	patches := 0
+	for _, a := range client.Actions() {
+		if a.GetVerb() == "patch" && a.GetResource().Resource == "nodes" && a.GetSubresource() == "" {
+			patches++
+		}
+	}
+	if patches != 1 {
+		t.Errorf("node labelled %d times, want once per engagement", patches)
+	}
+
+	if err := c.disengage(ctx, "node1", disengageOperatorDisabled); err != nil {
+		t.Fatalf("disengage() error: %v", err)
+	}
+	if l := labels(); len(l) != 1 || l["pool"] != "edge" {
+		t.Errorf("labels after disengaging = %v, want only pool", l)
+	}
+}
+
+// TestActiveLabelRestored tests that disengaging a node engaged before a
+// restart removes the label the previous run applied.
+func TestActiveLabelRestored(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1", Labels: map[string]string{activeLabel: "true"}}}
+	client := fake.NewSimpleClientset(node)
+	c := &NodeLifeSupportController{client: client, opts: Options{HolderIdentity: HolderIdentityNode, ActiveLabel: true}}
+	ctx := context.Background()
+
+	c.engage("node1")
+	if err := c.disengage(ctx, "node1", disengageOperatorDisabled); err != nil {
+		t.Fatalf("disengage() error: %v", err)
+	}
+	n, err := client.CoreV1().Nodes().Get(ctx, "node1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := n.Labels[activeLabel]; ok {
+		t.Errorf("labels after disengaging = %v", n.Labels)
+	}
+}
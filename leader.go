@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// leaderLeaseName is the Lease, in the controller's namespace, replicas
+// compete for with LEADER_ELECTION.
+const leaderLeaseName = "node-life-support-leader"
+
+// leaderState is what this replica knows of the leader election, served on
+// /status and exported as metrics.
+type leaderState struct {
+	identity string
+	// now is the controller's clock, timing this replica's leadership.
+	now func() time.Time
+
+	mu sync.Mutex
+	// leader is the replica last seen holding the lease, empty until one
+	// has been.
+	leader string
+	// leadingSince is when this replica became leader, zero when it is not.
+	leadingSince time.Time
+	transitions  int
+}
+
+// leadership is set with LEADER_ELECTION.
+var leadership *leaderState
+
+// standingBy reports whether this replica is waiting for the leader lease,
+// knowing nothing of which nodes are on life support.
+func standingBy() bool {
+	return leadership != nil && !leadership.leading()
+}
+
+type leaderStatus struct {
+	// Identity is this replica's.
+	Identity string `json:"identity"`
+	Leader   string `json:"leader"`
+	// Transitions is how many times the leader has changed since this
+	// replica started.
+	Transitions    int        `json:"transitions"`
+	LeadingSince   *time.Time `json:"leadingSince,omitempty"`
+	LeadingSeconds float64    `json:"leadingSeconds,omitempty"`
+}
+
+// observe records the replica seen holding the lease.
+func (l *leaderState) observe(identity string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if identity == l.leader {
+		return
+	}
+	if l.leader != "" {
+		l.transitions++
+		leaderTransitionsTotal.Inc()
+		log.Printf("leader changed from %s to %s", l.leader, identity)
+	} else {
+		log.Printf("leader is %s", identity)
+	}
+	leaderInfo.Reset()
+	leaderInfo.WithLabelValues(identity).Set(1)
+	l.leader = identity
+}
+
+// setLeading records this replica gaining or losing the lease at now.
+func (l *leaderState) setLeading(leading bool, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if leading {
+		l.leadingSince = now
+	} else {
+		l.leadingSince = time.Time{}
+	}
+}
+
+// leading reports whether this replica holds the lease.
+func (l *leaderState) leading() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return !l.leadingSince.IsZero()
+}
+
+// leadingSeconds is how long this replica has been leader at now.
+func (l *leaderState) leadingSeconds(now time.Time) float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.leadingSince.IsZero() {
+		return 0
+	}
+	return now.Sub(l.leadingSince).Seconds()
+}
+
+func (l *leaderState) status(now time.Time) *leaderStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := &leaderStatus{Identity: l.identity, Leader: l.leader, Transitions: l.transitions}
+	if !l.leadingSince.IsZero() {
+		since := l.leadingSince.UTC()
+		s.LeadingSince, s.LeadingSeconds = &since, now.Sub(l.leadingSince).Seconds()
+	}
+	return s
+}
+
+// runLeaderElection calls run while this replica holds the leader lease,
+// until ctx is done. Losing the lease cancels run's context; the replica then
+// rejoins the election, and engagements are reconciled on its first cycle if
+// it wins again, as after a restart.
+func runLeaderElection(ctx context.Context, client kubernetes.Interface, opts *Options, run func(context.Context)) error {
+	l := leadership
+	// A run cancelled on losing the lease may still be finishing a cycle
+	// when the lease is won back.
+	var running sync.Mutex
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Name: leaderLeaseName, Namespace: opts.Namespace},
+			Client:     client.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: opts.Identity},
+		},
+		LeaseDuration:   opts.LeaderLeaseDuration,
+		RenewDeadline:   opts.LeaderLeaseDuration * 2 / 3,
+		RetryPeriod:     opts.LeaderLeaseDuration / 5,
+		ReleaseOnCancel: true,
+		Name:            "node-life-support",
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.Printf("became leader")
+				lifecycleEvent(v1.EventTypeNormal, reasonBecameLeader, "Became leader, syncing nodes")
+				l.setLeading(true, l.now())
+				running.Lock()
+				defer running.Unlock()
+				run(ctx)
+			},
+			OnStoppedLeading: func() {
+				l.setLeading(false, l.now())
+				if ctx.Err() == nil {
+					log.Printf("lost leadership; rejoining the election")
+					lifecycleEvent(v1.EventTypeWarning, reasonLostLeadership, "Lost leadership, rejoining the election")
+				}
+			},
+			OnNewLeader: l.observe,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	for ctx.Err() == nil {
+		le.Run(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// TestLeaderState tests that leader changes are counted and exported, and
+// that time as leader is tracked.
+func TestLeaderState(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	l := &leaderState{identity: "replica-a"}
+	before := testutil.ToFloat64(leaderTransitionsTotal)
+
+	l.observe("replica-b")
+	l.observe("replica-b")
+	l.observe("replica-a")
+	l.setLeading(true, t0)
+
+	if got := testutil.ToFloat64(leaderTransitionsTotal) - before; got != 1 {
+		t.Errorf("transitions counted = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(leaderInfo.WithLabelValues("replica-a")); got != 1 {
+		t.Errorf("leader replica-a = %v, want 1", got)
+	}
+	if got := testutil.CollectAndCount(leaderInfo); got != 1 {
+		t.Errorf("leader series = %d, want 1", got)
+	}
+	s := l.status(t0.Add(time.Minute))
+	if s.Leader != "replica-a" || s.Transitions != 1 || s.LeadingSince == nil || s.LeadingSeconds != 60 {
+		t.Errorf("status = %+v", s)
+	}
+
+	l.setLeading(false, t0.Add(2*time.Minute))
+	if got := l.leadingSeconds(t0.Add(3 * time.Minute)); got != 0 {
+		t.Errorf("leadingSeconds() after losing the lease = %v, want 0", got)
+	}
+	if s := l.status(t0.Add(3 * time.Minute)); s.LeadingSince != nil {
+		t.Errorf("status after losing the lease = %+v", s)
+	}
+}
+
+// TestRunLeaderElection tests that run is only called once the lease is
+// held, and that it is released when ctx is done.
+func TestRunLeaderElection(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	leadership = &leaderState{identity: "replica-a", now: clocktesting.NewFakeClock(t0).Now}
+	defer func() { leadership = nil }()
+	client := fake.NewSimpleClientset()
+	opts := &Options{Namespace: "kube-system", Identity: "replica-a", LeaderLeaseDuration: time.Second}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- runLeaderElection(ctx, client, opts, func(ctx context.Context) {
+			close(started)
+			<-ctx.Done()
+		})
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run not called")
+	}
+	lease, err := client.CoordinationV1().Leases("kube-system").Get(context.Background(), leaderLeaseName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder := lease.Spec.HolderIdentity; holder == nil || *holder != "replica-a" {
+		t.Errorf("lease holder = %v, want replica-a", holder)
+	}
+	if s := leadership.status(t0.Add(time.Minute)); s.LeadingSince == nil || !s.LeadingSince.Equal(t0) {
+		t.Errorf("leadingSince = %v after run was called, want %v from the clock", s.LeadingSince, t0)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	lease, err = client.CoordinationV1().Leases("kube-system").Get(context.Background(), leaderLeaseName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder := lease.Spec.HolderIdentity; holder != nil && *holder == "replica-a" {
+		t.Errorf("lease not released")
+	}
+}
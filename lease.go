@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"text/template"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coordinationv1apply "k8s.io/client-go/applyconfigurations/coordination/v1"
+)
+
+// nodeLeaseNamespace holds the kubelet heartbeat leases, one per node, named
+// after the node.
+const nodeLeaseNamespace = "kube-node-lease"
+
+// Holder identity strategies for node leases (HOLDER_IDENTITY).
+const (
+	// HolderIdentityNode impersonates the kubelet by writing the node name.
+	HolderIdentityNode = "node"
+	// HolderIdentityController writes the controller's own identity.
+	HolderIdentityController = "controller"
+	// HolderIdentityPreserve leaves whatever holder is already recorded.
+	HolderIdentityPreserve = "preserve"
+	// HolderIdentityTemplate renders HOLDER_IDENTITY_TEMPLATE.
+	HolderIdentityTemplate = "template"
+)
+
+// holderTemplateData is passed to HOLDER_IDENTITY_TEMPLATE.
+type holderTemplateData struct {
+	Node       string
+	Controller string
+}
+
+// parseHolderIdentity validates the holder identity strategy and, for the
+// template strategy, parses its template.
+func parseHolderIdentity(mode, tmpl string) (*template.Template, error) {
+	switch mode {
+	case HolderIdentityNode, HolderIdentityController, HolderIdentityPreserve:
+		return nil, nil
+	case HolderIdentityTemplate:
+		if tmpl == "" {
+			return nil, fmt.Errorf("HOLDER_IDENTITY_TEMPLATE must be set when HOLDER_IDENTITY=%s", HolderIdentityTemplate)
+		}
+		t, err := template.New("holderIdentity").Option("missingkey=error").Parse(tmpl)
+		if err != nil {
+			return nil, fmt.Errorf("HOLDER_IDENTITY_TEMPLATE: %w", err)
+		}
+		return t, nil
+	default:
+		return nil, fmt.Errorf("HOLDER_IDENTITY: unknown strategy %q", mode)
+	}
+}
+
+// holderIdentity returns the holderIdentity to write to a node's lease, or
+// false if the existing holder should be left untouched.
+func (c *NodeLifeSupportController) holderIdentity(nodeName string) (string, bool, error) {
+	switch c.opts.HolderIdentity {
+	case HolderIdentityController:
+		return c.opts.Identity, true, nil
+	case HolderIdentityPreserve:
+		return "", false, nil
+	case HolderIdentityTemplate:
+		var buf bytes.Buffer
+		data := holderTemplateData{Node: nodeName, Controller: c.opts.Identity}
+		if err := c.opts.HolderIdentityTemplate.Execute(&buf, data); err != nil {
+			return "", false, fmt.Errorf("render holder identity: %w", err)
+		}
+		return buf.String(), true, nil
+	default:
+		return nodeName, true, nil
+	}
+}
+
+// originalHolderAnnotation records, on a node lease, the holderIdentity the
+// controller replaced when it took the lease over, so it can be handed back.
+const originalHolderAnnotation = "node-life-support.io/original-holder"
+
+// defaultLeaseDurationSeconds matches the kubelet's default node lease
+// duration and is used when a lease has none recorded.
+const defaultLeaseDurationSeconds int32 = 40
+
+// fieldManager is the Server-Side Apply field manager for everything the
+// controller writes.
+const fieldManager = "node-life-support"
+
+// UpdateLease renews the node's lease with Server-Side Apply and returns the
+// lease duration it was renewed for. When the controller takes the lease over
+// from another holder, acquireTime, leaseTransitions and leaseDurationSeconds
+// are set as a real holder would, so the lease remains meaningful to other
+// consumers.
+func (c *NodeLifeSupportController) UpdateLease(ctx context.Context, node *metav1.PartialObjectMetadata) (time.Duration, error) {
+	holder, setHolder, err := c.holderIdentity(node.Name)
+	if err != nil {
+		return 0, err
+	}
+
+	client, err := c.nodeClient(node.Name)
+	if err != nil {
+		return 0, err
+	}
+	leases := client.CoordinationV1().Leases(nodeLeaseNamespace)
+	var duration int32
+	err = c.retryWrite(ctx, func(ctx context.Context) error {
+		lease, err := leases.Get(ctx, node.Name, metav1.GetOptions{})
+		now := c.now()
+		c.recordRenew(node.Name, now)
+		if apierrors.IsNotFound(err) {
+			holder := holder
+			if !setHolder {
+				holder = node.Name
+			}
+			lease = newNodeLease(node, holder, now, c.opts.LeaseDurationSeconds)
+			c.debugPayload(node.Name, "creating lease", lease)
+			if c.debugging(node.Name) {
+				c.debugDiff(node.Name, leaseDiff(nil, lease))
+			}
+			if _, err := leases.Create(ctx, lease, metav1.CreateOptions{FieldManager: fieldManager}); err != nil {
+				return err
+			}
+			duration = *lease.Spec.LeaseDurationSeconds
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		apply := leaseRenewal(lease, holder, setHolder, now, c.opts.LeaseDurationSeconds)
+		c.debugPayload(node.Name, "applying lease", apply)
+		if c.debugging(node.Name) {
+			c.debugDiff(node.Name, leaseDiff(lease, appliedLease(lease, apply)))
+		}
+		if _, err := leases.Apply(ctx, apply, metav1.ApplyOptions{FieldManager: fieldManager, Force: true}); err != nil {
+			return err
+		}
+		duration = *apply.Spec.LeaseDurationSeconds
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(duration) * time.Second, nil
+}
+
+// renewFraction is the fraction of a lease's duration after which it is
+// renewed; the kubelet renews its node lease at a quarter of its duration.
+const renewFraction = 4
+
+// renewInterval returns how long to wait before renewing a lease of the given
+// duration again, for a node handled by policy p (nil for none). The
+// policy's renewInterval, then RENEW_INTERVAL, apply if set.
+func (c *NodeLifeSupportController) renewInterval(p *Policy, leaseDuration time.Duration) time.Duration {
+	if p != nil && p.RenewInterval != nil {
+		return p.RenewInterval.Duration
+	}
+	if c.opts.RenewInterval > 0 {
+		return c.opts.RenewInterval
+	}
+	return leaseDuration / renewFraction
+}
+
+// ReleaseLease hands a node's lease back to the holder recorded when the
+// controller took it over. If the original holder (normally the kubelet) has
+// already reclaimed it, only the annotation is dropped.
+func (c *NodeLifeSupportController) ReleaseLease(ctx context.Context, nodeName string) error {
+	client, err := c.nodeClient(nodeName)
+	if err != nil {
+		return err
+	}
+	leases := client.CoordinationV1().Leases(nodeLeaseNamespace)
+	var released string
+	err = c.retryWrite(ctx, func(ctx context.Context) error {
+		released = ""
+		lease, err := leases.Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		original, ok := lease.Annotations[originalHolderAnnotation]
+		if !ok {
+			return nil
+		}
+		released = original
+
+		// Everything else is re-applied unchanged: with Server-Side Apply,
+		// omitting a field we own would delete it. The annotation is omitted
+		// and so removed. Re-applying the renewTime we read must not undo a
+		// renewal by the kubelet since, hence the resourceVersion.
+		apply := leaseApplyFromSpec(lease).WithResourceVersion(lease.ResourceVersion)
+		if holder, setHolder, err := c.holderIdentity(nodeName); err == nil && setHolder &&
+			lease.Spec.HolderIdentity != nil && *lease.Spec.HolderIdentity == holder {
+			apply.Spec.WithHolderIdentity(original)
+		}
+		_, err = leases.Apply(ctx, apply, metav1.ApplyOptions{FieldManager: fieldManager, Force: true})
+		return err
+	})
+	if err == nil && released != "" {
+		c.audit(nodeName, auditLeaseReleased, "originalHolder="+released)
+	}
+	return err
+}
+
+// newNodeLease builds the lease for a node whose kubelet never created one
+// (e.g. pre-registered bare-metal nodes), owned by the Node like the kubelet's
+// own lease so it is garbage collected with it.
+func newNodeLease(node *metav1.PartialObjectMetadata, holder string, now time.Time, duration int32) *coordinationv1.Lease {
+	if duration == 0 {
+		duration = defaultLeaseDurationSeconds
+	}
+	ts := metav1.NewMicroTime(now)
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      node.Name,
+			Namespace: nodeLeaseNamespace,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: v1.SchemeGroupVersion.String(),
+				Kind:       "Node",
+				Name:       node.Name,
+				UID:        node.UID,
+			}},
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &duration,
+			AcquireTime:          &ts,
+			RenewTime:            &ts,
+		},
+	}
+}
+
+// leaseApplyFromSpec returns an apply configuration carrying the lease's
+// current spec fields, so that applying it changes nothing by itself.
+func leaseApplyFromSpec(lease *coordinationv1.Lease) *coordinationv1apply.LeaseApplyConfiguration {
+	s := lease.Spec
+	spec := coordinationv1apply.LeaseSpec()
+	if s.HolderIdentity != nil {
+		spec.WithHolderIdentity(*s.HolderIdentity)
+	}
+	if s.LeaseDurationSeconds != nil {
+		spec.WithLeaseDurationSeconds(*s.LeaseDurationSeconds)
+	}
+	if s.AcquireTime != nil {
+		spec.WithAcquireTime(*s.AcquireTime)
+	}
+	if s.RenewTime != nil {
+		spec.WithRenewTime(*s.RenewTime)
+	}
+	if s.LeaseTransitions != nil {
+		spec.WithLeaseTransitions(*s.LeaseTransitions)
+	}
+	return coordinationv1apply.Lease(lease.Name, lease.Namespace).WithSpec(spec)
+}
+
+// leaseRenewal builds the apply configuration renewing a lease. duration
+// overrides leaseDurationSeconds when non-zero; otherwise the lease's own
+// value is kept, falling back to the kubelet default if it has none. Fields
+// the controller owns are always included, since omitting them from an apply
+// would remove them.
+func leaseRenewal(lease *coordinationv1.Lease, holder string, setHolder bool, now time.Time, duration int32) *coordinationv1apply.LeaseApplyConfiguration {
+	apply := leaseApplyFromSpec(lease)
+	spec := apply.Spec
+	spec.WithRenewTime(metav1.NewMicroTime(now))
+
+	if duration == 0 {
+		if lease.Spec.LeaseDurationSeconds != nil && *lease.Spec.LeaseDurationSeconds > 0 {
+			duration = *lease.Spec.LeaseDurationSeconds
+		} else {
+			duration = defaultLeaseDurationSeconds
+		}
+	}
+	spec.WithLeaseDurationSeconds(duration)
+
+	original, hasOriginal := lease.Annotations[originalHolderAnnotation]
+	prev := lease.Spec.HolderIdentity
+	if setHolder && (prev == nil || *prev != holder) {
+		spec.WithHolderIdentity(holder)
+		spec.WithAcquireTime(metav1.NewMicroTime(now))
+		var transitions int32
+		if lease.Spec.LeaseTransitions != nil {
+			transitions = *lease.Spec.LeaseTransitions
+		}
+		// Only count a transition away from an actual previous holder, and
+		// remember who that was unless an earlier takeover already did.
+		if prev != nil && *prev != "" {
+			if transitions < math.MaxInt32 {
+				transitions++
+			}
+			if !hasOriginal {
+				original, hasOriginal = *prev, true
+			}
+		}
+		spec.WithLeaseTransitions(transitions)
+	}
+	if hasOriginal {
+		apply.WithAnnotations(map[string]string{originalHolderAnnotation: original})
+	}
+	return apply
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// TestHolderIdentity tests the holder identity strategies.
+func TestHolderIdentity(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		tmpl       string
+		expected   string
+		expectSet  bool
+		expectErr  bool
+		parseError bool
+	}{
+		{name: "node", mode: HolderIdentityNode, expected: "node1", expectSet: true},
+		{name: "controller", mode: HolderIdentityController, expected: "nls-0", expectSet: true},
+		{name: "preserve", mode: HolderIdentityPreserve, expectSet: false},
+		{name: "template", mode: HolderIdentityTemplate, tmpl: "node-life-support/{{.Node}}", expected: "node-life-support/node1", expectSet: true},
+		{name: "template with controller", mode: HolderIdentityTemplate, tmpl: "{{.Controller}}:{{.Node}}", expected: "nls-0:node1", expectSet: true},
+		{name: "template missing", mode: HolderIdentityTemplate, parseError: true},
+		{name: "template unknown field", mode: HolderIdentityTemplate, tmpl: "{{.Nope}}", expectErr: true},
+		{name: "unknown mode", mode: "kubelet", parseError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := parseHolderIdentity(tt.mode, tt.tmpl)
+			if tt.parseError {
+				if err == nil {
+					t.Fatalf("parseHolderIdentity() expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseHolderIdentity() unexpected error: %v", err)
+			}
+
+			c := &NodeLifeSupportController{opts: Options{Identity: "nls-0", HolderIdentity: tt.mode, HolderIdentityTemplate: tmpl}}
+			holder, set, err := c.holderIdentity("node1")
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("holderIdentity() error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if tt.expectErr {
+				return
+			}
+			if set != tt.expectSet || holder != tt.expected {
+				t.Errorf("holderIdentity() = %q, %v, want %q, %v", holder, set, tt.expected, tt.expectSet)
+			}
+		})
+	}
+}
+
+// TestLeaseRenewal tests renewal and takeover fields of the lease apply configuration.
+func TestLeaseRenewal(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC)
+	earlier := metav1.NewMicroTime(now.Add(-time.Hour))
+	ts := metav1.NewMicroTime(now)
+	ptr := func(i int32) *int32 { return &i }
+	str := func(s string) *string { return &s }
+
+	tests := []struct {
+		name        string
+		spec        coordinationv1.LeaseSpec
+		annotations map[string]string
+		holder      string
+		setHolder   bool
+		duration    int32
+		expected    coordinationv1.LeaseSpec
+		original    string
+	}{
+		{
+			name:      "renew own lease keeps duration and acquire time",
+			spec:      coordinationv1.LeaseSpec{HolderIdentity: str("node1"), LeaseDurationSeconds: ptr(40), AcquireTime: &earlier},
+			holder:    "node1",
+			setHolder: true,
+			expected:  coordinationv1.LeaseSpec{HolderIdentity: str("node1"), LeaseDurationSeconds: ptr(40), AcquireTime: &earlier, RenewTime: &ts},
+		},
+		{
+			name:      "takeover from kubelet",
+			spec:      coordinationv1.LeaseSpec{HolderIdentity: str("node1"), LeaseDurationSeconds: ptr(40), AcquireTime: &earlier, LeaseTransitions: ptr(2)},
+			holder:    "nls-0",
+			setHolder: true,
+			expected:  coordinationv1.LeaseSpec{HolderIdentity: str("nls-0"), LeaseDurationSeconds: ptr(40), AcquireTime: &ts, RenewTime: &ts, LeaseTransitions: ptr(3)},
+			original:  "node1",
+		},
+		{
+			name:        "repeated takeover keeps first original holder",
+			spec:        coordinationv1.LeaseSpec{HolderIdentity: str("node1"), LeaseDurationSeconds: ptr(40)},
+			annotations: map[string]string{originalHolderAnnotation: "kubelet-a"},
+			holder:      "nls-0",
+			setHolder:   true,
+			expected:    coordinationv1.LeaseSpec{HolderIdentity: str("nls-0"), LeaseDurationSeconds: ptr(40), AcquireTime: &ts, RenewTime: &ts, LeaseTransitions: ptr(1)},
+			original:    "kubelet-a",
+		},
+		{
+			name:      "first holder is not a transition",
+			spec:      coordinationv1.LeaseSpec{},
+			holder:    "node1",
+			setHolder: true,
+			expected:  coordinationv1.LeaseSpec{HolderIdentity: str("node1"), LeaseDurationSeconds: ptr(40), AcquireTime: &ts, RenewTime: &ts, LeaseTransitions: ptr(0)},
+		},
+		{
+			name:     "preserve never takes over",
+			spec:     coordinationv1.LeaseSpec{HolderIdentity: str("node1"), LeaseDurationSeconds: ptr(40)},
+			expected: coordinationv1.LeaseSpec{HolderIdentity: str("node1"), LeaseDurationSeconds: ptr(40), RenewTime: &ts},
+		},
+		{
+			name:      "configured duration overrides",
+			spec:      coordinationv1.LeaseSpec{HolderIdentity: str("node1"), LeaseDurationSeconds: ptr(40)},
+			holder:    "node1",
+			setHolder: true,
+			duration:  60,
+			expected:  coordinationv1.LeaseSpec{HolderIdentity: str("node1"), LeaseDurationSeconds: ptr(60), RenewTime: &ts},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lease := &coordinationv1.Lease{
+				ObjectMeta: metav1.ObjectMeta{Name: "node1", Namespace: nodeLeaseNamespace, Annotations: tt.annotations},
+				Spec:       tt.spec,
+			}
+			apply := leaseRenewal(lease, tt.holder, tt.setHolder, now, tt.duration)
+
+			got := coordinationv1.LeaseSpec{
+				HolderIdentity:       apply.Spec.HolderIdentity,
+				LeaseDurationSeconds: apply.Spec.LeaseDurationSeconds,
+				AcquireTime:          apply.Spec.AcquireTime,
+				RenewTime:            apply.Spec.RenewTime,
+				LeaseTransitions:     apply.Spec.LeaseTransitions,
+			}
+			if !apiequality.Semantic.DeepEqual(got, tt.expected) {
+				t.Errorf("leaseRenewal() spec = %+v, want %+v", got, tt.expected)
+			}
+			if original := apply.Annotations[originalHolderAnnotation]; original != tt.original {
+				t.Errorf("original holder annotation = %q, want %q", original, tt.original)
+			}
+		})
+	}
+}
+
+// TestUpdateLeaseCreatesMissingLease tests that a node without a lease gets
+// one, owned by the node.
+func TestUpdateLeaseCreatesMissingLease(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	c := &NodeLifeSupportController{client: client, opts: Options{HolderIdentity: HolderIdentityNode}}
+	node := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1"}}
+
+	d, err := c.UpdateLease(context.Background(), node)
+	if err != nil {
+		t.Fatalf("UpdateLease() error: %v", err)
+	}
+	if d != 40*time.Second {
+		t.Errorf("UpdateLease() duration = %v, want 40s", d)
+	}
+
+	lease, err := client.CoordinationV1().Leases(nodeLeaseNamespace).Get(context.Background(), "node1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("lease not created: %v", err)
+	}
+	if *lease.Spec.HolderIdentity != "node1" || *lease.Spec.LeaseDurationSeconds != defaultLeaseDurationSeconds {
+		t.Errorf("lease spec = %+v", lease.Spec)
+	}
+	if lease.Spec.RenewTime == nil || lease.Spec.AcquireTime == nil {
+		t.Errorf("lease times not set: %+v", lease.Spec)
+	}
+	if len(lease.OwnerReferences) != 1 || lease.OwnerReferences[0].Kind != "Node" || lease.OwnerReferences[0].UID != "uid-1" {
+		t.Errorf("lease owner references = %+v", lease.OwnerReferences)
+	}
+}
+
+// TestLeaseTakeoverAndRelease tests that the original holder is recorded on
+// takeover and restored on release.
+func TestLeaseTakeoverAndRelease(t *testing.T) {
+	ctx := context.Background()
+	holder := "node1"
+	duration := int32(40)
+	client := fake.NewSimpleClientset(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1", Namespace: nodeLeaseNamespace},
+		Spec:       coordinationv1.LeaseSpec{HolderIdentity: &holder, LeaseDurationSeconds: &duration},
+	})
+	c := &NodeLifeSupportController{client: client, opts: Options{Identity: "nls-0", HolderIdentity: HolderIdentityController}}
+	node := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	leases := client.CoordinationV1().Leases(nodeLeaseNamespace)
+	var lastApply []byte
+	client.PrependReactor("patch", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if p, ok := action.(k8stesting.PatchAction); ok && p.GetPatchType() == types.ApplyPatchType {
+			lastApply = p.GetPatch()
+		}
+		return false, nil, nil
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.UpdateLease(ctx, node); err != nil {
+			t.Fatalf("UpdateLease() error: %v", err)
+		}
+	}
+	lease, _ := leases.Get(ctx, "node1", metav1.GetOptions{})
+	if *lease.Spec.HolderIdentity != "nls-0" {
+		t.Errorf("holder after takeover = %q, want nls-0", *lease.Spec.HolderIdentity)
+	}
+	if got := lease.Annotations[originalHolderAnnotation]; got != "node1" {
+		t.Errorf("original holder annotation = %q, want node1", got)
+	}
+
+	if err := c.ReleaseLease(ctx, "node1"); err != nil {
+		t.Fatalf("ReleaseLease() error: %v", err)
+	}
+	lease, _ = leases.Get(ctx, "node1", metav1.GetOptions{})
+	if *lease.Spec.HolderIdentity != "node1" {
+		t.Errorf("holder after release = %q, want node1", *lease.Spec.HolderIdentity)
+	}
+	// The fake client cannot model Server-Side Apply field removal, so check
+	// the applied configuration no longer carries the annotation.
+	if !strings.Contains(string(lastApply), `"holderIdentity":"node1"`) || strings.Contains(string(lastApply), originalHolderAnnotation) {
+		t.Errorf("release apply = %s, want holder node1 and no original holder annotation", lastApply)
+	}
+}
+
+// TestRenewInterval tests the per-node renewal cadence.
+func TestRenewInterval(t *testing.T) {
+	c := &NodeLifeSupportController{}
+	if got := c.renewInterval(nil, 40*time.Second); got != 10*time.Second {
+		t.Errorf("renewInterval(40s) = %v, want 10s", got)
+	}
+	if got := c.renewInterval(nil, 120*time.Second); got != 30*time.Second {
+		t.Errorf("renewInterval(120s) = %v, want 30s", got)
+	}
+	c.opts.RenewInterval = 30 * time.Second
+	if got := c.renewInterval(nil, 40*time.Second); got != 30*time.Second {
+		t.Errorf("renewInterval() with RENEW_INTERVAL = %v, want 30s", got)
+	}
+	p := &Policy{RenewInterval: &metav1.Duration{Duration: 5 * time.Second}}
+	if got := c.renewInterval(p, 40*time.Second); got != 5*time.Second {
+		t.Errorf("renewInterval() with a policy renewInterval = %v, want 5s", got)
+	}
+}
+
+// TestLeaseRenewalTimeEncoding tests that lease times go out as MicroTime
+// (RFC 3339 with exactly six fractional digits), which the API server
+// requires when decoding leases.
+func TestLeaseRenewalTimeEncoding(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.FixedZone("BST", 3600))
+	lease := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: "node1", Namespace: nodeLeaseNamespace}}
+
+	raw, err := json.Marshal(leaseRenewal(lease, "node1", true, now, 0))
+	if err != nil {
+		t.Fatalf("marshal apply configuration: %v", err)
+	}
+	for _, field := range []string{"renewTime", "acquireTime"} {
+		want := fmt.Sprintf(`%q:"2024-05-01T11:00:00.123456Z"`, field)
+		if !strings.Contains(string(raw), want) {
+			t.Errorf("apply configuration %s does not contain %s", raw, want)
+		}
+	}
+}
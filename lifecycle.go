@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+// Event reasons recorded on the controller's own Pod, so cluster-level Event
+// streams show what the controller itself is going through.
+const (
+	reasonControllerStarted      = "ControllerStarted"
+	reasonCredentialsReloaded    = "CredentialsReloaded"
+	reasonDegradedModeEntered    = "DegradedModeEntered"
+	reasonDegradedModeExited     = "DegradedModeExited"
+	reasonEngagementLimitReached = "EngagementLimitReached"
+	reasonEngagementLimitCleared = "EngagementLimitCleared"
+	reasonSyncLoopStuck          = "SyncLoopStuck"
+	reasonBecameLeader           = "BecameLeader"
+	reasonLostLeadership         = "LostLeadership"
+)
+
+// lifecycleRecorder records Events on the controller's Pod.
+type lifecycleRecorder struct {
+	recorder record.EventRecorder
+	pod      *v1.ObjectReference
+}
+
+// lifecycle is set in single-cluster mode when the controller knows its Pod
+// from the downward API. In multi-cluster mode the Pod is not necessarily in
+// any supervised cluster, so there are no lifecycle Events.
+var lifecycle atomic.Pointer[lifecycleRecorder]
+
+// newLifecycleRecorder returns a recorder for Events on the Pod the options
+// locate, or nil if they do not.
+func newLifecycleRecorder(recorder record.EventRecorder, opts *Options) *lifecycleRecorder {
+	if recorder == nil || opts.PodUID == "" || opts.Namespace == "" || opts.Identity == "" {
+		return nil
+	}
+	return &lifecycleRecorder{
+		recorder: recorder,
+		pod:      &v1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: opts.Namespace, Name: opts.Identity, UID: types.UID(opts.PodUID)},
+	}
+}
+
+// lifecycleEvent records an Event on the controller's Pod, if it has one.
+// Unlike node Events they are not throttled: each marks a transition.
+func lifecycleEvent(eventType, reason, messageFmt string, args ...interface{}) {
+	l := lifecycle.Load()
+	if l == nil {
+		return
+	}
+	l.recorder.Event(l.pod, eventType, reason, fmt.Sprintf(messageFmt, args...))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/record"
+)
+
+// TestLifecycleEvents tests that the controller records its own transitions
+// as Events on its Pod, once each, and none without a Pod to record them on.
+func TestLifecycleEvents(t *testing.T) {
+	if l := newLifecycleRecorder(record.NewFakeRecorder(1), &Options{Namespace: "nls", Identity: "nls-0"}); l != nil {
+		t.Errorf("lifecycle recorder without POD_UID: %+v", l)
+	}
+	recorder := record.NewFakeRecorder(10)
+	lifecycle.Store(newLifecycleRecorder(recorder, &Options{Namespace: "nls", Identity: "nls-0", PodUID: "uid-0"}))
+	defer lifecycle.Store(nil)
+
+	var readyErr error
+	c := &NodeLifeSupportController{opts: Options{EngagementLimit: 1, EngagementLimitWindow: 10 * time.Minute}}
+	c.readyz = func(context.Context) error { return readyErr }
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	readyErr = errors.New("etcd failed")
+	c.preflight(context.Background())
+	c.preflight(context.Background())
+	readyErr = nil
+	c.preflight(context.Background())
+	c.admitEngagements(candidates("a", "b"), t0)
+	c.admitEngagements(candidates("b", "c"), t0.Add(time.Minute))
+	c.admitEngagements(candidates("b"), t0.Add(11*time.Minute))
+
+	want := []string{
+		"Warning DegradedModeEntered API server not ready, renewing leases only until it recovers: etcd failed",
+		"Normal DegradedModeExited API server ready again, resuming node status updates",
+		"Warning EngagementLimitReached Engagement limit of 1 per 10m0s reached, queueing new engagements",
+		"Normal EngagementLimitCleared Engagement queue drained, engaging nodes as they fail again",
+	}
+	var got []string
+	for len(recorder.Events) > 0 {
+		got = append(got, <-recorder.Events)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Events:\n%q\nwant:\n%q", got, want)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// engagement is a node waiting to be newly put on life support, and the
+// policy it falls under, if any.
+type engagement struct {
+	node   *metav1.PartialObjectMetadata
+	policy *Policy
+}
+
+// admitEngagements decides which of this cycle's new engagements go ahead.
+// ENGAGEMENT_LIMIT caps new engagements cluster-wide per
+// ENGAGEMENT_LIMIT_WINDOW as a brake against correlated failures being
+// silently absorbed; nodes over the limit are queued and admitted in the order
+// they first queued as capacity frees up. Policy rollouts apply on top.
+func (c *NodeLifeSupportController) admitEngagements(candidates []engagement, now time.Time) []engagement {
+	c.mu.Lock()
+	if c.queued == nil {
+		c.queued = make(map[string]time.Time)
+	}
+	wanted := make(map[string]struct{}, len(candidates))
+	for _, e := range candidates {
+		wanted[e.node.Name] = struct{}{}
+	}
+	for name := range c.queued {
+		if _, ok := wanted[name]; !ok {
+			delete(c.queued, name)
+		}
+	}
+	queuedAt := func(name string) time.Time {
+		if t, ok := c.queued[name]; ok {
+			return t
+		}
+		return now
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return queuedAt(candidates[i].node.Name).Before(queuedAt(candidates[j].node.Name))
+	})
+	limit, window := c.opts.EngagementLimit, c.opts.EngagementLimitWindow
+	recent := c.recentEngagements[:0]
+	for _, t := range c.recentEngagements {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	c.recentEngagements = recent
+	c.mu.Unlock()
+
+	var admitted []engagement
+	for _, e := range candidates {
+		name := e.node.Name
+		c.mu.Lock()
+		full := limit > 0 && len(c.recentEngagements) >= limit
+		if full && !c.limitReached {
+			c.limitReached = true
+			lifecycleEvent(v1.EventTypeWarning, reasonEngagementLimitReached, "Engagement limit of %d per %s reached, queueing new engagements", limit, window)
+		}
+		if full {
+			if _, ok := c.queued[name]; !ok {
+				c.queued[name] = now
+				c.logf("node %s: engagement limit of %d per %s reached, queued", name, limit, window)
+			}
+		}
+		c.mu.Unlock()
+		if full || !c.admit(name, e.policy, now) {
+			continue
+		}
+
+		c.mu.Lock()
+		delete(c.queued, name)
+		if limit > 0 {
+			c.recentEngagements = append(c.recentEngagements, now)
+		}
+		c.mu.Unlock()
+		admitted = append(admitted, e)
+	}
+
+	c.mu.Lock()
+	engagementsQueued.WithLabelValues(c.opts.Cluster).Set(float64(len(c.queued)))
+	if c.limitReached && len(c.queued) == 0 {
+		c.limitReached = false
+		lifecycleEvent(v1.EventTypeNormal, reasonEngagementLimitCleared, "Engagement queue drained, engaging nodes as they fail again")
+	}
+	c.mu.Unlock()
+	return admitted
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func candidates(names ...string) []engagement {
+	out := make([]engagement, 0, len(names))
+	for _, name := range names {
+		out = append(out, engagement{node: &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: name}}})
+	}
+	return out
+}
+
+func admittedNames(es []engagement) []string {
+	out := make([]string, 0, len(es))
+	for _, e := range es {
+		out = append(out, e.node.Name)
+	}
+	return out
+}
+
+// TestAdmitEngagements tests the cluster-wide engagement limit and that
+// queued nodes are admitted in the order they queued.
+func TestAdmitEngagements(t *testing.T) {
+	c := &NodeLifeSupportController{opts: Options{EngagementLimit: 1, EngagementLimitWindow: 10 * time.Minute}}
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		at         time.Time
+		candidates []string
+		admitted   []string
+		queued     float64
+	}{
+		{at: t0, candidates: []string{"m", "z"}, admitted: []string{"m"}, queued: 1},
+		{at: t0.Add(time.Minute), candidates: []string{"a", "z"}, admitted: []string{}, queued: 2},
+		// z queued first, so it goes before a once the window has passed.
+		{at: t0.Add(10 * time.Minute), candidates: []string{"a", "z"}, admitted: []string{"z"}, queued: 1},
+		// A node no longer wanted leaves the queue.
+		{at: t0.Add(11 * time.Minute), candidates: []string{}, admitted: []string{}, queued: 0},
+	}
+	for i, s := range steps {
+		got := admittedNames(c.admitEngagements(candidates(s.candidates...), s.at))
+		if len(got) != len(s.admitted) || (len(got) > 0 && got[0] != s.admitted[0]) {
+			t.Errorf("step %d: admitted %v, want %v", i, got, s.admitted)
+		}
+		if q := testutil.ToFloat64(engagementsQueued.WithLabelValues("")); q != s.queued {
+			t.Errorf("step %d: queued metric = %v, want %v", i, q, s.queued)
+		}
+	}
+}
+
+// TestAdmitEngagementsUnlimited tests that without a limit every candidate
+// is admitted.
+func TestAdmitEngagementsUnlimited(t *testing.T) {
+	c := &NodeLifeSupportController{}
+	got := c.admitEngagements(candidates("a", "b", "c"), time.Now())
+	if len(got) != 3 {
+		t.Errorf("admitted %v, want all candidates", admittedNames(got))
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// logSampler thins out log lines repeated for the same node every cycle,
+// such as "updated node X": with LOG_SAMPLE_WINDOW set, each is logged the
+// first time and then once per window, saying how many were left out.
+type logSampler struct {
+	mu    sync.Mutex
+	lines map[sampleKey]*sampledLine
+}
+
+// sampleKey identifies a repeated line: what kind it is, and for which node.
+type sampleKey struct {
+	kind, node string
+}
+
+// sampledLine is a line last logged at logged, repeated suppressed times
+// since, most recently at last.
+type sampledLine struct {
+	logged, last time.Time
+	suppressed   int
+}
+
+// sampledLogf logs a steady-state line about a node, unless one of the same
+// kind was logged for it within LOG_SAMPLE_WINDOW, in which case it is only
+// counted. Transitions are logged with logf, in full, instead, as is every
+// line about a node annotated for debug logging.
+func (c *NodeLifeSupportController) sampledLogf(kind, node, format string, args ...interface{}) {
+	window := c.opts.LogSampleWindow
+	if window <= 0 || c.debugging(node) {
+		c.logf(format, args...)
+		return
+	}
+	now := c.now()
+	key := sampleKey{kind: kind, node: node}
+
+	s := &c.logSamples
+	s.mu.Lock()
+	if s.lines == nil {
+		s.lines = make(map[sampleKey]*sampledLine)
+	}
+	l, ok := s.lines[key]
+	if ok && now.Sub(l.logged) < window {
+		l.suppressed++
+		l.last = now
+		s.mu.Unlock()
+		return
+	}
+	var summary string
+	if ok && l.suppressed > 0 {
+		summary = fmt.Sprintf(" (%d more since %s)", l.suppressed, l.logged.UTC().Format(time.RFC3339))
+	}
+	s.lines[key] = &sampledLine{logged: now, last: now}
+	s.mu.Unlock()
+	c.logf(format+"%s", append(args, summary)...)
+}
+
+// flushLogSamples logs what was left out of a node's sampled lines since
+// they were last logged, with the last occurrence, and forgets them: the node
+// is leaving the steady state, e.g. because it is being disengaged.
+func (c *NodeLifeSupportController) flushLogSamples(node string) {
+	s := &c.logSamples
+	s.mu.Lock()
+	var flushed []string
+	for key, l := range s.lines {
+		if key.node != node {
+			continue
+		}
+		if l.suppressed > 0 {
+			flushed = append(flushed, fmt.Sprintf("node %s: %s %d more times since %s, last at %s",
+				node, key.kind, l.suppressed, l.logged.UTC().Format(time.RFC3339), l.last.UTC().Format(time.RFC3339)))
+		}
+		delete(s.lines, key)
+	}
+	s.mu.Unlock()
+	for _, msg := range flushed {
+		c.logf("%s", msg)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// TestSampledLogf tests that a line repeated for a node is logged once per
+// LOG_SAMPLE_WINDOW with a count of those left out, and that the rest are
+// summarised when the node is flushed.
+func TestSampledLogf(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock := clocktesting.NewFakeClock(start)
+	c := &NodeLifeSupportController{clock: clock, opts: Options{LogSampleWindow: time.Minute}}
+	for i := 0; i < 7; i++ {
+		c.sampledLogf("updated", "node1", "updated node %s", "node1")
+		c.sampledLogf("updated", "node2", "updated node %s", "node2")
+		clock.Step(15 * time.Second)
+	}
+	c.flushLogSamples("node1")
+	c.flushLogSamples("node1")
+
+	want := []string{
+		"updated node node1",
+		"updated node node2",
+		"updated node node1 (3 more since 2024-05-01T12:00:00Z)",
+		"updated node node2 (3 more since 2024-05-01T12:00:00Z)",
+		"node node1: updated 2 more times since 2024-05-01T12:01:00Z, last at 2024-05-01T12:01:30Z",
+	}
+	if got := strings.Split(strings.TrimSpace(buf.String()), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("logged:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	buf.Reset()
+	c.opts.LogSampleWindow = 0
+	c.sampledLogf("updated", "node1", "updated node %s", "node1")
+	c.sampledLogf("updated", "node1", "updated node %s", "node1")
+	if got := strings.Count(buf.String(), "updated node node1\n"); got != 2 {
+		t.Errorf("logged %d lines without sampling, want 2", got)
+	}
+}
@@ -3,183 +3,883 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"os"
-	"strings"
+	"os/signal"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	coordinationlisters "k8s.io/client-go/listers/coordination/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/clock"
 )
 
+// version is set at build time via -ldflags "-X main.version=...".
+var version = "dev"
+
+// userAgent identifies the controller's API traffic, distinct from the
+// kubelets it stands in for, so API Priority and Fairness and audit policies
+// can match on it.
+func userAgent() string {
+	return fmt.Sprintf("node-life-support/%s (%s/%s)", version, runtime.GOOS, runtime.GOARCH)
+}
+
 func main() {
-	ctx := context.Background()
+	kubeconfig := flag.String("kubeconfig", "", "path to a kubeconfig file (default: in-cluster, $KUBECONFIG or ~/.kube/config)")
+	kubeContext := flag.String("context", "", "kubeconfig context to use (default: the current context)")
+	flag.Parse()
+	switch flag.Arg(0) {
+	case "report":
+		if err := runReport(flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatalf("report: %v", err)
+		}
+		return
+	case "history":
+		if err := runHistory(flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatalf("history: %v", err)
+		}
+		return
+	case "rbac-gen":
+		if err := runRBACGen(flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatalf("rbac-gen: %v", err)
+		}
+		return
+	case "simulate":
+		if err := runSimulate(flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatalf("simulate: %v", err)
+		}
+		return
+	case "bench":
+		if err := runBench(flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatalf("bench: %v", err)
+		}
+		return
+	}
 
-	cfg, err := BuildConfig()
+	// SIGTERM or SIGINT cancels everything, in-flight API calls included; a
+	// second signal kills the process outright.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+		log.Printf("shutting down")
+	}()
+
+	opts, err := LoadOptions()
 	if err != nil {
-		log.Fatalf("failed to build kubeconfig: %v", err)
+		log.Fatalf("invalid configuration: %v", err)
 	}
 
-	// Read allowed node label keys from environment (comma-separated).
-	// If empty, controller applies to all nodes.
-	allowlist := os.Getenv("NODE_LABEL_ALLOWLIST")
-	var allowedKeys []string
-	if allowlist != "" {
-		for _, k := range strings.Split(allowlist, ",") {
-			if t := strings.TrimSpace(k); t != "" {
-				allowedKeys = append(allowedKeys, t)
+	if opts.MetricsAddr != "" {
+		go serveHTTP(ctx, opts.MetricsAddr, opts.ReportRetention)
+	}
+
+	if len(opts.Clusters) > 0 || opts.ClusterAPIDiscovery {
+		log.Printf("node-life-support controller %s starting for %d clusters…", version, len(opts.Clusters))
+		if opts.AdmissionAddr != "" {
+			log.Printf("admission webhooks: evictions are not checked in multi-cluster mode")
+			go serveAdmission(ctx, opts, nil)
+		}
+		for _, spec := range opts.Clusters {
+			go runCluster(ctx, spec, opts)
+		}
+		if opts.ClusterAPIDiscovery {
+			cfg, err := loadConfig(ctx, *kubeconfig, *kubeContext, opts.CredentialReloadInterval)
+			if err != nil {
+				log.Fatalf("failed to build management cluster kubeconfig: %v", err)
+			}
+			d, err := newCAPIDiscovery(cfg, opts)
+			if err != nil {
+				log.Fatalf("failed to init cluster API discovery: %v", err)
 			}
+			log.Printf("discovering Cluster API workload clusters every %s", opts.ClusterAPIInterval)
+			go d.run(ctx, opts.ClusterAPIInterval)
 		}
+		<-ctx.Done()
+		waitAuditExports()
+		waitExecHooks()
+		return
 	}
 
-	c, err := NewNodeLifeSupportController(cfg, allowedKeys)
+	var cfg *rest.Config
+	if opts.APIReplayFile != "" {
+		replay, err := loadAPIReplay(opts.APIReplayFile)
+		if err != nil {
+			log.Fatalf("failed to load API_REPLAY_FILE: %v", err)
+		}
+		log.Printf("replaying API responses from %s instead of calling the API server", opts.APIReplayFile)
+		cfg = replay.config()
+	} else if cfg, err = loadConfig(ctx, *kubeconfig, *kubeContext, opts.CredentialReloadInterval); err != nil {
+		log.Fatalf("failed to build kubeconfig: %v", err)
+	}
+	c, err := NewNodeLifeSupportController(cfg, opts)
 	if err != nil {
 		log.Fatalf("failed to init controller: %v", err)
 	}
+	fleetRegistry.register("", c, nil)
+	lifecycle.Store(newLifecycleRecorder(c.recorder, opts))
+	if err := c.Start(ctx); err != nil {
+		log.Fatalf("failed to start node watches: %v", err)
+	}
+	if opts.AdmissionAddr != "" {
+		go serveAdmission(ctx, opts, c)
+	}
 
-	log.Printf("node-life-support controller starting…")
+	log.Printf("node-life-support controller %s starting…", version)
+	lifecycleEvent(v1.EventTypeNormal, reasonControllerStarted, "node-life-support %s started", version)
+	if opts.LeaderElection {
+		leadership = &leaderState{identity: opts.Identity, now: c.now}
+		if err := runLeaderElection(ctx, c.client, opts, c.Run); err != nil {
+			log.Fatalf("failed to start leader election: %v", err)
+		}
+	} else {
+		c.Run(ctx)
+	}
+	waitAuditExports()
+	waitExecHooks()
+}
 
-	ticker := time.NewTicker(30 * time.Second)
+// runCluster supervises one cluster in multi-cluster mode. A cluster that
+// cannot be set up is logged and skipped rather than stopping the others.
+func runCluster(ctx context.Context, spec ClusterSpec, base *Options) {
+	opts, err := spec.options(base)
+	if err != nil {
+		log.Printf("cluster %s: invalid configuration: %v", spec.Name, err)
+		fleetRegistry.register(spec.Name, nil, err)
+		return
+	}
+	cfg, err := loadConfig(ctx, spec.Kubeconfig, spec.Context, opts.CredentialReloadInterval)
+	if err != nil {
+		log.Printf("cluster %s: failed to build kubeconfig: %v", spec.Name, err)
+		fleetRegistry.register(spec.Name, nil, err)
+		return
+	}
+	superviseCluster(ctx, spec.Name, cfg, opts)
+}
+
+// superviseCluster runs a controller for the named cluster until ctx is done.
+func superviseCluster(ctx context.Context, name string, cfg *rest.Config, opts *Options) {
+	c, err := NewNodeLifeSupportController(cfg, opts)
+	if err != nil {
+		log.Printf("cluster %s: failed to init controller: %v", name, err)
+		fleetRegistry.register(name, nil, err)
+		return
+	}
+	fleetRegistry.register(name, c, nil)
+	if err := c.Start(ctx); err != nil {
+		log.Printf("cluster %s: failed to start node watches: %v", name, err)
+		fleetRegistry.register(name, c, err)
+		return
+	}
+	c.logf("supervising nodes")
+	c.Run(ctx)
+}
+
+// Run syncs nodes every SyncInterval until ctx is done. A cycle taking longer
+// than that is followed by a full interval rather than straight away by the
+// tick that fired meanwhile, so cycles never run back to back. Orphaned
+// leases are swept after a cycle every LEASE_GC_INTERVAL, so only by the
+// leader, or by each shard for its own nodes.
+func (c *NodeLifeSupportController) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.opts.SyncInterval)
 	defer ticker.Stop()
+	c.lastCycle.Store(c.now().UnixNano())
+	if c.opts.WatchdogIntervals > 0 {
+		watchdogCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		defer c.watchdogFired.Store(false)
+		go c.runWatchdog(watchdogCtx)
+	}
 
+	var nextGC time.Time
 	for {
-		if err := c.SyncAllNodes(ctx); err != nil {
-			log.Printf("sync error: %v", err)
+		start := c.now()
+		trace := newTraceContext()
+		c.cycleTrace.Store(trace)
+		if err := c.SyncAllNodes(withTrace(ctx, trace)); err != nil && ctx.Err() == nil {
+			c.logf("sync error: %v", err)
+		} else if err == nil && c.opts.HeartbeatLeaseDuration > 0 {
+			if err := c.renewHeartbeat(ctx); err != nil {
+				c.logf("renewing heartbeat lease %s: %v", c.heartbeatLease(), err)
+			}
+		}
+		c.cycleTrace.Store(nil)
+		c.lastCycle.Store(c.now().UnixNano())
+		if c.opts.LeaseGCInterval > 0 && !c.now().Before(nextGC) {
+			if err := c.sweepOrphanedLeases(ctx); err != nil && ctx.Err() == nil {
+				c.logf("orphaned lease sweep: %v", err)
+			}
+			nextGC = c.now().Add(c.opts.LeaseGCInterval)
+		}
+		if c.recordCycleDuration(c.now().Sub(start)) {
+			select {
+			case <-ticker.C:
+			default:
+			}
+			ticker.Reset(c.opts.SyncInterval)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
-		<-ticker.C
 	}
 }
 
-func BuildConfig() (*rest.Config, error) {
-	cfg, err := rest.InClusterConfig()
-	if err == nil {
-		return cfg, nil
+// BuildConfig returns the client configuration for the given kubeconfig path
+// and context. Without either, and without KUBECONFIG, the in-cluster
+// configuration is used when running in a pod; otherwise the standard loading
+// rules apply: the explicit path, else KUBECONFIG, else ~/.kube/config.
+func BuildConfig(kubeconfig, kubeContext string) (*rest.Config, error) {
+	if kubeconfig == "" && kubeContext == "" && os.Getenv(clientcmd.RecommendedConfigPathEnvVar) == "" {
+		if cfg, err := rest.InClusterConfig(); err == nil {
+			return cfg, nil
+		}
 	}
-	return clientcmd.BuildConfigFromFlags("", clientcmd.RecommendedHomeFile)
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
 }
 
+// nodesResource identifies Nodes for the metadata client.
+var nodesResource = v1.SchemeGroupVersion.WithResource("nodes")
+
 type NodeLifeSupportController struct {
-	client        *kubernetes.Clientset
+	client kubernetes.Interface
+	// meta is used to watch nodes as PartialObjectMetadata: targeting only
+	// needs names and labels, not full (and often very large) Node statuses.
+	meta          metadata.Interface
 	allowedLabels map[string]struct{}
+	// selectors are the server-side label selectors used to watch nodes; see
+	// allowlistSelectors. There is one informer per selector.
+	selectors []string
+	informers []cache.SharedIndexInformer
+	opts      Options
+	// shard is set when sharding is enabled and decides which nodes this
+	// replica is responsible for.
+	shard    *shardMembership
+	recorder record.EventRecorder
+	notifier *webhookNotifier
+	// throttle deduplicates and rate limits Events and notifications.
+	throttle *notifyThrottle
+	// auditor exports the audit log with AUDIT_EXPORT_URL.
+	auditor *auditExporter
+	// failover, when API_SERVERS is set, routes requests to a healthy
+	// endpoint; healthCfg reaches each endpoint directly for health checks.
+	failover  *endpointFailover
+	healthCfg *rest.Config
+	// restConfig builds the per-node clients used with IMPERSONATE_NODES.
+	restConfig  *rest.Config
+	nodeClients map[string]kubernetes.Interface
+	// clock is the controller's source of time, faked in tests; see now.
+	clock clock.Clock
+
+	mu              sync.Mutex
+	nodes           map[string]*nodeState
+	keepAliveNext   map[string]time.Time
+	windowOverrides map[string]parsedWindows
+	badAnnotations  map[string]string
+	// debugNodes are the nodes annotated for debug logging.
+	debugNodes map[string]struct{}
+	// fullNodes caches whole Node objects for policy expressions, only when
+	// there are any; exprErrors are their failures, logged once.
+	fullNodes  corelisters.NodeLister
+	exprErrors expressionErrors
+	// skippedNodes are the nodes last found unsupportable, with the reason,
+	// and supportableChecks the last check of each node read for it.
+	skippedNodes      map[string]string
+	supportableChecks map[string]supportableCheck
+	// leases caches node leases when kubelet heartbeats need observing;
+	// stale records when a node was first seen with a stale heartbeat.
+	leases coordinationlisters.LeaseNamespaceLister
+	stale  map[string]time.Time
+	// rollouts tracks each policy's staged rollout, by policy name.
+	rollouts map[string]*rolloutState
+	// recentEngagements are the times of new engagements within
+	// ENGAGEMENT_LIMIT_WINDOW; queued holds nodes waiting for the limit, by
+	// when they were first queued.
+	recentEngagements []time.Time
+	queued            map[string]time.Time
+	// limitReached is set while ENGAGEMENT_LIMIT holds engagements back.
+	limitReached bool
+	// readyz, set with PREFLIGHT_CHECK, checks the API server before each
+	// cycle; degraded records that it last failed.
+	readyz   func(ctx context.Context) error
+	degraded bool
+	// lastSync and the counts of node syncs attempted and failed in that
+	// cycle feed /status.
+	lastSync                           time.Time
+	lastSyncAttempts, lastSyncFailures int
+	// lastCycle is when Run last started or finished a cycle, in Unix
+	// nanoseconds, and watchdogFired whether SYNC_WATCHDOG_INTERVALS have
+	// passed since; both are read without c.mu, which a hung cycle may
+	// hold.
+	lastCycle     atomic.Int64
+	watchdogFired atomic.Bool
+	// cycleTrace identifies the running sync cycle, if any, to exec hooks;
+	// webhook notifications get it from their context.
+	cycleTrace atomic.Pointer[traceContext]
+	// activity is kept for /report; writes counts successful API writes,
+	// of which lastWrites had been made by the end of the last cycle.
+	activity   []activityRecord
+	writes     atomic.Int64
+	lastWrites int64
+	// history holds each node's latest transitions for /history.
+	history map[string][]transition
+	// accounts hold each node's time on life support in past engagements;
+	// accountMetrics are the nodes it was last exported for.
+	accounts       map[string]*supportAccount
+	accountMetrics map[string]struct{}
+	// nextDigest is when the next DIGEST_SCHEDULE digest is due; it is only
+	// used by SyncAllNodes.
+	nextDigest time.Time
+	// lastExclusion is the calendar exclusion in effect last cycle.
+	lastExclusion string
+	// exhausted holds nodes taken off life support after
+	// MaxLifeSupportDuration, until they are no longer selected.
+	exhausted map[string]struct{}
+	// drains are the drains of nodes being given up on.
+	drains map[string]*drainState
+	// nodePolicies are the policies last found handling nodes, and
+	// policyOverlaps the policies matching nodes matched by several, as
+	// warned about.
+	nodePolicies   map[string]string
+	policyOverlaps map[string]string
+	// probeResults hold each PROBE_PLUGIN_DIR plugin's last result for
+	// nodes on life support or found unhealthy, by node and plugin;
+	// followedRules are the CONDITION_RULES_FILE rules nodes last followed.
+	probeResults  map[string]map[string]probeOutcome
+	followedRules map[string]string
+	// wasmProbes are the compiled WASM probe plugins, by path.
+	wasmProbes map[string]*wasmProbe
+	// podInventory is the latest inventory of pods on supported nodes.
+	podInventory map[string]*nodePods
+	// metadataSwept is set once stale controller-owned labels, taints and
+	// conditions have been removed.
+	metadataSwept bool
+	// lackingPermissions are those RBAC_CHECK last found missing.
+	lackingPermissions []string
+	// restored holds when nodes were engaged according to STATE_CONFIGMAP,
+	// until the first cycle resumes them; persisted is the state last
+	// written there.
+	restored  map[string]time.Time
+	persisted map[string]string
+	// connectivity tracks whether the API server is reachable; outages
+	// are the periods it was not for API_OUTAGE_ALERT_AFTER, kept for
+	// /report.
+	connectivity *connectivity
+	outages      []apiOutage
+	// logSamples thins out repeated per-node lines, see LOG_SAMPLE_WINDOW.
+	logSamples logSampler
 }
 
-func NewNodeLifeSupportController(cfg *rest.Config, allowedKeys []string) (*NodeLifeSupportController, error) {
+func NewNodeLifeSupportController(cfg *rest.Config, opts *Options) (*NodeLifeSupportController, error) {
+	cfg = rest.CopyConfig(cfg)
+	cfg.UserAgent = userAgent()
+	if opts.ClientQPS > 0 {
+		cfg.QPS = opts.ClientQPS
+	}
+	if opts.ClientBurst > 0 {
+		cfg.Burst = opts.ClientBurst
+	}
+	applyTransportOptions(cfg, opts)
+	if opts.chaosEnabled() {
+		// Innermost, so that injected faults look like the API server's.
+		cfg.Wrap(newChaos(opts).wrap)
+	}
+	if opts.APIRecordFile != "" {
+		// Outside chaos, so that injected faults are replayed too.
+		recorder, err := newAPIRecorder(opts.APIRecordFile)
+		if err != nil {
+			return nil, fmt.Errorf("API_RECORD_FILE: %w", err)
+		}
+		cfg.Wrap(recorder.wrap)
+	}
+	reachability := &connectivity{}
+	var failover *endpointFailover
+	healthCfg := cfg
+	if len(opts.APIServers) > 0 {
+		var err error
+		if failover, err = newEndpointFailover(cfg.Host, opts.APIServers); err != nil {
+			return nil, err
+		}
+		healthCfg = rest.CopyConfig(cfg)
+		cfg.Wrap(failover.wrap)
+	}
+	cfg.Wrap(reachability.wrap)
+
 	client, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
+	meta, err := metadata.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
 	m := make(map[string]struct{})
-	for _, k := range allowedKeys {
+	for _, k := range opts.AllowedLabelKeys {
 		if k != "" {
 			m[k] = struct{}{}
 		}
 	}
-	return &NodeLifeSupportController{client: client, allowedLabels: m}, nil
+	selectors, err := allowlistSelectors(m)
+	if err != nil {
+		return nil, err
+	}
+	c := &NodeLifeSupportController{
+		client:        client,
+		meta:          meta,
+		allowedLabels: m,
+		selectors:     selectors,
+		opts:          *opts,
+		recorder:      newEventRecorder(client),
+		failover:      failover,
+		healthCfg:     healthCfg,
+		restConfig:    cfg,
+		clock:         clock.RealClock{},
+		throttle:      newNotifyThrottle(opts.Cluster, opts.NotifyWindow, opts.NotifyBurst),
+		connectivity:  reachability,
+	}
+	reachability.now = c.now
+	if opts.WebhookURL != "" {
+		c.notifier = newWebhookNotifier(opts.WebhookURL)
+	}
+	if opts.AuditExportURL != "" {
+		store, prefix, err := newObjectStore(opts.AuditExportURL)
+		if err != nil {
+			return nil, fmt.Errorf("AUDIT_EXPORT_URL: %w", err)
+		}
+		c.auditor = newAuditExporter(store, prefix, opts)
+		c.auditor.logf, c.auditor.now = c.logf, c.now
+	}
+	if opts.PreflightCheck {
+		c.readyz = func(ctx context.Context) error {
+			return apiServerReady(ctx, client.Discovery().RESTClient())
+		}
+	}
+	if opts.Sharding {
+		c.shard = &shardMembership{
+			client:        client,
+			namespace:     opts.Namespace,
+			identity:      opts.Identity,
+			podUID:        types.UID(opts.PodUID),
+			leaseDuration: opts.ShardLeaseDuration,
+			now:           c.now,
+		}
+	}
+	return c, nil
+}
+
+// allowlistSelectors translates the allowed label keys into label selectors
+// for the Nodes List call. The allowlist means "has any of these keys", which a
+// single selector cannot express (requirements are ANDed), so there is one
+// existence selector per key and the results are merged. An empty allowlist
+// yields a single empty selector matching every node.
+func allowlistSelectors(allowed map[string]struct{}) ([]string, error) {
+	if len(allowed) == 0 {
+		return []string{""}, nil
+	}
+	selectors := make([]string, 0, len(allowed))
+	for k := range allowed {
+		req, err := labels.NewRequirement(k, selection.Exists, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed label key %q: %w", k, err)
+		}
+		selectors = append(selectors, labels.NewSelector().Add(*req).String())
+	}
+	sort.Strings(selectors)
+	return selectors, nil
+}
+
+// Start begins watching nodes, one metadata informer per selector, and blocks
+// until the initial lists have been cached. The informers' reflectors request
+// watch bookmarks, so an expired watch resumes from the last bookmarked
+// resourceVersion rather than triggering a full relist; RESYNC_PERIOD only
+// controls how often cached objects are re-delivered to handlers.
+func (c *NodeLifeSupportController) Start(ctx context.Context) error {
+	if c.opts.RBACCheck {
+		if err := c.startPermissionCheck(ctx); err != nil {
+			return err
+		}
+	}
+	if err := c.restoreState(ctx); err != nil {
+		// Carry on: at worst durations are counted from now.
+		c.logf("restoring state: %v", err)
+	}
+	if c.opts.APIOutageAlertAfter > 0 {
+		// Before the caches sync, which they may not while it lasts.
+		go c.watchConnectivity(ctx)
+	}
+	for _, sel := range c.selectors {
+		sel := sel
+		inf := metadatainformer.NewFilteredMetadataInformer(c.meta, nodesResource, "", c.opts.ResyncPeriod, cache.Indexers{},
+			func(o *metav1.ListOptions) { o.LabelSelector = sel }).Informer()
+		if err := inf.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+			c.logf("node watch %q interrupted, resuming: %v", sel, err)
+		}); err != nil {
+			return err
+		}
+		if _, err := inf.AddEventHandler(c.nodeDeleteHandler(ctx)); err != nil {
+			return err
+		}
+		c.informers = append(c.informers, inf)
+		go inf.Run(ctx.Done())
+	}
+
+	synced := make([]cache.InformerSynced, 0, len(c.informers)+1)
+	for _, inf := range c.informers {
+		synced = append(synced, inf.HasSynced)
+	}
+	if c.watchHeartbeats() {
+		synced = append(synced, c.startLeaseInformer(ctx.Done()))
+	}
+	if hasExpressions(c.opts.Policies) {
+		synced = append(synced, c.startNodeInformer(ctx.Done()))
+	}
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return fmt.Errorf("timed out waiting for node and lease caches to sync")
+	}
+
+	if c.failover != nil {
+		go c.failover.run(ctx, c.healthCfg, c.opts.APIServerCheckInterval)
+	}
+	if c.opts.ProbePluginDir != "" {
+		go c.runProbes(ctx, c.opts.ProbeInterval)
+	}
+	if c.opts.PodInventoryInterval > 0 {
+		go c.runPodInventory(ctx, c.opts.PodInventoryInterval)
+	}
+	if c.auditor != nil {
+		auditExports.Add(1)
+		go c.auditor.run(ctx, c.opts.AuditFlushInterval)
+	}
+	return nil
+}
+
+// listNodes returns the cached node metadata from every informer, sorted by
+// name. Nodes matched by more than one selector are returned once.
+func (c *NodeLifeSupportController) listNodes() []*metav1.PartialObjectMetadata {
+	var nodes []*metav1.PartialObjectMetadata
+	seen := make(map[string]struct{})
+	for _, inf := range c.informers {
+		for _, obj := range inf.GetStore().List() {
+			n, ok := obj.(*metav1.PartialObjectMetadata)
+			if !ok {
+				continue
+			}
+			if _, ok := seen[n.Name]; ok {
+				continue
+			}
+			seen[n.Name] = struct{}{}
+			nodes = append(nodes, n)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes
 }
 
 func (c *NodeLifeSupportController) SyncAllNodes(ctx context.Context) error {
-	nodes, err := c.client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return fmt.Errorf("list nodes: %w", err)
+	if c.shard != nil {
+		// Carry on with the last known membership if this fails; a stale view
+		// at worst means a node is briefly handled twice or not at all.
+		if err := c.shard.refresh(ctx); err != nil {
+			c.logf("shard membership: %v", err)
+		}
 	}
 
-	for _, n := range nodes.Items {
-		// If allowedLabels is non-empty, only operate on nodes that have any of the allowed label keys.
+	c.preflight(ctx)
+
+	now := c.now()
+	targeted := make(map[string]struct{})
+	// reasons are why nodes were skipped, should they be engaged.
+	reasons := make(map[string]string)
+	held := make(map[string]struct{})
+	var candidates []engagement
+	var due []syncItem
+	errs := newSyncErrors()
+	freeze := c.exclusion(now)
+	for _, n := range c.listNodes() {
+		if err := ctx.Err(); err != nil {
+			// Shutting down; leave the rest of the cycle.
+			return err
+		}
+		// A node being deleted is going away whatever its kubelet does;
+		// renewing its lease would only hold up whoever cleans up after it,
+		// such as the cloud controller manager.
+		if n.DeletionTimestamp != nil {
+			reasons[n.Name] = disengageNodeDeleting
+			continue
+		}
+		// The API server has already filtered on the allowlist; this check only
+		// guards against a node being relabelled while a watch catches up.
 		if len(c.allowedLabels) > 0 {
-			if !c.nodeHasAllowedLabel(&n) {
-				log.Printf("skipping node %s: no matching allowed labels", n.Name)
+			if !c.nodeHasAllowedLabel(n) {
+				c.logf("skipping node %s: no matching allowed labels", n.Name)
+				reasons[n.Name] = disengageOperatorDisabled
 				continue
 			}
 		}
 
-		if err := c.SyncNode(ctx, &n); err != nil {
-			log.Printf("failed updating node %s: %v", n.Name, err)
+		if c.shard != nil && !c.shard.owns(n.Name) {
+			reasons[n.Name] = disengageShardReassigned
+			continue
+		}
+
+		var p *Policy
+		if expiry, ok := c.expiresAt(n); ok {
+			// An explicit expiry takes precedence over policies.
+			c.recordPolicies(n.Name, nil)
+			if !now.Before(expiry) {
+				c.expire(ctx, n, v1.EventTypeNormal, disengageExpiryReached, fmt.Sprintf("%s %s reached", expiresAtAnnotation, expiry.Format(time.RFC3339)))
+				continue
+			}
 		} else {
-			log.Printf("updated node %s", n.Name)
+			p = c.resolvePolicy(n)
+			if len(c.opts.Policies) > 0 && p == nil {
+				reasons[n.Name] = disengagePolicyDeleted
+				continue
+			}
+			if !c.inWindow(n.Name, n.Annotations, p, now) {
+				reasons[n.Name] = disengageWindowClosed
+				continue
+			}
+		}
+
+		rule := c.conditionRule(n.Name)
+		if rule != nil && rule.Action == RuleActionDisengage {
+			reasons[n.Name] = disengageProbeFailed
+			continue
+		}
+
+		if c.overstayed(ctx, n, now) {
+			held[n.Name] = struct{}{}
+			continue
+		}
+
+		// A node engaged before a restart is still on life support: its
+		// lease was last renewed by the previous instance, and the grace
+		// period, freeze and engagement limits only govern new engagements.
+		resuming := c.resuming(n.Name)
+		if !resuming && !c.needsSupport(n.Name, p, now) {
+			continue
+		}
+		// Only nodes about to be engaged, or renewed, are read in full.
+		if !c.engaged(n.Name) || c.due(n.Name, now) {
+			reason, why := c.unsupportableAt(ctx, n.Name, now)
+			c.noteSkipped(n.Name, n.UID, reason, why)
+			if reason != "" {
+				reasons[n.Name] = reason
+				continue
+			}
+		}
+		if resuming {
+			c.engage(n.Name)
+		}
+		if !c.engaged(n.Name) {
+			if freeze == nil {
+				candidates = append(candidates, engagement{node: n, policy: p})
+			}
+			continue
+		}
+		if freeze != nil && c.opts.Calendar.DisengageExisting {
+			reasons[n.Name] = disengageCalendarExclusion
+			continue
+		}
+
+		targeted[n.Name] = struct{}{}
+		c.checkSupportThreshold(ctx, n, now)
+		if rule != nil && rule.Action == RuleActionSkip {
+			continue
+		}
+		if c.coolingDown(ctx, n, now) {
+			continue
+		}
+		if !c.due(n.Name, now) || c.quarantined(n.Name, now) {
+			continue
 		}
+		due = append(due, syncItem{node: n})
 	}
 
+	for _, e := range c.admitEngagements(candidates, now) {
+		targeted[e.node.Name] = struct{}{}
+		c.engage(e.node.Name)
+		due = append(due, syncItem{node: e.node})
+	}
+	attempts, err := c.syncQueue(ctx, due, now, errs)
+	if err != nil {
+		return err
+	}
+
+	c.disengageUntargeted(ctx, targeted, reasons)
+	c.restoreOrphanedCordons(ctx)
+	if !c.metadataSwept {
+		c.sweepStaleMetadata(ctx)
+	}
+	c.pruneExhausted(held)
+	c.accountSupport(now)
+	c.persistState(ctx)
+	c.renewKeepAliveLeases(ctx, now)
+	c.reportSyncErrors(errs, attempts)
+	c.flushNotifications(ctx)
+	c.sendDigest(ctx, now)
+	c.recordCycle(now, attempts, errs.total)
+
 	return nil
 }
 
-func (c *NodeLifeSupportController) SyncNode(ctx context.Context, node *v1.Node) error {
-	if err := c.UpdateLease(ctx, node.Name); err != nil {
-		return fmt.Errorf("update lease: %w", err)
+// syncAndLog syncs a node and logs its success, or adds its failure to the
+// cycle's errs, reporting whether it succeeded.
+func (c *NodeLifeSupportController) syncAndLog(ctx context.Context, node *metav1.PartialObjectMetadata, errs *syncErrors) bool {
+	err := c.SyncNode(ctx, node)
+	if apierrors.IsNotFound(err) && c.nodeDeleted(ctx, node.Name) {
+		// Deleted since it was listed; the informer will catch up.
+		c.forget(node.Name)
+		return true
 	}
+	if err != nil {
+		errs.add(node.Name, err)
+	} else {
+		c.sampledLogf("updated", node.Name, "updated node %s", node.Name)
+	}
+	c.recordSyncResult(node.Name, err, c.now())
+	return err == nil
+}
 
-	if err := c.ForceNodeReady(ctx, node.Name); err != nil {
-		return fmt.Errorf("update node status: %w", err)
+// SyncNode runs the patchers enabled for a single node, renewing its lease
+// and asserting readiness by default, then schedules its next renewal based
+// on the lease duration. Only the node's metadata is needed; anything
+// requiring the full Node object should fetch it on demand rather than
+// widening the list call. The lease and the node status are kept up
+// independently: the sync only fails if both do, see recordPartialSync.
+func (c *NodeLifeSupportController) SyncNode(ctx context.Context, node *metav1.PartialObjectMetadata) error {
+	if c.noteDebug(node) {
+		c.logf("node %s: debug: syncing, annotations %v, labels %v", node.Name, node.Annotations, node.Labels)
+	}
+	s := &nodeSync{node: node, policy: c.nodePolicy(node.Name)}
+	halves, err := c.runPatchers(ctx, s)
+	if err != nil {
+		return err
 	}
+	c.recordPartialSync(node.Name, halves[failingLease], halves[failingStatus])
+
+	c.handleDeclaredDead(ctx, node)
 
+	leaseDuration := s.leaseDuration
+	if leaseDuration == 0 {
+		leaseDuration = fallbackLeaseDuration(c.opts.LeaseDurationSeconds)
+	}
+	c.scheduleNext(node.Name, c.now().Add(c.renewInterval(s.policy, leaseDuration)))
 	return nil
 }
 
-func (c *NodeLifeSupportController) UpdateLease(ctx context.Context, nodeName string) error {
-	leaseName := nodeName
-	// Kubernetes expects timestamps with microsecond precision (6 fractional digits).
-	// Format time accordingly to avoid parsing errors when the API server decodes the patch.
-	now := time.Now().UTC()
-	renew := now.Format("2006-01-02T15:04:05.000000Z07:00")
-
-	patch := fmt.Sprintf(`{
-			"spec": {
-				"holderIdentity": %q,
-				"renewTime": %q
-			}
-		}`, nodeName, renew)
-
-	_, err := c.client.CoordinationV1().Leases("kube-node-lease").Patch(
-		ctx,
-		leaseName,
-		types.MergePatchType,
-		[]byte(patch),
-		metav1.PatchOptions{},
-	)
+// now returns the current time from the controller's clock, falling back to
+// the real one when none is set.
+func (c *NodeLifeSupportController) now() time.Time {
+	if c.clock == nil {
+		return time.Now()
+	}
+	return c.clock.Now()
+}
 
-	return err
+// opContext derives the context for a single API call, bounded by APITimeout.
+func (c *NodeLifeSupportController) opContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.opts.APITimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.opts.APITimeout)
 }
 
-func (c *NodeLifeSupportController) ForceNodeReady(ctx context.Context, nodeName string) error {
-	ready := v1.NodeCondition{
-		Type:               v1.NodeReady,
-		Status:             v1.ConditionTrue,
-		LastHeartbeatTime:  metav1.Time{Time: time.Now().UTC()},
-		LastTransitionTime: metav1.Time{Time: time.Now().UTC()},
-		Reason:             "NodeLifeSupportOverride",
-		Message:            "node-life-support controller asserting node health.",
+func (c *NodeLifeSupportController) ForceNodeReady(ctx context.Context, node *metav1.PartialObjectMetadata) error {
+	nodeName := node.Name
+	now := c.now().UTC()
+	ready, err := c.readyCondition(node, now)
+	if err != nil {
+		return err
 	}
 
+	conditions := []v1.NodeCondition{ready}
+	if p := c.nodePolicy(nodeName); p != nil {
+		if p.profile != nil {
+			conditions = withConditions(conditions, p.profile.Conditions, now)
+		}
+		conditions = withConditions(conditions, p.Conditions, now)
+	}
+	if rule := c.conditionRule(nodeName); rule != nil {
+		conditions = withConditions(conditions, rule.Conditions, now)
+	}
+	if since, ok := c.engagedSince(nodeName); ok {
+		conditions = append(conditions, lifeSupportActive(since, now))
+	}
 	patchObj := map[string]interface{}{
 		"status": map[string]interface{}{
-			"conditions": []v1.NodeCondition{ready},
+			"conditions": conditions,
 		},
 	}
+	if c.opts.PatchHook != nil {
+		if patchObj, err = c.opts.PatchHook.apply(node, patchObj); err != nil {
+			return err
+		}
+		if patchObj == nil {
+			return errPatchVetoed
+		}
+		if meta, ok := patchObj["metadata"]; ok {
+			delete(patchObj, "metadata")
+			if err := c.patchNodeMetadata(ctx, nodeName, meta); err != nil {
+				return err
+			}
+		}
+	}
 
 	raw, err := json.Marshal(patchObj)
 	if err != nil {
 		return err
 	}
+	c.debugPayload(nodeName, "patching status", raw)
+	if c.debugging(nodeName) {
+		c.debugStatusDiff(ctx, nodeName, raw)
+	}
 
-	_, err = c.client.CoreV1().Nodes().Patch(
-		ctx,
-		nodeName,
-		types.MergePatchType,
-		raw,
-		metav1.PatchOptions{},
-		"status",
-	)
-
-	return err
+	client, err := c.nodeClient(nodeName)
+	if err != nil {
+		return err
+	}
+	return c.retryWrite(ctx, func(ctx context.Context) error {
+		_, err := client.CoreV1().Nodes().Patch(
+			ctx,
+			nodeName,
+			types.MergePatchType,
+			raw,
+			metav1.PatchOptions{},
+			"status",
+		)
+		return err
+	})
 }
 
 // nodeHasAllowedLabel returns true if the node has at least one label key
 // that exists in the controller's allowedLabels set.
-func (c *NodeLifeSupportController) nodeHasAllowedLabel(node *v1.Node) bool {
+func (c *NodeLifeSupportController) nodeHasAllowedLabel(node *metav1.PartialObjectMetadata) bool {
 	if node == nil {
 		return false
 	}
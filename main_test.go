@@ -1,17 +1,23 @@
 package main
 
 import (
+	"context"
+	"strings"
 	"testing"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	clocktesting "k8s.io/utils/clock/testing"
 )
 
 // TestNodeHasAllowedLabel tests the label filtering logic.
 func TestNodeHasAllowedLabel(t *testing.T) {
 	tests := []struct {
 		name          string
-		node          *v1.Node
+		node          *metav1.PartialObjectMetadata
 		allowedLabels map[string]struct{}
 		expected      bool
 	}{
@@ -23,31 +29,31 @@ func TestNodeHasAllowedLabel(t *testing.T) {
 		},
 		{
 			name:          "empty allowedLabels (all nodes pass)",
-			node:          &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"foo": "bar"}}},
+			node:          &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"foo": "bar"}}},
 			allowedLabels: map[string]struct{}{},
 			expected:      true,
 		},
 		{
 			name:          "node has matching key",
-			node:          &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"disktype": "ssd", "other": "value"}}},
+			node:          &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"disktype": "ssd", "other": "value"}}},
 			allowedLabels: map[string]struct{}{"disktype": {}, "gpu": {}},
 			expected:      true,
 		},
 		{
 			name:          "node has no matching keys",
-			node:          &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"foo": "bar"}}},
+			node:          &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"foo": "bar"}}},
 			allowedLabels: map[string]struct{}{"disktype": {}, "gpu": {}},
 			expected:      false,
 		},
 		{
 			name:          "node with no labels, allowedLabels non-empty",
-			node:          &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{}}},
+			node:          &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{}}},
 			allowedLabels: map[string]struct{}{"disktype": {}},
 			expected:      false,
 		},
 		{
 			name:          "node with multiple labels, one matches",
-			node:          &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"zone": "us-east", "disktype": "ssd", "critical": "true"}}},
+			node:          &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"zone": "us-east", "disktype": "ssd", "critical": "true"}}},
 			allowedLabels: map[string]struct{}{"gpu": {}, "disktype": {}},
 			expected:      true,
 		},
@@ -125,13 +131,13 @@ func TestBuildAllowedLabelsMap(t *testing.T) {
 func TestNodeFilteringLogic(t *testing.T) {
 	tests := []struct {
 		name          string
-		nodes         []*v1.Node
+		nodes         []*metav1.PartialObjectMetadata
 		allowedLabels map[string]struct{}
 		expectedCount int
 	}{
 		{
 			name: "no allowlist, all nodes pass",
-			nodes: []*v1.Node{
+			nodes: []*metav1.PartialObjectMetadata{
 				{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"foo": "bar"}}},
 				{ObjectMeta: metav1.ObjectMeta{Name: "node2", Labels: map[string]string{"baz": "qux"}}},
 			},
@@ -140,7 +146,7 @@ func TestNodeFilteringLogic(t *testing.T) {
 		},
 		{
 			name: "with allowlist, only matching nodes pass",
-			nodes: []*v1.Node{
+			nodes: []*metav1.PartialObjectMetadata{
 				{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"disktype": "ssd"}}},
 				{ObjectMeta: metav1.ObjectMeta{Name: "node2", Labels: map[string]string{"foo": "bar"}}},
 				{ObjectMeta: metav1.ObjectMeta{Name: "node3", Labels: map[string]string{"gpu": "true"}}},
@@ -150,13 +156,13 @@ func TestNodeFilteringLogic(t *testing.T) {
 		},
 		{
 			name:          "no nodes",
-			nodes:         []*v1.Node{},
+			nodes:         []*metav1.PartialObjectMetadata{},
 			allowedLabels: map[string]struct{}{"disktype": {}},
 			expectedCount: 0,
 		},
 		{
 			name: "allowlist, no matching nodes",
-			nodes: []*v1.Node{
+			nodes: []*metav1.PartialObjectMetadata{
 				{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"foo": "bar"}}},
 				{ObjectMeta: metav1.ObjectMeta{Name: "node2", Labels: map[string]string{"baz": "qux"}}},
 			},
@@ -186,3 +192,204 @@ func TestNodeFilteringLogic(t *testing.T) {
 		})
 	}
 }
+
+// TestAllowlistSelectors tests translation of the allowlist into server-side label selectors.
+func TestAllowlistSelectors(t *testing.T) {
+	tests := []struct {
+		name          string
+		allowedLabels map[string]struct{}
+		expected      []string
+		expectErr     bool
+	}{
+		{
+			name:          "empty allowlist selects everything",
+			allowedLabels: map[string]struct{}{},
+			expected:      []string{""},
+		},
+		{
+			name:          "single key",
+			allowedLabels: map[string]struct{}{"nickperry.co.uk/node-life-support": {}},
+			expected:      []string{"nickperry.co.uk/node-life-support"},
+		},
+		{
+			name:          "multiple keys, one selector each, sorted",
+			allowedLabels: map[string]struct{}{"gpu": {}, "disktype": {}},
+			expected:      []string{"disktype", "gpu"},
+		},
+		{
+			name:          "invalid key",
+			allowedLabels: map[string]struct{}{"not a label": {}},
+			expectErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := allowlistSelectors(tt.allowedLabels)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("allowlistSelectors() expected error, got %v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("allowlistSelectors() unexpected error: %v", err)
+			}
+			if strings.Join(result, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("allowlistSelectors() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+// TestListNodesFromInformers tests that nodes are served from the per-selector
+// watches and de-duplicated across selectors.
+func TestListNodesFromInformers(t *testing.T) {
+	scheme := metadatafake.NewTestScheme()
+	metav1.AddMetaToScheme(scheme)
+	node := func(name string, labels map[string]string) *metav1.PartialObjectMetadata {
+		return &metav1.PartialObjectMetadata{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Node"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		}
+	}
+	client := metadatafake.NewSimpleMetadataClient(scheme,
+		node("node-b", map[string]string{"disktype": "ssd", "gpu": "true"}),
+		node("node-a", map[string]string{"gpu": "true"}),
+		node("node-c", map[string]string{"other": "x"}),
+	)
+
+	allowed := map[string]struct{}{"disktype": {}, "gpu": {}}
+	selectors, err := allowlistSelectors(allowed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &NodeLifeSupportController{meta: client, allowedLabels: allowed, selectors: selectors}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := c.Start(ctx); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+
+	var names []string
+	for _, n := range c.listNodes() {
+		names = append(names, n.Name)
+	}
+	if strings.Join(names, ",") != "node-a,node-b" {
+		t.Errorf("listNodes() = %v, want [node-a node-b]", names)
+	}
+}
+
+// TestBuildConfig tests kubeconfig path, context and KUBECONFIG handling.
+func TestBuildConfig(t *testing.T) {
+	kubeconfig := writeFile(t, "kubeconfig", testKubeconfig)
+	tests := []struct {
+		name       string
+		env        string
+		kubeconfig string
+		context    string
+		host       string
+	}{
+		{name: "explicit path", kubeconfig: kubeconfig, host: "https://a.example.com:6443"},
+		{name: "explicit path and context", kubeconfig: kubeconfig, context: "ctx-b", host: "https://b.example.com:6443"},
+		{name: "KUBECONFIG", env: kubeconfig, host: "https://a.example.com:6443"},
+		{name: "KUBECONFIG and context", env: kubeconfig, context: "ctx-b", host: "https://b.example.com:6443"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("KUBECONFIG", tt.env)
+			cfg, err := BuildConfig(tt.kubeconfig, tt.context)
+			if err != nil {
+				t.Fatalf("BuildConfig() error: %v", err)
+			}
+			if cfg.Host != tt.host {
+				t.Errorf("host = %q, want %q", cfg.Host, tt.host)
+			}
+		})
+	}
+
+	if _, err := BuildConfig(kubeconfig, "missing"); err == nil {
+		t.Errorf("BuildConfig() with an unknown context succeeded")
+	}
+}
+
+// TestSyncNodeClock tests that SyncNode takes every timestamp it writes or
+// schedules by from the controller's clock.
+func TestSyncNodeClock(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1"}}
+	client := fake.NewSimpleClientset(node)
+	c := &NodeLifeSupportController{
+		client: client,
+		opts:   Options{HolderIdentity: HolderIdentityNode},
+		clock:  clocktesting.NewFakeClock(now),
+	}
+	ctx := context.Background()
+
+	st := c.engage("node1")
+	if err := c.SyncNode(ctx, &metav1.PartialObjectMetadata{ObjectMeta: node.ObjectMeta}); err != nil {
+		t.Fatalf("SyncNode() error: %v", err)
+	}
+
+	if !st.engagedSince.Equal(now) {
+		t.Errorf("engagedSince = %v, want %v", st.engagedSince, now)
+	}
+	if want := now.Add(10 * time.Second); !st.nextSync.Equal(want) {
+		t.Errorf("nextSync = %v, want %v", st.nextSync, want)
+	}
+	lease, err := client.CoordinationV1().Leases(nodeLeaseNamespace).Get(ctx, "node1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lease.Spec.RenewTime.Time.Equal(now) {
+		t.Errorf("lease renewTime = %v, want %v", lease.Spec.RenewTime.Time, now)
+	}
+	got, err := client.CoreV1().Nodes().Get(ctx, "node1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Status.Conditions) != 2 || !got.Status.Conditions[0].LastHeartbeatTime.Time.Equal(now) {
+		t.Errorf("conditions = %v, want Ready heartbeating at %v", got.Status.Conditions, now)
+	}
+}
+
+// TestRunStopsWhenCancelled tests that Run returns as soon as its context is
+// done, without syncing any more nodes.
+func TestRunStopsWhenCancelled(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	client := fake.NewSimpleClientset(node)
+	scheme := metadatafake.NewTestScheme()
+	metav1.AddMetaToScheme(scheme)
+	meta := metadatafake.NewSimpleMetadataClient(scheme,
+		&metav1.PartialObjectMetadata{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Node"}, ObjectMeta: node.ObjectMeta},
+	)
+	selectors, err := allowlistSelectors(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &NodeLifeSupportController{client: client, meta: meta, selectors: selectors,
+		opts: Options{HolderIdentity: HolderIdentityNode, SyncInterval: time.Hour}}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := c.Start(ctx); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return after cancellation")
+	}
+	for _, a := range client.Actions() {
+		if a.GetResource().Resource == "leases" {
+			t.Errorf("node synced after cancellation: %s %s", a.GetVerb(), a.GetResource().Resource)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// metricsRegistry holds the controller's metrics, served on /metrics.
+var metricsRegistry = prometheus.NewRegistry()
+
+// Metrics are labelled by cluster, which is empty in single-cluster mode.
+var (
+	engagementsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "node_life_support_engagements_total",
+		Help: "Number of times a node was put on life support.",
+	}, []string{"cluster"})
+	disengagementsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "node_life_support_disengagements_total",
+		Help: "Number of times a node was taken off life support, by reason.",
+	}, []string{"cluster", "reason"})
+	engagedNodes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "node_life_support_engaged_nodes",
+		Help: "Number of nodes currently on life support.",
+	}, []string{"cluster"})
+	engagementsQueued = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "node_life_support_engagements_queued",
+		Help: "Number of nodes waiting to be engaged because ENGAGEMENT_LIMIT was reached.",
+	}, []string{"cluster"})
+	drainBlockedPods = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "node_life_support_drain_blocked_pods",
+		Help: "Number of pods whose eviction from a node being given up on is refused, by PodDisruptionBudget.",
+	}, []string{"cluster", "poddisruptionbudget"})
+	supportedPods = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "node_life_support_supported_pods",
+		Help: "Number of pods on nodes on life support, as of the last POD_INVENTORY_INTERVAL.",
+	}, []string{"cluster"})
+	supportedCriticalPods = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "node_life_support_supported_critical_pods",
+		Help: "Number of critical pods on nodes on life support, by namespace.",
+	}, []string{"cluster", "namespace"})
+	degradedMode = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "node_life_support_degraded",
+		Help: "1 while the API server fails its pre-flight /readyz check and only leases are renewed.",
+	}, []string{"cluster"})
+	partialSyncNodes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "node_life_support_partial_sync_nodes",
+		Help: "Number of nodes on life support whose lease renewals or node status updates alone are failing, by failing half.",
+	}, []string{"cluster", "failing"})
+	syncCycleSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "node_life_support_sync_cycle_seconds",
+		Help: "How long the last sync cycle took.",
+	}, []string{"cluster"})
+	syncOverrunsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "node_life_support_sync_overruns_total",
+		Help: "Number of sync cycles that took longer than SYNC_INTERVAL.",
+	}, []string{"cluster"})
+	syncsDeferredTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "node_life_support_syncs_deferred_total",
+		Help: "Number of node syncs with fresh leases deferred to the next cycle because a cycle overran SYNC_INTERVAL.",
+	}, []string{"cluster"})
+	apiUnreachable = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "node_life_support_api_unreachable",
+		Help: "1 while the API server has been unreachable for API_OUTAGE_ALERT_AFTER, 0 otherwise.",
+	}, []string{"cluster"})
+	syncErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "node_life_support_sync_errors_total",
+		Help: "Number of failed node syncs, by error class (API status reason, Timeout or Other).",
+	}, []string{"cluster", "class"})
+	notificationsSuppressed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "node_life_support_notifications_suppressed_total",
+		Help: "Number of Events and webhook notifications not sent as duplicates or beyond NOTIFY_BURST, by notifier and reason.",
+	}, []string{"cluster", "notifier", "reason"})
+	auditRecordsExported = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "node_life_support_audit_records_exported_total",
+		Help: "Number of audit records exported to AUDIT_EXPORT_URL.",
+	}, []string{"cluster"})
+	auditRecordsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "node_life_support_audit_records_dropped_total",
+		Help: "Number of audit records dropped because too many were waiting to be exported.",
+	}, []string{"cluster"})
+	auditExportErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "node_life_support_audit_export_errors_total",
+		Help: "Number of audit batches that could not be written to AUDIT_EXPORT_URL.",
+	}, []string{"cluster"})
+	execHookFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "node_life_support_exec_hook_failures_total",
+		Help: "Number of exec hooks that failed or timed out, by transition.",
+	}, []string{"cluster", "transition"})
+	probeResultsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "node_life_support_probe_results_total",
+		Help: "Number of node probes by PROBE_PLUGIN_DIR plugins, by plugin and result: healthy, unhealthy or error.",
+	}, []string{"cluster", "plugin", "result"})
+	nodeSupportedSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "node_life_support_node_supported_seconds",
+		Help: "How long a node has been on life support, by period: the current engagement, the last SUPPORT_WINDOW, or in total.",
+	}, []string{"cluster", "node", "period"})
+	nodeEngagementsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "node_life_support_node_engagements_total",
+		Help: "Number of times a node was put on life support, by node.",
+	}, []string{"cluster", "node"})
+	nodeFlapsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "node_life_support_node_flaps_total",
+		Help: "Number of times a node was put back on life support within FLAP_WINDOW of being taken off it, by node.",
+	}, []string{"cluster", "node"})
+	missingPermissions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "node_life_support_missing_permissions",
+		Help: "Number of permissions the enabled features need that RBAC_CHECK found missing.",
+	}, []string{"cluster"})
+	quarantinedNodes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "node_life_support_quarantined_nodes",
+		Help: "Number of nodes on life support not synced after QUARANTINE_AFTER consecutive failures.",
+	}, []string{"cluster"})
+	chaosFaultsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "node_life_support_chaos_faults_total",
+		Help: "Number of faults injected into API requests by CHAOS_*, by kind.",
+	}, []string{"cluster", "kind"})
+)
+
+// Leader election metrics are per replica, and LEADER_ELECTION is only
+// supported in single-cluster mode, so they have no cluster label.
+var (
+	leaderInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "node_life_support_leader",
+		Help: "1 for the replica last seen holding the LEADER_ELECTION lease.",
+	}, []string{"leader"})
+	leaderTransitionsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "node_life_support_leader_transitions_total",
+		Help: "Number of times the leader has changed since this replica started.",
+	})
+	leadingSeconds = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "node_life_support_leading_seconds",
+		Help: "How long this replica has been the leader, 0 when it is not.",
+	}, func() float64 {
+		if leadership == nil {
+			return 0
+		}
+		return leadership.leadingSeconds(leadership.now())
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		engagementsTotal,
+		disengagementsTotal,
+		engagedNodes,
+		engagementsQueued,
+		degradedMode,
+		quarantinedNodes,
+		missingPermissions,
+		syncErrorsTotal,
+		notificationsSuppressed,
+		auditRecordsExported,
+		auditRecordsDropped,
+		auditExportErrors,
+		execHookFailures,
+		probeResultsTotal,
+		nodeSupportedSeconds,
+		nodeEngagementsTotal,
+		nodeFlapsTotal,
+		syncCycleSeconds,
+		syncOverrunsTotal,
+		syncsDeferredTotal,
+		apiUnreachable,
+		chaosFaultsTotal,
+		partialSyncNodes,
+		drainBlockedPods,
+		supportedPods,
+		supportedCriticalPods,
+		leaderInfo,
+		leaderTransitionsTotal,
+		leadingSeconds,
+	)
+}
+
+// forgetClusterMetrics drops the series of a cluster no longer supervised.
+func forgetClusterMetrics(cluster string) {
+	engagementsTotal.DeleteLabelValues(cluster)
+	disengagementsTotal.DeletePartialMatch(prometheus.Labels{"cluster": cluster})
+	engagedNodes.DeleteLabelValues(cluster)
+	engagementsQueued.DeleteLabelValues(cluster)
+	degradedMode.DeleteLabelValues(cluster)
+	quarantinedNodes.DeleteLabelValues(cluster)
+	chaosFaultsTotal.DeletePartialMatch(prometheus.Labels{"cluster": cluster})
+	missingPermissions.DeleteLabelValues(cluster)
+	syncErrorsTotal.DeletePartialMatch(prometheus.Labels{"cluster": cluster})
+	notificationsSuppressed.DeletePartialMatch(prometheus.Labels{"cluster": cluster})
+	auditRecordsExported.DeleteLabelValues(cluster)
+	auditRecordsDropped.DeleteLabelValues(cluster)
+	auditExportErrors.DeleteLabelValues(cluster)
+	execHookFailures.DeletePartialMatch(prometheus.Labels{"cluster": cluster})
+	probeResultsTotal.DeletePartialMatch(prometheus.Labels{"cluster": cluster})
+	nodeSupportedSeconds.DeletePartialMatch(prometheus.Labels{"cluster": cluster})
+	nodeEngagementsTotal.DeletePartialMatch(prometheus.Labels{"cluster": cluster})
+	nodeFlapsTotal.DeletePartialMatch(prometheus.Labels{"cluster": cluster})
+	partialSyncNodes.DeletePartialMatch(prometheus.Labels{"cluster": cluster})
+	syncCycleSeconds.DeleteLabelValues(cluster)
+	syncOverrunsTotal.DeleteLabelValues(cluster)
+	syncsDeferredTotal.DeleteLabelValues(cluster)
+	apiUnreachable.DeleteLabelValues(cluster)
+	drainBlockedPods.DeletePartialMatch(prometheus.Labels{"cluster": cluster})
+	supportedPods.DeleteLabelValues(cluster)
+	supportedCriticalPods.DeletePartialMatch(prometheus.Labels{"cluster": cluster})
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// deleteMirrorPods deletes the mirror pods of a node the controller has given
+// up on, or that is declared dead, and reports whether every one was. Only the
+// kubelet removes mirror pods of static pods, so without it they linger as
+// ghosts of a dead machine.
+func (c *NodeLifeSupportController) deleteMirrorPods(ctx context.Context, name string) bool {
+	pods, err := c.nodePods(ctx, name)
+	if err != nil {
+		c.logf("node %s: listing mirror pods: %v", name, err)
+		return false
+	}
+	failed := false
+	deleted := 0
+	for _, p := range pods {
+		if _, ok := p.Annotations[mirrorPodAnnotation]; !ok {
+			continue
+		}
+		opCtx, cancel := c.opContext(ctx)
+		err := c.client.CoreV1().Pods(p.Namespace).Delete(opCtx, p.Name, metav1.DeleteOptions{
+			// Only the mirror pod we listed, not one a recovered kubelet
+			// recreated meanwhile.
+			Preconditions: &metav1.Preconditions{UID: &p.UID},
+		})
+		cancel()
+		if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+			c.logf("node %s: deleting mirror pod %s/%s: %v", name, p.Namespace, p.Name, err)
+			failed = true
+			continue
+		}
+		if err == nil {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		c.logf("node %s: deleted %d mirror pods", name, deleted)
+	}
+	return !failed
+}
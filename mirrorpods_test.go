@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// TestMirrorPodCleanup tests that a node given up on loses its mirror pods,
+// and only those, when CLEAN_UP_MIRROR_PODS is set.
+func TestMirrorPodCleanup(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1"}}
+		mirror := drainPod("kube-apiserver-node1", "node1", func(p *v1.Pod) {
+			p.Namespace = "kube-system"
+			p.Annotations = map[string]string{mirrorPodAnnotation: "hash"}
+		})
+		otherMirror := drainPod("kube-apiserver-node2", "node2", func(p *v1.Pod) {
+			p.Namespace = "kube-system"
+			p.Annotations = map[string]string{mirrorPodAnnotation: "hash"}
+		})
+		client := fake.NewSimpleClientset(node, mirror, otherMirror, drainPod("app", "node1", nil))
+		c := &NodeLifeSupportController{
+			client: client,
+			opts:   Options{HolderIdentity: HolderIdentityNode, MaxLifeSupportDuration: time.Hour, CleanUpMirrorPods: enabled},
+		}
+		ctx := context.Background()
+		meta := &metav1.PartialObjectMetadata{ObjectMeta: node.ObjectMeta}
+		c.engage("node1")
+		c.nodes["node1"].engagedSince = time.Now().Add(-2 * time.Hour)
+
+		if !c.overstayed(ctx, meta, time.Now()) {
+			t.Fatal("not given up after the maximum duration")
+		}
+
+		want := map[string]bool{"kube-system/kube-apiserver-node1": !enabled, "kube-system/kube-apiserver-node2": true, "ns/app": true}
+		for key, exists := range want {
+			ns, name, _ := strings.Cut(key, "/")
+			_, err := client.CoreV1().Pods(ns).Get(ctx, name, metav1.GetOptions{})
+			if (err == nil) != exists {
+				t.Errorf("enabled=%v: pod %s exists = %v, want %v", enabled, key, err == nil, exists)
+			}
+		}
+	}
+}
+
+// TestMirrorPodCleanupGone tests that mirror pods gone or recreated before
+// they could be deleted are not counted as deleted.
+func TestMirrorPodCleanupGone(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	mirror := func(name string) *v1.Pod {
+		return drainPod(name, "node1", func(p *v1.Pod) {
+			p.Namespace = "kube-system"
+			p.Annotations = map[string]string{mirrorPodAnnotation: "hash"}
+		})
+	}
+	client := fake.NewSimpleClientset(mirror("etcd-node1"), mirror("gone-node1"), mirror("recreated-node1"))
+	client.PrependReactor("delete", "pods", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		switch name := a.(k8stesting.DeleteAction).GetName(); name {
+		case "gone-node1":
+			return true, nil, apierrors.NewNotFound(v1.Resource("pods"), name)
+		case "recreated-node1":
+			return true, nil, apierrors.NewConflict(v1.Resource("pods"), name, errors.New("UID precondition failed"))
+		}
+		return false, nil, nil
+	})
+	c := &NodeLifeSupportController{client: client}
+	c.deleteMirrorPods(context.Background(), "node1")
+	if want := "deleted 1 mirror pods"; !strings.Contains(buf.String(), want) {
+		t.Errorf("log does not contain %q:\n%s", want, buf.String())
+	}
+}
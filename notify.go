@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// webhookTimeout bounds a single webhook delivery.
+const webhookTimeout = 10 * time.Second
+
+// notification is the JSON body POSTed to WEBHOOK_URL.
+type notification struct {
+	// Event is the Event reason recorded on the node, e.g. LifeSupportExpired,
+	// or LifeSupportDigest for a digest.
+	Event string `json:"event"`
+	// Reason is why the node was taken off life support, e.g.
+	// MaxDurationExceeded, on expiries.
+	Reason  string `json:"reason,omitempty"`
+	Node    string `json:"node,omitempty"`
+	Message string `json:"message"`
+	// EngagedSince and SupportedSeconds describe the engagement the
+	// notification is about, when there is one.
+	EngagedSince     time.Time `json:"engagedSince,omitempty"`
+	SupportedSeconds float64   `json:"supportedSeconds,omitempty"`
+	// Nodes and Suppressed are set, instead of Node, on a summary of
+	// notifications suppressed by NOTIFY_BURST or as duplicates.
+	Nodes      int `json:"nodes,omitempty"`
+	Suppressed int `json:"suppressed,omitempty"`
+	// Digest lists the nodes on life support in the last day, with Nodes
+	// their number, on a DIGEST_SCHEDULE digest.
+	Digest []digestNode `json:"digest,omitempty"`
+	// Cluster is set in multi-cluster mode.
+	Cluster    string    `json:"cluster,omitempty"`
+	Controller string    `json:"controller"`
+	Time       time.Time `json:"time"`
+}
+
+// webhookNotifier POSTs notifications to an HTTP endpoint.
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookNotifier(url string) *webhookNotifier {
+	return &webhookNotifier{url: url, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// send delivers a single notification.
+func (w *webhookNotifier) send(ctx context.Context, n notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent())
+	if tp := traceparentFrom(ctx); tp != "" {
+		req.Header.Set(traceparentHeader, tp)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// notify sends a notification in the background, so a slow or unreachable
+// endpoint never holds up heartbeats, unless it is throttled. It is a no-op
+// without WEBHOOK_URL.
+func (c *NodeLifeSupportController) notify(ctx context.Context, n notification) {
+	if c.notifier == nil {
+		return
+	}
+	if !c.throttle.allow(notifierWebhook, n.Event, n.Node, n.Message, c.now()) {
+		return
+	}
+	c.sendNotification(ctx, n)
+}
+
+// sendNotification sends a notification in the background, unthrottled.
+func (c *NodeLifeSupportController) sendNotification(ctx context.Context, n notification) {
+	if c.notifier == nil {
+		return
+	}
+	n.Cluster = c.opts.Cluster
+	n.Controller = c.opts.Identity
+	if n.Time.IsZero() {
+		n.Time = c.now().UTC()
+	}
+	go func() {
+		if err := c.notifier.send(ctx, n); err != nil {
+			if n.Node == "" {
+				c.logf("webhook notification: %v", err)
+			} else {
+				c.logf("webhook notification for node %s: %v", n.Node, err)
+			}
+		}
+	}()
+}
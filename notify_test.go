@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestWebhookSend tests webhook delivery and error reporting.
+func TestWebhookSend(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		expectErr bool
+	}{
+		{name: "accepted", status: http.StatusNoContent},
+		{name: "rejected", status: http.StatusInternalServerError, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got notification
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+					t.Errorf("Content-Type = %q", ct)
+				}
+				if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+					t.Errorf("decode body: %v", err)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			w := newWebhookNotifier(srv.URL)
+			err := w.send(context.Background(), notification{Event: reasonLifeSupportExpired, Node: "node1", SupportedSeconds: 90})
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("send() error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if got.Node != "node1" || got.Event != reasonLifeSupportExpired || got.SupportedSeconds != 90 {
+				t.Errorf("received %+v", got)
+			}
+		})
+	}
+}
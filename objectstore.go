@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// objectStoreTimeout bounds a single object storage request.
+const objectStoreTimeout = 30 * time.Second
+
+// objectStore is the little of S3, GCS or Azure Blob Storage the audit export
+// needs. Keys are relative to the bucket or container.
+type objectStore interface {
+	put(ctx context.Context, key, contentType string, body []byte) error
+	// list returns the objects under prefix with when they were last
+	// modified.
+	list(ctx context.Context, prefix string) (map[string]time.Time, error)
+	delete(ctx context.Context, key string) error
+}
+
+// newObjectStore returns the store for an AUDIT_EXPORT_URL and the key prefix
+// it names:
+//
+//	s3://bucket/prefix
+//	gs://bucket/prefix
+//	https://account.blob.core.windows.net/container/prefix
+//
+// S3 and GCS (through its S3-compatible XML API, with HMAC keys) are signed
+// with AUDIT_ACCESS_KEY_ID and AUDIT_SECRET_ACCESS_KEY, falling back to the
+// AWS_ variables; Azure requests carry AUDIT_AZURE_SAS_TOKEN.
+func newObjectStore(raw string) (objectStore, string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, "", err
+	}
+	prefix := strings.Trim(u.Path, "/")
+	client := &http.Client{Timeout: objectStoreTimeout}
+	switch u.Scheme {
+	case "s3", "gs":
+		if u.Host == "" {
+			return nil, "", fmt.Errorf("missing bucket")
+		}
+		s := &s3Store{
+			client:       client,
+			bucket:       u.Host,
+			region:       envString("AUDIT_REGION", envString("AWS_REGION", "us-east-1")),
+			accessKey:    envString("AUDIT_ACCESS_KEY_ID", os.Getenv("AWS_ACCESS_KEY_ID")),
+			secretKey:    envString("AUDIT_SECRET_ACCESS_KEY", os.Getenv("AWS_SECRET_ACCESS_KEY")),
+			sessionToken: envString("AUDIT_SESSION_TOKEN", os.Getenv("AWS_SESSION_TOKEN")),
+		}
+		s.endpoint = "https://s3." + s.region + ".amazonaws.com"
+		if u.Scheme == "gs" {
+			s.endpoint, s.region = "https://storage.googleapis.com", "auto"
+		}
+		s.endpoint = envString("AUDIT_ENDPOINT", s.endpoint)
+		if s.accessKey == "" || s.secretKey == "" {
+			return nil, "", fmt.Errorf("AUDIT_ACCESS_KEY_ID and AUDIT_SECRET_ACCESS_KEY are required")
+		}
+		return s, prefix, nil
+	case "https":
+		if !strings.HasSuffix(u.Host, ".blob.core.windows.net") {
+			return nil, "", fmt.Errorf("https URLs must be Azure Blob Storage containers")
+		}
+		container, prefix, _ := strings.Cut(prefix, "/")
+		if container == "" {
+			return nil, "", fmt.Errorf("missing container")
+		}
+		sas, err := url.ParseQuery(strings.TrimPrefix(envString("AUDIT_AZURE_SAS_TOKEN", ""), "?"))
+		if err != nil || len(sas) == 0 {
+			return nil, "", fmt.Errorf("AUDIT_AZURE_SAS_TOKEN is required")
+		}
+		return &azureStore{client: client, container: "https://" + u.Host + "/" + container, sas: sas}, prefix, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported scheme %q, want s3, gs or https", u.Scheme)
+	}
+}
+
+// s3Store talks to S3, or anything S3-compatible, with path-style requests
+// signed with Signature Version 4.
+type s3Store struct {
+	client                             *http.Client
+	endpoint, bucket, region           string
+	accessKey, secretKey, sessionToken string
+	// now is overridden in tests.
+	now func() time.Time
+}
+
+func (s *s3Store) put(ctx context.Context, key, contentType string, body []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, "/"+key, nil, body, map[string]string{"Content-Type": contentType})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3Store) delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, "/"+key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3Store) list(ctx context.Context, prefix string) (map[string]time.Time, error) {
+	out := make(map[string]time.Time)
+	q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		resp, err := s.do(ctx, http.MethodGet, "", q, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key          string
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range page.Contents {
+			out[o.Key] = o.LastModified
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return out, nil
+		}
+		q.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+// do sends a signed request for a key in the bucket, or for the bucket itself
+// with an empty key, failing on any status but 2xx.
+func (s *s3Store) do(ctx context.Context, method, key string, query url.Values, body []byte, headers map[string]string) (*http.Response, error) {
+	u := strings.TrimSuffix(s.endpoint, "/") + "/" + s.bucket + key
+	if len(query) > 0 {
+		u += "?" + canonicalQuery(query)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("User-Agent", userAgent())
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	s.sign(req, body, now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s %s: %s: %s", method, req.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// sign adds a Signature Version 4 Authorization header to req.
+func (s *s3Store) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+	payload := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(payload[:])
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	signed := []string{"host"}
+	canonicalHeaders := "host:" + req.URL.Host + "\n"
+	var names []string
+	for k := range req.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-amz-") || lk == "content-type" {
+			names = append(names, lk)
+		}
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		signed = append(signed, k)
+		canonicalHeaders += k + ":" + strings.TrimSpace(req.Header.Get(k)) + "\n"
+	}
+	signedHeaders := strings.Join(signed, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{date, s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes a query string as Signature Version 4 expects:
+// sorted by key, with spaces as %20.
+func canonicalQuery(q url.Values) string {
+	return strings.ReplaceAll(q.Encode(), "+", "%20")
+}
+
+// azureStore talks to an Azure Blob Storage container, authorised by a
+// shared access signature.
+type azureStore struct {
+	client    *http.Client
+	container string
+	sas       url.Values
+}
+
+// azureVersion is the Blob Storage REST API version requested.
+const azureVersion = "2021-08-06"
+
+func (a *azureStore) put(ctx context.Context, key, contentType string, body []byte) error {
+	resp, err := a.do(ctx, http.MethodPut, "/"+key, nil, body, map[string]string{
+		"Content-Type":   contentType,
+		"x-ms-blob-type": "BlockBlob",
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (a *azureStore) delete(ctx context.Context, key string) error {
+	resp, err := a.do(ctx, http.MethodDelete, "/"+key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (a *azureStore) list(ctx context.Context, prefix string) (map[string]time.Time, error) {
+	out := make(map[string]time.Time)
+	q := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {prefix}}
+	for {
+		resp, err := a.do(ctx, http.MethodGet, "", q, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Blobs struct {
+				Blob []struct {
+					Name       string
+					Properties struct {
+						LastModified string `xml:"Last-Modified"`
+					}
+				}
+			}
+			NextMarker string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range page.Blobs.Blob {
+			modified, err := http.ParseTime(b.Properties.LastModified)
+			if err != nil {
+				return nil, fmt.Errorf("blob %s: %w", b.Name, err)
+			}
+			out[b.Name] = modified
+		}
+		if page.NextMarker == "" {
+			return out, nil
+		}
+		q.Set("marker", page.NextMarker)
+	}
+}
+
+// do sends a request for a blob, or for the container itself with an empty
+// key, failing on any status but 2xx.
+func (a *azureStore) do(ctx context.Context, method, key string, query url.Values, body []byte, headers map[string]string) (*http.Response, error) {
+	q := url.Values{}
+	for k, v := range a.sas {
+		q[k] = v
+	}
+	for k, v := range query {
+		q[k] = v
+	}
+	req, err := http.NewRequestWithContext(ctx, method, a.container+key+"?"+q.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("x-ms-version", azureVersion)
+	req.Header.Set("User-Agent", userAgent())
+	resp, err := a.client.Do(req)
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			// Not the url.Error itself: its URL carries the SAS token.
+			err = uerr.Err
+		}
+		return nil, fmt.Errorf("%s %s: %w", method, req.URL.Path, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		// The URL carries the SAS token, so only the path is reported.
+		return nil, fmt.Errorf("%s %s: %s: %s", method, req.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Notification events for API server outages, which cannot be recorded as
+// Kubernetes Events.
+const (
+	eventAPIServerUnreachable = "APIServerUnreachable"
+	eventAPIServerReachable   = "APIServerReachable"
+)
+
+// connectivity tracks whether the API server answers the controller's
+// requests, with any status: only requests that get no response at all count
+// as failures.
+type connectivity struct {
+	now func() time.Time
+
+	mu sync.Mutex
+	// failingSince is when requests started failing, zero while they
+	// succeed; lastErr is the latest failure.
+	failingSince time.Time
+	lastErr      error
+}
+
+// wrap is a rest.Config WrapTransport recording every request's outcome.
+func (k *connectivity) wrap(rt http.RoundTripper) http.RoundTripper {
+	return &connectivityTransport{connectivity: k, next: rt}
+}
+
+type connectivityTransport struct {
+	connectivity *connectivity
+	next         http.RoundTripper
+}
+
+func (t *connectivityTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil && (req.Context().Err() != nil || errors.Is(err, context.Canceled)) {
+		// Given up on by the controller, not refused by the network.
+		return resp, err
+	}
+	t.connectivity.record(err)
+	return resp, err
+}
+
+// record notes the outcome of a request.
+func (k *connectivity) record(err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err == nil {
+		k.failingSince, k.lastErr = time.Time{}, nil
+		return
+	}
+	if k.failingSince.IsZero() {
+		k.failingSince = k.now()
+	}
+	k.lastErr = err
+}
+
+// failing returns when requests started failing, and the latest error, or a
+// zero time if they succeed.
+func (k *connectivity) failing() (time.Time, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.failingSince, k.lastErr
+}
+
+// apiOutage is a period the API server could not be reached for longer than
+// API_OUTAGE_ALERT_AFTER, reported on /report. End is nil while it lasts.
+type apiOutage struct {
+	Start time.Time  `json:"start"`
+	End   *time.Time `json:"end,omitempty"`
+	// Seconds is how long it lasted, or has so far.
+	Seconds float64 `json:"seconds"`
+	// Error is the last error seen while it lasted.
+	Error string `json:"error"`
+}
+
+// watchConnectivity checks every SYNC_INTERVAL whether the API server has been
+// unreachable for API_OUTAGE_ALERT_AFTER, until ctx is done.
+func (c *NodeLifeSupportController) watchConnectivity(ctx context.Context) {
+	ticker := time.NewTicker(c.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if since, _ := c.connectivity.failing(); !since.IsZero() {
+			// Nothing else may be calling the API server: ask it directly
+			// rather than go on the last failure alone.
+			probeCtx, cancel := c.opContext(ctx)
+			_ = c.client.Discovery().RESTClient().Get().AbsPath("/version").Do(probeCtx).Error()
+			cancel()
+		}
+		c.checkConnectivity(ctx, c.now())
+	}
+}
+
+// checkConnectivity alerts through WEBHOOK_URL, logs and records an outage
+// once the API server has been unreachable for API_OUTAGE_ALERT_AFTER at now,
+// and again when it is reachable after one.
+func (c *NodeLifeSupportController) checkConnectivity(ctx context.Context, now time.Time) {
+	since, lastErr := c.connectivity.failing()
+
+	c.mu.Lock()
+	var current *apiOutage
+	if n := len(c.outages); n > 0 && c.outages[n-1].End == nil {
+		current = &c.outages[n-1]
+	}
+	switch {
+	case current == nil && !since.IsZero() && now.Sub(since) >= c.opts.APIOutageAlertAfter:
+		c.outages = append(c.outages, apiOutage{Start: since.UTC(), Seconds: now.Sub(since).Seconds(), Error: lastErr.Error()})
+		c.pruneOutages(now)
+		c.mu.Unlock()
+		apiUnreachable.WithLabelValues(c.opts.Cluster).Set(1)
+		msg := fmt.Sprintf("API server unreachable since %s: %v", since.UTC().Format(time.RFC3339), lastErr)
+		c.logf("%s", msg)
+		c.sendNotification(ctx, notification{Event: eventAPIServerUnreachable, Message: msg})
+	case current != nil && since.IsZero():
+		end := now.UTC()
+		current.End, current.Seconds = &end, end.Sub(current.Start).Seconds()
+		outage := *current
+		c.mu.Unlock()
+		apiUnreachable.WithLabelValues(c.opts.Cluster).Set(0)
+		msg := fmt.Sprintf("API server reachable again after %s unreachable", time.Duration(outage.Seconds*float64(time.Second)).Round(time.Second))
+		c.logf("%s", msg)
+		c.sendNotification(ctx, notification{Event: eventAPIServerReachable, Message: msg})
+	case current != nil:
+		current.Seconds, current.Error = now.Sub(current.Start).Seconds(), lastErr.Error()
+		c.mu.Unlock()
+	default:
+		c.mu.Unlock()
+	}
+}
+
+// pruneOutages drops outages that ended before REPORT_RETENTION. c.mu must be
+// held.
+func (c *NodeLifeSupportController) pruneOutages(now time.Time) {
+	cutoff := now.Add(-c.opts.ReportRetention)
+	i := 0
+	for i < len(c.outages) && c.outages[i].End != nil && c.outages[i].End.Before(cutoff) {
+		i++
+	}
+	c.outages = c.outages[i:]
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// TestConnectivityTransport tests that requests getting no response count as
+// failures, and those cancelled by the controller or answered with any status
+// do not.
+func TestConnectivityTransport(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	k := &connectivity{now: func() time.Time { return t0 }}
+	var err error
+	rt := k.wrap(roundTripFunc(func(*http.Request) (*http.Response, error) {
+		if err != nil {
+			return nil, err
+		}
+		return &http.Response{StatusCode: http.StatusServiceUnavailable}, nil
+	}))
+	do := func(ctx context.Context) {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://apiserver/version", nil)
+		_, _ = rt.RoundTrip(req)
+	}
+
+	err = errors.New("connection refused")
+	do(context.Background())
+	if since, lastErr := k.failing(); !since.Equal(t0) || lastErr != err {
+		t.Errorf("failing() = %v, %v after a refused request", since, lastErr)
+	}
+	k.now = func() time.Time { return t0.Add(time.Minute) }
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = context.Canceled
+	do(ctx)
+	if since, lastErr := k.failing(); !since.Equal(t0) || lastErr == context.Canceled {
+		t.Errorf("failing() = %v, %v after a cancelled request", since, lastErr)
+	}
+	err = nil
+	do(context.Background())
+	if since, _ := k.failing(); !since.IsZero() {
+		t.Errorf("still failing since %v after a 503", since)
+	}
+}
+
+// TestCheckConnectivity tests that an outage is alerted on once it lasts
+// API_OUTAGE_ALERT_AFTER, again when it ends, and reported.
+func TestCheckConnectivity(t *testing.T) {
+	received := make(chan notification, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		received <- n
+	}))
+	defer srv.Close()
+
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	c := &NodeLifeSupportController{
+		opts:         Options{Cluster: "outage", APIOutageAlertAfter: time.Minute, ReportRetention: time.Hour},
+		notifier:     newWebhookNotifier(srv.URL),
+		connectivity: &connectivity{now: func() time.Time { return t0 }},
+	}
+	ctx := context.Background()
+	c.connectivity.record(errors.New("i/o timeout"))
+
+	c.checkConnectivity(ctx, t0.Add(59*time.Second))
+	select {
+	case n := <-received:
+		t.Fatalf("alerted before API_OUTAGE_ALERT_AFTER: %+v", n)
+	default:
+	}
+	c.checkConnectivity(ctx, t0.Add(time.Minute))
+	n := <-received
+	if n.Event != eventAPIServerUnreachable || n.Message != "API server unreachable since 2024-05-01T12:00:00Z: i/o timeout" {
+		t.Errorf("alert = %+v", n)
+	}
+	if got := testutil.ToFloat64(apiUnreachable.WithLabelValues("outage")); got != 1 {
+		t.Errorf("api_unreachable = %v during the outage", got)
+	}
+	c.checkConnectivity(ctx, t0.Add(2*time.Minute))
+
+	c.connectivity.record(nil)
+	c.checkConnectivity(ctx, t0.Add(3*time.Minute))
+	n = <-received
+	if n.Event != eventAPIServerReachable || n.Message != "API server reachable again after 3m0s unreachable" {
+		t.Errorf("recovery = %+v", n)
+	}
+	if got := testutil.ToFloat64(apiUnreachable.WithLabelValues("outage")); got != 0 {
+		t.Errorf("api_unreachable = %v after the outage", got)
+	}
+	select {
+	case n := <-received:
+		t.Errorf("alerted twice: %+v", n)
+	default:
+	}
+
+	f := &fleet{}
+	f.register("outage", c, nil)
+	var buf bytes.Buffer
+	writeReport(&buf, f.report(t0.Add(10*time.Minute), time.Hour))
+	if want := "    2024-05-01T12:00:00Z to 2024-05-01T12:03:00Z, 3m0s: i/o timeout\n"; !strings.Contains(buf.String(), want) {
+		t.Errorf("report missing %q:\n%s", want, buf.String())
+	}
+	if r := f.report(t0.Add(2*time.Hour), time.Hour); len(r.Clusters[0].Outages) != 0 {
+		t.Errorf("outage reported outside the window: %+v", r.Clusters[0].Outages)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// Halves of a node sync that can fail on their own, e.g. because RBAC or an
+// admission webhook rejects one but not the other.
+const (
+	failingLease  = "lease"
+	failingStatus = "status"
+)
+
+// fallbackLeaseDuration is the duration to schedule the next sync by when the
+// lease could not be renewed, and so its duration is unknown.
+func fallbackLeaseDuration(seconds int32) time.Duration {
+	if seconds == 0 {
+		seconds = defaultLeaseDurationSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// syncErrorList is a node sync's errors, joined on one line unlike
+// errors.Join, as they are logged in cycle summaries.
+type syncErrorList []error
+
+func (l syncErrorList) Error() string {
+	msgs := make([]string, len(l))
+	for i, err := range l {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (l syncErrorList) Unwrap() []error { return l }
+
+// joinErrors combines the non-nil errs, returning nil if there are none.
+func joinErrors(errs ...error) error {
+	var l syncErrorList
+	for _, err := range errs {
+		if err != nil {
+			l = append(l, err)
+		}
+	}
+	switch len(l) {
+	case 0:
+		return nil
+	case 1:
+		return l[0]
+	}
+	return l
+}
+
+// recordPartialSync notes which half of a node's otherwise successful sync
+// failed, if either, logging when that changes rather than every cycle. The
+// failing half is shown in /status and counted in
+// node_life_support_partial_sync_nodes.
+func (c *NodeLifeSupportController) recordPartialSync(name string, leaseErr, statusErr error) {
+	failing, err := "", error(nil)
+	switch {
+	case leaseErr != nil:
+		failing, err = failingLease, leaseErr
+	case statusErr != nil:
+		failing, err = failingStatus, statusErr
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st, ok := c.nodes[name]
+	if !ok {
+		return
+	}
+	if err != nil {
+		st.lastError = err.Error()
+	}
+	if st.failing == failing {
+		return
+	}
+	switch failing {
+	case failingLease:
+		c.logf("node %s: lease renewals failing, still updating node status: %v", name, err)
+	case failingStatus:
+		c.logf("node %s: node status updates failing, still renewing its lease: %v", name, err)
+	default:
+		c.logf("node %s: %s updates recovered", name, st.failing)
+	}
+	st.failing = failing
+	c.updatePartialSyncMetric()
+}
+
+// updatePartialSyncMetric must be called with c.mu held.
+func (c *NodeLifeSupportController) updatePartialSyncMetric() {
+	counts := map[string]int{failingLease: 0, failingStatus: 0}
+	for _, st := range c.nodes {
+		if st.failing != "" {
+			counts[st.failing]++
+		}
+	}
+	for half, n := range counts {
+		partialSyncNodes.WithLabelValues(c.opts.Cluster, half).Set(float64(n))
+	}
+}
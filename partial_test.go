@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// TestPartialSync tests that the lease and the node status are kept up even
+// while the other fails, that the sync only fails when both do, and that the
+// failing half is reported.
+func TestPartialSync(t *testing.T) {
+	forbidden := func(resource string) k8stesting.ReactionFunc {
+		return func(k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: resource}, "node1", errors.New("denied"))
+		}
+	}
+	tests := []struct {
+		name        string
+		leaseFails  bool
+		statusFails bool
+		wantErr     bool
+		wantFailing string
+	}{
+		{name: "healthy"},
+		{name: "lease failing", leaseFails: true, wantFailing: failingLease},
+		{name: "status failing", statusFails: true, wantFailing: failingStatus},
+		{name: "both failing", leaseFails: true, statusFails: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1"}}
+			client := fake.NewSimpleClientset(node)
+			if tt.leaseFails {
+				client.PrependReactor("get", "leases", forbidden("leases"))
+			}
+			if tt.statusFails {
+				client.PrependReactor("patch", "nodes", forbidden("nodes"))
+			}
+			c := &NodeLifeSupportController{client: client, opts: Options{HolderIdentity: HolderIdentityNode, Cluster: "partial-test"}}
+			ctx := context.Background()
+			meta := &metav1.PartialObjectMetadata{ObjectMeta: node.ObjectMeta}
+			c.engage("node1")
+
+			errs := newSyncErrors()
+			if ok := c.syncAndLog(ctx, meta, errs); ok == tt.wantErr {
+				t.Fatalf("sync succeeded = %v, want %v: %s", ok, !tt.wantErr, errs)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !tt.leaseFails {
+				if _, err := client.CoordinationV1().Leases(nodeLeaseNamespace).Get(ctx, "node1", metav1.GetOptions{}); err != nil {
+					t.Errorf("lease not renewed: %v", err)
+				}
+			}
+			if !tt.statusFails {
+				n, _ := client.CoreV1().Nodes().Get(ctx, "node1", metav1.GetOptions{})
+				if len(n.Status.Conditions) == 0 {
+					t.Error("node status not updated")
+				}
+			}
+
+			cs := &clusterStatus{}
+			c.fillStatus(cs, time.Now())
+			if got := cs.Nodes[0].Failing; got != tt.wantFailing {
+				t.Errorf("status failing = %q, want %q", got, tt.wantFailing)
+			}
+			if got := cs.Nodes[0].LastError != ""; got != (tt.wantFailing != "") {
+				t.Errorf("status lastError = %q", cs.Nodes[0].LastError)
+			}
+			for _, half := range []string{failingLease, failingStatus} {
+				want := 0.0
+				if half == tt.wantFailing {
+					want = 1
+				}
+				if got := testutil.ToFloat64(partialSyncNodes.WithLabelValues("partial-test", half)); got != want {
+					t.Errorf("partial sync nodes failing %s = %v, want %v", half, got, want)
+				}
+			}
+		})
+	}
+}
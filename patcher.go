@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Patcher is one kind of change the controller makes to nodes on life
+// support. Each sync runs the patchers enabled for the node in registry
+// order, and disengaging undoes every one of them. To add a kind of change,
+// implement Patcher and add it to patchers.
+type Patcher interface {
+	// Name identifies the patcher in a policy's patchers.
+	Name() string
+	// Default reports whether the patcher runs for nodes whose policy does
+	// not say.
+	Default(o *Options) bool
+	// Patch makes the change to the node being synced.
+	Patch(ctx context.Context, c *NodeLifeSupportController, s *nodeSync) error
+	// Unpatch undoes it for a node leaving life support, whether or not it
+	// ran for the node, so turning a patcher off also cleans up after it.
+	Unpatch(ctx context.Context, c *NodeLifeSupportController, name string) error
+}
+
+// keepalive is implemented by patchers that keep a node looking healthy on
+// their own. Their errors only fail a sync if those of every other keepalive
+// patcher that ran do too; errors of other patchers always do.
+type keepalive interface {
+	// half names what is failing in /status when only this patcher is, see
+	// recordPartialSync.
+	half() string
+}
+
+// patchers is the registry of Patchers, in the order they run.
+var patchers = []Patcher{LeasePatcher{}, CordonPatcher{}, TaintPatcher{}, LabelPatcher{}, ConditionPatcher{}}
+
+// Patcher names.
+const (
+	patcherLease      = "lease"
+	patcherCordon     = "cordon"
+	patcherTaint      = "taint"
+	patcherLabel      = "label"
+	patcherConditions = "conditions"
+)
+
+// errPatchSkipped is returned by a patcher with nothing to do this sync, so
+// it does not count as having run.
+var errPatchSkipped = errors.New("patch skipped")
+
+// nodeSync is a node sync in progress, shared by the patchers run for it.
+type nodeSync struct {
+	node   *metav1.PartialObjectMetadata
+	policy *Policy
+	// leaseDuration is set by the lease patcher, to schedule the next sync
+	// by.
+	leaseDuration time.Duration
+}
+
+// patcherNamed returns the registered patcher called name, or nil.
+func patcherNamed(name string) Patcher {
+	for _, p := range patchers {
+		if p.Name() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// patcherEnabled reports whether patcher p runs for nodes under policy pol
+// (nil for none).
+func (o *Options) patcherEnabled(p Patcher, pol *Policy) bool {
+	if pol != nil {
+		if on, ok := pol.Patchers[p.Name()]; ok {
+			return on
+		}
+	}
+	return p.Default(o)
+}
+
+// patcherConfigured reports whether patcher p runs for any node.
+func (o *Options) patcherConfigured(p Patcher) bool {
+	if p.Default(o) {
+		return true
+	}
+	for _, pol := range o.Policies {
+		if pol.Patchers[p.Name()] {
+			return true
+		}
+	}
+	return false
+}
+
+// runPatchers runs the patchers enabled for s's node. It returns the errors
+// of the keepalive patchers that failed, by half, unless all that ran did,
+// in which case, or if any other patcher fails, it returns the sync's error.
+func (c *NodeLifeSupportController) runPatchers(ctx context.Context, s *nodeSync) (map[string]error, error) {
+	halves := make(map[string]error)
+	ran := 0
+	for _, p := range patchers {
+		if !c.opts.patcherEnabled(p, s.policy) {
+			continue
+		}
+		err := p.Patch(ctx, c, s)
+		if errors.Is(err, errPatchSkipped) {
+			continue
+		}
+		ka, isKeepalive := p.(keepalive)
+		if !isKeepalive {
+			if err != nil {
+				return nil, joinErrors(append(halfErrors(halves), err)...)
+			}
+			continue
+		}
+		ran++
+		if err != nil {
+			halves[ka.half()] = err
+		}
+	}
+	if ran > 0 && len(halves) == ran {
+		return nil, joinErrors(halfErrors(halves)...)
+	}
+	return halves, nil
+}
+
+// halfErrors returns the errors of failing halves, lease first.
+func halfErrors(halves map[string]error) []error {
+	return []error{halves[failingLease], halves[failingStatus]}
+}
+
+// LeasePatcher renews the node's lease.
+type LeasePatcher struct{}
+
+func (LeasePatcher) Name() string          { return patcherLease }
+func (LeasePatcher) Default(*Options) bool { return true }
+func (LeasePatcher) half() string          { return failingLease }
+
+func (LeasePatcher) Patch(ctx context.Context, c *NodeLifeSupportController, s *nodeSync) error {
+	d, err := c.UpdateLease(ctx, s.node)
+	if err != nil {
+		return fmt.Errorf("update lease: %w", err)
+	}
+	s.leaseDuration = d
+	c.recordLeaseExpiry(s.node.Name, c.now().Add(d))
+	c.audit(s.node.Name, auditLeaseRenewed, fmt.Sprintf("leaseDurationSeconds=%d", int(d.Seconds())))
+	return nil
+}
+
+func (LeasePatcher) Unpatch(ctx context.Context, c *NodeLifeSupportController, name string) error {
+	return c.ReleaseLease(ctx, name)
+}
+
+// CordonPatcher cordons the node, with CORDON, or makes it schedulable if its
+// policy's schedulability says so.
+type CordonPatcher struct{}
+
+func (CordonPatcher) Name() string            { return patcherCordon }
+func (CordonPatcher) Default(o *Options) bool { return o.Cordon }
+
+func (CordonPatcher) Patch(ctx context.Context, c *NodeLifeSupportController, s *nodeSync) error {
+	unschedulable := s.policy == nil || s.policy.Schedulability != SchedulabilitySchedulable
+	if err := c.cordon(ctx, s.node.Name, unschedulable); err != nil {
+		return fmt.Errorf("cordon: %w", err)
+	}
+	return nil
+}
+
+func (CordonPatcher) Unpatch(ctx context.Context, c *NodeLifeSupportController, name string) error {
+	return c.uncordon(ctx, name)
+}
+
+// TaintPatcher adds the assistedTaint to the node, with ASSISTED_TAINT.
+type TaintPatcher struct{}
+
+func (TaintPatcher) Name() string            { return patcherTaint }
+func (TaintPatcher) Default(o *Options) bool { return o.AssistedTaint }
+
+func (TaintPatcher) Patch(ctx context.Context, c *NodeLifeSupportController, s *nodeSync) error {
+	if err := c.taintAssisted(ctx, s.node.Name); err != nil {
+		return fmt.Errorf("taint: %w", err)
+	}
+	return nil
+}
+
+func (TaintPatcher) Unpatch(ctx context.Context, c *NodeLifeSupportController, name string) error {
+	return c.untaintAssisted(ctx, name)
+}
+
+// LabelPatcher adds the activeLabel to the node, with ACTIVE_LABEL.
+type LabelPatcher struct{}
+
+func (LabelPatcher) Name() string            { return patcherLabel }
+func (LabelPatcher) Default(o *Options) bool { return o.ActiveLabel }
+
+func (LabelPatcher) Patch(ctx context.Context, c *NodeLifeSupportController, s *nodeSync) error {
+	if err := c.labelActive(ctx, s.node.Name); err != nil {
+		return fmt.Errorf("label: %w", err)
+	}
+	return nil
+}
+
+func (LabelPatcher) Unpatch(ctx context.Context, c *NodeLifeSupportController, name string) error {
+	return c.unlabelActive(ctx, name)
+}
+
+// ConditionPatcher asserts the node's Ready condition, and any others its
+// policy and condition rule add. It skips nodes while the controller is
+// degraded, and new nodes until their READINESS_GATES pods are running.
+type ConditionPatcher struct{}
+
+func (ConditionPatcher) Name() string          { return patcherConditions }
+func (ConditionPatcher) Default(*Options) bool { return true }
+func (ConditionPatcher) half() string          { return failingStatus }
+
+func (ConditionPatcher) Patch(ctx context.Context, c *NodeLifeSupportController, s *nodeSync) error {
+	if c.isDegraded() {
+		return errPatchSkipped
+	}
+	pending, err := c.pendingReadinessGates(ctx, s.node)
+	if err != nil {
+		return fmt.Errorf("check readiness gates: %w", err)
+	}
+	if len(pending) > 0 {
+		return errPatchSkipped
+	}
+	err = c.ForceNodeReady(ctx, s.node)
+	if errors.Is(err, errPatchVetoed) {
+		// PATCH_HOOK_FILE chose not to assert readiness this time.
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("update node status: %w", err)
+	}
+	c.audit(s.node.Name, auditReadyAsserted, "")
+	return nil
+}
+
+func (ConditionPatcher) Unpatch(ctx context.Context, c *NodeLifeSupportController, name string) error {
+	return c.clearLifeSupportCondition(ctx, name)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// TestPolicyPatchers tests that a policy can turn patchers on and off for
+// its nodes, and that disengaging undoes those it turned on.
+func TestPolicyPatchers(t *testing.T) {
+	policies, err := loadPolicies(writePolicyFile(t, `
+policies:
+  - name: quiet
+    patchers:
+      cordon: true
+      conditions: false
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := fake.NewSimpleClientset(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1"}})
+	c := &NodeLifeSupportController{
+		client:       client,
+		opts:         Options{HolderIdentity: HolderIdentityNode, Policies: policies},
+		nodePolicies: map[string]string{"node1": "quiet"},
+	}
+	ctx := context.Background()
+	meta := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1"}}
+
+	c.engage("node1")
+	if err := c.SyncNode(ctx, meta); err != nil {
+		t.Fatalf("SyncNode() error: %v", err)
+	}
+	n, err := client.CoreV1().Nodes().Get(ctx, "node1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !n.Spec.Unschedulable {
+		t.Errorf("node not cordoned by its policy")
+	}
+	if ready := nodeCondition(t, client, "node1", v1.NodeReady); ready != nil {
+		t.Errorf("Ready asserted with conditions off: %+v", ready)
+	}
+	if _, err := client.CoordinationV1().Leases(nodeLeaseNamespace).Get(ctx, "node1", metav1.GetOptions{}); err != nil {
+		t.Errorf("lease not renewed: %v", err)
+	}
+
+	if err := c.disengage(ctx, "node1", disengageOperatorDisabled); err != nil {
+		t.Fatalf("disengage() error: %v", err)
+	}
+	if n, _ := client.CoreV1().Nodes().Get(ctx, "node1", metav1.GetOptions{}); n.Spec.Unschedulable {
+		t.Errorf("node still cordoned after disengaging")
+	}
+}
+
+// TestRunPatchers tests that a sync only fails if every keepalive patcher
+// that ran does, or any other patcher does.
+func TestRunPatchers(t *testing.T) {
+	type write struct{ resource, subresource string }
+	tests := []struct {
+		name      string
+		opts      Options
+		failing   []write
+		expectErr bool
+		halves    int
+	}{
+		{name: "ok"},
+		{name: "lease failing", failing: []write{{"leases", ""}}, halves: 1},
+		{name: "status failing", failing: []write{{"nodes", "status"}}, halves: 1},
+		{name: "both failing", failing: []write{{"leases", ""}, {"nodes", "status"}}, expectErr: true},
+		{name: "cordon failing", opts: Options{Cordon: true}, failing: []write{{"nodes", ""}}, expectErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1"}})
+			for _, w := range tt.failing {
+				client.PrependReactor("*", w.resource, func(a k8stesting.Action) (bool, runtime.Object, error) {
+					if a.GetVerb() == "get" || a.GetSubresource() != w.subresource {
+						return false, nil, nil
+					}
+					return true, nil, errors.New("denied")
+				})
+			}
+			tt.opts.HolderIdentity = HolderIdentityNode
+			c := &NodeLifeSupportController{client: client, opts: tt.opts}
+			c.engage("node1")
+			s := &nodeSync{node: &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1"}}}
+			halves, err := c.runPatchers(context.Background(), s)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("runPatchers() error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if len(halves) != tt.halves {
+				t.Errorf("failing halves = %v, want %d", halves, tt.halves)
+			}
+		})
+	}
+}
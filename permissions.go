@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// permission is an API access the controller needs, checked with a
+// SelfSubjectAccessReview.
+type permission struct {
+	verb        string
+	group       string
+	resource    string
+	subresource string
+	namespace   string
+	name        string
+	// feature is the setting that needs it, empty for the core controller.
+	feature string
+}
+
+// String reads like RBAC, e.g. "patch nodes/status" or "create
+// leases.coordination.k8s.io in kube-node-lease".
+func (p permission) String() string {
+	s := p.verb + " " + p.resource
+	if p.group != "" {
+		s += "." + p.group
+	}
+	if p.subresource != "" {
+		s += "/" + p.subresource
+	}
+	if p.name != "" {
+		s += " " + p.name
+	}
+	if p.namespace != "" {
+		s += " in " + p.namespace
+	}
+	return s
+}
+
+// requiredPermissions lists everything the enabled features need.
+func (c *NodeLifeSupportController) requiredPermissions() []permission {
+	o := c.opts
+	ps := []permission{
+		{verb: "list", resource: "nodes"},
+		{verb: "watch", resource: "nodes"},
+		{verb: "get", resource: "nodes"},
+		{verb: "create", resource: "events"},
+		// Repeated Events are aggregated into one.
+		{verb: "patch", resource: "events"},
+	}
+	if o.ImpersonateNodes {
+		// Leases and node status are written as the node itself.
+		ps = append(ps,
+			permission{verb: "impersonate", resource: "users", feature: "IMPERSONATE_NODES"},
+			permission{verb: "impersonate", resource: "groups", name: "system:nodes", feature: "IMPERSONATE_NODES"},
+			permission{verb: "impersonate", resource: "groups", name: "system:authenticated", feature: "IMPERSONATE_NODES"},
+		)
+	} else {
+		ps = append(ps,
+			permission{verb: "get", group: "coordination.k8s.io", resource: "leases", namespace: nodeLeaseNamespace},
+			permission{verb: "create", group: "coordination.k8s.io", resource: "leases", namespace: nodeLeaseNamespace},
+			permission{verb: "patch", group: "coordination.k8s.io", resource: "leases", namespace: nodeLeaseNamespace},
+			permission{verb: "patch", resource: "nodes", subresource: "status"},
+		)
+	}
+	if c.watchHeartbeats() {
+		for _, verb := range []string{"list", "watch"} {
+			ps = append(ps, permission{verb: verb, group: "coordination.k8s.io", resource: "leases", namespace: nodeLeaseNamespace,
+				feature: "ENGAGE_GRACE_PERIOD/RECOVERY_COOLDOWN"})
+		}
+	}
+	if o.LeaseGCInterval > 0 {
+		for _, verb := range []string{"list", "delete"} {
+			ps = append(ps, permission{verb: verb, group: "coordination.k8s.io", resource: "leases", namespace: nodeLeaseNamespace,
+				feature: "LEASE_GC_INTERVAL"})
+		}
+	}
+	for _, l := range o.KeepAliveLeases {
+		for _, verb := range []string{"get", "patch"} {
+			ps = append(ps, permission{verb: verb, group: "coordination.k8s.io", resource: "leases", namespace: l.Namespace, name: l.Name,
+				feature: "KEEPALIVE_LEASES"})
+		}
+	}
+	if o.LeaderElection {
+		for _, verb := range []string{"get", "create", "update"} {
+			ps = append(ps, permission{verb: verb, group: "coordination.k8s.io", resource: "leases", namespace: o.Namespace,
+				feature: "LEADER_ELECTION"})
+		}
+	}
+	if o.HeartbeatLeaseDuration > 0 {
+		for _, verb := range []string{"get", "create", "update"} {
+			ps = append(ps, permission{verb: verb, group: "coordination.k8s.io", resource: "leases", namespace: o.Namespace,
+				feature: "HEARTBEAT_LEASE_DURATION"})
+		}
+	}
+	if o.Sharding {
+		for _, verb := range []string{"get", "list", "create", "update"} {
+			ps = append(ps, permission{verb: verb, group: "coordination.k8s.io", resource: "leases", namespace: o.Namespace,
+				feature: "SHARDING_ENABLED"})
+		}
+	}
+	if o.StateConfigMap != "" {
+		// RBAC cannot name an object not yet created, so only get and patch
+		// are scoped to the ConfigMap, as in the shipped ClusterRole.
+		ps = append(ps, permission{verb: "create", resource: "configmaps", namespace: o.Namespace, feature: "STATE_CONFIGMAP"})
+		for _, verb := range []string{"get", "patch"} {
+			ps = append(ps, permission{verb: verb, resource: "configmaps", namespace: o.Namespace, name: o.StateConfigMap,
+				feature: "STATE_CONFIGMAP"})
+		}
+	}
+	if o.patcherConfigured(CordonPatcher{}) {
+		ps = append(ps, permission{verb: "patch", resource: "nodes", feature: "CORDON"})
+	}
+	if o.patcherConfigured(TaintPatcher{}) {
+		ps = append(ps, permission{verb: "update", resource: "nodes", feature: "ASSISTED_TAINT"})
+	}
+	if o.patcherConfigured(LabelPatcher{}) {
+		ps = append(ps, permission{verb: "patch", resource: "nodes", feature: "ACTIVE_LABEL"})
+	}
+	if o.DrainOnGiveUp {
+		ps = append(ps,
+			permission{verb: "update", resource: "nodes", feature: "DRAIN_ON_GIVE_UP"},
+			permission{verb: "list", resource: "pods", feature: "DRAIN_ON_GIVE_UP"},
+			permission{verb: "create", resource: "pods", subresource: "eviction", feature: "DRAIN_ON_GIVE_UP"},
+			permission{verb: "list", group: "policy", resource: "poddisruptionbudgets", feature: "DRAIN_ON_GIVE_UP"},
+		)
+		for _, p := range o.Policies {
+			if p.DisruptionBudgets == DisruptionBudgetsForce {
+				ps = append(ps, permission{verb: "delete", resource: "pods", feature: "disruptionBudgets: Force"})
+				break
+			}
+		}
+	}
+	if o.CleanUpMirrorPods {
+		ps = append(ps, permission{verb: "delete", resource: "pods", feature: "CLEAN_UP_MIRROR_PODS"})
+	}
+	if o.PodInventoryInterval > 0 {
+		ps = append(ps, permission{verb: "list", resource: "pods", feature: "POD_INVENTORY_INTERVAL"})
+	}
+	if len(o.ReadinessGates) > 0 {
+		ps = append(ps, permission{verb: "list", resource: "pods", feature: "READINESS_GATES"})
+	}
+	if o.MarkPodsNotReady {
+		ps = append(ps,
+			permission{verb: "list", resource: "pods", feature: "MARK_PODS_NOT_READY"},
+			permission{verb: "patch", resource: "pods", subresource: "status", feature: "MARK_PODS_NOT_READY"},
+		)
+	}
+	return ps
+}
+
+// missingPermissions asks the API server which required permissions the
+// controller lacks, describing each with the feature needing it.
+func (c *NodeLifeSupportController) missingPermissions(ctx context.Context) ([]string, error) {
+	seen := make(map[string]struct{})
+	var missing []string
+	for _, p := range c.requiredPermissions() {
+		if _, ok := seen[p.String()]; ok {
+			continue
+		}
+		seen[p.String()] = struct{}{}
+		review := &authorizationv1.SelfSubjectAccessReview{Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace:   p.namespace,
+				Verb:        p.verb,
+				Group:       p.group,
+				Resource:    p.resource,
+				Subresource: p.subresource,
+				Name:        p.name,
+			},
+		}}
+		opCtx, cancel := c.opContext(ctx)
+		res, err := c.client.AuthorizationV1().SelfSubjectAccessReviews().Create(opCtx, review, metav1.CreateOptions{})
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("checking %s: %w", p, err)
+		}
+		if res.Status.Allowed {
+			continue
+		}
+		msg := p.String()
+		if p.feature != "" {
+			msg += " (for " + p.feature + ")"
+		}
+		missing = append(missing, msg)
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+// checkPermissions implements RBAC_CHECK: every missing permission is
+// logged, counted in node_life_support_missing_permissions and listed in
+// /status, and returned.
+func (c *NodeLifeSupportController) checkPermissions(ctx context.Context) ([]string, error) {
+	missing, err := c.missingPermissions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.lackingPermissions = missing
+	c.mu.Unlock()
+	missingPermissions.WithLabelValues(c.opts.Cluster).Set(float64(len(missing)))
+	for _, m := range missing {
+		c.logf("missing permission: %s", m)
+	}
+	return missing, nil
+}
+
+// startPermissionCheck checks permissions before the controller starts,
+// failing if any are missing, then again every RBAC_CHECK_INTERVAL to catch
+// RBAC changed later. Should the check itself fail, the controller starts
+// anyway rather than depend on it.
+func (c *NodeLifeSupportController) startPermissionCheck(ctx context.Context) error {
+	missing, err := c.checkPermissions(ctx)
+	if err != nil {
+		c.logf("permission check: %v", err)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing permissions: %s", strings.Join(missing, ", "))
+	}
+	if c.opts.RBACCheckInterval > 0 {
+		go c.runPermissionCheck(ctx, c.opts.RBACCheckInterval)
+	}
+	return nil
+}
+
+func (c *NodeLifeSupportController) runPermissionCheck(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := c.checkPermissions(ctx); err != nil {
+			c.logf("permission check: %v", err)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/yaml"
+)
+
+// accessClient returns a clientset answering SelfSubjectAccessReviews, denying
+// the permissions in denied (as rendered by permission.String).
+func accessClient(denied ...string) *fake.Clientset {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		a := review.Spec.ResourceAttributes
+		p := permission{verb: a.Verb, group: a.Group, resource: a.Resource, subresource: a.Subresource, namespace: a.Namespace, name: a.Name}
+		review.Status.Allowed = true
+		for _, d := range denied {
+			if p.String() == d {
+				review.Status.Allowed = false
+			}
+		}
+		return true, review, nil
+	})
+	return client
+}
+
+// TestStartPermissionCheck tests that the controller refuses to start without
+// the permissions its enabled features need, naming them.
+func TestStartPermissionCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		denied  []string
+		missing []string
+	}{
+		{name: "all allowed", opts: Options{Cordon: true}},
+		{name: "node status", denied: []string{"patch nodes/status"}, missing: []string{"patch nodes/status"}},
+		{name: "feature", opts: Options{Cordon: true}, denied: []string{"patch nodes"}, missing: []string{"patch nodes (for CORDON)"}},
+		{name: "feature disabled", denied: []string{"patch nodes"}},
+		{name: "impersonating", opts: Options{ImpersonateNodes: true}, denied: []string{"patch nodes/status", "impersonate users"},
+			missing: []string{"impersonate users (for IMPERSONATE_NODES)"}},
+		{name: "keep-alive lease", opts: Options{KeepAliveLeases: []keepAliveLease{{Namespace: "kube-system", Name: "cilium"}}},
+			denied:  []string{"patch leases.coordination.k8s.io cilium in kube-system"},
+			missing: []string{"patch leases.coordination.k8s.io cilium in kube-system (for KEEPALIVE_LEASES)"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &NodeLifeSupportController{client: accessClient(tt.denied...), opts: tt.opts}
+			err := c.startPermissionCheck(context.Background())
+			if (err != nil) != (len(tt.missing) > 0) {
+				t.Fatalf("startPermissionCheck() error = %v, want missing %v", err, tt.missing)
+			}
+			for _, m := range tt.missing {
+				if !strings.Contains(err.Error(), m) {
+					t.Errorf("error %q does not name %q", err, m)
+				}
+			}
+			cs := &clusterStatus{}
+			c.fillStatus(cs, c.now())
+			if !reflect.DeepEqual(cs.MissingPermissions, tt.missing) {
+				t.Errorf("status missingPermissions = %v, want %v", cs.MissingPermissions, tt.missing)
+			}
+		})
+	}
+}
+
+// TestStartPermissionCheckUnavailable tests that the controller starts when
+// its permissions cannot be checked.
+func TestStartPermissionCheckUnavailable(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("connection refused")
+	})
+	c := &NodeLifeSupportController{client: client}
+	if err := c.startPermissionCheck(context.Background()); err != nil {
+		t.Errorf("startPermissionCheck() error = %v, want none", err)
+	}
+}
+
+// TestShippedClusterRole tests that manifests/clusterrole.yaml grants what
+// the default options need, so the startup permission check passes.
+func TestShippedClusterRole(t *testing.T) {
+	raw, err := os.ReadFile("manifests/clusterrole.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var role rbacv1.ClusterRole
+	if err := yaml.UnmarshalStrict(raw, &role); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := LoadOptions()
+	if err != nil {
+		t.Fatalf("LoadOptions() error: %v", err)
+	}
+	c := &NodeLifeSupportController{opts: *opts}
+	for _, p := range c.requiredPermissions() {
+		if !ruleGrants(role.Rules, p) {
+			t.Errorf("%s not granted", p)
+		}
+	}
+}
+
+// ruleGrants reports whether rules allow p, as RBAC authorizes it.
+func ruleGrants(rules []rbacv1.PolicyRule, p permission) bool {
+	resource := p.resource
+	if p.subresource != "" {
+		resource += "/" + p.subresource
+	}
+	for _, r := range rules {
+		if slices.Contains(r.APIGroups, p.group) && slices.Contains(r.Resources, resource) && slices.Contains(r.Verbs, p.verb) &&
+			(len(r.ResourceNames) == 0 || p.name != "" && slices.Contains(r.ResourceNames, p.name)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// persistedNode is what STATE_CONFIGMAP keeps about a node, under its name,
+// so a restarted controller carries on where the last one left off instead
+// of restarting MAX_LIFE_SUPPORT_DURATION.
+type persistedNode struct {
+	EngagedSince time.Time `json:"engagedSince,omitempty"`
+	// Exhausted is set once the node has reached MAX_LIFE_SUPPORT_DURATION
+	// and until it stops being selected.
+	Exhausted bool `json:"exhausted,omitempty"`
+	// SupportedSeconds, Recent and Alerted are the node's supportAccount.
+	SupportedSeconds float64             `json:"supportedSeconds,omitempty"`
+	Recent           []persistedInterval `json:"recent,omitempty"`
+	Alerted          bool                `json:"alerted,omitempty"`
+}
+
+type persistedInterval struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// restoreState loads the state persisted by a previous run. Engaged nodes
+// keep their engagedSince once engaged again; nodes given up on stay so.
+func (c *NodeLifeSupportController) restoreState(ctx context.Context) error {
+	if c.opts.StateConfigMap == "" {
+		return nil
+	}
+	opCtx, cancel := c.opContext(ctx)
+	defer cancel()
+	cm, err := c.client.CoreV1().ConfigMaps(c.opts.Namespace).Get(opCtx, c.opts.StateConfigMap, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.persisted = make(map[string]string, len(cm.Data))
+	for name, raw := range cm.Data {
+		c.persisted[name] = raw
+		var p persistedNode
+		if err := json.Unmarshal([]byte(raw), &p); err != nil {
+			c.logf("node %s: ignoring unreadable persisted state: %v", name, err)
+			continue
+		}
+		if p.SupportedSeconds > 0 || len(p.Recent) > 0 || p.Alerted {
+			a := c.account(name)
+			a.total = time.Duration(p.SupportedSeconds * float64(time.Second))
+			a.alerted = p.Alerted
+			for _, iv := range p.Recent {
+				a.recent = append(a.recent, supportInterval{from: iv.From, to: iv.To})
+			}
+		}
+		if p.Exhausted {
+			if c.exhausted == nil {
+				c.exhausted = make(map[string]struct{})
+			}
+			c.exhausted[name] = struct{}{}
+			continue
+		}
+		if !p.EngagedSince.IsZero() {
+			if c.restored == nil {
+				c.restored = make(map[string]time.Time)
+			}
+			c.restored[name] = p.EngagedSince
+		}
+	}
+	c.logf("restored state of %d nodes from ConfigMap %s/%s", len(cm.Data), c.opts.Namespace, c.opts.StateConfigMap)
+	return nil
+}
+
+// persistState writes what changed in the state since the last cycle to
+// STATE_CONFIGMAP. Only changed keys are patched, and with SHARDING_ENABLED
+// only those of this replica's nodes, so replicas sharing it do not
+// overwrite each other's.
+func (c *NodeLifeSupportController) persistState(ctx context.Context) {
+	if c.opts.StateConfigMap == "" {
+		return
+	}
+	c.mu.Lock()
+	state := make(map[string]*persistedNode)
+	node := func(name string) *persistedNode {
+		p, ok := state[name]
+		if !ok {
+			p = &persistedNode{}
+			state[name] = p
+		}
+		return p
+	}
+	for name, st := range c.nodes {
+		node(name).EngagedSince = st.engagedSince.UTC()
+	}
+	for name := range c.exhausted {
+		node(name).Exhausted = true
+	}
+	for name, a := range c.accounts {
+		if a.total == 0 && !a.alerted {
+			continue
+		}
+		p := node(name)
+		p.SupportedSeconds, p.Alerted = a.total.Seconds(), a.alerted
+		for _, iv := range a.recent {
+			p.Recent = append(p.Recent, persistedInterval{From: iv.from.UTC(), To: iv.to.UTC()})
+		}
+	}
+	current := make(map[string]string, len(state))
+	for name, p := range state {
+		raw, _ := json.Marshal(p)
+		current[name] = string(raw)
+	}
+	// Nodes restored but not resumed in the first cycle no longer qualify.
+	c.restored = nil
+	changes := make(map[string]interface{})
+	for name, raw := range current {
+		if c.persisted[name] != raw {
+			changes[name] = raw
+		}
+	}
+	for name, raw := range c.persisted {
+		if _, ok := current[name]; ok {
+			continue
+		}
+		if c.shard != nil && !c.shard.owns(name) {
+			// Another replica's node; keep it as read.
+			current[name] = raw
+			continue
+		}
+		changes[name] = nil
+	}
+	c.mu.Unlock()
+	if len(changes) == 0 {
+		return
+	}
+
+	if err := c.patchState(ctx, changes); err != nil {
+		c.logf("persisting state to ConfigMap %s/%s: %v", c.opts.Namespace, c.opts.StateConfigMap, err)
+		return
+	}
+	c.mu.Lock()
+	c.persisted = current
+	c.mu.Unlock()
+}
+
+// patchState merge-patches the changed keys into the ConfigMap, creating it
+// if it does not exist yet.
+func (c *NodeLifeSupportController) patchState(ctx context.Context, changes map[string]interface{}) error {
+	raw, err := json.Marshal(map[string]interface{}{"data": changes})
+	if err != nil {
+		return err
+	}
+	configMaps := c.client.CoreV1().ConfigMaps(c.opts.Namespace)
+	return c.retryWrite(ctx, func(ctx context.Context) error {
+		_, err := configMaps.Patch(ctx, c.opts.StateConfigMap, types.MergePatchType, raw, metav1.PatchOptions{})
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		cm := &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      c.opts.StateConfigMap,
+				Namespace: c.opts.Namespace,
+				Labels:    map[string]string{"app.kubernetes.io/name": "node-life-support"},
+			},
+			Data: make(map[string]string),
+		}
+		for name, v := range changes {
+			if s, ok := v.(string); ok {
+				cm.Data[name] = s
+			}
+		}
+		_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			// Created by another replica meanwhile; patch it next time.
+			return apierrors.NewConflict(v1.Resource("configmaps"), c.opts.StateConfigMap, err)
+		}
+		return err
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// TestPersistState tests that a restarted controller resumes engagements with
+// their original engagedSince and keeps nodes given up on exhausted, and that
+// entries it does not own are left alone.
+func TestPersistState(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	opts := Options{StateConfigMap: "state", Namespace: "nls"}
+	// The restarted replica owns node3 but not the node persisted by the
+	// other replica.
+	members := []string{"a", "b"}
+	shard := &shardMembership{identity: shardOwner("node3", members), members: members}
+	elsewhere := "elsewhere"
+	for i := 0; shard.owns(elsewhere); i++ {
+		elsewhere = fmt.Sprintf("elsewhere-%d", i)
+	}
+	client := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "state", Namespace: "nls"},
+		Data:       map[string]string{elsewhere: `{"engagedSince":"2024-05-01T09:00:00Z"}`},
+	})
+	ctx := context.Background()
+
+	first := &NodeLifeSupportController{client: client, opts: opts, clock: clocktesting.NewFakeClock(start)}
+	first.engage("node1")
+	first.engage("node2")
+	first.exhausted = map[string]struct{}{"node3": {}}
+	first.persistState(ctx)
+	if err := first.disengage(ctx, "node2", disengageOperatorDisabled); err != nil {
+		t.Fatal(err)
+	}
+	first.persistState(ctx)
+
+	restart := start.Add(time.Hour)
+	second := &NodeLifeSupportController{client: client, opts: opts, clock: clocktesting.NewFakeClock(restart), shard: shard}
+	if err := second.restoreState(ctx); err != nil {
+		t.Fatalf("restoreState() error: %v", err)
+	}
+	if since := second.engage("node1").engagedSince; !since.Equal(start) {
+		t.Errorf("node1 engagedSince = %v, want %v", since, start)
+	}
+	if since := second.engage("node2").engagedSince; !since.Equal(restart) {
+		t.Errorf("node2 engagedSince = %v, want %v as it was disengaged", since, restart)
+	}
+	if _, ok := second.exhausted["node3"]; !ok {
+		t.Error("node3 no longer exhausted")
+	}
+
+	second.pruneExhausted(nil)
+	second.persistState(ctx)
+	cm, err := client.CoreV1().ConfigMaps("nls").Get(ctx, "state", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"node1", "node2", elsewhere} {
+		if _, ok := cm.Data[name]; !ok {
+			t.Errorf("%s missing from the ConfigMap", name)
+		}
+	}
+	if _, ok := cm.Data["node3"]; ok {
+		t.Error("node3 still persisted after it was pruned")
+	}
+}
+
+// TestPersistStateCreates tests that the ConfigMap is created on first use.
+func TestPersistStateCreates(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	c := &NodeLifeSupportController{client: client, opts: Options{StateConfigMap: "state", Namespace: "nls"}}
+	ctx := context.Background()
+	c.engage("node1")
+	c.persistState(ctx)
+
+	cm, err := client.CoreV1().ConfigMaps("nls").Get(ctx, "state", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("ConfigMap not created: %v", err)
+	}
+	if _, ok := cm.Data["node1"]; !ok {
+		t.Errorf("data = %v, want node1", cm.Data)
+	}
+}
+
+// TestPersistStateRestart tests that nodes engaged before a restart stay
+// engaged with their original engagedSince, though a calendar freeze is in
+// effect, the engagement limit is reached and their leases were renewed
+// within the grace period by the previous instance.
+func TestPersistStateRestart(t *testing.T) {
+	nodes := []*v1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node2", UID: "uid-2"}},
+	}
+	opts := Options{StateConfigMap: "state", Namespace: "nls", EngageGracePeriod: 10 * time.Minute}
+	h := newHarness(t, opts, nodes...)
+	h.sync()
+	for _, n := range nodes {
+		h.state(n.Name)
+	}
+
+	cal, err := loadCalendar(writeCalendarFile(t, `
+exclusions:
+  - name: freeze
+    start: "2024-05-01"
+    end: "2024-05-01"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Calendar = cal
+	opts.EngagementLimit, opts.EngagementLimitWindow = 1, time.Hour
+	restarted := h.restart(opts, time.Minute)
+	restarted.sync()
+	for _, n := range nodes {
+		if since := restarted.state(n.Name).engagedSince; !since.Equal(harnessStart) {
+			t.Errorf("%s engagedSince = %v, want %v", n.Name, since, harnessStart)
+		}
+	}
+	cm, err := h.client.CoreV1().ConfigMaps("nls").Get(h.ctx, "state", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range nodes {
+		if _, ok := cm.Data[n.Name]; !ok {
+			t.Errorf("%s missing from the ConfigMap", n.Name)
+		}
+	}
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	// Embed the IANA timezone database: the distroless runtime image has none.
+	_ "time/tzdata"
+
+	"github.com/google/cel-go/cel"
+	"github.com/robfig/cron/v3"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// PolicyFile is the format of the file named by POLICY_FILE.
+type PolicyFile struct {
+	Policies []*Policy `json:"policies"`
+	// ConditionProfiles are sets of conditions policies can share.
+	ConditionProfiles []*ConditionProfile `json:"conditionProfiles,omitempty"`
+}
+
+// ConditionProfile names a set of conditions to write to the status of nodes
+// on life support, e.g. for every GPU pool, so policies selecting such pools
+// need not repeat them.
+type ConditionProfile struct {
+	Name       string          `json:"name"`
+	Conditions []RuleCondition `json:"conditions"`
+}
+
+// Policy selects a group of nodes and says when they may be put on life
+// support. A node matched by several policies is handled by the one with the
+// highest Priority, then the most specific selector, then the first listed.
+type Policy struct {
+	Name string `json:"name"`
+	// Priority decides between policies matching the same node: the
+	// highest wins. Defaults to 0.
+	Priority int `json:"priority,omitempty"`
+	// NodeSelector selects the nodes the policy applies to; unset selects
+	// every node (subject to NODE_LABEL_ALLOWLIST).
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+	// Expression, if set, is a CEL expression over the Node object, as node,
+	// and the current time, as now, that the nodes selected must also pass,
+	// e.g. "now - timestamp(node.metadata.creationTimestamp) > duration('1h')".
+	Expression string `json:"expression,omitempty"`
+	// Filter, if set, is the path of a WASI module that the nodes selected
+	// must also pass, for site-specific rules; see filterRequest.
+	Filter string `json:"filter,omitempty"`
+	// Timezone is the IANA timezone (e.g. "Europe/London") Windows are
+	// expressed in. Defaults to UTC.
+	Timezone string `json:"timezone,omitempty"`
+	// Windows, if set, restrict life support to these maintenance windows.
+	Windows []Window `json:"windows,omitempty"`
+	// Rollout, if set, limits how quickly nodes are newly engaged under the
+	// policy.
+	Rollout *Rollout `json:"rollout,omitempty"`
+	// RenewInterval and EngageGracePeriod, if set, override RENEW_INTERVAL
+	// and ENGAGE_GRACE_PERIOD for the policy's nodes.
+	RenewInterval     *metav1.Duration `json:"renewInterval,omitempty"`
+	EngageGracePeriod *metav1.Duration `json:"engageGracePeriod,omitempty"`
+	// ConditionProfile, if set, names the ConditionProfile whose conditions
+	// are written to the status of the policy's nodes.
+	ConditionProfile string `json:"conditionProfile,omitempty"`
+	// Conditions are written to the status of the policy's nodes alongside
+	// Ready, or in its place for a Ready one, like those of a ConditionRule,
+	// which take precedence. They take precedence over those of the
+	// ConditionProfile.
+	Conditions []RuleCondition `json:"conditions,omitempty"`
+	// Patchers turns the changes the controller makes to the policy's nodes
+	// on or off, by Patcher name, overriding CORDON, ASSISTED_TAINT and ACTIVE_LABEL.
+	Patchers map[string]bool `json:"patchers,omitempty"`
+	// Schedulability sets the spec.unschedulable of the policy's nodes while
+	// they are on life support, restoring it afterwards: Unschedulable
+	// cordons them, Schedulable uncordons them and Unmanaged leaves it
+	// alone. It is shorthand for patchers.cordon, which unset, with CORDON,
+	// decides.
+	Schedulability string `json:"schedulability,omitempty"`
+	// DisruptionBudgets says what to do about PodDisruptionBudgets refusing
+	// evictions when a node is drained before being given up on: Respect
+	// (the default) leaves the pods, Force deletes them after DRAIN_TIMEOUT.
+	// Either way the blocking budgets are reported.
+	DisruptionBudgets string `json:"disruptionBudgets,omitempty"`
+
+	profile  *ConditionProfile
+	selector labels.Selector
+	// specificity is the number of requirements in the selector.
+	specificity  int
+	program      cel.Program
+	filterModule *wasmModule
+	location     *time.Location
+}
+
+// Window is a recurring period: it opens at each activation of a standard
+// five-field cron Schedule and stays open for Duration.
+type Window struct {
+	Schedule string          `json:"schedule"`
+	Duration metav1.Duration `json:"duration"`
+
+	schedule cron.Schedule
+}
+
+// Schedulability values.
+const (
+	SchedulabilityUnschedulable = "Unschedulable"
+	SchedulabilitySchedulable   = "Schedulable"
+	SchedulabilityUnmanaged     = "Unmanaged"
+)
+
+// DisruptionBudgets values.
+const (
+	DisruptionBudgetsRespect = "Respect"
+	DisruptionBudgetsForce   = "Force"
+)
+
+// loadPolicies reads and validates the policy file at path.
+func loadPolicies(path string) ([]*Policy, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parsePolicies(path, raw)
+}
+
+// parsePolicies validates the policy file read from path.
+func parsePolicies(path string, raw []byte) ([]*Policy, error) {
+	var f PolicyFile
+	if err := yaml.UnmarshalStrict(raw, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	profiles := make(map[string]*ConditionProfile)
+	for i, cp := range f.ConditionProfiles {
+		if cp == nil || cp.Name == "" {
+			return nil, fmt.Errorf("%s: condition profile %d has no name", path, i)
+		}
+		if _, ok := profiles[cp.Name]; ok {
+			return nil, fmt.Errorf("%s: duplicate condition profile %q", path, cp.Name)
+		}
+		if err := validateConditions(cp.Conditions); err != nil {
+			return nil, fmt.Errorf("%s: condition profile %q: %w", path, cp.Name, err)
+		}
+		profiles[cp.Name] = cp
+	}
+	seen := make(map[string]struct{})
+	for i, p := range f.Policies {
+		if p == nil || p.Name == "" {
+			return nil, fmt.Errorf("%s: policy %d has no name", path, i)
+		}
+		if _, ok := seen[p.Name]; ok {
+			return nil, fmt.Errorf("%s: duplicate policy %q", path, p.Name)
+		}
+		seen[p.Name] = struct{}{}
+		if p.ConditionProfile != "" {
+			if p.profile = profiles[p.ConditionProfile]; p.profile == nil {
+				return nil, fmt.Errorf("%s: policy %q: conditionProfile: unknown profile %q", path, p.Name, p.ConditionProfile)
+			}
+		}
+		if err := p.compile(); err != nil {
+			return nil, fmt.Errorf("%s: policy %q: %w", path, p.Name, err)
+		}
+	}
+	return f.Policies, nil
+}
+
+// compile validates the policy and prepares its selector, expression,
+// timezone and schedules.
+func (p *Policy) compile() error {
+	p.selector = labels.Everything()
+	if p.NodeSelector != nil {
+		sel, err := metav1.LabelSelectorAsSelector(p.NodeSelector)
+		if err != nil {
+			return fmt.Errorf("nodeSelector: %w", err)
+		}
+		p.selector = sel
+		reqs, _ := sel.Requirements()
+		p.specificity = len(reqs)
+	}
+
+	if p.Expression != "" {
+		prg, err := compileExpression(p.Expression)
+		if err != nil {
+			return fmt.Errorf("expression: %w", err)
+		}
+		p.program = prg
+	}
+
+	if p.Filter != "" {
+		mod, err := loadWASMModule(context.Background(), p.Filter)
+		if err != nil {
+			return fmt.Errorf("filter: %w", err)
+		}
+		p.filterModule = mod
+	}
+
+	if p.RenewInterval != nil && p.RenewInterval.Duration <= 0 {
+		return fmt.Errorf("renewInterval: must be positive")
+	}
+	if p.EngageGracePeriod != nil && p.EngageGracePeriod.Duration < 0 {
+		return fmt.Errorf("engageGracePeriod: must not be negative")
+	}
+	if err := validateConditions(p.Conditions); err != nil {
+		return err
+	}
+	for name := range p.Patchers {
+		if patcherNamed(name) == nil {
+			return fmt.Errorf("patchers: unknown patcher %q", name)
+		}
+	}
+	if p.Schedulability != "" {
+		if _, ok := p.Patchers[patcherCordon]; ok {
+			return fmt.Errorf("schedulability: cannot be combined with patchers.%s", patcherCordon)
+		}
+		switch p.Schedulability {
+		case SchedulabilityUnschedulable, SchedulabilitySchedulable:
+			p.setPatcher(patcherCordon, true)
+		case SchedulabilityUnmanaged:
+			p.setPatcher(patcherCordon, false)
+		default:
+			return fmt.Errorf("schedulability: must be %s, %s or %s", SchedulabilityUnschedulable, SchedulabilitySchedulable, SchedulabilityUnmanaged)
+		}
+	}
+	if on, ok := p.Patchers[patcherLease]; ok && !on {
+		if on, ok := p.Patchers[patcherConditions]; ok && !on {
+			return fmt.Errorf("patchers: %s and %s cannot both be off", patcherLease, patcherConditions)
+		}
+	}
+
+	p.location = time.UTC
+	if p.Timezone != "" {
+		loc, err := time.LoadLocation(p.Timezone)
+		if err != nil {
+			return fmt.Errorf("timezone: %w", err)
+		}
+		p.location = loc
+	}
+
+	for i := range p.Windows {
+		if err := p.Windows[i].compile(); err != nil {
+			return fmt.Errorf("window %d: %w", i, err)
+		}
+	}
+	if p.Rollout != nil {
+		if err := p.Rollout.validate(); err != nil {
+			return fmt.Errorf("rollout: %w", err)
+		}
+	}
+	switch p.DisruptionBudgets {
+	case "", DisruptionBudgetsRespect, DisruptionBudgetsForce:
+	default:
+		return fmt.Errorf("disruptionBudgets: must be %s or %s", DisruptionBudgetsRespect, DisruptionBudgetsForce)
+	}
+	return nil
+}
+
+// setPatcher turns a patcher on or off for the policy's nodes.
+func (p *Policy) setPatcher(name string, on bool) {
+	if p.Patchers == nil {
+		p.Patchers = make(map[string]bool)
+	}
+	p.Patchers[name] = on
+}
+
+func (w *Window) compile() error {
+	sched, err := parseSchedule(w.Schedule)
+	if err != nil {
+		return fmt.Errorf("schedule %q: %w", w.Schedule, err)
+	}
+	if w.Duration.Duration <= 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+	w.schedule = sched
+	return nil
+}
+
+// parseSchedule parses a standard cron schedule. The cron package panics on
+// some malformed CRON_TZ= prefixes, such as one with nothing after it, which
+// a node's window annotation must not be able to do to the controller.
+func parseSchedule(spec string) (sched cron.Schedule, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			sched, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+	return cron.ParseStandard(spec)
+}
+
+// matches reports whether the policy selects a node with the given labels.
+func (p *Policy) matches(nodeLabels map[string]string) bool {
+	return p.selector.Matches(labels.Set(nodeLabels))
+}
+
+// active reports whether life support is permitted at t: always if the
+// policy has no windows, otherwise only while one of them is open.
+func (p *Policy) active(t time.Time) bool {
+	return windowsOpen(p.Windows, t.In(p.location))
+}
+
+// windowsOpen reports whether any of windows is open at t, or true if there
+// are none.
+func windowsOpen(windows []Window, t time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	for _, w := range windows {
+		if w.open(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// open reports whether the window is open at t. Cron schedules are
+// evaluated in t's location, so t must already be in the policy's timezone.
+func (w *Window) open(t time.Time) bool {
+	start := w.schedule.Next(t.Add(-w.Duration.Duration))
+	return !start.After(t)
+}
+
+// matchingPolicies returns the policies whose selector, expression and filter
+// a node passes, in order of precedence: highest priority, then most specific
+// selector, then as listed.
+func (c *NodeLifeSupportController) matchingPolicies(node *metav1.PartialObjectMetadata) []*Policy {
+	var matched []*Policy
+	for _, p := range c.opts.Policies {
+		if p.matches(node.Labels) && c.expressionMatches(p, node.Name) && c.filterMatches(p, node) {
+			matched = append(matched, p)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if matched[i].Priority != matched[j].Priority {
+			return matched[i].Priority > matched[j].Priority
+		}
+		return matched[i].specificity > matched[j].specificity
+	})
+	return matched
+}
+
+// policyFor returns the policy handling a node, or nil if none matches it.
+func (c *NodeLifeSupportController) policyFor(node *metav1.PartialObjectMetadata) *Policy {
+	if matched := c.matchingPolicies(node); len(matched) > 0 {
+		return matched[0]
+	}
+	return nil
+}
+
+// nodePolicy returns the policy the sync loop last found handling a node,
+// without evaluating expressions and filters again, or nil if none.
+func (c *NodeLifeSupportController) nodePolicy(name string) *Policy {
+	c.mu.Lock()
+	policy, ok := c.nodePolicies[name]
+	c.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	for _, p := range c.opts.Policies {
+		if p.Name == policy {
+			return p
+		}
+	}
+	return nil
+}
+
+// resolvePolicy is policyFor for the sync loop: it also records the policy
+// for /status and, when several policies match the node, warns once with a
+// PolicyOverlap Event saying which won and why.
+func (c *NodeLifeSupportController) resolvePolicy(node *metav1.PartialObjectMetadata) *Policy {
+	matched := c.matchingPolicies(node)
+	if len(matched) == 0 {
+		c.recordPolicies(node.Name, nil)
+		return nil
+	}
+	p := matched[0]
+	if overlap, warn := c.recordPolicies(node.Name, matched); warn {
+		why := "listed first"
+		switch runnerUp := matched[1]; {
+		case p.Priority != runnerUp.Priority:
+			why = fmt.Sprintf("highest priority, %d", p.Priority)
+		case p.specificity != runnerUp.specificity:
+			why = "most specific selector"
+		}
+		c.logf("node %s: policies %s all match; using %q (%s)", node.Name, overlap, p.Name, why)
+		c.nodeEvent(node.Name, node.UID, v1.EventTypeWarning, reasonPolicyOverlap,
+			"Policies %s all match; using %q (%s)", overlap, p.Name, why)
+	}
+	return p
+}
+
+// recordPolicies records the policies matching a node, in precedence order,
+// returning them as a list and whether they overlap anew.
+func (c *NodeLifeSupportController) recordPolicies(name string, matched []*Policy) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.nodePolicies == nil {
+		c.nodePolicies = make(map[string]string)
+		c.policyOverlaps = make(map[string]string)
+	}
+	if len(matched) == 0 {
+		delete(c.nodePolicies, name)
+	} else {
+		c.nodePolicies[name] = matched[0].Name
+	}
+	if len(matched) < 2 {
+		delete(c.policyOverlaps, name)
+		return "", false
+	}
+	names := make([]string, 0, len(matched))
+	for _, m := range matched {
+		names = append(names, m.Name)
+	}
+	overlap := strings.Join(names, ", ")
+	if c.policyOverlaps[name] == overlap {
+		return overlap, false
+	}
+	c.policyOverlaps[name] = overlap
+	return overlap, true
+}
+
+// windowAnnotation overrides the policy's windows for a single node, e.g.
+// "0 2 * * 6/4h" for Saturdays 02:00-06:00. Several windows may be separated
+// by ";", and a schedule may carry its own "CRON_TZ=<zone> " prefix.
+const windowAnnotation = "node-life-support.io/window"
+
+// parseWindowAnnotation parses the value of windowAnnotation. The duration
+// follows the last "/", as cron step expressions may contain "/" too.
+func parseWindowAnnotation(value string) ([]Window, error) {
+	var windows []Window
+	for _, part := range strings.Split(value, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		i := strings.LastIndex(part, "/")
+		if i < 0 {
+			return nil, fmt.Errorf("%q: expected <cron schedule>/<duration>", part)
+		}
+		d, err := time.ParseDuration(part[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", part, err)
+		}
+		w := Window{Schedule: strings.TrimSpace(part[:i]), Duration: metav1.Duration{Duration: d}}
+		if err := w.compile(); err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	if len(windows) == 0 {
+		return nil, fmt.Errorf("no windows")
+	}
+	return windows, nil
+}
+
+// inWindow reports whether life support is permitted for a node at now,
+// taking its window annotation, if valid, over its policy's windows.
+// Annotation schedules are evaluated in the policy's timezone unless they
+// carry CRON_TZ.
+func (c *NodeLifeSupportController) inWindow(name string, annotations map[string]string, p *Policy, now time.Time) bool {
+	var windows []Window
+	loc := time.UTC
+	if p != nil {
+		windows, loc = p.Windows, p.location
+	}
+	if v, ok := annotations[windowAnnotation]; ok {
+		if override, err := c.windowOverride(name, v); err == nil {
+			windows = override
+		} else {
+			c.invalidAnnotation(name, windowAnnotation, v, err)
+		}
+	}
+	return windowsOpen(windows, now.In(loc))
+}
+
+// windowOverride parses a node's window annotation, caching the result.
+func (c *NodeLifeSupportController) windowOverride(name, value string) ([]Window, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.windowOverrides == nil {
+		c.windowOverrides = make(map[string]parsedWindows)
+	}
+	if cached, ok := c.windowOverrides[name]; ok && cached.value == value {
+		return cached.windows, cached.err
+	}
+	windows, err := parseWindowAnnotation(value)
+	c.windowOverrides[name] = parsedWindows{value: value, windows: windows, err: err}
+	return windows, err
+}
+
+// parsedWindows caches the parse result of a node's window annotation.
+type parsedWindows struct {
+	value   string
+	windows []Window
+	err     error
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func writePolicyFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policies.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func labelledNode(name string, labels map[string]string) *metav1.PartialObjectMetadata {
+	return &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+// TestLoadPolicies tests policy file parsing and validation.
+func TestLoadPolicies(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		expectErr bool
+	}{
+		{
+			name: "valid",
+			content: `
+policies:
+  - name: edge
+    nodeSelector:
+      matchLabels:
+        pool: edge
+    timezone: Europe/London
+    windows:
+      - schedule: "0 9 * * 1-5"
+        duration: 8h
+  - name: everything-else
+`,
+		},
+		{name: "missing name", content: "policies:\n  - timezone: UTC\n", expectErr: true},
+		{name: "duplicate name", content: "policies:\n  - name: a\n  - name: a\n", expectErr: true},
+		{name: "unknown timezone", content: "policies:\n  - name: a\n    timezone: Mars/Olympus\n", expectErr: true},
+		{name: "bad schedule", content: "policies:\n  - name: a\n    windows:\n      - schedule: \"every day\"\n        duration: 1h\n", expectErr: true},
+		{name: "missing duration", content: "policies:\n  - name: a\n    windows:\n      - schedule: \"0 2 * * *\"\n", expectErr: true},
+		{name: "bad selector", content: "policies:\n  - name: a\n    nodeSelector:\n      matchLabels:\n        \"not a key\": x\n", expectErr: true},
+		{name: "unknown field", content: "policies:\n  - name: a\n    tz: UTC\n", expectErr: true},
+		{name: "force budgets", content: "policies:\n  - name: a\n    disruptionBudgets: Force\n"},
+		{name: "bad budgets", content: "policies:\n  - name: a\n    disruptionBudgets: Ignore\n", expectErr: true},
+		{name: "overrides", content: "policies:\n  - name: a\n    renewInterval: 5s\n    engageGracePeriod: 1m\n    conditions:\n      - type: NetworkUnavailable\n        status: \"False\"\n"},
+		{name: "zero renew interval", content: "policies:\n  - name: a\n    renewInterval: 0s\n", expectErr: true},
+		{name: "negative grace period", content: "policies:\n  - name: a\n    engageGracePeriod: -1m\n", expectErr: true},
+		{name: "controller condition", content: "policies:\n  - name: a\n    conditions:\n      - type: LifeSupportActive\n        status: \"True\"\n", expectErr: true},
+		{name: "patchers", content: "policies:\n  - name: a\n    patchers:\n      cordon: true\n      lease: false\n"},
+		{name: "unknown patcher", content: "policies:\n  - name: a\n    patchers:\n      annotate: true\n", expectErr: true},
+		{name: "nothing kept alive", content: "policies:\n  - name: a\n    patchers:\n      lease: false\n      conditions: false\n", expectErr: true},
+		{name: "schedulability", content: "policies:\n  - name: a\n    schedulability: Schedulable\n"},
+		{name: "unknown schedulability", content: "policies:\n  - name: a\n    schedulability: Drained\n", expectErr: true},
+		{name: "condition profile", content: "conditionProfiles:\n  - name: gpu\n    conditions:\n      - type: GPUHealthy\n        status: \"True\"\npolicies:\n  - name: a\n    conditionProfile: gpu\n"},
+		{name: "unknown condition profile", content: "policies:\n  - name: a\n    conditionProfile: gpu\n", expectErr: true},
+		{name: "duplicate condition profile", content: "conditionProfiles:\n  - name: gpu\n  - name: gpu\npolicies:\n  - name: a\n", expectErr: true},
+		{name: "bad condition profile", content: "conditionProfiles:\n  - name: gpu\n    conditions:\n      - type: GPUHealthy\n        status: Maybe\npolicies:\n  - name: a\n", expectErr: true},
+		{name: "schedulability and cordon patcher", content: "policies:\n  - name: a\n    schedulability: Unmanaged\n    patchers:\n      cordon: true\n", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadPolicies(writePolicyFile(t, tt.content))
+			if (err != nil) != tt.expectErr {
+				t.Errorf("loadPolicies() error = %v, expectErr %v", err, tt.expectErr)
+			}
+		})
+	}
+}
+
+// TestPolicyActive tests maintenance windows in the policy's timezone.
+func TestPolicyActive(t *testing.T) {
+	policies, err := loadPolicies(writePolicyFile(t, `
+policies:
+  - name: london-business-hours
+    timezone: Europe/London
+    windows:
+      - schedule: "0 9 * * 1-5"
+        duration: 8h
+  - name: utc-business-hours
+    windows:
+      - schedule: "0 9 * * 1-5"
+        duration: 8h
+  - name: always
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	london, utc, always := policies[0], policies[1], policies[2]
+
+	tests := []struct {
+		name     string
+		policy   *Policy
+		at       string
+		expected bool
+	}{
+		// 1 May 2024 is a Wednesday; London is on BST (UTC+1).
+		{name: "london window open", policy: london, at: "2024-05-01T08:30:00Z", expected: true},
+		{name: "london before window", policy: london, at: "2024-05-01T07:30:00Z", expected: false},
+		{name: "london window closed", policy: london, at: "2024-05-01T16:30:00Z", expected: false},
+		{name: "utc before window", policy: utc, at: "2024-05-01T08:30:00Z", expected: false},
+		{name: "utc window open", policy: utc, at: "2024-05-01T16:30:00Z", expected: true},
+		{name: "window start is inclusive", policy: utc, at: "2024-05-01T09:00:00Z", expected: true},
+		{name: "weekend", policy: utc, at: "2024-05-04T12:00:00Z", expected: false},
+		{name: "no windows", policy: always, at: "2024-05-04T12:00:00Z", expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at, err := time.Parse(time.RFC3339, tt.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result := tt.policy.active(at); result != tt.expected {
+				t.Errorf("active(%s) = %v, want %v", tt.at, result, tt.expected)
+			}
+		})
+	}
+}
+
+// TestPolicyFor tests that the first matching policy wins.
+func TestPolicyFor(t *testing.T) {
+	policies, err := loadPolicies(writePolicyFile(t, `
+policies:
+  - name: edge
+    nodeSelector:
+      matchLabels:
+        pool: edge
+  - name: fallback
+    nodeSelector:
+      matchExpressions:
+        - key: pool
+          operator: Exists
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &NodeLifeSupportController{opts: Options{Policies: policies}}
+
+	if p := c.policyFor(labelledNode("node1", map[string]string{"pool": "edge"})); p == nil || p.Name != "edge" {
+		t.Errorf("policyFor(edge) = %v, want edge", p)
+	}
+	if p := c.policyFor(labelledNode("node2", map[string]string{"pool": "core"})); p == nil || p.Name != "fallback" {
+		t.Errorf("policyFor(core) = %v, want fallback", p)
+	}
+	if p := c.policyFor(labelledNode("node3", map[string]string{"other": "x"})); p != nil {
+		t.Errorf("policyFor(unlabelled) = %v, want nil", p.Name)
+	}
+}
+
+// TestParseWindowAnnotation tests the per-node window annotation format.
+func TestParseWindowAnnotation(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		count     int
+		expectErr bool
+	}{
+		{name: "single", value: "0 2 * * 6/4h", count: 1},
+		{name: "cron step", value: "*/30 * * * */10m", count: 1},
+		{name: "several", value: "0 2 * * 6/4h; 0 22 * * 1-5/2h", count: 2},
+		{name: "own timezone", value: "CRON_TZ=America/New_York 0 9 * * *  /1h", count: 1},
+		{name: "no duration", value: "0 2 * * 6", expectErr: true},
+		{name: "bad duration", value: "0 2 * * 6/forever", expectErr: true},
+		{name: "bad schedule", value: "0 2 * */4h", expectErr: true},
+		{name: "empty", value: " ; ", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			windows, err := parseWindowAnnotation(tt.value)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("parseWindowAnnotation() error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if len(windows) != tt.count {
+				t.Errorf("parseWindowAnnotation() returned %d windows, want %d", len(windows), tt.count)
+			}
+		})
+	}
+}
+
+// TestInWindowOverride tests that a node's annotation replaces its policy's windows.
+func TestInWindowOverride(t *testing.T) {
+	policies, err := loadPolicies(writePolicyFile(t, `
+policies:
+  - name: weekdays
+    timezone: Europe/London
+    windows:
+      - schedule: "0 9 * * 1-5"
+        duration: 8h
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := policies[0]
+	c := &NodeLifeSupportController{}
+	saturday := time.Date(2024, 5, 4, 2, 30, 0, 0, time.UTC) // 03:30 BST
+	wednesday := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	override := map[string]string{windowAnnotation: "0 3 * * 6/4h"}
+
+	if c.inWindow("node1", nil, p, saturday) {
+		t.Errorf("policy window open on Saturday")
+	}
+	if !c.inWindow("node1", override, p, saturday) {
+		t.Errorf("override window closed on Saturday 03:30 London")
+	}
+	if c.inWindow("node1", override, p, wednesday) {
+		t.Errorf("override did not replace policy windows")
+	}
+	if !c.inWindow("node1", map[string]string{windowAnnotation: "garbage"}, p, wednesday) {
+		t.Errorf("invalid override should fall back to policy windows")
+	}
+	if !c.inWindow("node2", override, nil, time.Date(2024, 5, 4, 4, 0, 0, 0, time.UTC)) {
+		t.Errorf("override without a policy should use UTC")
+	}
+}
+
+// TestPolicyPrecedence tests that a node matched by several policies is
+// handled by the one with the highest priority, then the most specific
+// selector, then the first listed, with one PolicyOverlap Event per overlap.
+func TestPolicyPrecedence(t *testing.T) {
+	policies, err := loadPolicies(writePolicyFile(t, `
+policies:
+  - name: everything
+  - name: gpu
+    nodeSelector:
+      matchLabels:
+        gpu: "true"
+  - name: gpu-edge
+    nodeSelector:
+      matchLabels:
+        gpu: "true"
+        pool: edge
+  - name: edge
+    nodeSelector:
+      matchLabels:
+        pool: edge
+  - name: pinned
+    priority: 10
+    nodeSelector:
+      matchLabels:
+        pinned: "true"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := record.NewFakeRecorder(10)
+	c := &NodeLifeSupportController{opts: Options{Policies: policies}, recorder: recorder}
+
+	tests := []struct {
+		labels map[string]string
+		policy string
+		event  string
+	}{
+		{labels: nil, policy: "everything"},
+		{labels: map[string]string{"gpu": "true"}, policy: "gpu",
+			event: `Warning PolicyOverlap Policies gpu, everything all match; using "gpu" (most specific selector)`},
+		{labels: map[string]string{"gpu": "true", "pool": "edge"}, policy: "gpu-edge",
+			event: `Warning PolicyOverlap Policies gpu-edge, gpu, edge, everything all match; using "gpu-edge" (most specific selector)`},
+		{labels: map[string]string{"pool": "edge", "pinned": "true"}, policy: "pinned",
+			event: `Warning PolicyOverlap Policies pinned, edge, everything all match; using "pinned" (highest priority, 10)`},
+	}
+	for i, tt := range tests {
+		node := labelledNode(fmt.Sprintf("node%d", i), tt.labels)
+		for cycle := 0; cycle < 2; cycle++ {
+			if p := c.resolvePolicy(node); p == nil || p.Name != tt.policy {
+				t.Errorf("resolvePolicy(%v) = %v, want %s", tt.labels, p, tt.policy)
+			}
+		}
+		if c.nodePolicies[node.Name] != tt.policy {
+			t.Errorf("recorded policy for %v = %q, want %s", tt.labels, c.nodePolicies[node.Name], tt.policy)
+		}
+		var events []string
+		for len(recorder.Events) > 0 {
+			events = append(events, <-recorder.Events)
+		}
+		if tt.event == "" && len(events) > 0 || tt.event != "" && !reflect.DeepEqual(events, []string{tt.event}) {
+			t.Errorf("events for %v = %q, want %q", tt.labels, events, tt.event)
+		}
+	}
+
+	// Equally specific policies fall back to the order they are listed in.
+	policies, err = loadPolicies(writePolicyFile(t, `
+policies:
+  - name: a
+    nodeSelector: {matchLabels: {a: "true"}}
+  - name: b
+    nodeSelector: {matchLabels: {b: "true"}}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.opts.Policies = policies
+	if p := c.resolvePolicy(labelledNode("both", map[string]string{"a": "true", "b": "true"})); p == nil || p.Name != "a" {
+		t.Errorf("resolvePolicy(both) = %v, want a", p)
+	}
+	if ev := <-recorder.Events; ev != `Warning PolicyOverlap Policies a, b all match; using "a" (listed first)` {
+		t.Errorf("event = %q", ev)
+	}
+}
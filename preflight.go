@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+// apiServerReady asks the API server's /readyz whether the control plane is
+// healthy, returning the failed checks if it is not.
+func apiServerReady(ctx context.Context, client rest.Interface) error {
+	var status int
+	body, err := client.Get().AbsPath("/readyz").Param("verbose", "true").Do(ctx).StatusCode(&status).Raw()
+	if status != 0 && status != http.StatusOK {
+		return fmt.Errorf("/readyz returned %d: %s", status, failedChecks(body))
+	}
+	return err
+}
+
+// failedChecks extracts the "[-]<check> failed" lines of a verbose /readyz
+// response.
+func failedChecks(body []byte) string {
+	var failed []string
+	for _, line := range strings.Split(string(body), "\n") {
+		if strings.HasPrefix(line, "[-]") {
+			failed = append(failed, strings.TrimPrefix(line, "[-]"))
+		}
+	}
+	if len(failed) == 0 {
+		return strings.TrimSpace(string(body))
+	}
+	return strings.Join(failed, ", ")
+}
+
+// preflight checks the API server before a sync cycle when PREFLIGHT_CHECK is
+// enabled. While the control plane is degraded, nodes only have their leases
+// renewed and their status is left alone, to take load off the API server.
+func (c *NodeLifeSupportController) preflight(ctx context.Context) {
+	if c.readyz == nil {
+		return
+	}
+	ctx, cancel := c.opContext(ctx)
+	defer cancel()
+	err := c.readyz(ctx)
+
+	c.mu.Lock()
+	was := c.degraded
+	c.degraded = err != nil
+	c.mu.Unlock()
+	degradedMode.WithLabelValues(c.opts.Cluster).Set(boolGauge(err != nil))
+
+	switch {
+	case err != nil && !was:
+		c.logf("API server not ready, renewing leases only until it recovers: %v", err)
+		lifecycleEvent(v1.EventTypeWarning, reasonDegradedModeEntered, "API server not ready, renewing leases only until it recovers: %v", err)
+	case err == nil && was:
+		c.logf("API server ready again, resuming node status updates")
+		lifecycleEvent(v1.EventTypeNormal, reasonDegradedModeExited, "API server ready again, resuming node status updates")
+	}
+}
+
+// isDegraded reports whether the last pre-flight check found the control
+// plane degraded.
+func (c *NodeLifeSupportController) isDegraded() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.degraded
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+// TestAPIServerReady tests the /readyz check against healthy and degraded
+// API servers.
+func TestAPIServerReady(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{name: "ready", status: http.StatusOK, body: "[+]ping ok\nreadyz check passed\n"},
+		{
+			name:    "degraded",
+			status:  http.StatusInternalServerError,
+			body:    "[+]ping ok\n[-]etcd failed: reason withheld\n[+]log ok\nreadyz check failed\n",
+			wantErr: "/readyz returned 500: etcd failed: reason withheld",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/readyz" || r.URL.Query().Get("verbose") != "true" {
+					t.Errorf("unexpected request %s", r.URL)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+			client, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = apiServerReady(context.Background(), client.Discovery().RESTClient())
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("got %v, want ready", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("got %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestDegradedModeSkipsNodeStatus tests that only the lease is renewed while
+// the API server is degraded, and node status resumes once it recovers.
+func TestDegradedModeSkipsNodeStatus(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1"}}
+	client := fake.NewSimpleClientset(node)
+	readyErr := errors.New("etcd failed")
+	c := &NodeLifeSupportController{
+		client: client,
+		opts:   Options{HolderIdentity: HolderIdentityNode},
+		nodes:  make(map[string]*nodeState),
+		readyz: func(context.Context) error { return readyErr },
+	}
+	meta := &metav1.PartialObjectMetadata{ObjectMeta: node.ObjectMeta}
+	ctx := context.Background()
+
+	statusPatches := func() int {
+		n := 0
+		for _, a := range client.Actions() {
+			if a.GetVerb() == "patch" && a.GetResource().Resource == "nodes" && a.GetSubresource() == "status" {
+				n++
+			}
+		}
+		return n
+	}
+
+	c.preflight(ctx)
+	if err := c.SyncNode(ctx, meta); err != nil {
+		t.Fatalf("SyncNode() error: %v", err)
+	}
+	if _, err := client.CoordinationV1().Leases(nodeLeaseNamespace).Get(ctx, "node1", metav1.GetOptions{}); err != nil {
+		t.Errorf("lease not renewed while degraded: %v", err)
+	}
+	if n := statusPatches(); n != 0 {
+		t.Errorf("node status patched %d times while degraded", n)
+	}
+
+	readyErr = nil
+	c.preflight(ctx)
+	if err := c.SyncNode(ctx, meta); err != nil {
+		t.Fatalf("SyncNode() error: %v", err)
+	}
+	if n := statusPatches(); n != 1 {
+		t.Errorf("node status patched %d times after recovery, want 1", n)
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// probeAPIVersion is the version of the probe plugin protocol, sent in every
+// request and expected in every result.
+const probeAPIVersion = "probe.node-life-support.io/v1"
+
+// probeParallelism bounds how many probe plugins run at once.
+const probeParallelism = 8
+
+// probeRequest is written as JSON to a probe plugin's stdin. A plugin is any
+// executable or WASI module (*.wasm) in PROBE_PLUGIN_DIR, or in one of its
+// subdirectories named for an operating system, such as windows, to probe
+// only nodes running it; it checks the machine behind the node by whatever
+// means it has, such as its BMC, SSH or WinRM, and writes a probeResult to
+// stdout.
+type probeRequest struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	// Cluster is set in multi-cluster mode.
+	Cluster string     `json:"cluster,omitempty"`
+	Node    pluginNode `json:"node"`
+}
+
+// pluginNode is the node's metadata as sent to plugins.
+type pluginNode struct {
+	Name string `json:"name"`
+	// OS is the node's kubernetes.io/os label, e.g. linux or windows.
+	OS          string            `json:"os,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// probeResult is what a probe plugin writes to stdout. A plugin that cannot
+// tell, e.g. because the BMC is unreachable, should exit non-zero instead.
+type probeResult struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Healthy    *bool  `json:"healthy"`
+	Message    string `json:"message,omitempty"`
+}
+
+// discoverProbePlugins returns the executables and WASM modules in dir and
+// its subdirectories, for the operating system each is named for, sorted by
+// path. Hidden files are skipped, so plugins can be staged and renamed into
+// place.
+func discoverProbePlugins(dir string) ([]string, error) {
+	plugins, subdirs, err := probePluginsIn(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range subdirs {
+		more, _, err := probePluginsIn(sub)
+		if err != nil {
+			return nil, err
+		}
+		plugins = append(plugins, more...)
+	}
+	sort.Strings(plugins)
+	return plugins, nil
+}
+
+// probePluginsIn returns the plugins directly in dir, and its subdirectories.
+func probePluginsIn(dir string) (plugins, subdirs []string, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		// Follow symlinks, as mounted ConfigMaps and Secrets use them.
+		fi, err := os.Stat(path)
+		if err == nil && fi.IsDir() {
+			subdirs = append(subdirs, path)
+			continue
+		}
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		if fi.Mode().Perm()&0o111 == 0 && !strings.HasSuffix(path, ".wasm") {
+			continue
+		}
+		plugins = append(plugins, path)
+	}
+	return plugins, subdirs, nil
+}
+
+// pluginOS returns the operating system a plugin discovered in dir is for,
+// or "" if it is for every node.
+func pluginOS(dir, plugin string) string {
+	if parent := filepath.Dir(plugin); parent != filepath.Clean(dir) {
+		return filepath.Base(parent)
+	}
+	return ""
+}
+
+// nodeOS returns the operating system a node runs, from its kubernetes.io/os
+// label, or "" if it has none.
+func nodeOS(node *metav1.PartialObjectMetadata) string {
+	return node.Labels[v1.LabelOSStable]
+}
+
+// runProbes probes nodes with the plugins in PROBE_PLUGIN_DIR every interval
+// until ctx is done, except while standing by for the leader lease.
+func (c *NodeLifeSupportController) runProbes(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if !standingBy() {
+			c.probeNodes(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeOutcome is a plugin's last result for a node.
+type probeOutcome struct {
+	healthy bool
+	message string
+}
+
+// probeNodes runs every plugin, rediscovered each time, against the nodes on
+// life support and those a plugin last found unhealthy, keeping each
+// plugin's result for each node. Plugins that fail or time out are logged and
+// counted but otherwise ignored: the node keeps the plugin's last result.
+func (c *NodeLifeSupportController) probeNodes(ctx context.Context) {
+	plugins, err := discoverProbePlugins(c.opts.ProbePluginDir)
+	if err != nil {
+		c.logf("discovering probe plugins: %v", err)
+		return
+	}
+	plugins = c.loadWASMProbes(ctx, plugins)
+	if len(plugins) == 0 {
+		return
+	}
+
+	listed := c.listNodes()
+	c.mu.Lock()
+	var nodes []*metav1.PartialObjectMetadata
+	for _, n := range listed {
+		_, engaged := c.nodes[n.Name]
+		_, failed := c.probeResults[n.Name]
+		if engaged || failed {
+			nodes = append(nodes, n)
+		}
+	}
+	c.mu.Unlock()
+
+	outcomes := make(map[string]map[string]probeOutcome, len(nodes))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, probeParallelism)
+	for _, n := range nodes {
+		outcomes[n.Name] = make(map[string]probeOutcome)
+		for _, plugin := range plugins {
+			if want := pluginOS(c.opts.ProbePluginDir, plugin); want != "" && want != nodeOS(n) {
+				continue
+			}
+			n, plugin := n, plugin
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer func() { <-sem; wg.Done() }()
+				res, err := c.probe(ctx, plugin, n)
+				name := filepath.Base(plugin)
+				mu.Lock()
+				defer mu.Unlock()
+				switch {
+				case err != nil:
+					probeResultsTotal.WithLabelValues(c.opts.Cluster, name, "error").Inc()
+					c.logf("node %s: probe %s: %v", n.Name, name, err)
+				case !*res.Healthy:
+					probeResultsTotal.WithLabelValues(c.opts.Cluster, name, "unhealthy").Inc()
+					outcomes[n.Name][name] = probeOutcome{message: res.Message}
+				default:
+					probeResultsTotal.WithLabelValues(c.opts.Cluster, name, "healthy").Inc()
+					outcomes[n.Name][name] = probeOutcome{healthy: true, message: res.Message}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	current := make(map[string]struct{}, len(plugins))
+	for _, plugin := range plugins {
+		current[filepath.Base(plugin)] = struct{}{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.probeResults == nil {
+		c.probeResults = make(map[string]map[string]probeOutcome)
+	}
+	for name, latest := range outcomes {
+		results := c.probeResults[name]
+		if results == nil {
+			results = make(map[string]probeOutcome)
+		}
+		for plugin, res := range latest {
+			prev, ok := results[plugin]
+			switch {
+			case !res.healthy && (!ok || prev.healthy):
+				c.logf("node %s: unhealthy according to probe %s: %s", name, plugin, res.message)
+			case res.healthy && ok && !prev.healthy:
+				c.logf("node %s: healthy again according to probe %s", name, plugin)
+			}
+			results[plugin] = res
+		}
+		unhealthy := false
+		for plugin, res := range results {
+			if _, ok := current[plugin]; !ok {
+				// The plugin has been removed.
+				delete(results, plugin)
+			} else if !res.healthy {
+				unhealthy = true
+			}
+		}
+		if _, engaged := c.nodes[name]; !engaged && !unhealthy {
+			delete(c.probeResults, name)
+			continue
+		}
+		c.probeResults[name] = results
+	}
+}
+
+// probe runs one plugin against a node, for up to PROBE_TIMEOUT, as a
+// process or, for WASM modules, in the controller.
+func (c *NodeLifeSupportController) probe(ctx context.Context, plugin string, node *metav1.PartialObjectMetadata) (*probeResult, error) {
+	input, err := json.Marshal(probeRequest{
+		APIVersion: probeAPIVersion,
+		Kind:       "ProbeRequest",
+		Cluster:    c.opts.Cluster,
+		Node:       pluginNode{Name: node.Name, OS: nodeOS(node), Labels: node.Labels, Annotations: node.Annotations},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.opts.ProbeTimeout)
+	defer cancel()
+	debug := c.noteDebug(node)
+	if debug {
+		c.logf("node %s: debug: probe %s input %s", node.Name, filepath.Base(plugin), input)
+	}
+	start := time.Now()
+	var out []byte
+	if p, ok := c.wasmProbes[plugin]; ok {
+		out, err = p.module.run(ctx, input)
+	} else {
+		out, err = runExecPlugin(ctx, plugin, input)
+	}
+	if debug {
+		c.logf("node %s: debug: probe %s took %s, output %s, error %v", node.Name, filepath.Base(plugin), time.Since(start).Round(time.Millisecond), out, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var res probeResult
+	if err := json.Unmarshal(out, &res); err != nil {
+		return nil, fmt.Errorf("decoding result: %v", err)
+	}
+	if res.APIVersion != probeAPIVersion || res.Kind != "ProbeResult" {
+		return nil, fmt.Errorf("result is %s %s, want %s ProbeResult", res.APIVersion, res.Kind, probeAPIVersion)
+	}
+	if res.Healthy == nil {
+		return nil, fmt.Errorf("result has no healthy field")
+	}
+	return &res, nil
+}
+
+// runExecPlugin runs an executable plugin with input on stdin, returning its
+// stdout, or an error with the end of its stderr if it fails.
+func runExecPlugin(ctx context.Context, plugin string, input []byte) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, plugin)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Don't wait on children left holding the output open after a timeout.
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		return nil, pluginError(err, stderr.Bytes())
+	}
+	return stdout.Bytes(), nil
+}
+
+// pluginError adds the end of a failed plugin's stderr, if any, to err.
+func pluginError(err error, stderr []byte) error {
+	if len(stderr) > execHookOutput {
+		stderr = stderr[len(stderr)-execHookOutput:]
+	}
+	if msg := strings.TrimSpace(string(stderr)); msg != "" {
+		return fmt.Errorf("%v: %s", err, msg)
+	}
+	return err
+}
+
+// wasmProbe is a compiled WASM probe plugin, recompiled if the file changes.
+type wasmProbe struct {
+	module  *wasmModule
+	modTime time.Time
+}
+
+// loadWASMProbes compiles the WASM plugins among those discovered that are
+// new or changed, and releases those gone, returning the plugins that can
+// be run. It is only called by probeNodes, before any probe runs.
+func (c *NodeLifeSupportController) loadWASMProbes(ctx context.Context, plugins []string) []string {
+	if c.wasmProbes == nil {
+		c.wasmProbes = make(map[string]*wasmProbe)
+	}
+	seen := make(map[string]struct{})
+	var usable []string
+	for _, plugin := range plugins {
+		if !strings.HasSuffix(plugin, ".wasm") {
+			usable = append(usable, plugin)
+			continue
+		}
+		seen[plugin] = struct{}{}
+		fi, err := os.Stat(plugin)
+		if err != nil {
+			c.logf("probe %s: %v", filepath.Base(plugin), err)
+			continue
+		}
+		if p, ok := c.wasmProbes[plugin]; ok && p.modTime.Equal(fi.ModTime()) {
+			usable = append(usable, plugin)
+			continue
+		}
+		if p, ok := c.wasmProbes[plugin]; ok {
+			p.module.close()
+			delete(c.wasmProbes, plugin)
+		}
+		mod, err := loadWASMModule(ctx, plugin)
+		if err != nil {
+			c.logf("probe %s: %v", filepath.Base(plugin), err)
+			continue
+		}
+		c.wasmProbes[plugin] = &wasmProbe{module: mod, modTime: fi.ModTime()}
+		usable = append(usable, plugin)
+	}
+	for plugin, p := range c.wasmProbes {
+		if _, ok := seen[plugin]; !ok {
+			p.module.close()
+			delete(c.wasmProbes, plugin)
+		}
+	}
+	return usable
+}
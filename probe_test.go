@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// nodeInformer returns an informer, never run, with the named nodes in its
+// store.
+func nodeInformer(t *testing.T, names ...string) []cache.SharedIndexInformer {
+	t.Helper()
+	inf := cache.NewSharedIndexInformer(&cache.ListWatch{}, &metav1.PartialObjectMetadata{}, 0, cache.Indexers{})
+	for _, name := range names {
+		if err := inf.GetStore().Add(&metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: name}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return []cache.SharedIndexInformer{inf}
+}
+
+func writePlugin(t *testing.T, dir, name, script string, mode os.FileMode) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// probeResultScript reports node2 unhealthy.
+const probeResultScript = `if grep -q '"name":"node2"'; then
+  echo '{"apiVersion":"probe.node-life-support.io/v1","kind":"ProbeResult","healthy":false,"message":"powered off"}'
+else
+  echo '{"apiVersion":"probe.node-life-support.io/v1","kind":"ProbeResult","healthy":true}'
+fi
+`
+
+// TestProbeNodes tests that nodes on life support are probed with every
+// plugin, that each plugin's result is kept until it returns another, and
+// that failing plugins are only counted.
+func TestProbeNodes(t *testing.T) {
+	dir := t.TempDir()
+	writePlugin(t, dir, "bmc", probeResultScript, 0o700)
+	writePlugin(t, dir, "flaky", "cat > /dev/null\n[ -e \"$(dirname \"$0\")/flaky.ok\" ] || { echo timeout >&2; exit 1; }\necho '{\"apiVersion\":\"probe.node-life-support.io/v1\",\"kind\":\"ProbeResult\",\"healthy\":false}'\n", 0o700)
+	writePlugin(t, dir, "README", "not a plugin", 0o600)
+	writePlugin(t, dir, ".staged", "exit 1", 0o700)
+
+	c := &NodeLifeSupportController{
+		client:    fake.NewSimpleClientset(),
+		informers: nodeInformer(t, "node1", "node2", "node3"),
+		clock:     clocktesting.NewFakeClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)),
+		opts:      Options{Cluster: "probes", ProbePluginDir: dir, ProbeTimeout: 10 * time.Second},
+	}
+	c.engage("node1")
+	c.engage("node2")
+	ctx := context.Background()
+
+	c.probeNodes(ctx)
+	want := map[string]map[string]probeOutcome{
+		"node1": {"bmc": {healthy: true}},
+		"node2": {"bmc": {message: "powered off"}},
+	}
+	if !reflect.DeepEqual(c.probeResults, want) {
+		t.Errorf("probe results = %v, want %v", c.probeResults, want)
+	}
+	if got := testutil.ToFloat64(probeResultsTotal.WithLabelValues("probes", "flaky", "error")); got != 2 {
+		t.Errorf("flaky errors = %v, want 2", got)
+	}
+	if c.conditionRule("node1") != nil || c.conditionRule("node2") != defaultUnhealthyRule {
+		t.Errorf("node1 follows %v, node2 %v", c.conditionRule("node1"), c.conditionRule("node2"))
+	}
+
+	// node2 is disengaged but still probed, and node1 is found unhealthy
+	// by flaky once it works. An error keeps bmc's last result for node2.
+	if err := c.disengage(ctx, "node2", disengageProbeFailed); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "flaky.ok"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	writePlugin(t, dir, "bmc", "exit 1\n", 0o700)
+	c.probeNodes(ctx)
+	want = map[string]map[string]probeOutcome{
+		"node1": {"bmc": {healthy: true}, "flaky": {}},
+		"node2": {"bmc": {message: "powered off"}, "flaky": {}},
+	}
+	if !reflect.DeepEqual(c.probeResults, want) {
+		t.Errorf("probe results = %v, want %v", c.probeResults, want)
+	}
+
+	// Once every plugin finds node2 healthy, or is removed, it is forgotten.
+	writePlugin(t, dir, "bmc", "cat > /dev/null\necho '{\"apiVersion\":\"probe.node-life-support.io/v1\",\"kind\":\"ProbeResult\",\"healthy\":true}'\n", 0o700)
+	if err := os.Remove(filepath.Join(dir, "flaky")); err != nil {
+		t.Fatal(err)
+	}
+	c.probeNodes(ctx)
+	want = map[string]map[string]probeOutcome{"node1": {"bmc": {healthy: true}}}
+	if !reflect.DeepEqual(c.probeResults, want) {
+		t.Errorf("probe results = %v, want %v", c.probeResults, want)
+	}
+	// node3 is neither engaged nor unhealthy, so never probed.
+	if got := testutil.ToFloat64(probeResultsTotal.WithLabelValues("probes", "bmc", "healthy")); got != 3 {
+		t.Errorf("bmc healthy = %v, want 3", got)
+	}
+}
+
+// TestProbe tests how plugin results are validated.
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		name      string
+		script    string
+		healthy   bool
+		expectErr bool
+	}{
+		{name: "healthy", script: `echo '{"apiVersion":"probe.node-life-support.io/v1","kind":"ProbeResult","healthy":true}'`, healthy: true},
+		{name: "unhealthy", script: `echo '{"apiVersion":"probe.node-life-support.io/v1","kind":"ProbeResult","healthy":false}'`},
+		{name: "wrong version", script: `echo '{"apiVersion":"probe.node-life-support.io/v2","kind":"ProbeResult","healthy":true}'`, expectErr: true},
+		{name: "no verdict", script: `echo '{"apiVersion":"probe.node-life-support.io/v1","kind":"ProbeResult"}'`, expectErr: true},
+		{name: "not JSON", script: `echo ok`, expectErr: true},
+		{name: "exit status", script: `echo '{"apiVersion":"probe.node-life-support.io/v1","kind":"ProbeResult","healthy":true}'; exit 2`, expectErr: true},
+		{name: "timeout", script: `sleep 5`, expectErr: true},
+	}
+	dir := t.TempDir()
+	c := &NodeLifeSupportController{opts: Options{ProbeTimeout: 500 * time.Millisecond}}
+	node := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writePlugin(t, dir, "plugin", "cat > /dev/null\n"+tt.script+"\n", 0o700)
+			res, err := c.probe(context.Background(), filepath.Join(dir, "plugin"), node)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("probe() error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if err == nil && *res.Healthy != tt.healthy {
+				t.Errorf("probe() healthy = %v, want %v", *res.Healthy, tt.healthy)
+			}
+		})
+	}
+}
+
+// TestProbeNodeOS tests that plugins in an operating system's subdirectory
+// only probe nodes running it, and that plugins are told each node's.
+func TestProbeNodeOS(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"linux", "windows"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	healthy := "cat > /dev/null\necho '{\"apiVersion\":\"probe.node-life-support.io/v1\",\"kind\":\"ProbeResult\",\"healthy\":true}'\n"
+	writePlugin(t, dir, "bmc", healthy, 0o700)
+	writePlugin(t, filepath.Join(dir, "linux"), "systemd", healthy, 0o700)
+	writePlugin(t, filepath.Join(dir, "windows"), "winrm", `if grep -q '"os":"windows"'; then
+  echo '{"apiVersion":"probe.node-life-support.io/v1","kind":"ProbeResult","healthy":true}'
+else
+  exit 1
+fi
+`, 0o700)
+
+	inf := cache.NewSharedIndexInformer(&cache.ListWatch{}, &metav1.PartialObjectMetadata{}, 0, cache.Indexers{})
+	for name, system := range map[string]string{"lin1": "linux", "win1": "windows", "bare1": ""} {
+		n := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if system != "" {
+			n.Labels = map[string]string{v1.LabelOSStable: system}
+		}
+		if err := inf.GetStore().Add(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := &NodeLifeSupportController{
+		client:    fake.NewSimpleClientset(),
+		informers: []cache.SharedIndexInformer{inf},
+		clock:     clocktesting.NewFakeClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)),
+		opts:      Options{Cluster: "mixed-os", ProbePluginDir: dir, ProbeTimeout: 10 * time.Second},
+	}
+	for _, name := range []string{"lin1", "win1", "bare1"} {
+		c.engage(name)
+	}
+
+	c.probeNodes(context.Background())
+	want := map[string]map[string]probeOutcome{
+		"lin1":  {"bmc": {healthy: true}, "systemd": {healthy: true}},
+		"win1":  {"bmc": {healthy: true}, "winrm": {healthy: true}},
+		"bare1": {"bmc": {healthy: true}},
+	}
+	if !reflect.DeepEqual(c.probeResults, want) {
+		t.Errorf("probe results = %v, want %v", c.probeResults, want)
+	}
+	if got := testutil.ToFloat64(probeResultsTotal.WithLabelValues("mixed-os", "winrm", "error")); got != 0 {
+		t.Errorf("winrm errors = %v, want none", got)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// recordSyncResult counts a node's consecutive sync failures. With
+// QUARANTINE_AFTER, a node the API server keeps rejecting, e.g. because RBAC
+// denies its lease or its status fails validation, is left alone for
+// QUARANTINE_DURATION rather than retried, and logged about, every cycle.
+// Timeouts, conflicts and server errors are not the node's fault and do not
+// count.
+func (c *NodeLifeSupportController) recordSyncResult(name string, err error, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st, ok := c.nodes[name]
+	if !ok {
+		return
+	}
+	if err == nil {
+		if st.failedSyncs > 0 {
+			c.recordTransition(name, transitionRenewedAfterFailure, fmt.Sprintf("after %d failed syncs, last: %s", st.failedSyncs, st.lastError))
+		}
+		st.failures, st.failedSyncs = 0, 0
+		if st.failing == "" {
+			st.lastError = ""
+		}
+		return
+	}
+	st.lastError = err.Error()
+	st.failedSyncs++
+	var status apierrors.APIStatus
+	if c.opts.QuarantineAfter <= 0 || !errors.As(err, &status) || retriable(err) {
+		return
+	}
+	st.failures++
+	if st.failures < c.opts.QuarantineAfter {
+		return
+	}
+	st.quarantinedUntil = now.Add(c.opts.QuarantineDuration)
+	c.logf("node %s: quarantined for %s after %d consecutive failures", name, c.opts.QuarantineDuration, st.failures)
+	c.updateQuarantineMetric(now)
+}
+
+// quarantined reports whether a node must not be synced because it is in
+// quarantine. A node coming out of it gets one attempt before going back in.
+func (c *NodeLifeSupportController) quarantined(name string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st, ok := c.nodes[name]
+	if !ok || st.quarantinedUntil.IsZero() {
+		return false
+	}
+	if now.Before(st.quarantinedUntil) {
+		return true
+	}
+	st.quarantinedUntil = time.Time{}
+	c.updateQuarantineMetric(now)
+	return false
+}
+
+// updateQuarantineMetric must be called with c.mu held.
+func (c *NodeLifeSupportController) updateQuarantineMetric(now time.Time) {
+	n := 0
+	for _, st := range c.nodes {
+		if now.Before(st.quarantinedUntil) {
+			n++
+		}
+	}
+	quarantinedNodes.WithLabelValues(c.opts.Cluster).Set(float64(n))
+}
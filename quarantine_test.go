@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// TestQuarantine tests that a node is quarantined after QUARANTINE_AFTER
+// consecutive failures rejected by the API server, and only those.
+func TestQuarantine(t *testing.T) {
+	leases := schema.GroupResource{Group: "coordination.k8s.io", Resource: "leases"}
+	forbidden := fmt.Errorf("update lease: %w", apierrors.NewForbidden(leases, "node1", errors.New("rbac")))
+	unavailable := fmt.Errorf("update lease: %w", apierrors.NewServiceUnavailable("overloaded"))
+	tests := []struct {
+		name   string
+		after  int
+		errs   []error
+		wantIn bool
+	}{
+		{name: "rejected", after: 3, errs: []error{forbidden, forbidden, forbidden}, wantIn: true},
+		{name: "too few", after: 3, errs: []error{forbidden, forbidden}},
+		{name: "not consecutive", after: 3, errs: []error{forbidden, forbidden, nil, forbidden}},
+		{name: "server errors", after: 3, errs: []error{unavailable, unavailable, unavailable}},
+		{name: "network errors", after: 3, errs: []error{errors.New("connection refused"), errors.New("connection refused"), errors.New("connection refused")}},
+		{name: "disabled", errs: []error{forbidden, forbidden, forbidden}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			c := &NodeLifeSupportController{opts: Options{QuarantineAfter: tt.after, QuarantineDuration: 10 * time.Minute}}
+			c.engage("node1")
+			for _, err := range tt.errs {
+				c.recordSyncResult("node1", err, now)
+			}
+			if got := c.quarantined("node1", now.Add(time.Minute)); got != tt.wantIn {
+				t.Fatalf("quarantined = %v, want %v", got, tt.wantIn)
+			}
+			if !tt.wantIn {
+				return
+			}
+
+			cs := &clusterStatus{}
+			c.fillStatus(cs, now.Add(time.Minute))
+			if cs.Quarantined != 1 || cs.Nodes[0].QuarantinedUntil == nil || cs.Nodes[0].LastError != forbidden.Error() {
+				t.Errorf("status = %+v, node %+v, want node1 quarantined with its error", cs, cs.Nodes[0])
+			}
+
+			// Once the quarantine is over the node gets one attempt, and goes
+			// straight back in if that fails too.
+			later := now.Add(11 * time.Minute)
+			if c.quarantined("node1", later) {
+				t.Fatal("still quarantined after QUARANTINE_DURATION")
+			}
+			c.recordSyncResult("node1", forbidden, later)
+			if !c.quarantined("node1", later.Add(time.Minute)) {
+				t.Error("not quarantined again after another failure")
+			}
+		})
+	}
+}
+
+// TestQuarantineSkipsSync tests that SyncAllNodes leaves a quarantined node
+// alone but keeps it on life support.
+func TestQuarantineSkipsSync(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	client := fake.NewSimpleClientset(node)
+	client.PrependReactor("get", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Group: "coordination.k8s.io", Resource: "leases"}, "node1", errors.New("rbac"))
+	})
+	client.PrependReactor("patch", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "nodes"}, "node1", errors.New("rbac"))
+	})
+	scheme := metadatafake.NewTestScheme()
+	metav1.AddMetaToScheme(scheme)
+	meta := metadatafake.NewSimpleMetadataClient(scheme,
+		&metav1.PartialObjectMetadata{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Node"}, ObjectMeta: node.ObjectMeta},
+	)
+	selectors, err := allowlistSelectors(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &NodeLifeSupportController{client: client, meta: meta, selectors: selectors, opts: Options{
+		HolderIdentity: HolderIdentityNode, SyncInterval: time.Minute,
+		QuarantineAfter: 2, QuarantineDuration: time.Hour,
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := c.Start(ctx); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		c.mu.Lock()
+		if st, ok := c.nodes["node1"]; ok {
+			st.nextSync = time.Time{}
+		}
+		c.mu.Unlock()
+		if err := c.SyncAllNodes(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !c.quarantined("node1", time.Now()) {
+		t.Fatal("node1 not quarantined after two rejected syncs")
+	}
+
+	client.ClearActions()
+	c.mu.Lock()
+	c.nodes["node1"].nextSync = time.Time{}
+	c.mu.Unlock()
+	if err := c.SyncAllNodes(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range client.Actions() {
+		if r := a.GetResource().Resource; r == "leases" || (r == "nodes" && a.GetVerb() == "patch") {
+			t.Errorf("quarantined node synced: %s %s", a.GetVerb(), a.GetResource().Resource)
+		}
+	}
+	if !c.engaged("node1") {
+		t.Error("quarantined node disengaged")
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// freshIntervals is how many SYNC_INTERVALs of lease a node must have left
+// for its sync to be deferred to the next cycle once a cycle overruns.
+const freshIntervals = 3
+
+// syncItem is a node due a sync this cycle.
+type syncItem struct {
+	node *metav1.PartialObjectMetadata
+	// deadline is when the node's lease expires as far as the controller
+	// knows. It is zero if the controller has not renewed the lease since
+	// engaging the node, or its status updates are failing: such nodes may
+	// well be NotReady already.
+	deadline time.Time
+}
+
+// leaseDeadline returns the syncItem deadline of a node.
+func (c *NodeLifeSupportController) leaseDeadline(name string) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st, ok := c.nodes[name]
+	if !ok || st.failing == failingStatus {
+		return time.Time{}
+	}
+	return st.leaseExpiry
+}
+
+// recordLeaseExpiry notes when the lease the controller just renewed for a
+// node expires.
+func (c *NodeLifeSupportController) recordLeaseExpiry(name string, expiry time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if st, ok := c.nodes[name]; ok {
+		st.leaseExpiry = expiry
+	}
+}
+
+// syncQueue syncs the due nodes, those closest to their lease expiring
+// first, returning how many it attempted. Once the cycle, begun at start,
+// has run longer than SYNC_INTERVAL, nodes with freshIntervals of lease left
+// are deferred to the next cycle, so a slow API server delays the nodes that
+// can afford it rather than those about to be marked unreachable. Nodes
+// whose sync fails are not rescheduled, so they are retried every cycle.
+func (c *NodeLifeSupportController) syncQueue(ctx context.Context, items []syncItem, start time.Time, errs *syncErrors) (int, error) {
+	for i := range items {
+		items[i].deadline = c.leaseDeadline(items[i].node.Name)
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].deadline.Before(items[j].deadline) })
+
+	attempts := 0
+	for i, item := range items {
+		if err := ctx.Err(); err != nil {
+			// Shutting down; leave the rest of the cycle.
+			return attempts, err
+		}
+		now := c.now()
+		if now.Sub(start) > c.opts.SyncInterval && item.deadline.After(now.Add(freshIntervals*c.opts.SyncInterval)) {
+			deferred := len(items) - i
+			syncsDeferredTotal.WithLabelValues(c.opts.Cluster).Add(float64(deferred))
+			c.logf("cycle running for %s; deferring %d nodes with fresh leases to the next cycle", now.Sub(start).Round(time.Millisecond), deferred)
+			break
+		}
+		attempts++
+		c.syncAndLog(ctx, item.node, errs)
+	}
+	return attempts, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// TestSyncQueue tests that nodes closest to their lease expiring, or likely
+// NotReady already, are synced first, and that nodes with fresh leases are
+// deferred once a cycle overruns.
+func TestSyncQueue(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	client := fake.NewSimpleClientset()
+	var order []string
+	client.PrependReactor("get", "leases", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		order = append(order, a.(k8stesting.GetAction).GetName())
+		return false, nil, nil
+	})
+	c := &NodeLifeSupportController{
+		client: client,
+		clock:  clocktesting.NewFakeClock(now),
+		opts:   Options{Cluster: "queue", HolderIdentity: HolderIdentityNode, SyncInterval: 5 * time.Second},
+	}
+	expiries := map[string]time.Time{
+		"fresh":    now.Add(time.Hour),
+		"expiring": now.Add(5 * time.Second),
+		"new":      {},
+		"notready": now.Add(time.Hour),
+	}
+	var items []syncItem
+	for _, name := range []string{"fresh", "expiring", "new", "notready"} {
+		if _, err := client.CoreV1().Nodes().Create(context.Background(), &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		c.engage(name).leaseExpiry = expiries[name]
+		items = append(items, syncItem{node: &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: name}}})
+	}
+	c.nodes["notready"].failing = failingStatus
+
+	// The cycle started two intervals ago, so it has overrun.
+	attempts, err := c.syncQueue(context.Background(), items, now.Add(-10*time.Second), newSyncErrors())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"new", "notready", "expiring"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("synced %v, want %v", order, want)
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+	if got := testutil.ToFloat64(syncsDeferredTotal.WithLabelValues("queue")); got != 1 {
+		t.Errorf("deferred = %v, want 1", got)
+	}
+	if !c.due("fresh", now) || c.due("expiring", now) {
+		t.Errorf("fresh due %v, expiring due %v", c.due("fresh", now), c.due("expiring", now))
+	}
+	if got := c.leaseDeadline("expiring"); !got.Equal(now.Add(fallbackLeaseDuration(0))) {
+		t.Errorf("expiring lease deadline = %v after renewal", got)
+	}
+}
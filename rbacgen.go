@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// rbacRule is one rule of a generated ClusterRole or Role: the verbs one
+// feature needs on one resource.
+type rbacRule struct {
+	feature  string
+	group    string
+	resource string
+	name     string
+	verbs    []string
+}
+
+// rbacRules groups permissions into rules by namespace, "" for the
+// ClusterRole, each permission granted once, for the first feature needing
+// it.
+func rbacRules(ps []permission) map[string][]*rbacRule {
+	seen := make(map[string]struct{})
+	rules := make(map[string][]*rbacRule)
+	for _, p := range ps {
+		if _, ok := seen[p.String()]; ok {
+			continue
+		}
+		seen[p.String()] = struct{}{}
+		resource := p.resource
+		if p.subresource != "" {
+			resource += "/" + p.subresource
+		}
+		var rule *rbacRule
+		for _, r := range rules[p.namespace] {
+			if r.feature == p.feature && r.group == p.group && r.resource == resource && r.name == p.name {
+				rule = r
+				break
+			}
+		}
+		if rule == nil {
+			rule = &rbacRule{feature: p.feature, group: p.group, resource: resource, name: p.name}
+			rules[p.namespace] = append(rules[p.namespace], rule)
+		}
+		rule.verbs = append(rule.verbs, p.verb)
+	}
+	return rules
+}
+
+// writeRBAC writes the ClusterRole and Roles for permissions as YAML, each
+// bound to the ServiceAccount serviceAccount in namespace.
+func writeRBAC(out io.Writer, name, namespace, serviceAccount string, ps []permission) {
+	rules := rbacRules(ps)
+	namespaces := make([]string, 0, len(rules))
+	for ns := range rules {
+		if ns != "" {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	sort.Strings(namespaces)
+
+	fmt.Fprintf(out, "# Generated by node-life-support %s rbac-gen for the configuration in its environment.\n", version)
+	writeRBACRole(out, "ClusterRole", name, "", rules[""])
+	writeRBACBinding(out, "ClusterRole", name, "", namespace, serviceAccount)
+	for _, ns := range namespaces {
+		writeRBACRole(out, "Role", name, ns, rules[ns])
+		writeRBACBinding(out, "Role", name, ns, namespace, serviceAccount)
+	}
+}
+
+func writeRBACRole(out io.Writer, kind, name, namespace string, rules []*rbacRule) {
+	fmt.Fprintf(out, "---\napiVersion: rbac.authorization.k8s.io/v1\nkind: %s\n", kind)
+	writeRBACMetadata(out, name, namespace)
+	fmt.Fprintf(out, "rules:\n")
+	for _, r := range rules {
+		if r.feature != "" {
+			fmt.Fprintf(out, "  # for %s\n", r.feature)
+		}
+		fmt.Fprintf(out, "  - apiGroups: %s\n    resources: %s\n    verbs: %s\n",
+			flowList([]string{r.group}), flowList([]string{r.resource}), flowList(r.verbs))
+		if r.name != "" {
+			fmt.Fprintf(out, "    resourceNames: %s\n", flowList([]string{r.name}))
+		}
+	}
+}
+
+func writeRBACBinding(out io.Writer, roleKind, name, namespace, saNamespace, serviceAccount string) {
+	fmt.Fprintf(out, "---\napiVersion: rbac.authorization.k8s.io/v1\nkind: %sBinding\n", roleKind)
+	writeRBACMetadata(out, name, namespace)
+	fmt.Fprintf(out, "roleRef:\n  apiGroup: rbac.authorization.k8s.io\n  kind: %s\n  name: %s\n", roleKind, name)
+	fmt.Fprintf(out, "subjects:\n  - kind: ServiceAccount\n    name: %s\n    namespace: %s\n", serviceAccount, saNamespace)
+}
+
+func writeRBACMetadata(out io.Writer, name, namespace string) {
+	fmt.Fprintf(out, "metadata:\n  name: %s\n", name)
+	if namespace != "" {
+		fmt.Fprintf(out, "  namespace: %s\n", namespace)
+	}
+	fmt.Fprintf(out, "  labels:\n    app.kubernetes.io/name: node-life-support\n    app.kubernetes.io/component: controller\n")
+}
+
+// flowList renders strings as a YAML flow sequence, e.g. ["get", "list"].
+func flowList(items []string) string {
+	quoted := make([]string, len(items))
+	for i, s := range items {
+		b, _ := json.Marshal(s)
+		quoted[i] = string(b)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// runRBACGen implements the rbac-gen command: it prints the least RBAC the
+// configuration in the environment needs, as for the controller itself,
+// with a ClusterRole for cluster-wide access and a Role in each namespace
+// it needs access in, bound to its ServiceAccount.
+func runRBACGen(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("rbac-gen", flag.ContinueOnError)
+	name := fs.String("name", "node-life-support", "name of the ClusterRole, Roles and bindings")
+	serviceAccount := fs.String("service-account", "node-life-support", "ServiceAccount to bind, in POD_NAMESPACE")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("usage: rbac-gen [-name NAME] [-service-account NAME]")
+	}
+	opts, err := LoadOptions()
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	c := &NodeLifeSupportController{opts: *opts}
+	writeRBAC(out, *name, opts.Namespace, *serviceAccount, c.requiredPermissions())
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// TestWriteRBAC tests that generated RBAC grants each required permission
+// once, cluster-wide or in its namespace, and is bound to the ServiceAccount.
+func TestWriteRBAC(t *testing.T) {
+	c := &NodeLifeSupportController{opts: Options{Namespace: "nls", Cordon: true, LeaderElection: true, ImpersonateNodes: true}}
+	var out bytes.Buffer
+	writeRBAC(&out, "nls-rbac", "nls", "nls-sa", c.requiredPermissions())
+
+	var roles []rbacv1.ClusterRole
+	var bindings []rbacv1.ClusterRoleBinding
+	for _, doc := range strings.Split(out.String(), "\n---\n")[1:] {
+		var meta struct{ Kind string }
+		if err := yaml.Unmarshal([]byte(doc), &meta); err != nil {
+			t.Fatalf("%v in:\n%s", err, doc)
+		}
+		switch meta.Kind {
+		case "ClusterRole", "Role":
+			var r rbacv1.ClusterRole
+			if err := yaml.UnmarshalStrict([]byte(doc), &r); err != nil {
+				t.Fatalf("%v in:\n%s", err, doc)
+			}
+			roles = append(roles, r)
+		case "ClusterRoleBinding", "RoleBinding":
+			var b rbacv1.ClusterRoleBinding
+			if err := yaml.UnmarshalStrict([]byte(doc), &b); err != nil {
+				t.Fatalf("%v in:\n%s", err, doc)
+			}
+			bindings = append(bindings, b)
+		default:
+			t.Fatalf("unexpected document:\n%s", doc)
+		}
+	}
+
+	var namespaces []string
+	for _, r := range roles {
+		namespaces = append(namespaces, r.Kind+" "+r.Namespace)
+	}
+	if want := []string{"ClusterRole ", "Role nls"}; !reflect.DeepEqual(namespaces, want) {
+		t.Fatalf("roles = %q, want %q", namespaces, want)
+	}
+	granted := map[string]bool{}
+	for _, r := range roles {
+		for _, rule := range r.Rules {
+			for _, verb := range rule.Verbs {
+				key := strings.Join([]string{r.Namespace, rule.APIGroups[0], rule.Resources[0], strings.Join(rule.ResourceNames, ","), verb}, " ")
+				if granted[key] {
+					t.Errorf("%s granted twice", key)
+				}
+				granted[key] = true
+			}
+		}
+	}
+	for _, key := range []string{
+		"  nodes  patch",
+		"  groups system:nodes impersonate",
+		"nls coordination.k8s.io leases  update",
+	} {
+		if !granted[key] {
+			t.Errorf("%q not granted; granted %v", key, granted)
+		}
+	}
+	if granted["  nodes/status  patch"] {
+		t.Error("nodes/status granted although written as the node")
+	}
+	for _, b := range bindings {
+		if b.RoleRef.Name != "nls-rbac" || len(b.Subjects) != 1 || b.Subjects[0].Name != "nls-sa" || b.Subjects[0].Namespace != "nls" {
+			t.Errorf("%s %s binds %+v to %+v", b.Kind, b.Namespace, b.RoleRef, b.Subjects)
+		}
+	}
+	if len(bindings) != len(roles) {
+		t.Errorf("%d bindings for %d roles", len(bindings), len(roles))
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// readinessGate is a DaemonSet, e.g. the CNI plugin, CSI node driver or
+// kube-proxy, whose pod must be running on a newly registered node before the
+// controller asserts the node Ready: until then it cannot serve the traffic
+// and pods that Ready would bring it.
+type readinessGate struct {
+	Namespace string
+	Name      string
+}
+
+func (g readinessGate) String() string {
+	return g.Namespace + "/" + g.Name
+}
+
+// parseReadinessGates parses READINESS_GATES entries of the form
+// "namespace/name".
+func parseReadinessGates(entries []string) ([]readinessGate, error) {
+	var out []readinessGate
+	for _, e := range entries {
+		ns, name, ok := strings.Cut(strings.TrimSpace(e), "/")
+		if !ok || ns == "" || name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("READINESS_GATES: %q is not namespace/name", e)
+		}
+		out = append(out, readinessGate{Namespace: ns, Name: name})
+	}
+	return out, nil
+}
+
+// pendingReadinessGates returns the READINESS_GATES DaemonSets without a
+// running pod on node, if it registered less than READINESS_GATE_NODE_AGE
+// ago. Once a node passes its gates they are not checked again for the
+// engagement.
+func (c *NodeLifeSupportController) pendingReadinessGates(ctx context.Context, node *metav1.PartialObjectMetadata) ([]string, error) {
+	if len(c.opts.ReadinessGates) == 0 || c.now().Sub(node.CreationTimestamp.Time) >= c.opts.ReadinessGateNodeAge {
+		return nil, nil
+	}
+	c.mu.Lock()
+	st, ok := c.nodes[node.Name]
+	passed := ok && st.gatesPassed
+	c.mu.Unlock()
+	if passed {
+		return nil, nil
+	}
+
+	pods, err := c.nodePods(ctx, node.Name)
+	if err != nil {
+		return nil, err
+	}
+	running := make(map[readinessGate]bool)
+	for _, p := range pods {
+		if p.Status.Phase != v1.PodRunning {
+			continue
+		}
+		if ref := metav1.GetControllerOf(&p); ref != nil && ref.Kind == "DaemonSet" {
+			running[readinessGate{Namespace: p.Namespace, Name: ref.Name}] = true
+		}
+	}
+	var pending []string
+	for _, g := range c.opts.ReadinessGates {
+		if !running[g] {
+			pending = append(pending, g.String())
+		}
+	}
+
+	waiting := strings.Join(pending, ", ")
+	c.mu.Lock()
+	var was string
+	if st, ok := c.nodes[node.Name]; ok {
+		was = st.gatesPending
+		st.gatesPending = waiting
+		st.gatesPassed = len(pending) == 0
+	}
+	c.mu.Unlock()
+	switch {
+	case waiting == was:
+	case len(pending) > 0:
+		c.logf("node %s: not asserting Ready until pods of %s are running on it", node.Name, waiting)
+	default:
+		c.logf("node %s: readiness gates passed", node.Name)
+	}
+	return pending, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// TestReadinessGates tests that a new node is only asserted Ready once a pod
+// of every gating DaemonSet is running on it, and older nodes straight away.
+func TestReadinessGates(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	daemonPod := func(ns, name, ds, node string, phase v1.PodPhase) *v1.Pod {
+		yes := true
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns, OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "apps/v1", Kind: "DaemonSet", Name: ds, Controller: &yes},
+			}},
+			Spec:   v1.PodSpec{NodeName: node},
+			Status: v1.PodStatus{Phase: phase},
+		}
+	}
+	tests := []struct {
+		name      string
+		age       time.Duration
+		pods      []runtime.Object
+		wantReady bool
+	}{
+		{
+			name: "all running",
+			age:  5 * time.Minute,
+			pods: []runtime.Object{
+				daemonPod("kube-system", "kube-proxy-a", "kube-proxy", "node1", v1.PodRunning),
+				daemonPod("kube-system", "cni-a", "cni", "node1", v1.PodRunning),
+			},
+			wantReady: true,
+		},
+		{
+			name: "one pending",
+			age:  5 * time.Minute,
+			pods: []runtime.Object{
+				daemonPod("kube-system", "kube-proxy-a", "kube-proxy", "node1", v1.PodRunning),
+				daemonPod("kube-system", "cni-a", "cni", "node1", v1.PodPending),
+			},
+		},
+		{
+			name: "running elsewhere",
+			age:  5 * time.Minute,
+			pods: []runtime.Object{
+				daemonPod("kube-system", "kube-proxy-a", "kube-proxy", "node1", v1.PodRunning),
+				daemonPod("kube-system", "cni-b", "cni", "node2", v1.PodRunning),
+			},
+		},
+		{
+			name: "same name in another namespace",
+			age:  5 * time.Minute,
+			pods: []runtime.Object{
+				daemonPod("kube-system", "kube-proxy-a", "kube-proxy", "node1", v1.PodRunning),
+				daemonPod("default", "cni-a", "cni", "node1", v1.PodRunning),
+			},
+		},
+		{
+			name:      "old node",
+			age:       2 * time.Hour,
+			wantReady: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1", CreationTimestamp: metav1.NewTime(now.Add(-tt.age))}}
+			client := fake.NewSimpleClientset(append(tt.pods, node)...)
+			c := &NodeLifeSupportController{client: client, clock: clocktesting.NewFakeClock(now), opts: Options{
+				HolderIdentity:       HolderIdentityNode,
+				ReadinessGates:       []readinessGate{{Namespace: "kube-system", Name: "kube-proxy"}, {Namespace: "kube-system", Name: "cni"}},
+				ReadinessGateNodeAge: time.Hour,
+			}}
+			c.engage("node1")
+			if err := c.SyncNode(context.Background(), &metav1.PartialObjectMetadata{ObjectMeta: node.ObjectMeta}); err != nil {
+				t.Fatalf("SyncNode() error: %v", err)
+			}
+			if ready := nodeCondition(t, client, "node1", v1.NodeReady) != nil; ready != tt.wantReady {
+				t.Errorf("Ready asserted = %v, want %v", ready, tt.wantReady)
+			}
+		})
+	}
+}
+
+// TestReadinessGatesPassed tests that the gates are not checked again once a
+// node has passed them.
+func TestReadinessGatesPassed(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1", CreationTimestamp: metav1.NewTime(now)}}
+	client := fake.NewSimpleClientset(node)
+	c := &NodeLifeSupportController{client: client, clock: clocktesting.NewFakeClock(now), opts: Options{
+		HolderIdentity:       HolderIdentityNode,
+		ReadinessGates:       []readinessGate{{Namespace: "kube-system", Name: "kube-proxy"}},
+		ReadinessGateNodeAge: time.Hour,
+	}}
+	ctx := context.Background()
+	meta := &metav1.PartialObjectMetadata{ObjectMeta: node.ObjectMeta}
+	c.engage("node1")
+
+	if pending, err := c.pendingReadinessGates(ctx, meta); err != nil || len(pending) != 1 {
+		t.Fatalf("pendingReadinessGates() = %v, %v; want kube-system/kube-proxy", pending, err)
+	}
+	yes := true
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-proxy-a", Namespace: "kube-system", OwnerReferences: []metav1.OwnerReference{
+			{APIVersion: "apps/v1", Kind: "DaemonSet", Name: "kube-proxy", Controller: &yes},
+		}},
+		Spec:   v1.PodSpec{NodeName: "node1"},
+		Status: v1.PodStatus{Phase: v1.PodRunning},
+	}
+	if _, err := client.CoreV1().Pods("kube-system").Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if pending, err := c.pendingReadinessGates(ctx, meta); err != nil || len(pending) != 0 {
+		t.Fatalf("pendingReadinessGates() = %v, %v; want none", pending, err)
+	}
+
+	client.ClearActions()
+	if pending, err := c.pendingReadinessGates(ctx, meta); err != nil || len(pending) != 0 {
+		t.Fatalf("pendingReadinessGates() = %v, %v; want none", pending, err)
+	}
+	if n := len(client.Actions()); n != 0 {
+		t.Errorf("%d API calls after the gates passed, want none", n)
+	}
+}
+
+func TestParseReadinessGates(t *testing.T) {
+	got, err := parseReadinessGates([]string{"kube-system/kube-proxy", " kube-system/cni "})
+	if err != nil || len(got) != 2 || got[1] != (readinessGate{Namespace: "kube-system", Name: "cni"}) {
+		t.Errorf("parseReadinessGates() = %v, %v", got, err)
+	}
+	for _, bad := range []string{"kube-proxy", "/kube-proxy", "kube-system/", "a/b/c"} {
+		if _, err := parseReadinessGates([]string{bad}); err == nil {
+			t.Errorf("parseReadinessGates(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// approachingLimit is the fraction of MAX_LIFE_SUPPORT_DURATION after which a
+// node on life support is reported as approaching it.
+const approachingLimit = 0.75
+
+// activityReport is served on /report: what the controller did over a recent
+// window, for shift handovers and postmortems.
+type activityReport struct {
+	Since    time.Time        `json:"since"`
+	Until    time.Time        `json:"until"`
+	Clusters []*clusterReport `json:"clusters"`
+}
+
+type clusterReport struct {
+	// Name is empty in single-cluster mode.
+	Name string `json:"name"`
+	// Engaged and Disengaged are the nodes put on and taken off life support
+	// in the window, in order.
+	Engaged    []nodeActivity `json:"engaged"`
+	Disengaged []nodeActivity `json:"disengaged"`
+	// Cycles, Syncs and FailedSyncs count the sync cycles completed and the
+	// node syncs attempted and failed in them; Writes counts successful API
+	// writes: lease renewals, status patches, cordons and the like.
+	Cycles      int `json:"cycles"`
+	Syncs       int `json:"syncs"`
+	FailedSyncs int `json:"failedSyncs"`
+	Writes      int `json:"writes"`
+	// ApproachingLimit are the nodes on life support past three quarters of
+	// MAX_LIFE_SUPPORT_DURATION, soonest to reach it first.
+	ApproachingLimit []nodeLimit `json:"approachingLimit,omitempty"`
+	// Outages are the periods in the window the API server was unreachable
+	// for API_OUTAGE_ALERT_AFTER or longer.
+	Outages []apiOutage `json:"apiOutages,omitempty"`
+}
+
+type nodeActivity struct {
+	Node string    `json:"node"`
+	Time time.Time `json:"time"`
+	// Reason is why a node was disengaged.
+	Reason string `json:"reason,omitempty"`
+}
+
+type nodeLimit struct {
+	Node             string    `json:"node"`
+	EngagedSince     time.Time `json:"engagedSince"`
+	RemainingSeconds float64   `json:"remainingSeconds"`
+}
+
+// activityRecord is an engagement, disengagement or completed sync cycle kept
+// for REPORT_RETENTION.
+type activityRecord struct {
+	at time.Time
+	// node is set for engagements and disengagements.
+	node    string
+	engaged bool
+	// reason is set for disengagements.
+	reason string
+	// syncs, failed and writes are set for cycles.
+	syncs, failed, writes int
+}
+
+// recordActivity keeps a record for /report, dropping those older than
+// REPORT_RETENTION. c.mu must be held.
+func (c *NodeLifeSupportController) recordActivity(r activityRecord) {
+	if c.opts.ReportRetention <= 0 {
+		return
+	}
+	cutoff := r.at.Add(-c.opts.ReportRetention)
+	i := 0
+	for i < len(c.activity) && c.activity[i].at.Before(cutoff) {
+		i++
+	}
+	c.activity = append(c.activity[i:], r)
+}
+
+// fillReport summarises the controller's activity since the given time.
+func (c *NodeLifeSupportController) fillReport(cr *clusterReport, since, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, r := range c.activity {
+		if r.at.Before(since) {
+			continue
+		}
+		switch {
+		case r.node == "":
+			cr.Cycles++
+			cr.Syncs += r.syncs
+			cr.FailedSyncs += r.failed
+			cr.Writes += r.writes
+		case r.engaged:
+			cr.Engaged = append(cr.Engaged, nodeActivity{Node: r.node, Time: r.at.UTC()})
+		default:
+			cr.Disengaged = append(cr.Disengaged, nodeActivity{Node: r.node, Time: r.at.UTC(), Reason: r.reason})
+		}
+	}
+	for _, o := range c.outages {
+		if o.End == nil || !o.End.Before(since) {
+			cr.Outages = append(cr.Outages, o)
+		}
+	}
+
+	limit := c.opts.MaxLifeSupportDuration
+	if limit <= 0 {
+		return
+	}
+	for name, st := range c.nodes {
+		supported := now.Sub(st.engagedSince)
+		if supported < time.Duration(float64(limit)*approachingLimit) {
+			continue
+		}
+		cr.ApproachingLimit = append(cr.ApproachingLimit, nodeLimit{
+			Node:             name,
+			EngagedSince:     st.engagedSince.UTC(),
+			RemainingSeconds: (limit - supported).Seconds(),
+		})
+	}
+	sort.Slice(cr.ApproachingLimit, func(i, j int) bool {
+		a, b := cr.ApproachingLimit[i], cr.ApproachingLimit[j]
+		if a.RemainingSeconds != b.RemainingSeconds {
+			return a.RemainingSeconds < b.RemainingSeconds
+		}
+		return a.Node < b.Node
+	})
+}
+
+// report summarises every registered cluster's activity over the window
+// ending at now.
+func (f *fleet) report(now time.Time, window time.Duration) activityReport {
+	f.mu.Lock()
+	members := append([]*fleetMember(nil), f.members...)
+	f.mu.Unlock()
+
+	r := activityReport{Since: now.Add(-window).UTC(), Until: now.UTC(), Clusters: []*clusterReport{}}
+	for _, m := range members {
+		cr := &clusterReport{Name: m.name, Engaged: []nodeActivity{}, Disengaged: []nodeActivity{}}
+		if m.c != nil {
+			m.c.fillReport(cr, now.Add(-window), now)
+		}
+		r.Clusters = append(r.Clusters, cr)
+	}
+	sort.Slice(r.Clusters, func(i, j int) bool { return r.Clusters[i].Name < r.Clusters[j].Name })
+	return r
+}
+
+// serveReport serves the report for the window given by the window query
+// parameter, e.g. "8h", or the last REPORT_RETENTION. It is JSON unless
+// format=text is asked for.
+func (f *fleet) serveReport(retention time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		window := retention
+		if v := r.URL.Query().Get("window"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				http.Error(w, "window: must be a positive duration, e.g. 8h", http.StatusBadRequest)
+				return
+			}
+			window = d
+		}
+		report := f.report(f.now(), window)
+		if r.URL.Query().Get("format") == "text" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			writeReport(w, report)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(report)
+	}
+}
+
+// writeReport renders a report for people to read.
+func writeReport(w io.Writer, r activityReport) {
+	fmt.Fprintf(w, "node-life-support activity from %s to %s\n", r.Since.Format(time.RFC3339), r.Until.Format(time.RFC3339))
+	for _, cr := range r.Clusters {
+		fmt.Fprintln(w)
+		if cr.Name != "" {
+			fmt.Fprintf(w, "cluster %s\n", cr.Name)
+		}
+		fmt.Fprintf(w, "  sync cycles: %d, node syncs: %d (%d failed), API writes: %d\n", cr.Cycles, cr.Syncs, cr.FailedSyncs, cr.Writes)
+		fmt.Fprintf(w, "  engaged: %d%s\n", len(cr.Engaged), activityList(cr.Engaged))
+		fmt.Fprintf(w, "  disengaged: %d%s\n", len(cr.Disengaged), activityList(cr.Disengaged))
+		if len(cr.ApproachingLimit) > 0 {
+			fmt.Fprintf(w, "  approaching MAX_LIFE_SUPPORT_DURATION:\n")
+			for _, n := range cr.ApproachingLimit {
+				remaining := time.Duration(n.RemainingSeconds * float64(time.Second)).Round(time.Second)
+				fmt.Fprintf(w, "    %s, engaged since %s, %s left\n", n.Node, n.EngagedSince.Format(time.RFC3339), remaining)
+			}
+		}
+		if len(cr.Outages) > 0 {
+			fmt.Fprintf(w, "  API server unreachable:\n")
+			for _, o := range cr.Outages {
+				lasted := time.Duration(o.Seconds * float64(time.Second)).Round(time.Second)
+				if o.End == nil {
+					fmt.Fprintf(w, "    since %s, %s so far: %s\n", o.Start.Format(time.RFC3339), lasted, o.Error)
+					continue
+				}
+				fmt.Fprintf(w, "    %s to %s, %s: %s\n", o.Start.Format(time.RFC3339), o.End.Format(time.RFC3339), lasted, o.Error)
+			}
+		}
+	}
+}
+
+func activityList(nodes []nodeActivity) string {
+	if len(nodes) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for _, n := range nodes {
+		fmt.Fprintf(&b, "\n    %s %s", n.Time.Format(time.RFC3339), n.Node)
+		if n.Reason != "" {
+			fmt.Fprintf(&b, " (%s)", n.Reason)
+		}
+	}
+	return b.String()
+}
+
+// runReport implements the report command: it fetches the text report from
+// a running controller's METRICS_ADDR and prints it.
+func runReport(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	addr := fs.String("addr", "http://localhost:8080", "base URL of the controller's METRICS_ADDR")
+	window := fs.Duration("window", 0, "how far back to report (default: REPORT_RETENTION)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	q := url.Values{"format": {"text"}}
+	if *window > 0 {
+		q.Set("window", window.String())
+	}
+	return fetchText(strings.TrimSuffix(*addr, "/")+"/report?"+q.Encode(), out)
+}
+
+// fetchText copies a text endpoint of a running controller to out.
+func fetchText(u string, out io.Writer) error {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// TestReport tests that the report covers the engagements, disengagements
+// and cycles within its window, and the nodes nearing
+// MAX_LIFE_SUPPORT_DURATION.
+func TestReport(t *testing.T) {
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	clock := clocktesting.NewFakeClock(start)
+	c := &NodeLifeSupportController{
+		opts:  Options{Cluster: "edge", ReportRetention: 24 * time.Hour, MaxLifeSupportDuration: 8 * time.Hour},
+		clock: clock,
+	}
+
+	c.engage("old")
+	c.recordCycle(clock.Now(), 1, 0)
+	clock.Step(2 * time.Hour)
+	c.engage("node1")
+	c.engage("node2")
+	c.writes.Add(4)
+	c.recordCycle(clock.Now(), 3, 1)
+	clock.Step(time.Hour)
+	c.forget("node2")
+	c.writes.Add(2)
+	c.recordCycle(clock.Now(), 2, 0)
+	clock.Step(4 * time.Hour)
+
+	f := &fleet{}
+	f.register("edge", c, nil)
+	r := f.report(clock.Now(), 6*time.Hour)
+	if len(r.Clusters) != 1 {
+		t.Fatalf("clusters = %d, want 1", len(r.Clusters))
+	}
+	cr := r.Clusters[0]
+	if cr.Cycles != 2 || cr.Syncs != 5 || cr.FailedSyncs != 1 || cr.Writes != 6 {
+		t.Errorf("cycles=%d syncs=%d failed=%d writes=%d, want 2, 5, 1, 6", cr.Cycles, cr.Syncs, cr.FailedSyncs, cr.Writes)
+	}
+	if len(cr.Engaged) != 2 || cr.Engaged[0].Node != "node1" || cr.Engaged[1].Node != "node2" {
+		t.Errorf("engaged = %+v, want node1, node2", cr.Engaged)
+	}
+	if len(cr.Disengaged) != 1 || cr.Disengaged[0].Node != "node2" {
+		t.Errorf("disengaged = %+v, want node2", cr.Disengaged)
+	}
+	// old has been engaged 7h of 8h, node1 5h.
+	if len(cr.ApproachingLimit) != 1 || cr.ApproachingLimit[0].Node != "old" || cr.ApproachingLimit[0].RemainingSeconds != time.Hour.Seconds() {
+		t.Errorf("approaching limit = %+v, want old with 1h left", cr.ApproachingLimit)
+	}
+
+	var buf bytes.Buffer
+	writeReport(&buf, r)
+	for _, want := range []string{"cluster edge", "node syncs: 5 (1 failed), API writes: 6", "engaged: 2", "old, engaged since 2024-05-01T00:00:00Z, 1h0m0s left"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("text report missing %q:\n%s", want, buf.String())
+		}
+	}
+
+	// Records older than REPORT_RETENTION are dropped.
+	clock.Step(24 * time.Hour)
+	c.recordCycle(clock.Now(), 0, 0)
+	if len(c.activity) != 1 {
+		t.Errorf("kept %d records past retention, want 1", len(c.activity))
+	}
+}
+
+// TestRunReport tests the report command against a controller's /report.
+func TestRunReport(t *testing.T) {
+	f := &fleet{clock: clocktesting.NewFakeClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))}
+	f.register("", &NodeLifeSupportController{opts: Options{ReportRetention: time.Hour}}, nil)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/report", f.serveReport(time.Hour))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	var out bytes.Buffer
+	if err := runReport([]string{"-addr", srv.URL, "-window", "30m"}, &out); err != nil {
+		t.Fatalf("runReport() error: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "node-life-support activity from 2024-05-01T11:30:00Z to 2024-05-01T12:00:00Z") || !strings.Contains(out.String(), "sync cycles: 0") {
+		t.Errorf("output:\n%s", out.String())
+	}
+
+	resp, err := http.Get(srv.URL + "/report?window=forever")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid window: status %d, want 400", resp.StatusCode)
+	}
+}
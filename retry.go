@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// retryDelay is the wait before the first retry of a failed write; it doubles
+// with every further retry, up to retryMaxDelay.
+var retryDelay = 100 * time.Millisecond
+
+const retryMaxDelay = 2 * time.Second
+
+// retryWrite runs write, with its own API_TIMEOUT, until it succeeds, fails
+// with an error retrying cannot fix, or API_RETRIES retries are used up.
+// Conflicts and server errors are retried after an exponential backoff with
+// jitter, so they are dealt with within the cycle rather than the next one.
+func (c *NodeLifeSupportController) retryWrite(ctx context.Context, write func(context.Context) error) error {
+	backoff := wait.Backoff{
+		Steps:    c.opts.APIRetries + 1,
+		Duration: retryDelay,
+		Factor:   2,
+		Jitter:   0.5,
+		Cap:      retryMaxDelay,
+	}
+	var last error
+	err := wait.ExponentialBackoffWithContext(ctx, backoff, func(ctx context.Context) (bool, error) {
+		opCtx, cancel := c.opContext(ctx)
+		defer cancel()
+		last = write(opCtx)
+		switch {
+		case last == nil:
+			c.writes.Add(1)
+			return true, nil
+		case retriable(last):
+			return false, nil
+		default:
+			return false, last
+		}
+	})
+	if wait.Interrupted(err) && last != nil {
+		// Out of retries, or cancelled while waiting for the next one.
+		return last
+	}
+	return err
+}
+
+// retriable reports whether a write failed with a conflict or a server error
+// that may well not happen again.
+func retriable(err error) bool {
+	if apierrors.IsConflict(err) {
+		return true
+	}
+	var status apierrors.APIStatus
+	return errors.As(err, &status) && status.Status().Code >= 500
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// TestRetryWrite tests that conflicts and server errors are retried, up to
+// API_RETRIES times, and other errors are not.
+func TestRetryWrite(t *testing.T) {
+	defer func(d time.Duration) { retryDelay = d }(retryDelay)
+	retryDelay = time.Millisecond
+
+	nodes := schema.GroupResource{Resource: "nodes"}
+	tests := []struct {
+		name      string
+		retries   int
+		errs      []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "success", retries: 3, wantCalls: 1},
+		{name: "conflict", retries: 3, errs: []error{apierrors.NewConflict(nodes, "node1", errors.New("modified"))}, wantCalls: 2},
+		{name: "server errors", retries: 3, errs: []error{
+			apierrors.NewInternalError(errors.New("etcd")),
+			apierrors.NewServiceUnavailable("overloaded"),
+			apierrors.NewServerTimeout(nodes, "patch", 1),
+		}, wantCalls: 4},
+		{name: "retries used up", retries: 1, errs: []error{
+			apierrors.NewServiceUnavailable("overloaded"),
+			apierrors.NewServiceUnavailable("overloaded"),
+		}, wantCalls: 2, wantErr: true},
+		{name: "disabled", errs: []error{apierrors.NewServiceUnavailable("overloaded")}, wantCalls: 1, wantErr: true},
+		{name: "not found", retries: 3, errs: []error{apierrors.NewNotFound(nodes, "node1")}, wantCalls: 1, wantErr: true},
+		{name: "forbidden", retries: 3, errs: []error{apierrors.NewForbidden(nodes, "node1", errors.New("rbac"))}, wantCalls: 1, wantErr: true},
+		{name: "other error", retries: 3, errs: []error{errors.New("connection refused")}, wantCalls: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &NodeLifeSupportController{opts: Options{APIRetries: tt.retries}}
+			calls := 0
+			err := c.retryWrite(context.Background(), func(context.Context) error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("%d calls, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+// TestRetryWriteCancelled tests that nothing is attempted once the context is
+// done.
+func TestRetryWriteCancelled(t *testing.T) {
+	c := &NodeLifeSupportController{opts: Options{APIRetries: 3}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	err := c.retryWrite(ctx, func(context.Context) error {
+		calls++
+		return apierrors.NewServiceUnavailable("overloaded")
+	})
+	if err == nil || calls != 0 {
+		t.Errorf("err = %v after %d calls, want an error without any", err, calls)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Rollout stages new engagements under a policy, limiting the blast radius of
+// a selector that suddenly matches far more nodes than intended.
+type Rollout struct {
+	// Canary nodes are engaged first; no further nodes are engaged until
+	// CanaryPeriod has passed after the last of them.
+	Canary       int             `json:"canary,omitempty"`
+	CanaryPeriod metav1.Duration `json:"canaryPeriod,omitempty"`
+	// After the canaries, at most BatchSize nodes are newly engaged per
+	// Interval.
+	BatchSize int             `json:"batchSize"`
+	Interval  metav1.Duration `json:"interval"`
+}
+
+func (r *Rollout) validate() error {
+	if r.Canary < 0 {
+		return fmt.Errorf("canary must not be negative")
+	}
+	if r.Canary > 0 && r.CanaryPeriod.Duration <= 0 {
+		return fmt.Errorf("canaryPeriod must be positive when canary is set")
+	}
+	if r.BatchSize <= 0 || r.Interval.Duration <= 0 {
+		return fmt.Errorf("batchSize and interval must be positive")
+	}
+	return nil
+}
+
+// rolloutState tracks a policy's rollout since the controller started.
+type rolloutState struct {
+	canaries   int
+	canaryDone time.Time
+	batchStart time.Time
+	batchCount int
+}
+
+// admit decides whether a node that is not yet on life support may be engaged
+// under policy p at now, counting it against the policy's rollout if so.
+// Nodes without a policy, or under a policy without a rollout, are always
+// admitted.
+//
+// Rollout state is kept in memory, so a restarted controller re-admits the
+// nodes a previous instance had engaged through the rollout as well.
+func (c *NodeLifeSupportController) admit(name string, p *Policy, now time.Time) bool {
+	if p == nil || p.Rollout == nil {
+		return true
+	}
+	r := p.Rollout
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rollouts == nil {
+		c.rollouts = make(map[string]*rolloutState)
+	}
+	st, ok := c.rollouts[p.Name]
+	if !ok {
+		st = &rolloutState{}
+		c.rollouts[p.Name] = st
+	}
+
+	if st.canaries < r.Canary {
+		st.canaries++
+		if st.canaries == r.Canary {
+			st.canaryDone = now
+		}
+		c.logf("node %s: engaging as canary %d/%d of policy %s", name, st.canaries, r.Canary, p.Name)
+		return true
+	}
+	if r.Canary > 0 && now.Before(st.canaryDone.Add(r.CanaryPeriod.Duration)) {
+		return false
+	}
+	if now.Sub(st.batchStart) >= r.Interval.Duration {
+		st.batchStart, st.batchCount = now, 0
+	}
+	if st.batchCount >= r.BatchSize {
+		return false
+	}
+	st.batchCount++
+	return true
+}
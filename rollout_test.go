@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestRolloutValidate tests rollout validation.
+func TestRolloutValidate(t *testing.T) {
+	minute := metav1.Duration{Duration: time.Minute}
+	tests := []struct {
+		name      string
+		rollout   Rollout
+		expectErr string
+	}{
+		{name: "batches", rollout: Rollout{BatchSize: 10, Interval: minute}},
+		{name: "canary", rollout: Rollout{Canary: 2, CanaryPeriod: minute, BatchSize: 10, Interval: minute}},
+		{name: "canary without period", rollout: Rollout{Canary: 2, BatchSize: 10, Interval: minute}, expectErr: "canaryPeriod"},
+		{name: "no batch size", rollout: Rollout{Interval: minute}, expectErr: "batchSize"},
+		{name: "no interval", rollout: Rollout{BatchSize: 10}, expectErr: "interval"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rollout.validate()
+			if tt.expectErr == "" && err != nil {
+				t.Fatalf("validate() error: %v", err)
+			}
+			if tt.expectErr != "" && (err == nil || !strings.Contains(err.Error(), tt.expectErr)) {
+				t.Fatalf("validate() error = %v, want %q", err, tt.expectErr)
+			}
+		})
+	}
+}
+
+// TestAdmit tests that a policy's canaries go first and later nodes are
+// engaged in batches.
+func TestAdmit(t *testing.T) {
+	p := &Policy{Name: "edge", Rollout: &Rollout{
+		Canary:       2,
+		CanaryPeriod: metav1.Duration{Duration: 10 * time.Minute},
+		BatchSize:    3,
+		Interval:     metav1.Duration{Duration: time.Minute},
+	}}
+	c := &NodeLifeSupportController{}
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	admitted := func(now time.Time, candidates int) int {
+		n := 0
+		for i := 0; i < candidates; i++ {
+			if c.admit(fmt.Sprintf("node%d", i), p, now) {
+				n++
+			}
+		}
+		return n
+	}
+
+	if got := admitted(t0, 10); got != 2 {
+		t.Errorf("admitted %d canaries, want 2", got)
+	}
+	if got := admitted(t0.Add(5*time.Minute), 10); got != 0 {
+		t.Errorf("admitted %d during the canary period, want 0", got)
+	}
+	if got := admitted(t0.Add(10*time.Minute), 10); got != 3 {
+		t.Errorf("admitted %d in the first batch, want 3", got)
+	}
+	if got := admitted(t0.Add(10*time.Minute+30*time.Second), 10); got != 0 {
+		t.Errorf("admitted %d within the same interval, want 0", got)
+	}
+	if got := admitted(t0.Add(11*time.Minute), 10); got != 3 {
+		t.Errorf("admitted %d in the second batch, want 3", got)
+	}
+	if !c.admit("other", nil, t0) {
+		t.Errorf("node without a policy not admitted")
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// ConditionRulesFile is the format of the file named by CONDITION_RULES_FILE.
+type ConditionRulesFile struct {
+	Rules []*ConditionRule `json:"rules"`
+}
+
+// ConditionRule maps the results of PROBE_PLUGIN_DIR plugins to what the
+// controller does for a node. Each node on life support follows the first
+// rule matching it; nodes matching none have Ready asserted as usual.
+type ConditionRule struct {
+	Name string `json:"name"`
+	// When maps plugin file names to the result each must have last
+	// returned for the node: Healthy, Unhealthy, or Unknown if it never has.
+	// Plugins that fail or time out keep their last result. Empty matches
+	// every node.
+	When map[string]string `json:"when,omitempty"`
+	// Action is Assert (the default) to write Conditions, Skip to leave the
+	// node alone, neither renewing its lease nor writing its status, or
+	// Disengage to take it off life support.
+	Action string `json:"action,omitempty"`
+	// Conditions are written to the node's status with Assert. A Ready one
+	// replaces the Ready condition otherwise asserted.
+	Conditions []RuleCondition `json:"conditions,omitempty"`
+}
+
+// RuleCondition is a node condition written by a ConditionRule. Reason and
+// Message default to those of the Ready condition otherwise asserted.
+type RuleCondition struct {
+	Type    v1.NodeConditionType `json:"type"`
+	Status  v1.ConditionStatus   `json:"status"`
+	Reason  string               `json:"reason,omitempty"`
+	Message string               `json:"message,omitempty"`
+}
+
+// ConditionRule actions.
+const (
+	RuleActionAssert    = "Assert"
+	RuleActionSkip      = "Skip"
+	RuleActionDisengage = "Disengage"
+)
+
+// Probe results a ConditionRule can match.
+const (
+	probeHealthy   = "Healthy"
+	probeUnhealthy = "Unhealthy"
+	probeUnknown   = "Unknown"
+)
+
+// defaultUnhealthyRule is followed by nodes a plugin found unhealthy when
+// there is no CONDITION_RULES_FILE.
+var defaultUnhealthyRule = &ConditionRule{Name: "default", Action: RuleActionDisengage}
+
+// loadConditionRules reads and validates the rules file at path.
+func loadConditionRules(path string) ([]*ConditionRule, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f ConditionRulesFile
+	if err := yaml.UnmarshalStrict(raw, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	seen := make(map[string]struct{})
+	for i, r := range f.Rules {
+		if r == nil || r.Name == "" {
+			return nil, fmt.Errorf("%s: rule %d has no name", path, i)
+		}
+		if _, ok := seen[r.Name]; ok {
+			return nil, fmt.Errorf("%s: duplicate rule %q", path, r.Name)
+		}
+		seen[r.Name] = struct{}{}
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("%s: rule %q: %w", path, r.Name, err)
+		}
+	}
+	return f.Rules, nil
+}
+
+func (r *ConditionRule) validate() error {
+	for plugin, result := range r.When {
+		switch result {
+		case probeHealthy, probeUnhealthy, probeUnknown:
+		default:
+			return fmt.Errorf("when %s: must be %s, %s or %s", plugin, probeHealthy, probeUnhealthy, probeUnknown)
+		}
+	}
+	switch r.Action {
+	case "":
+		r.Action = RuleActionAssert
+	case RuleActionAssert, RuleActionSkip, RuleActionDisengage:
+	default:
+		return fmt.Errorf("action: must be %s, %s or %s", RuleActionAssert, RuleActionSkip, RuleActionDisengage)
+	}
+	if r.Action != RuleActionAssert && len(r.Conditions) > 0 {
+		return fmt.Errorf("conditions: only written with action %s", RuleActionAssert)
+	}
+	return validateConditions(r.Conditions)
+}
+
+// validateConditions checks the conditions of a rule or policy.
+func validateConditions(conditions []RuleCondition) error {
+	seen := make(map[v1.NodeConditionType]struct{})
+	for i, cond := range conditions {
+		if cond.Type == "" {
+			return fmt.Errorf("condition %d has no type", i)
+		}
+		if cond.Type == lifeSupportCondition {
+			return fmt.Errorf("condition %s: is written by the controller", cond.Type)
+		}
+		if _, ok := seen[cond.Type]; ok {
+			return fmt.Errorf("duplicate condition %s", cond.Type)
+		}
+		seen[cond.Type] = struct{}{}
+		switch cond.Status {
+		case v1.ConditionTrue, v1.ConditionFalse, v1.ConditionUnknown:
+		default:
+			return fmt.Errorf("condition %s: status must be True, False or Unknown", cond.Type)
+		}
+	}
+	return nil
+}
+
+// matches reports whether the rule matches a node's probe results, by
+// plugin.
+func (r *ConditionRule) matches(results map[string]probeOutcome) bool {
+	for plugin, want := range r.When {
+		got := probeUnknown
+		if res, ok := results[plugin]; ok {
+			got = probeUnhealthy
+			if res.healthy {
+				got = probeHealthy
+			}
+		}
+		if got != want {
+			return false
+		}
+	}
+	return true
+}
+
+// conditionRule returns the rule a node follows, or nil to have Ready
+// asserted as usual. Without CONDITION_RULES_FILE, nodes a plugin found
+// unhealthy are disengaged.
+func (c *NodeLifeSupportController) conditionRule(name string) *ConditionRule {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	results := c.probeResults[name]
+	if c.opts.ConditionRules == nil {
+		for _, res := range results {
+			if !res.healthy {
+				return defaultUnhealthyRule
+			}
+		}
+		return nil
+	}
+	var rule *ConditionRule
+	for _, r := range c.opts.ConditionRules {
+		if r.matches(results) {
+			rule = r
+			break
+		}
+	}
+	if c.followedRules == nil {
+		c.followedRules = make(map[string]string)
+	}
+	followed := ""
+	if rule != nil {
+		followed = rule.Name
+	}
+	if prev, ok := c.followedRules[name]; !ok || prev != followed {
+		if rule != nil {
+			c.logf("node %s: following condition rule %q", name, followed)
+		} else if ok {
+			c.logf("node %s: no longer matching any condition rule", name)
+		}
+		c.followedRules[name] = followed
+	}
+	return rule
+}
+
+// withConditions adds the conditions of a rule or policy to those asserted
+// for a node, the first of which is Ready, replacing any of the same type.
+func withConditions(conditions []v1.NodeCondition, extra []RuleCondition, now time.Time) []v1.NodeCondition {
+	ready := conditions[0]
+	for _, rc := range extra {
+		cond := v1.NodeCondition{
+			Type:               rc.Type,
+			Status:             rc.Status,
+			LastHeartbeatTime:  metav1.Time{Time: now},
+			LastTransitionTime: metav1.Time{Time: now},
+			Reason:             ready.Reason,
+			Message:            ready.Message,
+		}
+		if rc.Reason != "" {
+			cond.Reason = rc.Reason
+		}
+		if rc.Message != "" {
+			cond.Message = rc.Message
+		}
+		replaced := false
+		for i := range conditions {
+			if conditions[i].Type == cond.Type {
+				conditions[i], replaced = cond, true
+			}
+		}
+		if !replaced {
+			conditions = append(conditions, cond)
+		}
+	}
+	return conditions
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+const testConditionRules = `
+rules:
+  - name: smart-failing
+    when:
+      smart: Unhealthy
+    conditions:
+      - type: DiskHealthy
+        status: "False"
+        reason: SMARTFailure
+        message: SMART self-test failed
+  - name: ping-down
+    when:
+      ping: Unhealthy
+    action: Skip
+  - name: powered-off
+    when:
+      bmc: Unhealthy
+    action: Disengage
+  - name: all-ok
+    when:
+      bmc: Healthy
+      ping: Healthy
+    conditions:
+      - type: Ready
+        status: "True"
+        reason: VerifiedByBMC
+`
+
+// TestConditionRules tests that nodes follow the first rule matching their
+// probe results, and that the conditions it asserts are written.
+func TestConditionRules(t *testing.T) {
+	rules, err := loadConditionRules(writePolicyFile(t, testConditionRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := probeOutcome{healthy: true}
+	bad := probeOutcome{message: "down"}
+	client := fake.NewSimpleClientset()
+	c := &NodeLifeSupportController{
+		client: client,
+		clock:  clocktesting.NewFakeClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)),
+		opts:   Options{ConditionRules: rules},
+		probeResults: map[string]map[string]probeOutcome{
+			"verified":  {"bmc": ok, "ping": ok, "smart": ok},
+			"unpinged":  {"bmc": ok, "ping": bad},
+			"off":       {"bmc": bad},
+			"degraded":  {"bmc": bad, "ping": bad, "smart": bad},
+			"bmc-down":  {"ping": ok},
+			"unhealthy": {"other": bad},
+		},
+	}
+
+	tests := []struct {
+		node string
+		rule string
+	}{
+		{node: "verified", rule: "all-ok"},
+		{node: "unpinged", rule: "ping-down"},
+		{node: "off", rule: "powered-off"},
+		{node: "degraded", rule: "smart-failing"},
+		// bmc is Unknown.
+		{node: "bmc-down", rule: ""},
+		{node: "unhealthy", rule: ""},
+		{node: "unprobed", rule: ""},
+	}
+	for _, tt := range tests {
+		var got string
+		if r := c.conditionRule(tt.node); r != nil {
+			got = r.Name
+		}
+		if got != tt.rule {
+			t.Errorf("conditionRule(%s) = %q, want %q", tt.node, got, tt.rule)
+		}
+	}
+
+	ctx := context.Background()
+	for _, name := range []string{"verified", "degraded"} {
+		if _, err := client.CoreV1().Nodes().Create(ctx, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.ForceNodeReady(ctx, &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: name}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ready := nodeCondition(t, client, "verified", v1.NodeReady); ready == nil || ready.Reason != "VerifiedByBMC" || ready.Message != defaultReadyMessage {
+		t.Errorf("verified Ready = %+v", ready)
+	}
+	if ready := nodeCondition(t, client, "degraded", v1.NodeReady); ready == nil || ready.Reason != defaultReadyReason {
+		t.Errorf("degraded Ready = %+v", ready)
+	}
+	if disk := nodeCondition(t, client, "degraded", "DiskHealthy"); disk == nil || disk.Status != v1.ConditionFalse || disk.Reason != "SMARTFailure" {
+		t.Errorf("degraded DiskHealthy = %+v", disk)
+	}
+}
+
+// TestPolicyConditions tests that a node's policy adds conditions to its
+// status, and that those of its condition rule take precedence.
+func TestPolicyConditions(t *testing.T) {
+	policies, err := loadPolicies(writePolicyFile(t, `
+policies:
+  - name: edge
+    conditions:
+      - type: NetworkUnavailable
+        status: "False"
+        reason: EdgeNetworkAssumed
+      - type: DiskHealthy
+        status: "True"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := loadConditionRules(writePolicyFile(t, testConditionRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := fake.NewSimpleClientset()
+	c := &NodeLifeSupportController{
+		client:       client,
+		clock:        clocktesting.NewFakeClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)),
+		opts:         Options{Policies: policies, ConditionRules: rules},
+		nodePolicies: map[string]string{"healthy": "edge", "degraded": "edge"},
+		probeResults: map[string]map[string]probeOutcome{
+			"degraded": {"smart": {}},
+		},
+	}
+
+	ctx := context.Background()
+	for _, name := range []string{"healthy", "degraded"} {
+		if _, err := client.CoreV1().Nodes().Create(ctx, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.ForceNodeReady(ctx, &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: name}}); err != nil {
+			t.Fatal(err)
+		}
+		if net := nodeCondition(t, client, name, "NetworkUnavailable"); net == nil || net.Status != v1.ConditionFalse || net.Reason != "EdgeNetworkAssumed" {
+			t.Errorf("%s NetworkUnavailable = %+v", name, net)
+		}
+	}
+	if disk := nodeCondition(t, client, "healthy", "DiskHealthy"); disk == nil || disk.Status != v1.ConditionTrue || disk.Reason != defaultReadyReason {
+		t.Errorf("healthy DiskHealthy = %+v", disk)
+	}
+	if disk := nodeCondition(t, client, "degraded", "DiskHealthy"); disk == nil || disk.Status != v1.ConditionFalse || disk.Reason != "SMARTFailure" {
+		t.Errorf("degraded DiskHealthy = %+v", disk)
+	}
+}
+
+// TestConditionProfiles tests that policies assert the conditions of their
+// condition profile, and that their own conditions take precedence.
+func TestConditionProfiles(t *testing.T) {
+	policies, err := loadPolicies(writePolicyFile(t, `
+conditionProfiles:
+  - name: gpu
+    conditions:
+      - type: GPUHealthy
+        status: "True"
+        reason: GPUAssumed
+      - type: NetworkUnavailable
+        status: "False"
+policies:
+  - name: gpu-a
+    conditionProfile: gpu
+  - name: gpu-edge
+    conditionProfile: gpu
+    conditions:
+      - type: NetworkUnavailable
+        status: "Unknown"
+  - name: virtual
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := fake.NewSimpleClientset()
+	c := &NodeLifeSupportController{
+		client:       client,
+		clock:        clocktesting.NewFakeClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)),
+		opts:         Options{Policies: policies},
+		nodePolicies: map[string]string{"gpu1": "gpu-a", "edge1": "gpu-edge", "vm1": "virtual"},
+	}
+
+	ctx := context.Background()
+	tests := []struct {
+		node    string
+		gpu     v1.ConditionStatus
+		network v1.ConditionStatus
+	}{
+		{node: "gpu1", gpu: v1.ConditionTrue, network: v1.ConditionFalse},
+		{node: "edge1", gpu: v1.ConditionTrue, network: v1.ConditionUnknown},
+		{node: "vm1"},
+	}
+	for _, tt := range tests {
+		if _, err := client.CoreV1().Nodes().Create(ctx, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: tt.node}}, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.ForceNodeReady(ctx, &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: tt.node}}); err != nil {
+			t.Fatal(err)
+		}
+		for typ, want := range map[v1.NodeConditionType]v1.ConditionStatus{"GPUHealthy": tt.gpu, "NetworkUnavailable": tt.network} {
+			cond := nodeCondition(t, client, tt.node, typ)
+			switch {
+			case want == "" && cond != nil:
+				t.Errorf("%s %s = %+v, want none", tt.node, typ, cond)
+			case want != "" && (cond == nil || cond.Status != want):
+				t.Errorf("%s %s = %+v, want %s", tt.node, typ, cond, want)
+			}
+		}
+	}
+	if gpu := nodeCondition(t, client, "gpu1", "GPUHealthy"); gpu == nil || gpu.Reason != "GPUAssumed" {
+		t.Errorf("gpu1 GPUHealthy = %+v, want reason GPUAssumed", gpu)
+	}
+}
+
+// TestDefaultConditionRule tests that without CONDITION_RULES_FILE nodes any
+// plugin found unhealthy are disengaged.
+func TestDefaultConditionRule(t *testing.T) {
+	c := &NodeLifeSupportController{probeResults: map[string]map[string]probeOutcome{
+		"ok":  {"bmc": {healthy: true}},
+		"bad": {"bmc": {healthy: true}, "ping": {}},
+	}}
+	if r := c.conditionRule("ok"); r != nil {
+		t.Errorf("conditionRule(ok) = %v", r)
+	}
+	if r := c.conditionRule("bad"); r != defaultUnhealthyRule {
+		t.Errorf("conditionRule(bad) = %v", r)
+	}
+}
+
+// TestLoadConditionRules tests rules file validation.
+func TestLoadConditionRules(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		expectErr bool
+	}{
+		{name: "valid", content: testConditionRules},
+		{name: "no name", content: "rules:\n  - action: Skip\n", expectErr: true},
+		{name: "duplicate", content: "rules:\n  - name: a\n  - name: a\n", expectErr: true},
+		{name: "bad result", content: "rules:\n  - name: a\n    when:\n      bmc: Down\n", expectErr: true},
+		{name: "bad action", content: "rules:\n  - name: a\n    action: Reboot\n", expectErr: true},
+		{name: "conditions with skip", content: "rules:\n  - name: a\n    action: Skip\n    conditions:\n      - type: Ready\n        status: \"True\"\n", expectErr: true},
+		{name: "bad status", content: "rules:\n  - name: a\n    conditions:\n      - type: Ready\n        status: \"Yes\"\n", expectErr: true},
+		{name: "controller condition", content: "rules:\n  - name: a\n    conditions:\n      - type: LifeSupportActive\n        status: \"False\"\n", expectErr: true},
+		{name: "unknown field", content: "rules:\n  - name: a\n    wen: {}\n", expectErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := loadConditionRules(writePolicyFile(t, tt.content)); (err != nil) != tt.expectErr {
+				t.Errorf("loadConditionRules() error = %v, expectErr %v", err, tt.expectErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// shardMemberLabel marks the Leases replicas use to announce shard
+	// membership.
+	shardMemberLabel = "node-life-support.io/shard-member"
+	shardLeasePrefix = "node-life-support-shard-"
+)
+
+// shardMembership tracks the set of live replicas via one Lease per replica
+// and assigns nodes to replicas with rendezvous (highest random weight)
+// hashing, so each node is owned by exactly one live replica and only the
+// nodes of a departing/arriving replica move.
+type shardMembership struct {
+	client        kubernetes.Interface
+	namespace     string
+	identity      string
+	podUID        types.UID
+	leaseDuration time.Duration
+	// now is the controller's clock, renewing and expiring shard leases.
+	now func() time.Time
+
+	members []string
+}
+
+// refresh renews this replica's membership lease and reloads the member list.
+func (s *shardMembership) refresh(ctx context.Context) error {
+	if err := s.heartbeat(ctx); err != nil {
+		return fmt.Errorf("renew shard lease: %w", err)
+	}
+	leases, err := s.client.CoordinationV1().Leases(s.namespace).List(ctx, metav1.ListOptions{LabelSelector: shardMemberLabel})
+	if err != nil {
+		return fmt.Errorf("list shard leases: %w", err)
+	}
+	s.members = liveMembers(leases.Items, s.now())
+	return nil
+}
+
+func (s *shardMembership) heartbeat(ctx context.Context) error {
+	now := metav1.NewMicroTime(s.now())
+	seconds := int32(s.leaseDuration / time.Second)
+	leases := s.client.CoordinationV1().Leases(s.namespace)
+
+	lease, err := leases.Get(ctx, shardLeasePrefix+s.identity, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      shardLeasePrefix + s.identity,
+				Namespace: s.namespace,
+				Labels:    map[string]string{shardMemberLabel: "true"},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &s.identity,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		// Tie the lease to our Pod so it is garbage collected with it.
+		if s.podUID != "" {
+			lease.OwnerReferences = []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "Pod",
+				Name:       s.identity,
+				UID:        s.podUID,
+			}}
+		}
+		_, err = leases.Create(ctx, lease, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	lease.Spec.HolderIdentity = &s.identity
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+// owns reports whether this replica owns the named node. Until the member
+// list has been loaded every node is considered owned, which errs on the side
+// of keeping nodes alive.
+func (s *shardMembership) owns(nodeName string) bool {
+	if len(s.members) == 0 {
+		return true
+	}
+	return shardOwner(nodeName, s.members) == s.identity
+}
+
+// liveMembers returns the sorted holder identities of unexpired shard leases.
+func liveMembers(leases []coordinationv1.Lease, now time.Time) []string {
+	var members []string
+	for _, l := range leases {
+		spec := l.Spec
+		if spec.HolderIdentity == nil || spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+			continue
+		}
+		expiry := spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second)
+		if now.After(expiry) {
+			continue
+		}
+		members = append(members, *spec.HolderIdentity)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// shardOwner picks the member with the highest hash of (member, node).
+func shardOwner(nodeName string, members []string) string {
+	var owner string
+	var best uint64
+	for _, m := range members {
+		h := fnv.New64a()
+		h.Write([]byte(m))
+		h.Write([]byte{0})
+		h.Write([]byte(nodeName))
+		if w := mix64(h.Sum64()); owner == "" || w > best {
+			owner, best = m, w
+		}
+	}
+	return owner
+}
+
+// mix64 is the murmur3 64-bit finalizer. FNV alone mixes too poorly for
+// near-identical inputs such as "replica-a"/"replica-b" to spread nodes evenly.
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
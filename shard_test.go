@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func shardLease(holder string, renew time.Time, seconds int32) coordinationv1.Lease {
+	rt := metav1.NewMicroTime(renew)
+	return coordinationv1.Lease{Spec: coordinationv1.LeaseSpec{
+		HolderIdentity:       &holder,
+		RenewTime:            &rt,
+		LeaseDurationSeconds: &seconds,
+	}}
+}
+
+// TestLiveMembers tests that expired and incomplete shard leases are ignored.
+func TestLiveMembers(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	leases := []coordinationv1.Lease{
+		shardLease("replica-b", now.Add(-10*time.Second), 30),
+		shardLease("replica-a", now.Add(-29*time.Second), 30),
+		shardLease("replica-c", now.Add(-31*time.Second), 30),
+		{},
+	}
+
+	result := liveMembers(leases, now)
+	expected := []string{"replica-a", "replica-b"}
+	if fmt.Sprint(result) != fmt.Sprint(expected) {
+		t.Errorf("liveMembers() = %v, want %v", result, expected)
+	}
+}
+
+// TestShardOwner tests that node assignment is deterministic and only moves
+// nodes belonging to a departed member.
+func TestShardOwner(t *testing.T) {
+	members := []string{"replica-a", "replica-b", "replica-c"}
+	before := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		node := fmt.Sprintf("node-%d", i)
+		owner := shardOwner(node, members)
+		if again := shardOwner(node, members); again != owner {
+			t.Fatalf("shardOwner(%s) not deterministic: %s then %s", node, owner, again)
+		}
+		before[node] = owner
+		counts[owner]++
+	}
+	for _, m := range members {
+		if counts[m] < 50 {
+			t.Errorf("member %s owns only %d of 300 nodes", m, counts[m])
+		}
+	}
+
+	remaining := []string{"replica-a", "replica-c"}
+	for node, owner := range before {
+		after := shardOwner(node, remaining)
+		if owner != "replica-b" && after != owner {
+			t.Errorf("node %s moved from %s to %s although its owner is still a member", node, owner, after)
+		}
+	}
+
+	if owner := shardOwner("node-1", nil); owner != "" {
+		t.Errorf("shardOwner() with no members = %q, want empty", owner)
+	}
+}
+
+// TestShardOwns tests that a replica without membership information keeps every node.
+func TestShardOwns(t *testing.T) {
+	s := &shardMembership{identity: "replica-a"}
+	if !s.owns("node-1") {
+		t.Errorf("owns() with no members = false, want true")
+	}
+	s.members = []string{"replica-a", "replica-b"}
+	owned := 0
+	for i := 0; i < 100; i++ {
+		if s.owns(fmt.Sprintf("node-%d", i)) {
+			owned++
+		}
+	}
+	if owned == 0 || owned == 100 {
+		t.Errorf("owns() claimed %d of 100 nodes with two members", owned)
+	}
+}
+
+// TestShardRefresh tests that refresh renews this replica's lease at the
+// controller's time and drops members once their leases expire by it.
+func TestShardRefresh(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock := clocktesting.NewFakeClock(t0)
+	client := fake.NewSimpleClientset()
+	ctx := context.Background()
+	replica := func(identity string) *shardMembership {
+		return &shardMembership{client: client, namespace: "nls", identity: identity, leaseDuration: time.Minute, now: clock.Now}
+	}
+	a, b := replica("replica-a"), replica("replica-b")
+	for _, s := range []*shardMembership{a, b} {
+		if err := s.refresh(ctx); err != nil {
+			t.Fatalf("refresh() error: %v", err)
+		}
+	}
+	lease, err := client.CoordinationV1().Leases("nls").Get(ctx, shardLeasePrefix+"replica-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed := lease.Spec.RenewTime; renewed == nil || !renewed.Time.Equal(t0) {
+		t.Errorf("renewTime = %v, want %v", renewed, t0)
+	}
+
+	steps := []struct {
+		after   time.Duration
+		members []string
+	}{
+		{after: 0, members: []string{"replica-a", "replica-b"}},
+		// replica-b stopped renewing at t0; its lease lasts a minute.
+		{after: time.Minute, members: []string{"replica-a", "replica-b"}},
+		{after: time.Minute + time.Second, members: []string{"replica-a"}},
+	}
+	for _, s := range steps {
+		clock.SetTime(t0.Add(s.after))
+		if err := a.refresh(ctx); err != nil {
+			t.Fatalf("refresh() error: %v", err)
+		}
+		if fmt.Sprint(a.members) != fmt.Sprint(s.members) {
+			t.Errorf("after %s: members = %v, want %v", s.after, a.members, s.members)
+		}
+	}
+}
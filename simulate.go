@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/fake"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/clock"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/yaml"
+)
+
+// clusterDump is the Nodes, node Leases and Pods read from a directory of
+// YAML or JSON dumps, e.g. of kubectl get nodes -o yaml.
+type clusterDump struct {
+	nodes  []*v1.Node
+	leases []*coordinationv1.Lease
+	pods   []*v1.Pod
+}
+
+// loadClusterDump reads every .yaml, .yml and .json file under dir. Files may
+// hold several documents and Lists; objects of other kinds are ignored.
+func loadClusterDump(dir string) (*clusterDump, error) {
+	d := &clusterDump{}
+	err := filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.IsDir() {
+			return nil
+		}
+		switch filepath.Ext(path) {
+		case ".yaml", ".yml", ".json":
+		default:
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r := utilyaml.NewYAMLReader(bufio.NewReader(f))
+		for {
+			doc, err := r.Read()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if len(bytes.TrimSpace(doc)) == 0 {
+				continue
+			}
+			raw, err := yaml.YAMLToJSON(doc)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if err := d.add(raw); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// add adds the object, or the items of the List, in raw JSON.
+func (d *clusterDump) add(raw []byte) error {
+	var head struct {
+		Kind  string            `json:"kind"`
+		Items []json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal(raw, &head); err != nil {
+		return err
+	}
+	var err error
+	switch head.Kind {
+	case "List", "NodeList", "LeaseList", "PodList":
+		for _, item := range head.Items {
+			if err := d.add(item); err != nil {
+				return err
+			}
+		}
+	case "Node":
+		n := &v1.Node{}
+		if err = json.Unmarshal(raw, n); err == nil {
+			d.nodes = append(d.nodes, n)
+		}
+	case "Lease":
+		l := &coordinationv1.Lease{}
+		if err = json.Unmarshal(raw, l); err == nil && l.Namespace == nodeLeaseNamespace {
+			d.leases = append(d.leases, l)
+		}
+	case "Pod":
+		p := &v1.Pod{}
+		if err = json.Unmarshal(raw, p); err == nil {
+			d.pods = append(d.pods, p)
+		}
+	}
+	return err
+}
+
+// simulationOptions returns opts with everything reaching beyond the
+// simulated API server turned off: hooks, probes, notifications, exports,
+// sharding and the like.
+func simulationOptions(opts Options) Options {
+	opts.Clusters, opts.ClusterAPIDiscovery, opts.Cluster = nil, false, ""
+	opts.ExecHooks = nil
+	opts.ProbePluginDir = ""
+	opts.WebhookURL, opts.DigestSchedule = "", nil
+	opts.AuditExportURL = ""
+	opts.RBACCheck, opts.PreflightCheck = false, false
+	opts.APIOutageAlertAfter = 0
+	opts.LeaseGCInterval, opts.PodInventoryInterval = 0, 0
+	opts.KeepAliveLeases = nil
+	opts.StateConfigMap = ""
+	opts.Sharding, opts.LeaderElection, opts.HeartbeatLeaseDuration = false, false, 0
+	opts.ImpersonateNodes = false
+	opts.ChaosErrorRate, opts.ChaosDropRate, opts.ChaosLatency = 0, 0, 0
+	return opts
+}
+
+// simulatedNode is the outcome of a simulated sync cycle for one node.
+type simulatedNode struct {
+	name    string
+	policy  string
+	engaged bool
+	// skipped is why the node may not be put on life support, if it may not.
+	skipped string
+	// changes are how the node's lease and conditions would change, as
+	// leaseDiff and conditionDiff describe them.
+	changes []string
+	writes  []string
+}
+
+// simulate runs one sync cycle at now over the dump, against a fake API
+// server, and returns what it did to each node.
+func simulate(ctx context.Context, d *clusterDump, opts Options, now time.Time) ([]simulatedNode, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	c, client, err := newFakeController(d, simulationOptions(opts), clocktesting.NewFakeClock(now))
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Start(ctx); err != nil {
+		return nil, err
+	}
+	client.ClearActions()
+	if err := c.SyncAllNodes(ctx); err != nil {
+		return nil, err
+	}
+
+	writes := make(map[string][]string)
+	for _, a := range client.Actions() {
+		if name, w, ok := describeWrite(a); ok {
+			writes[name] = append(writes[name], w)
+		}
+	}
+	leases := make(map[string]*coordinationv1.Lease)
+	for _, l := range d.leases {
+		leases[l.Name] = l
+	}
+	var out []simulatedNode
+	for _, n := range d.nodes {
+		s := simulatedNode{name: n.Name, engaged: c.engaged(n.Name), writes: writes[n.Name]}
+		if l, err := client.CoordinationV1().Leases(nodeLeaseNamespace).Get(ctx, n.Name, metav1.GetOptions{}); err == nil {
+			s.changes = leaseDiff(leases[n.Name], l)
+		}
+		if after, err := client.CoreV1().Nodes().Get(ctx, n.Name, metav1.GetOptions{}); err == nil {
+			s.changes = append(s.changes, conditionDiff(n.Status.Conditions, after.Status.Conditions)...)
+		}
+		if p := c.policyFor(&metav1.PartialObjectMetadata{ObjectMeta: n.ObjectMeta}); p != nil {
+			s.policy = p.Name
+		}
+		if reason, why := c.unsupportable(ctx, n.Name); reason != "" {
+			s.skipped = reason + ": " + why
+		}
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].name < out[j].name })
+	return out, nil
+}
+
+// newFakeController returns a controller with opts and clock against a fake
+// API server holding the dump, to be started.
+func newFakeController(d *clusterDump, opts Options, clock clock.Clock) (*NodeLifeSupportController, *fake.Clientset, error) {
+	var objects, metas []runtime.Object
+	for _, n := range d.nodes {
+		objects = append(objects, n)
+		metas = append(metas, &metav1.PartialObjectMetadata{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Node"}, ObjectMeta: n.ObjectMeta})
+	}
+	for _, l := range d.leases {
+		objects = append(objects, l)
+	}
+	for _, p := range d.pods {
+		objects = append(objects, p)
+	}
+	client := fake.NewSimpleClientset(objects...)
+	scheme := metadatafake.NewTestScheme()
+	if err := metav1.AddMetaToScheme(scheme); err != nil {
+		return nil, nil, err
+	}
+	allowed := make(map[string]struct{})
+	for _, k := range opts.AllowedLabelKeys {
+		allowed[k] = struct{}{}
+	}
+	selectors, err := allowlistSelectors(allowed)
+	if err != nil {
+		return nil, nil, err
+	}
+	c := &NodeLifeSupportController{
+		client:        client,
+		meta:          metadatafake.NewSimpleMetadataClient(scheme, metas...),
+		allowedLabels: allowed,
+		selectors:     selectors,
+		opts:          opts,
+		clock:         clock,
+	}
+	return c, client, nil
+}
+
+// describeWrite describes a write to a node or its lease, with its payload,
+// returning the node's name.
+func describeWrite(a k8stesting.Action) (string, string, bool) {
+	resource := a.GetResource().Resource
+	if a.GetSubresource() != "" {
+		resource += "/" + a.GetSubresource()
+	}
+	var name string
+	var body []byte
+	switch a := a.(type) {
+	case k8stesting.PatchAction:
+		name, body = a.GetName(), a.GetPatch()
+	case k8stesting.CreateAction:
+		o, err := meta.Accessor(a.GetObject())
+		if err != nil {
+			return "", "", false
+		}
+		name = o.GetName()
+		body, _ = json.Marshal(a.GetObject())
+	case k8stesting.UpdateAction:
+		o, err := meta.Accessor(a.GetObject())
+		if err != nil {
+			return "", "", false
+		}
+		name = o.GetName()
+		body, _ = json.Marshal(a.GetObject())
+	case k8stesting.DeleteAction:
+		name = a.GetName()
+	default:
+		return "", "", false
+	}
+	switch {
+	case a.GetResource().Resource == "nodes":
+	case a.GetResource().Resource == "leases" && a.GetNamespace() == nodeLeaseNamespace:
+	default:
+		return "", "", false
+	}
+	w := a.GetVerb() + " " + resource
+	if ns := a.GetNamespace(); ns != "" {
+		w += " " + ns + "/" + name
+	} else {
+		w += " " + name
+	}
+	if len(body) > 0 {
+		w += "\n" + string(body)
+	}
+	return name, w, true
+}
+
+// writeSimulation writes the simulated outcome for each policy's nodes, then
+// the nodes left alone.
+func writeSimulation(out io.Writer, nodes []simulatedNode) {
+	byPolicy := make(map[string][]simulatedNode)
+	var policies []string
+	var idle []simulatedNode
+	for _, n := range nodes {
+		if !n.engaged {
+			idle = append(idle, n)
+			continue
+		}
+		if _, ok := byPolicy[n.policy]; !ok {
+			policies = append(policies, n.policy)
+		}
+		byPolicy[n.policy] = append(byPolicy[n.policy], n)
+	}
+	sort.Strings(policies)
+	for _, p := range policies {
+		title := "policy " + p
+		if p == "" {
+			title = "no policy"
+		}
+		fmt.Fprintf(out, "%s: %d nodes engaged\n", title, len(byPolicy[p]))
+		for _, n := range byPolicy[p] {
+			fmt.Fprintf(out, "  %s\n", n.name)
+			for _, ch := range n.changes {
+				fmt.Fprintf(out, "    %s\n", ch)
+			}
+			for _, w := range n.writes {
+				fmt.Fprintf(out, "    %s\n", strings.ReplaceAll(w, "\n", "\n      "))
+			}
+		}
+	}
+	fmt.Fprintf(out, "not engaged: %d nodes\n", len(idle))
+	for _, n := range idle {
+		var notes []string
+		if n.policy != "" {
+			notes = append(notes, "policy "+n.policy)
+		}
+		if n.skipped != "" {
+			notes = append(notes, n.skipped)
+		}
+		if len(notes) > 0 {
+			fmt.Fprintf(out, "  %s (%s)\n", n.name, strings.Join(notes, "; "))
+		} else {
+			fmt.Fprintf(out, "  %s\n", n.name)
+		}
+	}
+}
+
+// runSimulate implements the simulate command: it loads a directory of Node,
+// Lease and Pod dumps and reports which nodes one sync cycle with the
+// configuration in the environment would put on life support, under which
+// policy, and the writes it would make, without an API server.
+func runSimulate(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	at := fs.String("now", "", "time to simulate at, RFC 3339 (default: the current time)")
+	verbose := fs.Bool("v", false, "log what the controller does, as it would")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: simulate [-now TIME] [-v] <dump directory>")
+	}
+	now := time.Now()
+	if *at != "" {
+		t, err := time.Parse(time.RFC3339, *at)
+		if err != nil {
+			return fmt.Errorf("-now: %w", err)
+		}
+		now = t
+	}
+	opts, err := LoadOptions()
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %v", err)
+	}
+	d, err := loadClusterDump(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if !*verbose {
+		w := log.Writer()
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(w)
+	}
+	nodes, err := simulate(context.Background(), d, *opts, now)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "simulated at %s with %d nodes, %d node leases and %d pods\n",
+		now.UTC().Format(time.RFC3339), len(d.nodes), len(d.leases), len(d.pods))
+	writeSimulation(out, nodes)
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const simulateNodes = `apiVersion: v1
+kind: List
+items:
+  - apiVersion: v1
+    kind: Node
+    metadata:
+      name: edge-1
+      uid: uid-1
+      labels:
+        pool: edge
+  - apiVersion: v1
+    kind: Node
+    metadata:
+      name: edge-2
+      uid: uid-2
+      labels:
+        pool: edge
+    spec:
+      taints:
+        - key: node.kubernetes.io/out-of-service
+          value: nodeshutdown
+          effect: NoExecute
+  - apiVersion: v1
+    kind: Node
+    metadata:
+      name: core-1
+      uid: uid-3
+      labels:
+        pool: core
+`
+
+const simulateLeases = `apiVersion: coordination.k8s.io/v1
+kind: Lease
+metadata:
+  name: edge-1
+  namespace: kube-node-lease
+spec:
+  holderIdentity: edge-1
+  leaseDurationSeconds: 40
+  renewTime: "2024-05-01T11:00:00.000000Z"
+---
+apiVersion: coordination.k8s.io/v1
+kind: Lease
+metadata:
+  name: core-1
+  namespace: kube-node-lease
+spec:
+  holderIdentity: core-1
+  leaseDurationSeconds: 40
+  renewTime: "2024-05-01T11:00:00.000000Z"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: ignored
+`
+
+// TestSimulate tests that a simulated cycle over a dump reports the nodes
+// each policy engages with the writes made, and the nodes left alone.
+func TestSimulate(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"nodes.yaml": simulateNodes, "leases/leases.yml": simulateLeases, "README.md": "not a dump"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d, err := loadClusterDump(dir)
+	if err != nil {
+		t.Fatalf("loadClusterDump() error: %v", err)
+	}
+	if len(d.nodes) != 3 || len(d.leases) != 2 || len(d.pods) != 0 {
+		t.Fatalf("loaded %d nodes, %d leases and %d pods, want 3, 2 and 0", len(d.nodes), len(d.leases), len(d.pods))
+	}
+
+	policies, err := loadPolicies(writePolicyFile(t, `
+policies:
+  - name: edge
+    nodeSelector:
+      matchLabels:
+        pool: edge
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{
+		HolderIdentity:      HolderIdentityNode,
+		SyncInterval:        time.Minute,
+		Policies:            policies,
+		RespectOutOfService: true,
+		// Would be a real webhook; must not be called.
+		WebhookURL: "http://192.0.2.1/hook",
+	}
+	nodes, err := simulate(context.Background(), d, opts, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("simulate() error: %v", err)
+	}
+	var out bytes.Buffer
+	writeSimulation(&out, nodes)
+	got := out.String()
+	for _, want := range []string{
+		"policy edge: 1 nodes engaged\n  edge-1\n",
+		"    lease renewTime: 2024-05-01T11:00:00Z -> 2024-05-01T12:00:00Z\n",
+		`    condition Ready: <none> -> True (NodeLifeSupportOverride) "`,
+		"    patch leases kube-node-lease/edge-1\n",
+		`"renewTime":"2024-05-01T12:00:00.000000Z"`,
+		"    patch nodes/status edge-1\n",
+		`"reason":"NodeLifeSupportOverride"`,
+		"not engaged: 2 nodes\n  core-1\n  edge-2 (policy edge; OutOfService: ",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("simulation does not contain %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "core-1\n    ") {
+		t.Errorf("writes to core-1, which no policy selects:\n%s", got)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// shutdownTaint is applied by the cloud controller manager to nodes whose
+// instance has been shut down.
+const shutdownTaint = "node.cloudprovider.kubernetes.io/shutdown"
+
+// kubeletShutdownMessage is part of the message of the NotReady condition a
+// kubelet posts during a graceful node shutdown.
+const kubeletShutdownMessage = "node is shutting down"
+
+// supportableRecheckInterval is how long whether a node is unsupportable is
+// remembered before it is read again, unless a cache of full Node objects
+// makes reading it free.
+var supportableRecheckInterval = time.Minute
+
+// supportableCheck is the result of checking whether a node is unsupportable.
+type supportableCheck struct {
+	at          time.Time
+	reason, why string
+}
+
+// unsupportableAt is unsupportable, remembering the result for
+// supportableRecheckInterval so nodes kept alive are not read on every
+// renewal.
+func (c *NodeLifeSupportController) unsupportableAt(ctx context.Context, name string, now time.Time) (reason, why string) {
+	c.mu.Lock()
+	last, ok := c.supportableChecks[name]
+	c.mu.Unlock()
+	if ok && c.fullNodes == nil && now.Before(last.at.Add(supportableRecheckInterval)) {
+		return last.reason, last.why
+	}
+	reason, why = c.unsupportable(ctx, name)
+	c.mu.Lock()
+	if c.supportableChecks == nil {
+		c.supportableChecks = make(map[string]supportableCheck)
+	}
+	c.supportableChecks[name] = supportableCheck{at: now, reason: reason, why: why}
+	c.mu.Unlock()
+	return reason, why
+}
+
+// unsupportable returns the reason a node must not be on life support
+// whatever else says it should, and why in words, or "" if there is none:
+// with RESPECT_OUT_OF_SERVICE it has the out-of-service taint, and with
+// RESPECT_SHUTDOWN it is shutting down on purpose, both checked from one read
+// of the node. Nodes that cannot be read have none.
+func (c *NodeLifeSupportController) unsupportable(ctx context.Context, name string) (reason, why string) {
+	if !c.opts.RespectOutOfService && !c.opts.RespectShutdown {
+		return "", ""
+	}
+	node, err := c.fullNode(ctx, name)
+	if err != nil {
+		return "", ""
+	}
+	if c.opts.RespectOutOfService && hasTaint(node, v1.TaintNodeOutOfService) {
+		return disengageOutOfService, "it has the " + v1.TaintNodeOutOfService + " taint"
+	}
+	if c.opts.RespectShutdown {
+		if why := shuttingDown(node); why != "" {
+			return disengageNodeShuttingDown, why
+		}
+	}
+	return "", ""
+}
+
+// fullNode returns the whole Node object: from the cache kept for policy
+// expressions if there is one, else read from the API server, which at one
+// read per node per supportableRecheckInterval costs less than watching
+// every Node.
+func (c *NodeLifeSupportController) fullNode(ctx context.Context, name string) (*v1.Node, error) {
+	if c.fullNodes != nil {
+		return c.fullNodes.Get(name)
+	}
+	ctx, cancel := c.opContext(ctx)
+	defer cancel()
+	return c.client.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+}
+
+// hasTaint reports whether a node has a taint with the given key.
+func hasTaint(node *v1.Node, key string) bool {
+	for _, t := range node.Spec.Taints {
+		if t.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// shuttingDown says how a node shows it is shutting down on purpose, or ""
+// if it does not: the cloud controller manager's shutdownTaint, or the
+// kubelet's graceful shutdown NotReady condition, which it posts for as long
+// as it takes, between the controller's own.
+func shuttingDown(node *v1.Node) string {
+	if hasTaint(node, shutdownTaint) {
+		return "it has the " + shutdownTaint + " taint"
+	}
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == v1.NodeReady && cond.Status != v1.ConditionTrue && strings.Contains(cond.Message, kubeletShutdownMessage) {
+			return "its kubelet reports " + kubeletShutdownMessage
+		}
+	}
+	return ""
+}
+
+// noteSkipped logs and records an Event when a node becomes unsupportable,
+// or stops being, rather than every cycle it is.
+func (c *NodeLifeSupportController) noteSkipped(name string, uid types.UID, reason, why string) {
+	c.mu.Lock()
+	was, ok := c.skippedNodes[name]
+	switch {
+	case reason != "" && was != reason:
+		if c.skippedNodes == nil {
+			c.skippedNodes = make(map[string]string)
+		}
+		c.skippedNodes[name] = reason
+	case reason == "" && ok:
+		delete(c.skippedNodes, name)
+	}
+	c.mu.Unlock()
+	switch {
+	case reason != "" && was != reason:
+		c.logf("node %s: not putting it on life support, as %s", name, why)
+		c.nodeEvent(name, uid, v1.EventTypeWarning, reasonLifeSupportSkipped, "Not putting node on life support: %s", why)
+	case reason == "" && ok:
+		c.logf("node %s: %s no longer applies, it may be put on life support again", name, was)
+	}
+}
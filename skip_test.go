@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+// TestOutOfService tests that a node with the out-of-service taint is taken
+// off life support, and not put back on, with one LifeSupportSkipped Event,
+// unless RESPECT_OUT_OF_SERVICE is off.
+func TestOutOfService(t *testing.T) {
+	tests := []struct {
+		name    string
+		respect bool
+		engaged []string
+	}{
+		{name: "respected", respect: true, engaged: []string{"healthy"}},
+		{name: "ignored", respect: false, engaged: []string{"dead", "healthy"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dead := metav1.ObjectMeta{Name: "dead", UID: "uid-dead"}
+			healthy := metav1.ObjectMeta{Name: "healthy", UID: "uid-healthy"}
+			client := fake.NewSimpleClientset(
+				&v1.Node{ObjectMeta: dead, Spec: v1.NodeSpec{Taints: []v1.Taint{{Key: v1.TaintNodeOutOfService, Value: "nodeshutdown", Effect: v1.TaintEffectNoExecute}}}},
+				&v1.Node{ObjectMeta: healthy},
+			)
+			scheme := metadatafake.NewTestScheme()
+			metav1.AddMetaToScheme(scheme)
+			meta := metadatafake.NewSimpleMetadataClient(scheme,
+				&metav1.PartialObjectMetadata{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Node"}, ObjectMeta: dead},
+				&metav1.PartialObjectMetadata{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Node"}, ObjectMeta: healthy},
+			)
+			selectors, err := allowlistSelectors(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorder := record.NewFakeRecorder(10)
+			c := &NodeLifeSupportController{client: client, meta: meta, selectors: selectors, recorder: recorder, opts: Options{
+				HolderIdentity: HolderIdentityNode, SyncInterval: time.Minute, RespectOutOfService: tt.respect,
+			}}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if err := c.Start(ctx); err != nil {
+				t.Fatalf("Start() error: %v", err)
+			}
+
+			c.engage("dead")
+			for i := 0; i < 2; i++ {
+				if err := c.SyncAllNodes(ctx); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, a := range client.Actions() {
+				if a.GetResource().Resource == "nodes" && a.GetVerb() == "watch" {
+					t.Error("full Nodes watched")
+				}
+			}
+			var engaged []string
+			for _, name := range []string{"dead", "healthy"} {
+				if c.engaged(name) {
+					engaged = append(engaged, name)
+				}
+			}
+			if len(engaged) != len(tt.engaged) || engaged[0] != tt.engaged[0] {
+				t.Errorf("engaged = %v, want %v", engaged, tt.engaged)
+			}
+
+			skipped := 0
+			for len(recorder.Events) > 0 {
+				if e := <-recorder.Events; e == "Warning "+reasonLifeSupportSkipped+" Not putting node on life support: it has the "+v1.TaintNodeOutOfService+" taint" {
+					skipped++
+				}
+			}
+			if want := map[bool]int{true: 1}[tt.respect]; skipped != want {
+				t.Errorf("%d %s Events, want %d", skipped, reasonLifeSupportSkipped, want)
+			}
+			if tt.respect {
+				h, _ := c.nodeHistory("dead")
+				if len(h) != 2 || h[1].Detail != disengageOutOfService {
+					t.Errorf("history = %+v, want disengagement for %s", h, disengageOutOfService)
+				}
+			}
+		})
+	}
+}
+
+// TestShuttingDown tests which nodes are recognised as shutting down on
+// purpose, and that RESPECT_SHUTDOWN leaves them off life support.
+func TestShuttingDown(t *testing.T) {
+	ready := func(status v1.ConditionStatus, message string) v1.NodeStatus {
+		return v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: status, Reason: "KubeletNotReady", Message: message}}}
+	}
+	tests := []struct {
+		name     string
+		node     v1.Node
+		expected bool
+	}{
+		{name: "healthy", node: v1.Node{Status: ready(v1.ConditionTrue, "kubelet is posting ready status")}},
+		{name: "not ready", node: v1.Node{Status: ready(v1.ConditionFalse, "container runtime is down")}},
+		{name: "graceful shutdown", node: v1.Node{Status: ready(v1.ConditionFalse, "container runtime status check may not have completed yet, node is shutting down")}, expected: true},
+		{name: "shutdown taint", node: v1.Node{Spec: v1.NodeSpec{Taints: []v1.Taint{{Key: shutdownTaint, Effect: v1.TaintEffectNoSchedule}}}}, expected: true},
+		{name: "not-ready taint", node: v1.Node{Spec: v1.NodeSpec{Taints: []v1.Taint{{Key: v1.TaintNodeNotReady, Effect: v1.TaintEffectNoSchedule}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shuttingDown(&tt.node) != ""; got != tt.expected {
+				t.Errorf("shuttingDown() = %v, want %v", got, tt.expected)
+			}
+			tt.node.Name = "node1"
+			client := fake.NewSimpleClientset(&tt.node)
+			for _, respect := range []bool{true, false} {
+				// Read from the API server, or from the cache kept for
+				// policy expressions.
+				for _, cached := range []bool{false, true} {
+					c := &NodeLifeSupportController{client: client, opts: Options{RespectShutdown: respect}}
+					stop := make(chan struct{})
+					if cached && !cache.WaitForCacheSync(stop, c.startNodeInformer(stop)) {
+						t.Fatal("node cache not synced")
+					}
+					reason, _ := c.unsupportable(context.Background(), "node1")
+					close(stop)
+					if want := map[bool]string{true: disengageNodeShuttingDown}[tt.expected && respect]; reason != want {
+						t.Errorf("RESPECT_SHUTDOWN=%v, cached %v: unsupportable() = %q, want %q", respect, cached, reason, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestSupportableRecheck tests that a node on life support is read again at
+// most every supportableRecheckInterval, one read serving both the
+// out-of-service and shutdown checks, and disengaged once it is found with
+// either taint.
+func TestSupportableRecheck(t *testing.T) {
+	tests := []struct {
+		taint  string
+		reason string
+	}{
+		{taint: v1.TaintNodeOutOfService, reason: disengageOutOfService},
+		{taint: shutdownTaint, reason: disengageNodeShuttingDown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reason, func(t *testing.T) {
+			h := newHarness(t, Options{RespectOutOfService: true, RespectShutdown: true}, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1"}})
+			gets := func() int {
+				n := 0
+				for _, a := range h.client.Actions() {
+					if a.GetVerb() == "get" && a.GetResource().Resource == "nodes" && a.GetSubresource() == "" {
+						n++
+					}
+				}
+				h.client.ClearActions()
+				return n
+			}
+			h.sync()
+			if n := gets(); n != 1 {
+				t.Errorf("%d node reads engaging, want 1", n)
+			}
+
+			node, err := h.client.CoreV1().Nodes().Get(h.ctx, "node1", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			node.Spec.Taints = []v1.Taint{{Key: tt.taint, Effect: v1.TaintEffectNoExecute}}
+			if _, err := h.client.CoreV1().Nodes().Update(h.ctx, node, metav1.UpdateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			h.client.ClearActions()
+			// Renewed every 10s, but not read again within the minute.
+			for i := 0; i < 5; i++ {
+				h.clock.Step(10 * time.Second)
+				h.sync()
+			}
+			if n := gets(); n != 0 {
+				t.Errorf("%d node reads renewing within %s, want none", n, supportableRecheckInterval)
+			}
+			if !h.c.engaged("node1") {
+				t.Fatal("disengaged before it was read again")
+			}
+			h.clock.Step(10 * time.Second)
+			h.sync()
+			if n := gets(); n != 1 {
+				t.Errorf("%d node reads after %s, want 1", n, supportableRecheckInterval)
+			}
+			if h.c.engaged("node1") {
+				t.Errorf("still engaged after the %s taint was read", tt.taint)
+			}
+			if h, _ := h.c.nodeHistory("node1"); len(h) != 2 || h[1].Detail != tt.reason {
+				t.Errorf("history = %+v, want disengagement for %s", h, tt.reason)
+			}
+		})
+	}
+}
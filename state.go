@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// nodeState is what the controller remembers about a node it has put on
+// life support.
+type nodeState struct {
+	engagedSince time.Time
+	// nextSync is when the node is next due; zero means immediately.
+	nextSync time.Time
+	// lastRenew is the renewTime the controller last wrote to the node's
+	// lease, and kubeletRenew the latest renewal by anyone else since.
+	lastRenew    time.Time
+	kubeletRenew time.Time
+	// leaseExpiry is when the lease the controller last renewed expires.
+	leaseExpiry time.Time
+	// recoveringSince is set while the node's kubelet is heartbeating again
+	// and the controller is cooling down before standing down.
+	recoveringSince time.Time
+	// cordoned is set once the controller has set the node's schedulability,
+	// unschedulable unless its policy's schedulability says otherwise.
+	cordoned bool
+	// assisted is set once the controller has applied the assistedTaint,
+	// and labelled once it has applied the activeLabel.
+	assisted bool
+	labelled bool
+	// gatesPending lists the READINESS_GATES the node is waiting for, and
+	// gatesPassed is set once it has none.
+	gatesPending string
+	gatesPassed  bool
+	// failures counts the node's consecutive failed syncs the API server
+	// rejected, lastError is the latest error, and quarantinedUntil is set
+	// while the node is not synced because of them.
+	failures         int
+	lastError        string
+	quarantinedUntil time.Time
+	// failing is the half of the node's sync, failingLease or failingStatus,
+	// that failed last time while the other succeeded.
+	failing string
+	// failedSyncs counts the node's consecutive failed syncs, whatever the
+	// error, for its history.
+	failedSyncs int
+	// deadHandled is set once the node's pods have been handled after it was
+	// declared dead, see handleDeclaredDead.
+	deadHandled bool
+}
+
+// engage records that the node is on life support and returns its state.
+func (c *NodeLifeSupportController) engage(name string) *nodeState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.nodes == nil {
+		c.nodes = make(map[string]*nodeState)
+	}
+	st, ok := c.nodes[name]
+	if !ok {
+		st = &nodeState{engagedSince: c.now()}
+		since, restored := c.restored[name]
+		if restored {
+			st.engagedSince = since
+			delete(c.restored, name)
+		}
+		c.nodes[name] = st
+		c.recordActivity(activityRecord{at: c.now(), node: name, engaged: true})
+		c.audit(name, auditEngaged, "")
+		delete(c.stale, name)
+		engagementsTotal.WithLabelValues(c.opts.Cluster).Inc()
+		engagedNodes.WithLabelValues(c.opts.Cluster).Set(float64(len(c.nodes)))
+		if restored {
+			c.recordTransition(name, transitionEngaged, "resumed after restart, engaged since "+since.UTC().Format(time.RFC3339))
+			c.logf("node %s: life support resumed, engaged since %s", name, since.Format(time.RFC3339))
+		} else if flapped, after := c.countIntervention(name, st.engagedSince); flapped {
+			detail := fmt.Sprintf("flapping, disengaged %s earlier", after.Round(time.Second))
+			c.recordTransition(name, transitionEngaged, detail)
+			c.runExecHook(transitionEngaged, name, "", st.engagedSince)
+			c.logf("node %s: life support engaged, %s", name, detail)
+		} else {
+			c.recordTransition(name, transitionEngaged, "")
+			c.runExecHook(transitionEngaged, name, "", st.engagedSince)
+			c.logf("node %s: life support engaged", name)
+		}
+	}
+	return st
+}
+
+// Reasons a node is taken off life support, recorded in its Events, history,
+// audit log and /status, the webhook notification and
+// node_life_support_disengagements_total, so automation can branch on them.
+const (
+	// disengageMaxDurationExceeded: MAX_LIFE_SUPPORT_DURATION was reached.
+	disengageMaxDurationExceeded = "MaxDurationExceeded"
+	// disengageExpiryReached: the expires-at annotation has passed.
+	disengageExpiryReached = "ExpiryReached"
+	// disengagePolicyDeleted: no policy in POLICY_FILE selects the node any
+	// more.
+	disengagePolicyDeleted = "PolicyDeleted"
+	// disengageWindowClosed: the node's policy window has closed.
+	disengageWindowClosed = "WindowClosed"
+	// disengageCalendarExclusion: a CALENDAR_FILE exclusion with
+	// disengageExisting began.
+	disengageCalendarExclusion = "CalendarExclusion"
+	// disengageRecovered: the kubelet heartbeat was stable for
+	// RECOVERY_COOL_DOWN.
+	disengageRecovered = "Recovered"
+	// disengageProbeFailed: a PROBE_PLUGIN_DIR plugin found the node
+	// unhealthy.
+	disengageProbeFailed = "ProbeFailed"
+	// disengageOperatorDisabled: the node is no longer selected by
+	// ALLOWED_LABEL_KEYS, e.g. because its label was removed.
+	disengageOperatorDisabled = "OperatorDisabled"
+	// disengageShardReassigned: the node moved to another replica's shard.
+	disengageShardReassigned = "ShardReassigned"
+	// disengageNodeDeleted: the node was deleted.
+	disengageNodeDeleted = "NodeDeleted"
+	// disengageNodeDeleting: the node is being deleted.
+	disengageNodeDeleting = "NodeDeleting"
+	// disengageOutOfService: the node has the out-of-service taint.
+	disengageOutOfService = "OutOfService"
+	// disengageNodeShuttingDown: the node is shutting down on purpose.
+	disengageNodeShuttingDown = "NodeShuttingDown"
+)
+
+// reasonAnnotation carries the reason a node was taken off life support on
+// the Events recorded when it is.
+const reasonAnnotation = "node-life-support.io/disengage-reason"
+
+// disengage takes a node off life support for the given reason: its lease is
+// handed back to the original holder, its schedulability is restored, taints
+// it was given are removed and its LifeSupportActive condition set to False,
+// and the controller stops tracking it. A node deleted in the meantime has
+// nothing left to restore.
+func (c *NodeLifeSupportController) disengage(ctx context.Context, name, reason string) error {
+	for _, p := range patchers {
+		if err := p.Unpatch(ctx, c, name); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	c.stopDrain(name)
+	c.mu.Lock()
+	if st, ok := c.nodes[name]; ok {
+		c.closeEngagement(name, st.engagedSince, c.now())
+		c.runExecHook(transitionDisengaged, name, reason, st.engagedSince)
+	}
+	delete(c.nodes, name)
+	c.recordActivity(activityRecord{at: c.now(), node: name, reason: reason})
+	c.recordTransition(name, transitionDisengaged, reason)
+	engagedNodes.WithLabelValues(c.opts.Cluster).Set(float64(len(c.nodes)))
+	c.updateQuarantineMetric(c.now())
+	c.updatePartialSyncMetric()
+	c.mu.Unlock()
+	disengagementsTotal.WithLabelValues(c.opts.Cluster, reason).Inc()
+	c.audit(name, auditDisengaged, reason)
+	c.flushLogSamples(name)
+	c.logf("node %s: life support disengaged (%s)", name, reason)
+	return nil
+}
+
+// engaged reports whether the node is currently on life support.
+func (c *NodeLifeSupportController) engaged(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.nodes[name]
+	return ok
+}
+
+// engagedSince returns when the node was put on life support, or false if it
+// is not.
+func (c *NodeLifeSupportController) engagedSince(name string) (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st, ok := c.nodes[name]
+	if !ok {
+		return time.Time{}, false
+	}
+	return st.engagedSince, true
+}
+
+// invalidAnnotation logs that a node's annotation could not be used, once per
+// distinct value rather than every cycle.
+func (c *NodeLifeSupportController) invalidAnnotation(name, key, value string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.badAnnotations == nil {
+		c.badAnnotations = make(map[string]string)
+	}
+	if last, ok := c.badAnnotations[name+" "+key]; ok && last == value {
+		return
+	}
+	c.badAnnotations[name+" "+key] = value
+	c.logf("node %s: ignoring invalid %s annotation: %v", name, key, err)
+}
+
+// forget drops all state for a node without touching the API, for nodes that
+// no longer exist.
+func (c *NodeLifeSupportController) forget(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.windowOverrides, name)
+	delete(c.exhausted, name)
+	delete(c.stale, name)
+	delete(c.queued, name)
+	delete(c.nodeClients, name)
+	delete(c.accounts, name)
+	nodeEngagementsTotal.DeleteLabelValues(c.opts.Cluster, name)
+	nodeFlapsTotal.DeleteLabelValues(c.opts.Cluster, name)
+	delete(c.probeResults, name)
+	delete(c.debugNodes, name)
+	delete(c.skippedNodes, name)
+	delete(c.supportableChecks, name)
+	delete(c.nodePolicies, name)
+	delete(c.policyOverlaps, name)
+	delete(c.followedRules, name)
+	if d, ok := c.drains[name]; ok {
+		d.cancel()
+		delete(c.drains, name)
+	}
+	c.flushLogSamples(name)
+	if st, ok := c.nodes[name]; ok {
+		delete(c.nodes, name)
+		c.runExecHook(transitionDisengaged, name, disengageNodeDeleted, st.engagedSince)
+		c.recordActivity(activityRecord{at: c.now(), node: name, reason: disengageNodeDeleted})
+		c.recordTransition(name, transitionDisengaged, disengageNodeDeleted)
+		engagedNodes.WithLabelValues(c.opts.Cluster).Set(float64(len(c.nodes)))
+		disengagementsTotal.WithLabelValues(c.opts.Cluster, disengageNodeDeleted).Inc()
+		c.logf("node %s: deleted, no longer tracked", name)
+	}
+}
+
+// disengageUntargeted disengages every tracked node that was not targeted in
+// the current cycle, e.g. because it lost its allowlisted label or moved to
+// another shard, with a LifeSupportDisengaged Event. reasons are why nodes were
+// skipped this cycle; nodes no longer listed at all have lost their label.
+func (c *NodeLifeSupportController) disengageUntargeted(ctx context.Context, targeted map[string]struct{}, reasons map[string]string) {
+	c.mu.Lock()
+	var stale []string
+	for name := range c.nodes {
+		if _, ok := targeted[name]; !ok {
+			stale = append(stale, name)
+		}
+	}
+	c.mu.Unlock()
+
+	sort.Strings(stale)
+	for _, name := range stale {
+		reason, ok := reasons[name]
+		if !ok {
+			reason = disengageOperatorDisabled
+		}
+		if err := c.disengage(ctx, name, reason); err != nil {
+			c.logf("failed disengaging node %s: %v", name, err)
+			continue
+		}
+		c.nodeEventAnnotated(name, "", map[string]string{reasonAnnotation: reason}, v1.EventTypeNormal,
+			reasonLifeSupportDisengaged, "Life support disengaged: %s", reason)
+	}
+}
+
+// due reports whether the node should be synced at now.
+func (c *NodeLifeSupportController) due(name string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st, ok := c.nodes[name]
+	return !ok || !now.Before(st.nextSync)
+}
+
+// resuming reports whether the node was engaged according to STATE_CONFIGMAP
+// and has yet to be engaged again.
+func (c *NodeLifeSupportController) resuming(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.restored[name]
+	return ok
+}
+
+// scheduleNext records when the node should next be synced.
+func (c *NodeLifeSupportController) scheduleNext(name string, next time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if st, ok := c.nodes[name]; ok {
+		st.nextSync = next
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// TestDisengageUntargeted tests that nodes no longer targeted are disengaged
+// with the reason they were skipped for, which is recorded in their Events,
+// history, /status and metrics.
+func TestDisengageUntargeted(t *testing.T) {
+	ctx := context.Background()
+	recorder := record.NewFakeRecorder(10)
+	c := &NodeLifeSupportController{
+		client:   fake.NewSimpleClientset(),
+		recorder: recorder,
+		opts:     Options{Cluster: "reasons", ReportRetention: time.Hour},
+	}
+	for _, name := range []string{"node1", "node2", "node3"} {
+		c.engage(name)
+	}
+
+	targeted := map[string]struct{}{"node3": {}}
+	c.disengageUntargeted(ctx, targeted, map[string]string{"node1": disengageWindowClosed})
+
+	for _, reason := range []string{disengageWindowClosed, disengageOperatorDisabled} {
+		want := "Normal LifeSupportDisengaged Life support disengaged: " + reason + " map[" + reasonAnnotation + ":" + reason + "]"
+		if e := <-recorder.Events; e != want {
+			t.Errorf("event = %q, want %q", e, want)
+		}
+	}
+	want := map[string]string{"node1": disengageWindowClosed, "node2": disengageOperatorDisabled}
+	for name, reason := range want {
+		if c.engaged(name) {
+			t.Errorf("%s still engaged", name)
+		}
+		h, _ := c.nodeHistory(name)
+		if last := h[len(h)-1]; last.Type != transitionDisengaged || last.Detail != reason {
+			t.Errorf("%s: last transition %+v, want %s %s", name, last, transitionDisengaged, reason)
+		}
+		if got := testutil.ToFloat64(disengagementsTotal.WithLabelValues("reasons", reason)); got != 1 {
+			t.Errorf("disengagements for %s = %v, want 1", reason, got)
+		}
+	}
+	if !c.engaged("node3") {
+		t.Errorf("targeted node3 disengaged")
+	}
+
+	cs := &clusterStatus{}
+	c.fillStatus(cs, c.now())
+	if len(cs.Disengaged) != len(want) {
+		t.Fatalf("status disengaged = %+v", cs.Disengaged)
+	}
+	for _, d := range cs.Disengaged {
+		if d.Reason != want[d.Node] {
+			t.Errorf("status: %s disengaged for %q, want %q", d.Node, d.Reason, want[d.Node])
+		}
+	}
+
+	c.engage("node1")
+	cs = &clusterStatus{}
+	c.fillStatus(cs, c.now())
+	if len(cs.Disengaged) != 1 || cs.Disengaged[0].Node != "node2" {
+		t.Errorf("status disengaged after node1 was engaged again = %+v", cs.Disengaged)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/utils/clock"
+)
+
+// worstOffenders is how many of the longest-supported nodes /status lists.
+const worstOffenders = 10
+
+// fleetStatus is served on /status: the engagement state of every cluster the
+// controller supervises, so a whole fleet can be checked in one place.
+type fleetStatus struct {
+	Clusters    int `json:"clusters"`
+	Unreachable int `json:"unreachable"`
+	Engaged     int `json:"engaged"`
+	Queued      int `json:"queued"`
+	Pods        int `json:"pods,omitempty"`
+	// WorstOffenders are the nodes on life support the longest, fleet-wide.
+	WorstOffenders []nodeStatus     `json:"worstOffenders"`
+	ClusterStatus  []*clusterStatus `json:"clusterStatus"`
+	// Leader is set with LEADER_ELECTION.
+	Leader *leaderStatus `json:"leader,omitempty"`
+}
+
+type clusterStatus struct {
+	// Name is empty in single-cluster mode.
+	Name      string `json:"name"`
+	Reachable bool   `json:"reachable"`
+	// Degraded is set while the API server fails PREFLIGHT_CHECK.
+	Degraded bool `json:"degraded,omitempty"`
+	// MissingPermissions are those RBAC_CHECK found missing.
+	MissingPermissions []string   `json:"missingPermissions,omitempty"`
+	Error              string     `json:"error,omitempty"`
+	LastSync           *time.Time `json:"lastSync,omitempty"`
+	Engaged            int        `json:"engaged"`
+	Queued             int        `json:"queued"`
+	// Quarantined are the engaged nodes not synced after QUARANTINE_AFTER
+	// consecutive failures.
+	Quarantined int `json:"quarantined,omitempty"`
+	// Pods are the pods on engaged nodes, with POD_INVENTORY_INTERVAL.
+	Pods  int          `json:"pods,omitempty"`
+	Nodes []nodeStatus `json:"nodes"`
+	// Draining are the nodes being drained before they are given up on.
+	Draining []drainStatus `json:"draining,omitempty"`
+	// Disengaged are the nodes taken off life support within
+	// REPORT_RETENTION and not engaged since, with why, latest first.
+	Disengaged []nodeActivity `json:"disengaged,omitempty"`
+}
+
+type drainStatus struct {
+	Node      string `json:"node"`
+	Remaining int    `json:"remaining"`
+	// BlockedBy are the PodDisruptionBudgets refusing evictions.
+	BlockedBy []string `json:"blockedBy,omitempty"`
+}
+
+type nodeStatus struct {
+	Cluster          string    `json:"cluster,omitempty"`
+	Name             string    `json:"name"`
+	EngagedSince     time.Time `json:"engagedSince"`
+	SupportedSeconds float64   `json:"supportedSeconds"`
+	// Policy is the policy handling the node, with POLICY_FILE.
+	Policy string `json:"policy,omitempty"`
+	// Pods and CriticalPods are filled in with POD_INVENTORY_INTERVAL.
+	Pods         int      `json:"pods,omitempty"`
+	CriticalPods []string `json:"criticalPods,omitempty"`
+	// LastError is why the node's last sync failed, and QuarantinedUntil
+	// when it is synced again after too many failures.
+	LastError        string     `json:"lastError,omitempty"`
+	QuarantinedUntil *time.Time `json:"quarantinedUntil,omitempty"`
+	// Failing is "lease" or "status" while only that half of the node's
+	// sync fails.
+	Failing string `json:"failing,omitempty"`
+	// WindowSupportedSeconds and TotalSupportedSeconds add up the node's
+	// time on life support over the last SUPPORT_WINDOW and in total.
+	WindowSupportedSeconds float64 `json:"windowSupportedSeconds"`
+	TotalSupportedSeconds  float64 `json:"totalSupportedSeconds"`
+}
+
+// fleet tracks the controllers running in this process, one per cluster.
+type fleet struct {
+	mu      sync.Mutex
+	members []*fleetMember
+	// clock times /status and /report, the real one when unset.
+	clock clock.Clock
+}
+
+type fleetMember struct {
+	name string
+	c    *NodeLifeSupportController
+	err  string
+}
+
+// fleetRegistry is the process-wide fleet served on /status.
+var fleetRegistry = &fleet{}
+
+// now returns the current time from the fleet's clock.
+func (f *fleet) now() time.Time {
+	if f.clock == nil {
+		return time.Now()
+	}
+	return f.clock.Now()
+}
+
+// register adds a cluster's controller, or records why it could not be
+// started when c is nil.
+func (f *fleet) register(name string, c *NodeLifeSupportController, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m := &fleetMember{name: name, c: c}
+	if err != nil {
+		m.err = err.Error()
+	}
+	for i, existing := range f.members {
+		if existing.name == name {
+			f.members[i] = m
+			return
+		}
+	}
+	f.members = append(f.members, m)
+}
+
+// unregister drops a cluster that is no longer supervised.
+func (f *fleet) unregister(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, m := range f.members {
+		if m.name == name {
+			f.members = append(f.members[:i], f.members[i+1:]...)
+			return
+		}
+	}
+}
+
+// status aggregates the state of every registered cluster at now.
+func (f *fleet) status(now time.Time) fleetStatus {
+	f.mu.Lock()
+	members := append([]*fleetMember(nil), f.members...)
+	f.mu.Unlock()
+
+	s := fleetStatus{ClusterStatus: []*clusterStatus{}}
+	var all []nodeStatus
+	for _, m := range members {
+		cs := &clusterStatus{Name: m.name, Error: m.err, Nodes: []nodeStatus{}}
+		if m.c != nil {
+			m.c.fillStatus(cs, now)
+		}
+		s.Clusters++
+		if !cs.Reachable {
+			s.Unreachable++
+		}
+		s.Engaged += cs.Engaged
+		s.Queued += cs.Queued
+		s.Pods += cs.Pods
+		all = append(all, cs.Nodes...)
+		s.ClusterStatus = append(s.ClusterStatus, cs)
+	}
+	sort.Slice(s.ClusterStatus, func(i, j int) bool { return s.ClusterStatus[i].Name < s.ClusterStatus[j].Name })
+	sort.SliceStable(all, func(i, j int) bool { return all[i].EngagedSince.Before(all[j].EngagedSince) })
+	if len(all) > worstOffenders {
+		all = all[:worstOffenders]
+	}
+	s.WorstOffenders = append([]nodeStatus{}, all...)
+	if leadership != nil {
+		s.Leader = leadership.status(now)
+	}
+	return s
+}
+
+func (f *fleet) serveStatus(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(f.status(f.now()))
+}
+
+// fillStatus reports the controller's engagements and whether its cluster is
+// reachable: it is not until the node watches have synced, nor once a cycle
+// has passed without one, or every node sync in the last cycle failed.
+func (c *NodeLifeSupportController) fillStatus(cs *clusterStatus, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, st := range c.nodes {
+		ns := nodeStatus{
+			Cluster:          c.opts.Cluster,
+			Name:             name,
+			EngagedSince:     st.engagedSince.UTC(),
+			SupportedSeconds: now.Sub(st.engagedSince).Seconds(),
+		}
+		ns.LastError, ns.Failing = st.lastError, st.failing
+		ns.Policy = c.nodePolicies[name]
+		_, window, total := c.supportedTime(name, now)
+		ns.WindowSupportedSeconds, ns.TotalSupportedSeconds = window.Seconds(), total.Seconds()
+		if now.Before(st.quarantinedUntil) {
+			until := st.quarantinedUntil.UTC()
+			ns.QuarantinedUntil = &until
+			cs.Quarantined++
+		}
+		if inv, ok := c.podInventory[name]; ok {
+			ns.Pods, ns.CriticalPods = inv.total, inv.critical
+			cs.Pods += inv.total
+		}
+		cs.Nodes = append(cs.Nodes, ns)
+	}
+	sort.Slice(cs.Nodes, func(i, j int) bool { return cs.Nodes[i].Name < cs.Nodes[j].Name })
+	cs.Engaged = len(c.nodes)
+	cs.Queued = len(c.queued)
+	cs.Degraded = c.degraded
+	cs.MissingPermissions = c.lackingPermissions
+	for name, d := range c.drains {
+		if d.done {
+			continue
+		}
+		ds := drainStatus{Node: name, Remaining: d.remaining}
+		for pdb := range d.blockers {
+			ds.BlockedBy = append(ds.BlockedBy, pdb)
+		}
+		sort.Strings(ds.BlockedBy)
+		cs.Draining = append(cs.Draining, ds)
+	}
+	sort.Slice(cs.Draining, func(i, j int) bool { return cs.Draining[i].Node < cs.Draining[j].Node })
+	seen := make(map[string]struct{})
+	for i := len(c.activity) - 1; i >= 0; i-- {
+		r := c.activity[i]
+		if r.node == "" {
+			continue
+		}
+		if _, ok := seen[r.node]; ok {
+			continue
+		}
+		seen[r.node] = struct{}{}
+		if _, engaged := c.nodes[r.node]; !engaged && !r.engaged {
+			cs.Disengaged = append(cs.Disengaged, nodeActivity{Node: r.node, Time: r.at.UTC(), Reason: r.reason})
+		}
+	}
+	if c.lastSync.IsZero() {
+		if cs.Error == "" {
+			cs.Error = "not synced yet"
+		}
+		return
+	}
+	last := c.lastSync.UTC()
+	cs.LastSync = &last
+	switch {
+	case now.Sub(c.lastSync) > 3*c.opts.SyncInterval:
+		cs.Error = "no sync cycle completed recently"
+	case c.lastSyncAttempts > 0 && c.lastSyncFailures == c.lastSyncAttempts:
+		cs.Error = "every node sync failed in the last cycle"
+	default:
+		cs.Reachable = true
+	}
+}
+
+// recordCycleDuration exports how long a sync cycle took and reports whether
+// it overran SYNC_INTERVAL, counting and logging overruns.
+func (c *NodeLifeSupportController) recordCycleDuration(elapsed time.Duration) bool {
+	syncCycleSeconds.WithLabelValues(c.opts.Cluster).Set(elapsed.Seconds())
+	if elapsed <= c.opts.SyncInterval {
+		return false
+	}
+	syncOverrunsTotal.WithLabelValues(c.opts.Cluster).Inc()
+	c.logf("sync cycle took %s, longer than SYNC_INTERVAL (%s); skipping the missed tick", elapsed.Round(time.Millisecond), c.opts.SyncInterval)
+	return true
+}
+
+// recordCycle notes the outcome of a sync cycle for /status and /report.
+func (c *NodeLifeSupportController) recordCycle(now time.Time, attempts, failures int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastSync, c.lastSyncAttempts, c.lastSyncFailures = now, attempts, failures
+	writes := c.writes.Load()
+	c.recordActivity(activityRecord{at: now, syncs: attempts, failed: failures, writes: int(writes - c.lastWrites)})
+	c.lastWrites = writes
+}
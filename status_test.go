@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestFleetStatus(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	controller := func(cluster string, lastSync time.Time, attempts, failures int, engaged map[string]time.Duration) *NodeLifeSupportController {
+		c := &NodeLifeSupportController{
+			opts:     Options{Cluster: cluster, SyncInterval: time.Minute},
+			nodes:    make(map[string]*nodeState),
+			lastSync: lastSync,
+		}
+		c.lastSyncAttempts, c.lastSyncFailures = attempts, failures
+		for name, ago := range engaged {
+			c.nodes[name] = &nodeState{engagedSince: now.Add(-ago)}
+		}
+		return c
+	}
+
+	f := &fleet{}
+	f.register("healthy", controller("healthy", now.Add(-30*time.Second), 2, 1, map[string]time.Duration{
+		"a": time.Hour, "b": 3 * time.Hour,
+	}), nil)
+	f.register("stale", controller("stale", now.Add(-10*time.Minute), 0, 0, map[string]time.Duration{
+		"c": 2 * time.Hour,
+	}), nil)
+	f.register("failing", controller("failing", now, 3, 3, nil), nil)
+	f.register("syncing", controller("syncing", time.Time{}, 0, 0, nil), nil)
+	f.register("broken", nil, errors.New("no kubeconfig"))
+
+	s := f.status(now)
+	if s.Clusters != 5 || s.Unreachable != 4 || s.Engaged != 3 {
+		t.Fatalf("got clusters=%d unreachable=%d engaged=%d, want 5, 4, 3", s.Clusters, s.Unreachable, s.Engaged)
+	}
+	var worst []string
+	for _, n := range s.WorstOffenders {
+		worst = append(worst, n.Cluster+"/"+n.Name)
+	}
+	if got, want := worst, []string{"healthy/b", "stale/c", "healthy/a"}; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("worst offenders %v, want %v", got, want)
+	}
+	wantErr := map[string]string{
+		"broken":  "no kubeconfig",
+		"failing": "every node sync failed in the last cycle",
+		"healthy": "",
+		"stale":   "no sync cycle completed recently",
+		"syncing": "not synced yet",
+	}
+	for _, cs := range s.ClusterStatus {
+		if cs.Error != wantErr[cs.Name] || cs.Reachable != (cs.Error == "") {
+			t.Errorf("cluster %s: reachable=%v error=%q, want error %q", cs.Name, cs.Reachable, cs.Error, wantErr[cs.Name])
+		}
+	}
+}
+
+func TestFleetRegisterReplaces(t *testing.T) {
+	f := &fleet{}
+	f.register("edge", nil, errors.New("boom"))
+	f.register("edge", &NodeLifeSupportController{nodes: map[string]*nodeState{}}, nil)
+	if s := f.status(time.Now()); s.Clusters != 1 || s.ClusterStatus[0].Error != "not synced yet" {
+		t.Errorf("got %+v", s.ClusterStatus)
+	}
+}
+
+// TestRecordCycleDuration tests that only cycles longer than SYNC_INTERVAL
+// are reported and counted as overruns.
+func TestRecordCycleDuration(t *testing.T) {
+	c := &NodeLifeSupportController{opts: Options{Cluster: "overrun-test", SyncInterval: 5 * time.Second}}
+	for _, tt := range []struct {
+		elapsed time.Duration
+		want    bool
+	}{
+		{elapsed: time.Second},
+		{elapsed: 5 * time.Second},
+		{elapsed: 7 * time.Second, want: true},
+	} {
+		if got := c.recordCycleDuration(tt.elapsed); got != tt.want {
+			t.Errorf("recordCycleDuration(%s) = %v, want %v", tt.elapsed, got, tt.want)
+		}
+		if got := testutil.ToFloat64(syncCycleSeconds.WithLabelValues("overrun-test")); got != tt.elapsed.Seconds() {
+			t.Errorf("cycle seconds = %v, want %v", got, tt.elapsed.Seconds())
+		}
+	}
+	if got := testutil.ToFloat64(syncOverrunsTotal.WithLabelValues("overrun-test")); got != 1 {
+		t.Errorf("overruns = %v, want 1", got)
+	}
+}
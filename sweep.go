@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// sweepStaleMetadata removes what the controller marks nodes on life support
+// with from nodes that are not, e.g. because the controller restarted while
+// they were, or its allowlist or policies changed in between: the
+// assistedTaint, the activeLabel and a LifeSupportActive condition still
+// True. It runs after the first sync cycle, once engagements are known again,
+// whatever ASSISTED_TAINT and ACTIVE_LABEL now say. Schedulability is
+// restored every cycle by restoreOrphanedCordons.
+func (c *NodeLifeSupportController) sweepStaleMetadata(ctx context.Context) {
+	opCtx, cancel := c.opContext(ctx)
+	nodes, err := c.client.CoreV1().Nodes().List(opCtx, metav1.ListOptions{})
+	cancel()
+	if err != nil {
+		c.logf("listing nodes to remove stale life support metadata: %v", err)
+		return
+	}
+	c.metadataSwept = true
+	taint := v1.Taint{Key: assistedTaint, Effect: v1.TaintEffectPreferNoSchedule}
+	for i := range nodes.Items {
+		n := &nodes.Items[i]
+		if c.engaged(n.Name) || (c.shard != nil && !c.shard.owns(n.Name)) {
+			continue
+		}
+		for _, t := range n.Spec.Taints {
+			if !t.MatchTaint(&taint) {
+				continue
+			}
+			if err := c.removeTaint(ctx, n.Name, taint); err != nil {
+				c.logf("node %s: removing stale %s taint: %v", n.Name, assistedTaint, err)
+			}
+			break
+		}
+		if _, ok := n.Labels[activeLabel]; ok {
+			if err := c.removeActiveLabel(ctx, n.Name); err != nil {
+				c.logf("node %s: removing stale %s label: %v", n.Name, activeLabel, err)
+			}
+		}
+		for _, cond := range n.Status.Conditions {
+			if cond.Type != lifeSupportCondition || cond.Status != v1.ConditionTrue {
+				continue
+			}
+			if err := c.clearLifeSupportCondition(ctx, n.Name); err != nil {
+				c.logf("node %s: clearing stale %s condition: %v", n.Name, lifeSupportCondition, err)
+			}
+			break
+		}
+	}
+}
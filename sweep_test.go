@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestSweepStaleMetadata tests that taints, labels and conditions left by a
+// previous run are removed from nodes that are no longer on life support.
+func TestSweepStaleMetadata(t *testing.T) {
+	taint := v1.Taint{Key: assistedTaint, Effect: v1.TaintEffectPreferNoSchedule}
+	marked := func(name string) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{activeLabel: "true", "pool": "edge"}},
+			Spec:       v1.NodeSpec{Taints: []v1.Taint{taint}},
+			Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: lifeSupportCondition, Status: v1.ConditionTrue}}},
+		}
+	}
+	client := fake.NewSimpleClientset(marked("stale"), marked("engaged"))
+	c := &NodeLifeSupportController{client: client}
+	c.engage("engaged")
+
+	c.sweepStaleMetadata(context.Background())
+
+	if taints := nodeTaints(t, client, "stale"); len(taints) != 0 {
+		t.Errorf("stale node still tainted: %v", taints)
+	}
+	if taints := nodeTaints(t, client, "engaged"); len(taints) != 1 {
+		t.Errorf("engaged node lost its taint: %v", taints)
+	}
+	for name, want := range map[string]int{"stale": 1, "engaged": 2} {
+		n, err := client.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(n.Labels) != want || n.Labels["pool"] != "edge" {
+			t.Errorf("%s node labels = %v", name, n.Labels)
+		}
+	}
+	if cond := nodeCondition(t, client, "stale", lifeSupportCondition); cond == nil || cond.Status != v1.ConditionFalse {
+		t.Errorf("stale node condition = %+v, want False", cond)
+	}
+	if cond := nodeCondition(t, client, "engaged", lifeSupportCondition); cond == nil || cond.Status != v1.ConditionTrue {
+		t.Errorf("engaged node condition = %+v, want True", cond)
+	}
+	if !c.metadataSwept {
+		t.Error("sweep not recorded")
+	}
+}
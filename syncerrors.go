@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// errorSamples is how many failures of each class a cycle summary quotes.
+const errorSamples = 3
+
+// syncErrors collects the node sync failures of one cycle by class, so they
+// can be reported in a single summary instead of a log line each.
+type syncErrors struct {
+	total   int
+	counts  map[string]int
+	samples map[string][]string
+}
+
+func newSyncErrors() *syncErrors {
+	return &syncErrors{counts: make(map[string]int), samples: make(map[string][]string)}
+}
+
+// add records that syncing the node failed with err.
+func (e *syncErrors) add(node string, err error) {
+	class := errorClass(err)
+	e.total++
+	e.counts[class]++
+	if len(e.samples[class]) < errorSamples {
+		e.samples[class] = append(e.samples[class], fmt.Sprintf("node %s: %v", node, err))
+	}
+}
+
+// String summarises the failures by class, most frequent first, e.g.
+// "Forbidden=2 (node a: ...; node b: ...), Timeout=1 (node c: ...)".
+func (e *syncErrors) String() string {
+	classes := make([]string, 0, len(e.counts))
+	for class := range e.counts {
+		classes = append(classes, class)
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		if e.counts[classes[i]] != e.counts[classes[j]] {
+			return e.counts[classes[i]] > e.counts[classes[j]]
+		}
+		return classes[i] < classes[j]
+	})
+	parts := make([]string, 0, len(classes))
+	for _, class := range classes {
+		parts = append(parts, fmt.Sprintf("%s=%d (%s)", class, e.counts[class], strings.Join(e.samples[class], "; ")))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// errorClass buckets an error by the API status reason, e.g. Forbidden or
+// Conflict, with Timeout for calls cut off by API_TIMEOUT and Other for
+// errors without a status, such as connection failures.
+func errorClass(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return string(metav1.StatusReasonTimeout)
+	}
+	if reason := apierrors.ReasonForError(err); reason != "" {
+		return string(reason)
+	}
+	return "Other"
+}
+
+// reportSyncErrors logs a cycle's failures once and counts them by class.
+func (c *NodeLifeSupportController) reportSyncErrors(errs *syncErrors, attempts int) {
+	for class, n := range errs.counts {
+		syncErrorsTotal.WithLabelValues(c.opts.Cluster, class).Add(float64(n))
+	}
+	if errs.total == 0 {
+		return
+	}
+	c.logf("%d of %d node syncs failed: %s", errs.total, attempts, errs)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestErrorClass(t *testing.T) {
+	nodes := schema.GroupResource{Resource: "nodes"}
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{err: fmt.Errorf("update lease: %w", apierrors.NewForbidden(nodes, "node1", errors.New("rbac"))), want: "Forbidden"},
+		{err: apierrors.NewConflict(nodes, "node1", errors.New("modified")), want: "Conflict"},
+		{err: apierrors.NewServiceUnavailable("overloaded"), want: "ServiceUnavailable"},
+		{err: fmt.Errorf("update node status: %w", context.DeadlineExceeded), want: "Timeout"},
+		{err: errors.New("connection refused"), want: "Other"},
+	}
+	for _, tt := range tests {
+		if got := errorClass(tt.err); got != tt.want {
+			t.Errorf("errorClass(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+// TestSyncErrors tests that a cycle's failures are summarised by class, most
+// frequent first, quoting a few of each, and counted.
+func TestSyncErrors(t *testing.T) {
+	nodes := schema.GroupResource{Resource: "nodes"}
+	errs := newSyncErrors()
+	for i := 0; i < 5; i++ {
+		errs.add(fmt.Sprintf("node%d", i), apierrors.NewForbidden(nodes, "x", errors.New("rbac")))
+	}
+	errs.add("node9", errors.New("connection refused"))
+
+	want := `Forbidden=5 (node node0: nodes "x" is forbidden: rbac; node node1: nodes "x" is forbidden: rbac; ` +
+		`node node2: nodes "x" is forbidden: rbac), Other=1 (node node9: connection refused)`
+	if got := errs.String(); got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+
+	c := &NodeLifeSupportController{opts: Options{Cluster: "sync-errors-test"}}
+	c.reportSyncErrors(errs, 10)
+	if got := testutil.ToFloat64(syncErrorsTotal.WithLabelValues("sync-errors-test", "Forbidden")); got != 5 {
+		t.Errorf("Forbidden errors counted = %v, want 5", got)
+	}
+	if got := testutil.ToFloat64(syncErrorsTotal.WithLabelValues("sync-errors-test", "Other")); got != 1 {
+		t.Errorf("Other errors counted = %v, want 1", got)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// assistedTaint is applied, PreferNoSchedule, to nodes on life support with
+// ASSISTED_TAINT, steering new pods elsewhere without forbidding them.
+const assistedTaint = "node-life-support.io/assisted"
+
+// addTaint adds a taint to a node unless it already has it.
+func (c *NodeLifeSupportController) addTaint(ctx context.Context, name string, taint v1.Taint) error {
+	return c.updateTaints(ctx, name, func(taints []v1.Taint) ([]v1.Taint, bool) {
+		for _, t := range taints {
+			if t.MatchTaint(&taint) {
+				return taints, false
+			}
+		}
+		now := metav1.Now()
+		taint.TimeAdded = &now
+		return append(taints, taint), true
+	})
+}
+
+// removeTaint removes a taint from a node, if present.
+func (c *NodeLifeSupportController) removeTaint(ctx context.Context, name string, taint v1.Taint) error {
+	return c.updateTaints(ctx, name, func(taints []v1.Taint) ([]v1.Taint, bool) {
+		out := taints[:0:0]
+		for _, t := range taints {
+			if !t.MatchTaint(&taint) {
+				out = append(out, t)
+			}
+		}
+		return out, len(out) != len(taints)
+	})
+}
+
+// updateTaints applies change to a node's taints, retrying on conflicts.
+// Taints are a list without merge key, so they cannot be patched safely.
+func (c *NodeLifeSupportController) updateTaints(ctx context.Context, name string, change func([]v1.Taint) ([]v1.Taint, bool)) error {
+	return c.retryWrite(ctx, func(opCtx context.Context) error {
+		node, err := c.client.CoreV1().Nodes().Get(opCtx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		taints, changed := change(node.Spec.Taints)
+		if !changed {
+			return nil
+		}
+		node.Spec.Taints = taints
+		c.debugPayload(name, "updating taints to", taints)
+		if _, err = c.client.CoreV1().Nodes().Update(opCtx, node, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		c.audit(name, auditTaintsUpdated, taintList(taints))
+		return nil
+	})
+}
+
+// taintList describes taints for the audit log, e.g.
+// "node-life-support.io/assisted:PreferNoSchedule".
+func taintList(taints []v1.Taint) string {
+	out := make([]string, 0, len(taints))
+	for _, t := range taints {
+		out = append(out, t.ToString())
+	}
+	return strings.Join(out, ",")
+}
+
+// taintAssisted marks an engaged node with the assistedTaint, once per
+// engagement.
+func (c *NodeLifeSupportController) taintAssisted(ctx context.Context, name string) error {
+	c.mu.Lock()
+	st, ok := c.nodes[name]
+	done := ok && st.assisted
+	c.mu.Unlock()
+	if !ok || done {
+		return nil
+	}
+	if err := c.addTaint(ctx, name, v1.Taint{Key: assistedTaint, Effect: v1.TaintEffectPreferNoSchedule}); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if st, ok := c.nodes[name]; ok {
+		st.assisted = true
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// untaintAssisted removes the assistedTaint from a node leaving life support.
+// With ASSISTED_TAINT on for any node it checks even if this run did not
+// taint the node, in case an earlier one did.
+func (c *NodeLifeSupportController) untaintAssisted(ctx context.Context, name string) error {
+	c.mu.Lock()
+	st, ok := c.nodes[name]
+	tainted := ok && st.assisted
+	c.mu.Unlock()
+	if !tainted && !c.opts.patcherConfigured(TaintPatcher{}) {
+		return nil
+	}
+	err := c.removeTaint(ctx, name, v1.Taint{Key: assistedTaint, Effect: v1.TaintEffectPreferNoSchedule})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
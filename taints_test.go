@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func nodeTaints(t *testing.T, client *fake.Clientset, name string) []v1.Taint {
+	t.Helper()
+	n, err := client.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n.Spec.Taints
+}
+
+// TestAssistedTaint tests that an engaged node is tainted PreferNoSchedule
+// once, keeping its other taints, and untainted when disengaged.
+func TestAssistedTaint(t *testing.T) {
+	other := v1.Taint{Key: "dedicated", Value: "edge", Effect: v1.TaintEffectNoSchedule}
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1"}, Spec: v1.NodeSpec{Taints: []v1.Taint{other}}}
+	client := fake.NewSimpleClientset(node)
+	c := &NodeLifeSupportController{client: client, opts: Options{HolderIdentity: HolderIdentityNode, AssistedTaint: true}}
+	ctx := context.Background()
+	meta := &metav1.PartialObjectMetadata{ObjectMeta: node.ObjectMeta}
+
+	c.engage("node1")
+	for i := 0; i < 2; i++ {
+		if err := c.SyncNode(ctx, meta); err != nil {
+			t.Fatalf("SyncNode() error: %v", err)
+		}
+	}
+	taints := nodeTaints(t, client, "node1")
+	if len(taints) != 2 || taints[1].Key != assistedTaint || taints[1].Effect != v1.TaintEffectPreferNoSchedule {
+		t.Fatalf("taints while engaged = %v", taints)
+	}
+
+	if err := c.disengage(ctx, "node1", disengageOperatorDisabled); err != nil {
+		t.Fatalf("disengage() error: %v", err)
+	}
+	if taints := nodeTaints(t, client, "node1"); len(taints) != 1 || taints[0].Key != other.Key {
+		t.Errorf("taints after disengaging = %v, want only %s", taints, other.Key)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Notifiers whose notifications are throttled.
+const (
+	notifierEvents  = "events"
+	notifierWebhook = "webhook"
+)
+
+// notifyThrottle deduplicates and rate limits notifications, per notifier, so
+// that a flapping node or a mass engagement does not produce a flood of
+// them. Within each NOTIFY_WINDOW a notification identical to one already
+// sent is dropped, and only NOTIFY_BURST are sent per notifier and reason;
+// the rest are summarised once the window has passed.
+type notifyThrottle struct {
+	cluster string
+	window  time.Duration
+	burst   int
+
+	mu sync.Mutex
+	// sent is when each notification, by notifier, reason, node and
+	// message, was last sent.
+	sent map[string]time.Time
+	// windows are the current windows, by notifier and reason.
+	windows map[throttleKey]*throttleWindow
+	// closed are past windows in which notifications were suppressed,
+	// waiting to be summarised.
+	closed []throttleSummary
+}
+
+type throttleKey struct {
+	notifier string
+	reason   string
+}
+
+type throttleWindow struct {
+	start      time.Time
+	sent       int
+	suppressed int
+	nodes      map[string]struct{}
+}
+
+// throttleSummary describes the notifications for one reason in a window in
+// which some were suppressed.
+type throttleSummary struct {
+	notifier   string
+	reason     string
+	window     time.Duration
+	nodes      int
+	suppressed int
+}
+
+// message aggregates the window, e.g. "42 nodes LifeSupportExpired in the
+// last 5m0s, 32 notifications suppressed".
+func (s throttleSummary) message() string {
+	return fmt.Sprintf("%d nodes %s in the last %s, %d notifications suppressed", s.nodes, s.reason, s.window, s.suppressed)
+}
+
+func newNotifyThrottle(cluster string, window time.Duration, burst int) *notifyThrottle {
+	return &notifyThrottle{cluster: cluster, window: window, burst: burst}
+}
+
+// allow reports whether a notification should be sent at now, counting it
+// towards its window either way. A nil throttle, or one with no window,
+// allows everything.
+func (t *notifyThrottle) allow(notifier, reason, node, message string, now time.Time) bool {
+	if t == nil || t.window <= 0 {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(now)
+
+	key := throttleKey{notifier: notifier, reason: reason}
+	if t.windows == nil {
+		t.windows = make(map[throttleKey]*throttleWindow)
+	}
+	w, ok := t.windows[key]
+	if !ok {
+		w = &throttleWindow{start: now, nodes: make(map[string]struct{})}
+		t.windows[key] = w
+	}
+	w.nodes[node] = struct{}{}
+
+	id := notifier + "\x00" + reason + "\x00" + node + "\x00" + message
+	if last, ok := t.sent[id]; ok && now.Sub(last) < t.window {
+		w.suppressed++
+		notificationsSuppressed.WithLabelValues(t.cluster, notifier, reason).Inc()
+		return false
+	}
+	if t.burst > 0 && w.sent >= t.burst {
+		w.suppressed++
+		notificationsSuppressed.WithLabelValues(t.cluster, notifier, reason).Inc()
+		return false
+	}
+	w.sent++
+	if t.sent == nil {
+		t.sent = make(map[string]time.Time)
+	}
+	t.sent[id] = now
+	return true
+}
+
+// summaries returns the summaries of windows that have passed by now with
+// notifications suppressed, sorted by notifier and reason.
+func (t *notifyThrottle) summaries(now time.Time) []throttleSummary {
+	if t == nil || t.window <= 0 {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(now)
+	out := t.closed
+	t.closed = nil
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].notifier != out[j].notifier {
+			return out[i].notifier < out[j].notifier
+		}
+		return out[i].reason < out[j].reason
+	})
+	return out
+}
+
+// expire closes windows that have passed by now and forgets notifications
+// sent before the current window. t.mu must be held.
+func (t *notifyThrottle) expire(now time.Time) {
+	for key, w := range t.windows {
+		if now.Sub(w.start) < t.window {
+			continue
+		}
+		if w.suppressed > 0 {
+			t.closed = append(t.closed, throttleSummary{
+				notifier:   key.notifier,
+				reason:     key.reason,
+				window:     t.window,
+				nodes:      len(w.nodes),
+				suppressed: w.suppressed,
+			})
+		}
+		delete(t.windows, key)
+	}
+	for id, last := range t.sent {
+		if now.Sub(last) >= t.window {
+			delete(t.sent, id)
+		}
+	}
+}
+
+// flushNotifications sends the summaries of throttled windows that have
+// passed: a webhook notification for the webhook, and a log line for Events,
+// which have no single node to be recorded on.
+func (c *NodeLifeSupportController) flushNotifications(ctx context.Context) {
+	for _, s := range c.throttle.summaries(c.now()) {
+		switch s.notifier {
+		case notifierWebhook:
+			c.sendNotification(ctx, notification{Event: s.reason, Message: s.message(), Nodes: s.nodes, Suppressed: s.suppressed})
+		default:
+			c.logf("throttled %s: %s", s.notifier, s.message())
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// TestNotifyThrottle tests that duplicates and notifications beyond the
+// burst are suppressed within a window, and summarised once it has passed.
+func TestNotifyThrottle(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	th := newNotifyThrottle("", 5*time.Minute, 2)
+
+	steps := []struct {
+		notifier, reason, node, message string
+		at                              time.Duration
+		want                            bool
+	}{
+		{notifierWebhook, reasonLifeSupportExpired, "node1", "expired", 0, true},
+		{notifierWebhook, reasonLifeSupportExpired, "node1", "expired", time.Minute, false},
+		{notifierWebhook, reasonLifeSupportExpired, "node2", "expired", time.Minute, true},
+		{notifierWebhook, reasonLifeSupportExpired, "node3", "expired", 2 * time.Minute, false},
+		// Other notifiers and reasons have their own windows.
+		{notifierEvents, reasonLifeSupportExpired, "node3", "expired", 2 * time.Minute, true},
+		{notifierWebhook, reasonLifeSupportStoodDown, "node3", "stood down", 2 * time.Minute, true},
+		// A new window.
+		{notifierWebhook, reasonLifeSupportExpired, "node1", "expired", 6 * time.Minute, true},
+	}
+	for i, s := range steps {
+		if got := th.allow(s.notifier, s.reason, s.node, s.message, now.Add(s.at)); got != s.want {
+			t.Errorf("step %d: allow(%s, %s) = %v, want %v", i, s.notifier, s.node, got, s.want)
+		}
+	}
+
+	got := th.summaries(now.Add(6 * time.Minute))
+	want := []throttleSummary{{notifier: notifierWebhook, reason: reasonLifeSupportExpired, window: 5 * time.Minute, nodes: 3, suppressed: 2}}
+	if len(got) != 1 || got[0] != want[0] {
+		t.Fatalf("summaries = %+v, want %+v", got, want)
+	}
+	if msg := got[0].message(); msg != "3 nodes LifeSupportExpired in the last 5m0s, 2 notifications suppressed" {
+		t.Errorf("message = %q", msg)
+	}
+	if got := th.summaries(now.Add(6 * time.Minute)); len(got) != 0 {
+		t.Errorf("summaries repeated: %+v", got)
+	}
+
+	var unthrottled *notifyThrottle
+	if !unthrottled.allow(notifierWebhook, reasonLifeSupportExpired, "node1", "expired", now) {
+		t.Errorf("nil throttle suppressed a notification")
+	}
+}
+
+// TestFlushNotifications tests that a window of suppressed webhook
+// notifications is summarised in a single aggregate notification.
+func TestFlushNotifications(t *testing.T) {
+	var mu sync.Mutex
+	var got []notification
+	done := make(chan struct{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		mu.Lock()
+		got = append(got, n)
+		mu.Unlock()
+		done <- struct{}{}
+	}))
+	defer srv.Close()
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock := clocktesting.NewFakeClock(now)
+	c := &NodeLifeSupportController{
+		notifier: newWebhookNotifier(srv.URL),
+		throttle: newNotifyThrottle("", 5*time.Minute, 1),
+		clock:    clock,
+	}
+	ctx := context.Background()
+	for _, node := range []string{"node1", "node2", "node3"} {
+		c.notify(ctx, notification{Event: reasonLifeSupportExpired, Node: node, Message: "expired"})
+	}
+	<-done
+	clock.Step(5 * time.Minute)
+	c.flushNotifications(ctx)
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 2 {
+		t.Fatalf("received %d notifications, want 2: %+v", len(got), got)
+	}
+	if s := got[1]; s.Node != "" || s.Nodes != 3 || s.Suppressed != 2 || s.Event != reasonLifeSupportExpired {
+		t.Errorf("summary = %+v, want 3 nodes with 2 suppressed", s)
+	}
+}
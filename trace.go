@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// traceparentHeader carries a W3C Trace Context on webhook notifications;
+// exec hooks get it in the TRACEPARENT environment variable.
+const traceparentHeader = "traceparent"
+
+// traceContext identifies a sync cycle as a W3C Trace Context, so what
+// webhook receivers and exec hooks do about the changes made in one cycle can
+// be told apart from, and joined to, those of others.
+type traceContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+// newTraceContext starts a new, sampled trace.
+func newTraceContext() *traceContext {
+	t := &traceContext{}
+	// crypto/rand does not fail on the platforms the controller runs on.
+	_, _ = rand.Read(t.traceID[:])
+	_, _ = rand.Read(t.spanID[:])
+	return t
+}
+
+// traceparent renders the trace context as a traceparent header value.
+func (t *traceContext) traceparent() string {
+	return "00-" + hex.EncodeToString(t.traceID[:]) + "-" + hex.EncodeToString(t.spanID[:]) + "-01"
+}
+
+type traceKey struct{}
+
+// withTrace returns a context carrying a trace context.
+func withTrace(ctx context.Context, t *traceContext) context.Context {
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+// traceparentFrom returns the traceparent of the trace context ctx carries,
+// or "" if it carries none.
+func traceparentFrom(ctx context.Context) string {
+	if t, ok := ctx.Value(traceKey{}).(*traceContext); ok {
+		return t.traceparent()
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTracePropagation tests that the sync cycle's trace context reaches
+// webhook notifications as a traceparent header and exec hooks in
+// TRACEPARENT, and that nothing is sent without one.
+func TestTracePropagation(t *testing.T) {
+	trace := newTraceContext()
+	tp := trace.traceparent()
+	if !regexp.MustCompile(`^00-[0-9a-f]{32}-[0-9a-f]{16}-01$`).MatchString(tp) {
+		t.Fatalf("traceparent %q is not W3C Trace Context", tp)
+	}
+	if other := newTraceContext().traceparent(); other == tp {
+		t.Errorf("two traces share traceparent %q", tp)
+	}
+
+	headers := make(chan string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Get(traceparentHeader)
+	}))
+	defer srv.Close()
+	w := newWebhookNotifier(srv.URL)
+	for _, ctx := range []context.Context{withTrace(context.Background(), trace), context.Background()} {
+		if err := w.send(ctx, notification{Event: reasonLifeSupportExpired}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := <-headers; got != tp {
+		t.Errorf("traceparent header = %q, want %q", got, tp)
+	}
+	if got := <-headers; got != "" {
+		t.Errorf("traceparent header = %q without a trace", got)
+	}
+
+	out := filepath.Join(t.TempDir(), "traceparent")
+	c := &NodeLifeSupportController{opts: Options{
+		ExecHooks:       map[string][]string{transitionEngaged: {"/bin/sh", "-c", "echo \"$TRACEPARENT\" > " + out}},
+		ExecHookTimeout: 10 * time.Second,
+	}}
+	c.cycleTrace.Store(trace)
+	c.runExecHook(transitionEngaged, "node1", "", time.Now())
+	waitExecHooks()
+	raw, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(raw)); got != tp {
+		t.Errorf("TRACEPARENT = %q, want %q", got, tp)
+	}
+}
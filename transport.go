@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+	"k8s.io/client-go/rest"
+)
+
+// applyTransportOptions applies the API_PROXY, API_CA_FILE and client
+// certificate settings to cfg, taking precedence over the kubeconfig and the
+// ambient HTTPS_PROXY/NO_PROXY environment.
+func applyTransportOptions(cfg *rest.Config, opts *Options) {
+	if opts.APIProxy != "" {
+		proxy := httpproxy.Config{
+			HTTPProxy:  opts.APIProxy,
+			HTTPSProxy: opts.APIProxy,
+			NoProxy:    strings.Join(opts.APINoProxy, ","),
+		}
+		proxyFunc := proxy.ProxyFunc()
+		cfg.Proxy = func(req *http.Request) (*url.URL, error) {
+			return proxyFunc(req.URL)
+		}
+	}
+	if opts.APICAFile != "" {
+		cfg.TLSClientConfig.CAFile = opts.APICAFile
+		cfg.TLSClientConfig.CAData = nil
+	}
+	if opts.APIClientCertFile != "" {
+		// With only file paths set, client-go reloads the pair whenever the
+		// files change, so rotated certificates are picked up without a
+		// restart.
+		cfg.TLSClientConfig.CertFile = opts.APIClientCertFile
+		cfg.TLSClientConfig.KeyFile = opts.APIClientKeyFile
+		cfg.TLSClientConfig.CertData = nil
+		cfg.TLSClientConfig.KeyData = nil
+	}
+}
+
+// validateTransportOptions checks the proxy and TLS settings at startup.
+func validateTransportOptions(o *Options) error {
+	if o.APIProxy != "" {
+		u, err := url.Parse(o.APIProxy)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("API_PROXY: invalid proxy URL %q", o.APIProxy)
+		}
+	}
+	if (o.APIClientCertFile == "") != (o.APIClientKeyFile == "") {
+		return fmt.Errorf("API_CLIENT_CERT_FILE and API_CLIENT_KEY_FILE must be set together")
+	}
+	for name, path := range map[string]string{
+		"API_CA_FILE":          o.APICAFile,
+		"API_CLIENT_CERT_FILE": o.APIClientCertFile,
+		"API_CLIENT_KEY_FILE":  o.APIClientKeyFile,
+	} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
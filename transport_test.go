@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/rest"
+)
+
+// TestApplyTransportOptions tests the proxy and TLS overrides.
+func TestApplyTransportOptions(t *testing.T) {
+	cfg := &rest.Config{TLSClientConfig: rest.TLSClientConfig{CAData: []byte("kubeconfig ca"), CertData: []byte("c"), KeyData: []byte("k")}}
+	applyTransportOptions(cfg, &Options{
+		APIProxy:          "http://proxy.corp:3128",
+		APINoProxy:        []string{"10.0.0.0/8", ".internal"},
+		APICAFile:         "/etc/ca.pem",
+		APIClientCertFile: "/etc/tls.crt",
+		APIClientKeyFile:  "/etc/tls.key",
+	})
+
+	tests := []struct {
+		url   string
+		proxy string
+	}{
+		{url: "https://api.example.com:6443/api", proxy: "http://proxy.corp:3128"},
+		{url: "https://10.1.2.3:6443/api", proxy: ""},
+		{url: "https://cp.internal:6443/api", proxy: ""},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, tt.url, nil)
+		u, err := cfg.Proxy(req)
+		if err != nil {
+			t.Fatalf("Proxy(%s) error: %v", tt.url, err)
+		}
+		got := ""
+		if u != nil {
+			got = u.String()
+		}
+		if got != tt.proxy {
+			t.Errorf("Proxy(%s) = %q, want %q", tt.url, got, tt.proxy)
+		}
+	}
+
+	tls := cfg.TLSClientConfig
+	if tls.CAFile != "/etc/ca.pem" || tls.CAData != nil {
+		t.Errorf("CA not overridden: %+v", tls)
+	}
+	if tls.CertFile != "/etc/tls.crt" || tls.KeyFile != "/etc/tls.key" || tls.CertData != nil || tls.KeyData != nil {
+		t.Errorf("client certificate not overridden with reloadable files: %+v", tls)
+	}
+}
+
+// TestValidateTransportOptions tests startup validation of proxy and TLS
+// settings.
+func TestValidateTransportOptions(t *testing.T) {
+	ca := writeFile(t, "ca.pem", "ca")
+	tests := []struct {
+		name      string
+		opts      Options
+		expectErr string
+	}{
+		{name: "none"},
+		{name: "valid", opts: Options{APIProxy: "http://proxy:3128", APICAFile: ca}},
+		{name: "bad proxy", opts: Options{APIProxy: "proxy"}, expectErr: "API_PROXY"},
+		{name: "cert without key", opts: Options{APIClientCertFile: ca}, expectErr: "set together"},
+		{name: "missing CA", opts: Options{APICAFile: "/nonexistent/ca.pem"}, expectErr: "API_CA_FILE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTransportOptions(&tt.opts)
+			if tt.expectErr == "" && err != nil {
+				t.Fatalf("validateTransportOptions() error: %v", err)
+			}
+			if tt.expectErr != "" && (err == nil || !strings.Contains(err.Error(), tt.expectErr)) {
+				t.Fatalf("validateTransportOptions() error = %v, want %q", err, tt.expectErr)
+			}
+		})
+	}
+}
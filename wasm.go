@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// wasmMemoryPages bounds the memory of a WASM module, in 64KiB pages.
+const wasmMemoryPages = 256
+
+// wasmFilterTimeout bounds a single call of a policy's WASM filter, which is
+// made for every node the policy selects, every cycle.
+const wasmFilterTimeout = time.Second
+
+// filterAPIVersion is the version of the policy filter protocol, sent in
+// every request and expected in every result.
+const filterAPIVersion = "filter.node-life-support.io/v1"
+
+// filterRequest is written as JSON to a policy filter's stdin.
+type filterRequest struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	// Cluster is set in multi-cluster mode.
+	Cluster string     `json:"cluster,omitempty"`
+	Policy  string     `json:"policy"`
+	Node    pluginNode `json:"node"`
+}
+
+// filterResult is what a policy filter writes to stdout.
+type filterResult struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Eligible   *bool  `json:"eligible"`
+}
+
+// wasmModule is a compiled WASI command module, instantiated afresh for each
+// call with the request on stdin and its result read from stdout. Modules
+// are sandboxed: they get no arguments, environment, files, network or real
+// clock, and their memory is bounded by wasmMemoryPages.
+type wasmModule struct {
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+}
+
+// loadWASMModule compiles the module at path.
+func loadWASMModule(ctx context.Context, path string) (*wasmModule, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(wasmMemoryPages))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+		r.Close(ctx)
+		return nil, err
+	}
+	compiled, err := r.CompileModule(ctx, raw)
+	if err != nil {
+		r.Close(ctx)
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &wasmModule{runtime: r, compiled: compiled}, nil
+}
+
+// run runs the module's _start with input on stdin until it returns, exits
+// or ctx is done, returning its stdout.
+func (m *wasmModule) run(ctx context.Context, input []byte) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	mod, err := m.runtime.InstantiateModule(ctx, m.compiled, wazero.NewModuleConfig().
+		WithName("").
+		WithStdin(bytes.NewReader(input)).
+		WithStdout(&stdout).
+		WithStderr(&stderr))
+	if mod != nil {
+		mod.Close(ctx)
+	}
+	var exit *sys.ExitError
+	if err != nil && !(errors.As(err, &exit) && exit.ExitCode() == 0) {
+		return nil, pluginError(err, stderr.Bytes())
+	}
+	return stdout.Bytes(), nil
+}
+
+// close releases the module's runtime.
+func (m *wasmModule) close() {
+	m.runtime.Close(context.Background())
+}
+
+// filter runs the policy's WASM filter against a node.
+func (p *Policy) filter(ctx context.Context, cluster string, node *metav1.PartialObjectMetadata) (bool, error) {
+	input, err := json.Marshal(filterRequest{
+		APIVersion: filterAPIVersion,
+		Kind:       "FilterRequest",
+		Cluster:    cluster,
+		Policy:     p.Name,
+		Node:       pluginNode{Name: node.Name, OS: nodeOS(node), Labels: node.Labels, Annotations: node.Annotations},
+	})
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, wasmFilterTimeout)
+	defer cancel()
+	out, err := p.filterModule.run(ctx, input)
+	if err != nil {
+		return false, err
+	}
+	var res filterResult
+	if err := json.Unmarshal(out, &res); err != nil {
+		return false, fmt.Errorf("decoding result: %v", err)
+	}
+	if res.APIVersion != filterAPIVersion || res.Kind != "FilterResult" {
+		return false, fmt.Errorf("result is %s %s, want %s FilterResult", res.APIVersion, res.Kind, filterAPIVersion)
+	}
+	if res.Eligible == nil {
+		return false, fmt.Errorf("result has no eligible field")
+	}
+	return *res.Eligible, nil
+}
+
+// filterMatches reports whether a node passes the policy's WASM filter.
+// Nodes whose filter fails do not.
+func (c *NodeLifeSupportController) filterMatches(p *Policy, node *metav1.PartialObjectMetadata) bool {
+	if p.filterModule == nil {
+		return true
+	}
+	ok, err := p.filter(context.Background(), c.opts.Cluster, node)
+	if err != nil {
+		c.exprErrors.report(c, node.Name, p.Name, "filter", err)
+		return false
+	}
+	return ok
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// Bodies of a test module's _start, after its (empty) locals.
+var (
+	// wasmWrite writes the module's output to stdout.
+	wasmWrite = []byte{
+		0x41, 0x01, // i32.const 1 (stdout)
+		0x41, 0x00, // i32.const 0 (iovec)
+		0x41, 0x01, // i32.const 1 (one iovec)
+		0x41, 0x08, // i32.const 8 (nwritten)
+		0x10, 0x00, // call fd_write
+		0x1a, // drop
+		0x0b, // end
+	}
+	wasmLoop = []byte{0x03, 0x40, 0x0c, 0x00, 0x0b, 0x0b}
+	wasmTrap = []byte{0x00, 0x0b}
+)
+
+// wasiModule assembles a WASI command module whose _start runs body, with
+// output in memory for wasmWrite to write.
+func wasiModule(body []byte, output string) []byte {
+	uleb := func(n int) []byte { return binary.AppendUvarint(nil, uint64(n)) }
+	name := func(s string) []byte { return append(uleb(len(s)), s...) }
+	section := func(id byte, content ...[]byte) []byte {
+		var b []byte
+		for _, c := range content {
+			b = append(b, c...)
+		}
+		return append(append([]byte{id}, uleb(len(b))...), b...)
+	}
+	iovec := binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, 16), uint32(len(output)))
+	code := append([]byte{0x00}, body...)
+
+	m := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	m = append(m, section(1, []byte{0x02,
+		0x60, 0x04, 0x7f, 0x7f, 0x7f, 0x7f, 0x01, 0x7f, // fd_write
+		0x60, 0x00, 0x00, // _start
+	})...)
+	m = append(m, section(2, []byte{0x01}, name("wasi_snapshot_preview1"), name("fd_write"), []byte{0x00, 0x00})...)
+	m = append(m, section(3, []byte{0x01, 0x01})...)
+	m = append(m, section(5, []byte{0x01, 0x00, 0x01})...)
+	m = append(m, section(7, []byte{0x02}, name("memory"), []byte{0x02, 0x00}, name("_start"), []byte{0x00, 0x01})...)
+	m = append(m, section(10, []byte{0x01}, uleb(len(code)), code)...)
+	m = append(m, section(11, []byte{0x02},
+		[]byte{0x00, 0x41, 0x00, 0x0b}, uleb(len(iovec)), iovec,
+		[]byte{0x00, 0x41, 0x10, 0x0b}, name(output))...)
+	return m
+}
+
+func writeWASM(t *testing.T, dir, name string, body []byte, output string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, wasiModule(body, output), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestPolicyFilter tests that nodes failing a policy's WASM filter, or whose
+// filter fails, fall through to later policies.
+func TestPolicyFilter(t *testing.T) {
+	dir := t.TempDir()
+	writeWASM(t, dir, "no.wasm", wasmWrite, `{"apiVersion":"filter.node-life-support.io/v1","kind":"FilterResult","eligible":false}`)
+	writeWASM(t, dir, "yes.wasm", wasmWrite, `{"apiVersion":"filter.node-life-support.io/v1","kind":"FilterResult","eligible":true}`)
+	writeWASM(t, dir, "loop.wasm", wasmLoop, "")
+	writeWASM(t, dir, "trap.wasm", wasmTrap, "")
+	writeWASM(t, dir, "garbage.wasm", wasmWrite, "eligible")
+
+	tests := []struct {
+		filter string
+		want   string
+	}{
+		{filter: "yes.wasm", want: "filtered"},
+		{filter: "no.wasm", want: "fallback"},
+		{filter: "loop.wasm", want: "fallback"},
+		{filter: "trap.wasm", want: "fallback"},
+		{filter: "garbage.wasm", want: "fallback"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			policies, err := loadPolicies(writePolicyFile(t, `
+policies:
+  - name: filtered
+    filter: `+filepath.Join(dir, tt.filter)+`
+  - name: fallback
+`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := &NodeLifeSupportController{opts: Options{Policies: policies}}
+			if p := c.policyFor(labelledNode("node1", nil)); p == nil || p.Name != tt.want {
+				t.Errorf("policyFor() = %v, want %s", p, tt.want)
+			}
+		})
+	}
+
+	if _, err := loadPolicies(writePolicyFile(t, "policies:\n  - name: bad\n    filter: "+filepath.Join(dir, "missing.wasm")+"\n")); err == nil {
+		t.Errorf("loadPolicies() with a missing filter succeeded")
+	}
+}
+
+// TestWASMProbe tests that WASM modules in PROBE_PLUGIN_DIR are run as
+// probes whatever their mode, and that broken ones are skipped.
+func TestWASMProbe(t *testing.T) {
+	dir := t.TempDir()
+	writeWASM(t, dir, "bmc.wasm", wasmWrite, `{"apiVersion":"probe.node-life-support.io/v1","kind":"ProbeResult","healthy":false,"message":"no power"}`)
+	if err := os.WriteFile(filepath.Join(dir, "broken.wasm"), []byte("not wasm"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &NodeLifeSupportController{
+		client:    fake.NewSimpleClientset(),
+		informers: nodeInformer(t, "node1"),
+		opts:      Options{Cluster: "wasm-probes", ProbePluginDir: dir, ProbeTimeout: time.Second},
+	}
+	c.engage("node1")
+	c.probeNodes(context.Background())
+	if got := c.probeResults["node1"]; !reflect.DeepEqual(got, map[string]probeOutcome{"bmc.wasm": {message: "no power"}}) {
+		t.Errorf("node1 probe results = %v", got)
+	}
+	if got := testutil.ToFloat64(probeResultsTotal.WithLabelValues("wasm-probes", "broken.wasm", "error")); got != 0 {
+		t.Errorf("broken.wasm was run")
+	}
+	if len(c.wasmProbes) != 1 {
+		t.Errorf("compiled probes = %v, want bmc.wasm", c.wasmProbes)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "bmc.wasm")); err != nil {
+		t.Fatal(err)
+	}
+	c.probeNodes(context.Background())
+	if len(c.wasmProbes) != 0 {
+		t.Errorf("compiled probes = %v after removal", c.wasmProbes)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// SYNC_WATCHDOG_ACTION values.
+const (
+	// watchdogExit exits the process, so Kubernetes restarts the pod.
+	watchdogExit = "exit"
+	// watchdogFailReadiness fails /readyz until a cycle completes again.
+	watchdogFailReadiness = "fail-readiness"
+)
+
+// exitProcess ends the process when the watchdog fires with watchdogExit.
+var exitProcess = func() { os.Exit(1) }
+
+// runWatchdog checks every SYNC_INTERVAL that the sync loop is still
+// completing cycles, until ctx is done. It checks a timestamp rather than
+// taking c.mu, which a hung cycle may hold.
+func (c *NodeLifeSupportController) runWatchdog(ctx context.Context) {
+	ticker := time.NewTicker(c.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.checkWatchdog(c.now())
+		}
+	}
+}
+
+// checkWatchdog reports whether no sync cycle has completed, or the loop not
+// started one, for SYNC_WATCHDOG_INTERVALS intervals at now, acting on it
+// the first time.
+func (c *NodeLifeSupportController) checkWatchdog(now time.Time) bool {
+	limit := time.Duration(c.opts.WatchdogIntervals) * c.opts.SyncInterval
+	last := time.Unix(0, c.lastCycle.Load())
+	stuck := now.Sub(last) > limit
+	if !stuck {
+		if c.watchdogFired.Swap(false) {
+			c.logf("sync loop completed a cycle again")
+		}
+		return false
+	}
+	if c.watchdogFired.Swap(true) {
+		return true
+	}
+	lifecycleEvent(v1.EventTypeWarning, reasonSyncLoopStuck, "No sync cycle completed since %s, over %d SYNC_INTERVALs", last.UTC().Format(time.RFC3339), c.opts.WatchdogIntervals)
+	if c.opts.WatchdogAction == watchdogExit {
+		c.logf("no sync cycle completed since %s, over %d SYNC_INTERVALs; exiting so the pod is restarted", last.UTC().Format(time.RFC3339), c.opts.WatchdogIntervals)
+		exitProcess()
+		return true
+	}
+	c.logf("no sync cycle completed since %s, over %d SYNC_INTERVALs; failing /readyz", last.UTC().Format(time.RFC3339), c.opts.WatchdogIntervals)
+	return true
+}
+
+// stuck returns the clusters whose sync loop the watchdog found hung.
+func (f *fleet) stuck() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var names []string
+	for _, m := range f.members {
+		if m.c != nil && m.c.watchdogFired.Load() {
+			names = append(names, m.name)
+		}
+	}
+	return names
+}
+
+// serveReady fails while the watchdog finds any sync loop hung.
+func (f *fleet) serveReady(w http.ResponseWriter, _ *http.Request) {
+	if stuck := f.stuck(); len(stuck) > 0 {
+		msg := "sync loop stuck"
+		if names := strings.Join(stuck, ", "); names != "" {
+			msg += " for clusters " + names
+		}
+		http.Error(w, msg, http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = w.Write([]byte("ok"))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestWatchdog tests that the watchdog acts once when no cycle completes for
+// SYNC_WATCHDOG_INTERVALS, and recovers when one does.
+func TestWatchdog(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	exits := 0
+	defer func(orig func()) { exitProcess = orig }(exitProcess)
+	exitProcess = func() { exits++ }
+
+	tests := []struct {
+		action string
+		exits  int
+	}{
+		{action: watchdogExit, exits: 1},
+		{action: watchdogFailReadiness},
+	}
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			exits = 0
+			c := &NodeLifeSupportController{opts: Options{
+				Cluster:           "watched",
+				SyncInterval:      5 * time.Second,
+				WatchdogIntervals: 3,
+				WatchdogAction:    tt.action,
+			}}
+			f := &fleet{}
+			f.register("watched", c, nil)
+			ready := func() int {
+				rec := httptest.NewRecorder()
+				f.serveReady(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+				return rec.Code
+			}
+			c.lastCycle.Store(t0.UnixNano())
+
+			if c.checkWatchdog(t0.Add(15 * time.Second)) {
+				t.Errorf("fired within the limit")
+			}
+			for i := 0; i < 2; i++ {
+				if !c.checkWatchdog(t0.Add(16 * time.Second)) {
+					t.Errorf("not fired past the limit")
+				}
+			}
+			if exits != tt.exits {
+				t.Errorf("exits = %d, want %d", exits, tt.exits)
+			}
+			if got := ready(); got != http.StatusServiceUnavailable {
+				t.Errorf("/readyz = %d", got)
+			}
+
+			c.lastCycle.Store(t0.Add(20 * time.Second).UnixNano())
+			if c.checkWatchdog(t0.Add(21 * time.Second)) {
+				t.Errorf("still fired after a cycle completed")
+			}
+			if got := ready(); got != http.StatusOK {
+				t.Errorf("/readyz after recovering = %d", got)
+			}
+		})
+	}
+}